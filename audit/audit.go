@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxSize    = 10 * 1024 * 1024
+	DefaultMaxBackups = 5
+)
+
+const (
+	ActionBlock   = "block"
+	ActionUnblock = "unblock"
+	// ActionReplace is a full replacement of the enforced set, i.e. when a provider sent a new list
+	ActionReplace = "replace"
+	// ActionAttempt is a packet of a blocked address the firewall logged before dropping it
+	ActionAttempt = "attempt"
+)
+
+// DirectionEgress marks records of egress blocks, records without a direction are about ingress blocks
+const DirectionEgress = "egress"
+
+const (
+	ResultApplied = "applied"
+	ResultFailed  = "failed"
+	// ResultDropped is the result of a change given up on, because the backend rejected it or it kept failing
+	ResultDropped = "dropped"
+	// ResultBlocked is the result of every attempt record
+	ResultBlocked = "blocked"
+)
+
+// Record is a single line of the audit log
+type Record struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Prefix    string    `json:"prefix,omitempty"`
+	Direction string    `json:"direction,omitempty"`
+	// Source is the provider the change came from, or "config" for changes caused by a configuration reload
+	Source      string `json:"source,omitempty"`
+	Serial      uint64 `json:"serial,omitempty"`
+	ListVersion string `json:"list_version,omitempty"`
+	// Entries is the size of the new enforced set of a replace record
+	Entries int `json:"entries,omitempty"`
+	// Protocol and Port describe the packet of an attempt record, Port being its destination port
+	Protocol string `json:"protocol,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Result   string `json:"result"`
+	Error    string `json:"error,omitempty"`
+}
+
+type Config struct {
+	Path string
+	// MaxSize is the size in bytes after which the log is rotated
+	MaxSize int64
+	// MaxBackups is the number of rotated logs kept next to the current one, as Path.1 (the newest) to Path.MaxBackups
+	MaxBackups int
+}
+
+// Log is an append-only JSON lines audit log with size based rotation
+// A nil *Log is valid and discards every record, so that auditing can be disabled
+type Log struct {
+	conf Config
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func Open(conf Config) (*Log, error) {
+	if conf.Path == "" {
+		return nil, errors.New("audit log path must not be empty")
+	}
+
+	if conf.MaxSize <= 0 {
+		conf.MaxSize = DefaultMaxSize
+	}
+
+	if conf.MaxBackups < 0 {
+		conf.MaxBackups = DefaultMaxBackups
+	}
+
+	l := &Log{conf: conf}
+
+	err := os.MkdirAll(filepath.Dir(conf.Path), 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	err = l.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Write appends records to the log, rotating it first if they would not fit
+func (l *Log) Write(records ...Record) error {
+	if l == nil || len(records) == 0 {
+		return nil
+	}
+
+	buf := make([]byte, 0, 256*len(records))
+	for _, record := range records {
+		if record.Time.IsZero() {
+			record.Time = time.Now()
+		}
+
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size > 0 && l.size+int64(len(buf)) > l.conf.MaxSize {
+		err := l.rotate()
+		if err != nil {
+			return fmt.Errorf("unable to rotate audit log: %w", err)
+		}
+	}
+
+	n, err := l.file.Write(buf)
+	l.size += int64(n)
+
+	return err
+}
+
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.conf.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	l.file = file
+	l.size = info.Size()
+
+	return nil
+}
+
+// rotate shifts Path.N-1 to Path.N and so on, dropping the oldest, then starts a new log at Path
+func (l *Log) rotate() error {
+	err := l.file.Close()
+	if err != nil {
+		return err
+	}
+
+	if l.conf.MaxBackups == 0 {
+		err = os.Remove(l.conf.Path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		return l.open()
+	}
+
+	for i := l.conf.MaxBackups - 1; i >= 1; i-- {
+		err = os.Rename(BackupPath(l.conf.Path, i), BackupPath(l.conf.Path, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	err = os.Rename(l.conf.Path, BackupPath(l.conf.Path, 1))
+	if err != nil {
+		return err
+	}
+
+	return l.open()
+}
+
+// BackupPath returns the path of the n-th rotated log, 1 being the newest
+func BackupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLogRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	l, err := Open(Config{Path: path, MaxSize: 200, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _ = l.Close() }()
+
+	for i := 0; i < 10; i++ {
+		err = l.Write(Record{Action: ActionBlock, Prefix: "192.0.2.1", Source: "turris", Result: ResultApplied})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(BackupPath(path, 2)); err != nil {
+		t.Fatalf("no second backup after rotating: %v", err)
+	}
+
+	if _, err := os.Stat(BackupPath(path, 3)); err == nil {
+		t.Fatal("more backups kept than max_backups")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Size() > 200 {
+		t.Fatalf("audit log grew to %d bytes, past its max size", info.Size())
+	}
+}
+
+func TestQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l, err := Open(Config{Path: path, MaxSize: 1024 * 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.Write(
+		Record{Time: start, Action: ActionBlock, Prefix: "192.0.2.0/24", Source: "turris", Result: ResultApplied},
+		Record{Time: start.Add(time.Hour), Action: ActionBlock, Prefix: "198.51.100.7", Source: "crowdsec", Result: ResultApplied},
+		Record{Time: start.Add(2 * time.Hour), Action: ActionReplace, Source: "turris", Entries: 2, Result: ResultApplied},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a line cut short by a crash is skipped rather than failing the whole query
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = file.WriteString(`{"time":"2024-01-01T03:00:00Z","act` + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   int
+	}{
+		{name: "everything", filter: Filter{}, want: 3},
+		{name: "address within a blocked prefix", filter: Filter{Addr: netip.MustParseAddr("192.0.2.200")}, want: 1},
+		{name: "single address", filter: Filter{Addr: netip.MustParseAddr("198.51.100.7")}, want: 1},
+		{name: "source", filter: Filter{Source: "turris"}, want: 2},
+		{name: "since", filter: Filter{Since: start.Add(30 * time.Minute)}, want: 2},
+	}
+
+	for _, test := range tests {
+		records, skipped, err := Query(path, test.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if len(records) != test.want {
+			t.Errorf("%s: Query() returned %d records, want %d", test.name, len(records), test.want)
+		}
+
+		if skipped != 1 {
+			t.Errorf("%s: Query() skipped %d lines, want the malformed one", test.name, skipped)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+)
+
+// Filter selects audit records, zero values match everything
+type Filter struct {
+	// Addr matches records whose prefix contains the address
+	Addr   netip.Addr
+	Since  time.Time
+	Source string
+	Action string
+}
+
+func (f Filter) matches(record Record) bool {
+	if !f.Since.IsZero() && record.Time.Before(f.Since) {
+		return false
+	}
+
+	if f.Source != "" && record.Source != f.Source {
+		return false
+	}
+
+	if f.Action != "" && record.Action != f.Action {
+		return false
+	}
+
+	if f.Addr.IsValid() {
+		if record.Prefix == "" {
+			return false
+		}
+
+		prefix, err := parsePrefix(record.Prefix)
+		if err != nil || !prefix.Contains(f.Addr.Unmap()) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Query returns the records matching filter from the log at path and its rotated backups, oldest first, along with how many
+// malformed lines it skipped, i.e. one cut short by a crash
+func Query(path string, filter Filter) ([]Record, int, error) {
+	paths := make([]string, 0)
+	for i := 1; ; i++ {
+		backup := BackupPath(path, i)
+		if _, err := os.Stat(backup); err != nil {
+			break
+		}
+
+		paths = append([]string{backup}, paths...)
+	}
+	paths = append(paths, path)
+
+	result := make([]Record, 0)
+	skipped := 0
+	for _, p := range paths {
+		records, malformed, err := readFile(p, filter)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return nil, skipped, err
+		}
+
+		result = append(result, records...)
+		skipped += malformed
+	}
+
+	return result, skipped, nil
+}
+
+func readFile(path string, filter Filter) ([]Record, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer func() {
+		err := file.Close()
+		if err != nil {
+			slog.Error("unable to close audit log", "path", path, "details", err)
+		}
+	}()
+
+	result := make([]Record, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	line := 0
+	malformed := 0
+	for scanner.Scan() {
+		line++
+
+		var record Record
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			slog.Debug("skipping malformed audit log line", "path", path, "line", line, "details", err)
+			malformed++
+			continue
+		}
+
+		if filter.matches(record) {
+			result = append(result, record)
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, malformed, fmt.Errorf("%s:%d: %w", path, line+1, err)
+	}
+
+	return result, malformed, nil
+}
+
+// parsePrefix accepts prefixes as well as single addresses, the way they are written to the log
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
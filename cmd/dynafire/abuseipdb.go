@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MatejLach/dynafire/config"
+	"github.com/MatejLach/dynafire/pipeline"
+	"github.com/MatejLach/dynafire/provider/abuseipdb"
+)
+
+const abuseipdbSource = "abuseipdb"
+
+// startAbuseIPDB feeds the AbuseIPDB blacklist into the pipeline, refreshing it on a schedule
+func (d *daemon) startAbuseIPDB(ctx context.Context, conf config.AbuseIPDB) error {
+	apiKey := conf.APIKey
+	if conf.APIKeyFile != "" {
+		keyB, err := os.ReadFile(conf.APIKeyFile)
+		if err != nil {
+			return fmt.Errorf("unable to read the AbuseIPDB API key: %w", err)
+		}
+
+		apiKey = strings.TrimSpace(string(keyB))
+	}
+
+	provider, err := abuseipdb.New(abuseipdb.Config{
+		URL:               conf.URL,
+		APIKey:            apiKey,
+		ConfidenceMinimum: conf.ConfidenceMinimum,
+		Limit:             conf.Limit,
+		RefreshInterval:   conf.RefreshInterval.Duration(),
+		CacheFile:         conf.CacheFile,
+		ReadOnlyCache:     d.dryRun,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to initialize AbuseIPDB provider: %w", err)
+	}
+
+	d.abuseipdb = provider
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		provider.Run(ctx)
+	}()
+
+	forwardLists(ctx, d, provider.ListChan, "AbuseIPDB blacklist", func(list abuseipdb.List) (pipeline.Origin, []netip.Prefix) {
+		slog.Info(fmt.Sprintf("blocking %d AbuseIPDB addresses", len(list.Entries)), "confidence_minimum", conf.ConfidenceMinimum, "generated_at", list.Generated)
+
+		return pipeline.Origin{Source: abuseipdbSource, ListVersion: list.Generated.UTC().Format(time.RFC3339)}, list.Prefixes()
+	})
+
+	return nil
+}
+
+// abuseipdbDetails attributes a prefix blocked by the AbuseIPDB provider to its confidence score
+func abuseipdbDetails(provider *abuseipdb.Provider, prefix netip.Prefix) []string {
+	if provider == nil {
+		return nil
+	}
+
+	entry, ok := provider.Lookup(prefix)
+	if !ok {
+		return nil
+	}
+
+	details := []string{fmt.Sprintf("confidence %d%%", entry.Confidence)}
+	if entry.CountryCode != "" {
+		details = append(details, entry.CountryCode)
+	}
+
+	if !entry.LastReported.IsZero() {
+		details = append(details, "last reported "+entry.LastReported.UTC().Format(time.DateTime))
+	}
+
+	return details
+}
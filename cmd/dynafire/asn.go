@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"time"
+
+	"github.com/MatejLach/dynafire/config"
+	"github.com/MatejLach/dynafire/pipeline"
+	"github.com/MatejLach/dynafire/provider/asn"
+)
+
+const asnSource = "asn"
+
+// startASN feeds the prefixes of the configured autonomous systems into the pipeline, refreshing them on a schedule
+func (d *daemon) startASN(ctx context.Context, conf config.ASN) error {
+	asns, _ := parseASNs(conf.ASNs)
+
+	provider, err := asn.New(asn.Config{
+		ASNs:            asns,
+		Database:        conf.Database,
+		PrefixFile:      conf.PrefixFile,
+		RefreshInterval: conf.RefreshInterval.Duration(),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to initialize ASN provider: %w", err)
+	}
+
+	d.asn = provider
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		provider.Run(ctx)
+	}()
+
+	forwardLists(ctx, d, provider.ListChan, "ASN prefixes", func(list asn.List) (pipeline.Origin, []netip.Prefix) {
+		slog.Info(fmt.Sprintf("blocking %d ASN prefixes", len(list.Prefixes)), "asns", conf.ASNs, "version", list.Version)
+
+		return pipeline.Origin{Source: asnSource, ListVersion: list.Version.UTC().Format(time.RFC3339)}, list.Prefixes
+	})
+
+	return nil
+}
+
+// asnDetails attributes a prefix blocked by the ASN provider to the autonomous systems announcing it
+func asnDetails(provider *asn.Provider, addr netip.Addr, prefix netip.Prefix) []string {
+	if provider == nil {
+		return nil
+	}
+
+	details := make([]string, 0)
+	for _, match := range provider.Lookup(addr) {
+		if match.Prefix != prefix {
+			continue
+		}
+
+		detail := fmt.Sprintf("AS%d", match.ASN)
+		if match.Name != "" {
+			detail += " " + match.Name
+		}
+		details = append(details, detail)
+	}
+
+	return details
+}
+
+// parseASNs is validated together with the rest of the config, so errors only matter there
+func parseASNs(list []string) ([]uint32, error) {
+	result := make([]uint32, 0, len(list))
+	for _, entry := range list {
+		n, err := asn.ParseASN(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, n)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MatejLach/dynafire/audit"
+	"github.com/MatejLach/dynafire/config"
+)
+
+func runAudit(args []string, conf config.Config) int {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	ip := fs.String("ip", "", "only show changes of addresses and prefixes containing this IP")
+	since := fs.Duration("since", 0, "only show changes made within this duration, i.e. 24h")
+	source := fs.String("source", "", "only show changes coming from this provider, i.e. turris")
+	action := fs.String("action", "", "only show records of this action; block, unblock, replace or attempt")
+	asJSON := fs.Bool("json", false, "print the matching records as JSON lines")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	if conf.Audit.Path == "" {
+		fmt.Fprintln(os.Stderr, "the audit log is disabled, see audit.path")
+		return 1
+	}
+
+	filter := audit.Filter{Source: *source, Action: *action}
+	if *ip != "" {
+		filter.Addr, err = netip.ParseAddr(*ip)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid IP %q: %v\n", *ip, err)
+			return 2
+		}
+	}
+
+	if *since > 0 {
+		filter.Since = time.Now().Add(-*since)
+	}
+
+	records, skipped, err := audit.Query(conf.Audit.Path, filter)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d malformed lines of the audit log\n", skipped)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, record := range records {
+			err = enc.Encode(record)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTION\tPREFIX\tSOURCE\tSERIAL\tLIST VERSION\tRESULT")
+	for _, record := range records {
+		target := record.Prefix
+		if record.Action == audit.ActionReplace {
+			target = fmt.Sprintf("(%d entries)", record.Entries)
+		}
+
+		if record.Direction == audit.DirectionEgress {
+			target += " (egress)"
+		}
+
+		serial := ""
+		if record.Serial != 0 {
+			serial = fmt.Sprint(record.Serial)
+		}
+
+		result := record.Result
+		if record.Error != "" {
+			result += ": " + record.Error
+		}
+
+		// attempts show what was being reached, i.e. "blocked 22/tcp"
+		if record.Action == audit.ActionAttempt {
+			switch {
+			case record.Port != 0:
+				result += fmt.Sprintf(" %d/%s", record.Port, record.Protocol)
+			case record.Protocol != "":
+				result += " " + record.Protocol
+			}
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", record.Time.Local().Format(time.RFC3339), record.Action, target, record.Source, serial, record.ListVersion, result)
+	}
+
+	err = w.Flush()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MatejLach/dynafire/control"
+)
+
+func runBlock(args []string, controlSocket string) int {
+	fs := flag.NewFlagSet("block", flag.ContinueOnError)
+	ttl := fs.Duration("ttl", 0, "lift the block after this duration, i.e. 1h; 0 keeps it until dynafire unblock or a restart")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	if fs.NArg() != 1 || *ttl < 0 {
+		fmt.Fprintln(os.Stderr, "usage: dynafire [flags] block [-ttl duration] <ip|prefix>")
+		return 2
+	}
+
+	req := control.BlockRequest{Prefix: fs.Arg(0)}
+	if *ttl > 0 {
+		req.TTL = ttl.String()
+	}
+
+	var result control.BlockResult
+	err = control.NewClient(controlSocket).Post("/block", req, &result)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if result.Expires.IsZero() {
+		fmt.Printf("blocked %s\n", result.Prefix)
+	} else {
+		fmt.Printf("blocked %s until %s\n", result.Prefix, result.Expires.Local().Format(time.DateTime))
+	}
+
+	return 0
+}
+
+func runUnblock(args []string, controlSocket string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: dynafire [flags] unblock <ip|prefix>")
+		return 2
+	}
+
+	var result control.BlockResult
+	err := control.NewClient(controlSocket).Post("/unblock", control.BlockRequest{Prefix: args[0]}, &result)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("unblocked %s\n", result.Prefix)
+	if len(result.BlockedBy) > 0 {
+		fmt.Printf("it stays blocked as it is listed by %s\n", strings.Join(result.BlockedBy, ", "))
+	}
+
+	return 0
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/MatejLach/dynafire/control"
+)
+
+func runCheck(args []string, controlSocket string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: dynafire [flags] check <ip>")
+		return 2
+	}
+
+	var result control.CheckResult
+	err := control.NewClient(controlSocket).Get("/check?ip="+url.QueryEscape(args[0]), &result)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch {
+	case result.Blocked:
+		fmt.Printf("%s is blocked by %s\n", result.IP, strings.Join(result.BlockedBy, ", "))
+	case result.Allowlisted:
+		fmt.Printf("%s is allowlisted\n", result.IP)
+	default:
+		fmt.Printf("%s is not blocked\n", result.IP)
+	}
+
+	if egress := result.Egress; egress != nil {
+		switch {
+		case egress.Blocked:
+			fmt.Printf("traffic to %s is blocked by %s\n", result.IP, strings.Join(egress.BlockedBy, ", "))
+		case egress.Allowlisted:
+			fmt.Printf("traffic to %s is allowlisted\n", result.IP)
+		default:
+			fmt.Printf("traffic to %s is not blocked\n", result.IP)
+		}
+	}
+
+	if len(result.Sources) == 0 {
+		return 0
+	}
+
+	fmt.Println("listed by:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, source := range result.Sources {
+		name := source.Source
+		switch {
+		case !source.Enabled && source.EgressEnabled:
+			name += " (egress only)"
+		case !source.Enabled:
+			name += " (disabled)"
+		}
+
+		line := fmt.Sprintf("  %s\t%s", name, source.Prefix)
+		if len(source.Details) > 0 {
+			line += "\t" + strings.Join(source.Details, ", ")
+		}
+		fmt.Fprintln(w, line)
+	}
+
+	err = w.Flush()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
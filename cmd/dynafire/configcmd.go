@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/MatejLach/dynafire/config"
+)
+
+func runConfig(args []string, configPath string, configFlags *config.Flags) int {
+	if len(args) != 1 || args[0] != "validate" {
+		fmt.Fprintln(os.Stderr, "usage: dynafire [flags] config validate")
+		return 2
+	}
+
+	_, path, err := config.Load(configPath, configFlags, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if path == "" {
+		fmt.Println("no config file found, the defaults are valid")
+		return 0
+	}
+
+	fmt.Printf("%s is valid\n", path)
+
+	return 0
+}
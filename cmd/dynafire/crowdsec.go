@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/MatejLach/dynafire/config"
+	"github.com/MatejLach/dynafire/pipeline"
+	"github.com/MatejLach/dynafire/provider/crowdsec"
+)
+
+const crowdsecSource = "crowdsec"
+
+// startCrowdSec polls the decisions of a CrowdSec Local API as a bouncer and feeds the bans into the pipeline
+func (d *daemon) startCrowdSec(ctx context.Context, conf config.CrowdSec) error {
+	apiKey := conf.APIKey
+	if conf.APIKeyFile != "" {
+		keyB, err := os.ReadFile(conf.APIKeyFile)
+		if err != nil {
+			return fmt.Errorf("unable to read the CrowdSec bouncer key: %w", err)
+		}
+
+		apiKey = strings.TrimSpace(string(keyB))
+	}
+
+	provider, err := crowdsec.New(crowdsec.Config{
+		URL:          conf.LAPIUrl,
+		APIKey:       apiKey,
+		PollInterval: conf.PollInterval.Duration(),
+		Scopes:       conf.Scopes,
+		Origins:      conf.Origins,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to initialize CrowdSec bouncer: %w", err)
+	}
+
+	d.crowdsecStarted = true
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		provider.Run(ctx)
+	}()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		origin := pipeline.Origin{Source: crowdsecSource}
+		for update := range provider.UpdateChan {
+			if update.Startup {
+				d.replaceSource(ctx, origin, update.Added, "CrowdSec decisions")
+				continue
+			}
+
+			for _, prefix := range update.Removed {
+				d.updateSource(ctx, origin, prefix, true, "CrowdSec decision")
+			}
+
+			for _, prefix := range update.Added {
+				d.updateSource(ctx, origin, prefix, false, "CrowdSec decision")
+			}
+		}
+	}()
+
+	return nil
+}
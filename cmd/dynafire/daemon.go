@@ -0,0 +1,712 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/MatejLach/dynafire/audit"
+	"github.com/MatejLach/dynafire/config"
+	"github.com/MatejLach/dynafire/control"
+	"github.com/MatejLach/dynafire/firewall"
+	"github.com/MatejLach/dynafire/firewall/containers"
+	"github.com/MatejLach/dynafire/firewall/firewalld"
+	"github.com/MatejLach/dynafire/firewall/memory"
+	"github.com/MatejLach/dynafire/firewall/resilient"
+	"github.com/MatejLach/dynafire/firewall/route"
+	"github.com/MatejLach/dynafire/firewall/xdp"
+	"github.com/MatejLach/dynafire/metrics"
+	"github.com/MatejLach/dynafire/pipeline"
+	"github.com/MatejLach/dynafire/provider/abuseipdb"
+	"github.com/MatejLach/dynafire/provider/asn"
+	"github.com/MatejLach/dynafire/provider/geoip"
+	"github.com/MatejLach/dynafire/provider/local"
+	"github.com/MatejLach/dynafire/provider/peers"
+)
+
+type daemon struct {
+	ctx        context.Context
+	loadConfig func() (config.Config, error)
+	logLevels  *logLevels
+	startedAt  time.Time
+
+	// dryRun keeps the daemon from writing anything but its audit log and control socket
+	dryRun bool
+
+	// mu guards conf and the providers started on reload, and serializes reloads
+	mu   sync.Mutex
+	conf config.Config
+
+	fwc   *resilient.Blocker
+	queue *pipeline.Queue
+	pipe  *pipeline.Pipeline
+	audit *audit.Log
+	// hits is nil unless the backend counts hits
+	hits *hitTracker
+
+	wg              sync.WaitGroup
+	fatal           chan error
+	turrisStarted   bool
+	crowdsecStarted bool
+	geoip           *geoip.Provider
+	asn             *asn.Provider
+	abuseipdb       *abuseipdb.Provider
+	local           *local.Store
+	// peers is nil until the peers provider is enabled, local detections are shared once it is set
+	peers atomic.Pointer[peers.Mesh]
+}
+
+// shutdownTimeout is how long stopping waits for everything to wind down before the backend's setup is removed regardless
+const shutdownTimeout = 10 * time.Second
+
+type zoneTargetPolicySetter interface {
+	SetZoneTargetPolicy(policy string) error
+}
+
+func runDaemon(conf config.Config, loadConfig func() (config.Config, error), levels *logLevels) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := &daemon{
+		ctx:        ctx,
+		loadConfig: loadConfig,
+		logLevels:  levels,
+		startedAt:  time.Now(),
+		conf:       conf,
+		dryRun:     conf.Backend == "dryrun",
+		fatal:      make(chan error, 1),
+	}
+
+	backend, err := newBlocker(conf)
+	if err != nil {
+		slog.Error("Initialization failed; host system pre-requisites not met", "details", err)
+		os.Exit(1)
+	}
+
+	if closer, ok := backend.(firewall.Closer); ok {
+		d.closeOnSignal(cancel, closer)
+	}
+
+	// dry-run leaves the container runtimes' chains alone just like the host firewall
+	if len(conf.Containers.Runtimes) > 0 && !d.dryRun {
+		integration, err := containers.New(backend, containers.Config{
+			Runtimes:      conf.Containers.Runtimes,
+			CheckInterval: conf.Containers.CheckInterval.Duration(),
+		})
+		if err != nil {
+			slog.Error("Unable to set up the container integration", "runtimes", conf.Containers.Runtimes, "details", err)
+			os.Exit(1)
+		}
+
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			integration.Run(d.ctx)
+		}()
+
+		backend = integration
+	}
+
+	d.fwc = resilient.New(backend, resilient.Policy{
+		MaxAttempts:      conf.BackendPolicy.RetryAttempts,
+		BreakerThreshold: conf.BackendPolicy.BreakerThreshold,
+		BreakerCooldown:  conf.BackendPolicy.BreakerCooldown.Duration(),
+	})
+
+	if conf.Audit.Path != "" {
+		d.audit, err = audit.Open(audit.Config{
+			Path:       conf.Audit.Path,
+			MaxSize:    int64(conf.Audit.MaxSizeMB) * 1024 * 1024,
+			MaxBackups: conf.Audit.MaxBackups,
+		})
+		if err != nil {
+			slog.Error("Unable to open audit log", "path", conf.Audit.Path, "details", err)
+			os.Exit(1)
+		}
+	}
+
+	d.queue = pipeline.NewQueue(d.fwc, pipeline.QueueConfig{
+		Size:          conf.Queue.Size,
+		BatchSize:     conf.Queue.BatchSize,
+		FlushInterval: conf.Queue.FlushInterval.Duration(),
+		Audit:         d.audit,
+	})
+
+	// validated together with the rest of the config, so it cannot fail here
+	allowlist, _ := config.ParsePrefixes(conf.Allowlist)
+	d.pipe = pipeline.New(d.queue, conf.Providers, allowlist)
+	if conf.Egress.Enabled {
+		egressAllowlist, _ := config.ParsePrefixes(conf.Egress.Allowlist)
+		d.pipe.EnableEgress(conf.Egress.Providers, egressAllowlist)
+	}
+
+	if conf.Hits.MaxEntries > 0 {
+		d.pipe.LimitEntries(conf.Hits.MaxEntries)
+	}
+
+	if conf.MetricsListenAddress != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+
+			err := http.ListenAndServe(conf.MetricsListenAddress, mux)
+			if err != nil {
+				slog.Error("metrics endpoint stopped", "details", err)
+			}
+		}()
+	}
+
+	// before the control API starts, which reads the hits
+	if conf.Hits.Enabled {
+		d.startHitSampler(ctx, conf.Hits)
+	}
+
+	// before the control API starts, which adds manual blocks
+	d.startLocal(ctx)
+
+	controlServer := control.NewServer(conf.ControlSocket)
+	controlServer.HandleFunc("/status", d.handleStatus)
+	controlServer.HandleFunc("/reload", d.handleReload)
+	controlServer.HandleFunc("/check", d.handleCheck)
+	controlServer.HandleFunc("/list", d.handleList)
+	controlServer.HandleFunc("/block", d.handleBlock)
+	controlServer.HandleFunc("/unblock", d.handleUnblock)
+
+	go func() {
+		err := controlServer.Serve(ctx)
+		if err != nil {
+			slog.Error("control API stopped", "details", err)
+		}
+	}()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		err := d.queue.Run(ctx)
+		if err != nil {
+			d.fatal <- err
+		}
+	}()
+
+	// a SIGHUP arriving while the providers start would otherwise kill dynafire, it is handled once they run
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	// the control API already runs, and may reload
+	d.mu.Lock()
+	err = d.startProviders(conf)
+	d.mu.Unlock()
+	if err != nil {
+		slog.Error("Unable to start providers", "details", err)
+		os.Exit(1)
+	}
+
+	if conf.DropLog.Enabled {
+		d.startDropLog(ctx, conf.DropLog)
+	}
+
+	if conf.Detector.Enabled {
+		err = d.startDetector(ctx, conf.Detector)
+		if err != nil {
+			slog.Error("Unable to start the log detector", "details", err)
+			os.Exit(1)
+		}
+	}
+
+	go func() {
+		for range hup {
+			slog.Info("received SIGHUP, reloading configuration")
+			_, err := d.reload()
+			if err != nil {
+				slog.Error("configuration reload failed, keeping the running configuration", "details", err)
+			}
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case err := <-d.fatal:
+		slog.Error("unrecoverable firewall backend failure", "details", err)
+		os.Exit(1)
+	}
+}
+
+// closeOnSignal undoes the setup of a backend that does not leave its blocks in place once SIGINT or SIGTERM stops dynafire;
+// the queue is stopped first, so that no change is applied behind Close's back
+func (d *daemon) closeOnSignal(cancel context.CancelFunc, closer firewall.Closer) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-stop
+		slog.Info("stopping, removing the blocks")
+		cancel()
+
+		done := make(chan struct{})
+		go func() {
+			d.wg.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(shutdownTimeout):
+			slog.Warn("not everything stopped in time, removing the blocks regardless", "timeout", shutdownTimeout)
+		}
+
+		err := closer.Close()
+		if err != nil {
+			slog.Error("unable to remove the blocks", "details", err)
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}()
+}
+
+// forwardLists replaces the prefixes of a source with every list a provider sends, until it closes lists;
+// prefixes turns a list into the prefixes along with the origin they are audited with
+func forwardLists[T any](ctx context.Context, d *daemon, lists <-chan T, what string, prefixes func(T) (pipeline.Origin, []netip.Prefix)) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		for list := range lists {
+			origin, listed := prefixes(list)
+			d.replaceSource(ctx, origin, listed, what)
+		}
+	}()
+}
+
+// replaceSource hands a list to the pipeline and reports whether it was accepted
+// A list the firewall fails to apply stays queued and is retried by the queue, so that failure is only logged
+func (d *daemon) replaceSource(ctx context.Context, origin pipeline.Origin, prefixes []netip.Prefix, what string) bool {
+	err := d.pipe.ReplaceSource(ctx, origin, prefixes)
+	if err == nil {
+		return true
+	}
+
+	// shutting down, nothing is retried
+	if ctx.Err() != nil {
+		return false
+	}
+
+	slog.Warn(fmt.Sprintf("unable to apply %s, retrying", what), "details", err)
+
+	return false
+}
+
+// updateSource adds prefix to the source of origin, or removes it, and reports whether the change was queued
+// Providers keep sending until they stop, so consumers skip a change that failed rather than stop draining them
+func (d *daemon) updateSource(ctx context.Context, origin pipeline.Origin, prefix netip.Prefix, remove bool, what string) bool {
+	var err error
+	if remove {
+		err = d.pipe.Remove(ctx, origin, prefix)
+	} else {
+		err = d.pipe.Add(ctx, origin, prefix)
+	}
+
+	if err == nil {
+		return true
+	}
+
+	// shutting down, the provider stops on its own
+	if ctx.Err() == nil {
+		slog.Error(fmt.Sprintf("unable to queue %s", what), "source", origin.Source, "prefix", firewall.PrefixString(prefix), "details", err)
+	}
+
+	return false
+}
+
+// startProviders starts every provider enabled in conf, in either direction, that is not running yet
+func (d *daemon) startProviders(conf config.Config) error {
+	names := conf.Providers
+	if conf.Egress.Enabled {
+		names = append(slices.Clone(names), conf.Egress.Providers...)
+	}
+
+	for _, name := range names {
+		switch name {
+		case turrisSource:
+			if d.turrisStarted {
+				continue
+			}
+
+			err := d.startTurris(d.ctx, conf.Turris)
+			if err != nil {
+				return err
+			}
+
+			d.turrisStarted = true
+		case geoipSource:
+			if d.geoip != nil {
+				continue
+			}
+
+			err := d.startGeoIP(d.ctx, conf.GeoIP)
+			if err != nil {
+				return err
+			}
+		case asnSource:
+			if d.asn != nil {
+				continue
+			}
+
+			err := d.startASN(d.ctx, conf.ASN)
+			if err != nil {
+				return err
+			}
+		case abuseipdbSource:
+			if d.abuseipdb != nil {
+				continue
+			}
+
+			err := d.startAbuseIPDB(d.ctx, conf.AbuseIPDB)
+			if err != nil {
+				return err
+			}
+		case crowdsecSource:
+			if d.crowdsecStarted {
+				continue
+			}
+
+			err := d.startCrowdSec(d.ctx, conf.CrowdSec)
+			if err != nil {
+				return err
+			}
+		case peersSource:
+			if d.peers.Load() != nil {
+				continue
+			}
+
+			err := d.startPeers(d.ctx, conf.Peers)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// reload re-reads the config and applies whatever can change while running
+// An invalid config is rejected as a whole and the running one stays in place
+func (d *daemon) reload() (control.ReloadResult, error) {
+	ctx := d.ctx
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	newConf, err := d.loadConfig()
+	if err != nil {
+		metrics.ConfigReloads.Inc("rejected")
+		return control.ReloadResult{}, err
+	}
+
+	result := control.ReloadResult{
+		Applied:         make([]string, 0),
+		RestartRequired: make([]string, 0),
+	}
+
+	resync := false
+	scopeSet := false
+	for _, key := range config.Diff(d.conf, newConf) {
+		switch key {
+		case "log_level", "log.text.level", "log.json.level", "log.journald.level", "log.syslog.level":
+			d.logLevels.set(newConf)
+		case "zone_target_policy":
+			setter, ok := d.fwc.Backend().(zoneTargetPolicySetter)
+			if !ok {
+				result.RestartRequired = append(result.RestartRequired, key)
+				continue
+			}
+
+			err = d.queue.Do(ctx, func() error { return setter.SetZoneTargetPolicy(newConf.ZoneTargetPolicy) })
+			resync = true
+		case "rule_action":
+			setter, ok := d.fwc.Backend().(firewall.RuleActionSetter)
+			if !ok {
+				result.RestartRequired = append(result.RestartRequired, key)
+				continue
+			}
+
+			err = d.queue.Do(ctx, func() error { return setter.SetRuleAction(newConf.RuleAction) })
+			resync = true
+		case "geoip.countries":
+			// a provider that is not running picks up the new countries once it is enabled
+			if d.geoip != nil {
+				d.geoip.SetCountries(newConf.GeoIP.Countries)
+			}
+		case "asn.asns":
+			if d.asn != nil {
+				asns, _ := parseASNs(newConf.ASN.ASNs)
+				d.asn.SetASNs(asns)
+			}
+		case "scope.ports", "scope.protocols", "scope.services":
+			setter, ok := d.fwc.Backend().(firewall.ScopeSetter)
+			if !ok {
+				result.RestartRequired = append(result.RestartRequired, key)
+				continue
+			}
+
+			// the scope is set as a whole, once for all of its keys
+			if !scopeSet {
+				// validated together with the rest of the config, so it cannot fail here
+				scope, _ := newConf.Scope.Parse()
+				err = d.queue.Do(ctx, func() error { return setter.SetScope(scope) })
+				scopeSet = true
+				resync = true
+			}
+		case "drop_log.rate":
+			setter, ok := d.fwc.Backend().(firewall.DropLogSetter)
+			if !ok || !newConf.DropLog.Enabled {
+				result.RestartRequired = append(result.RestartRequired, key)
+				continue
+			}
+
+			err = d.queue.Do(ctx, func() error { return setter.SetDropLog(newConf.DropLog.Parse()) })
+			resync = true
+		case "hits.max_entries":
+			err = d.pipe.SetMaxEntries(ctx, newConf.Hits.MaxEntries)
+		case "allowlist":
+			allowlist, _ := config.ParsePrefixes(newConf.Allowlist)
+			err = d.pipe.SetAllowlist(ctx, allowlist)
+		case "providers":
+			err = d.startProviders(newConf)
+			if err == nil {
+				err = d.pipe.SetEnabledSources(ctx, newConf.Providers)
+			}
+		case "egress.allowlist":
+			allowlist, _ := config.ParsePrefixes(newConf.Egress.Allowlist)
+			err = d.pipe.SetEgressAllowlist(ctx, allowlist)
+		case "egress.providers":
+			err = d.startProviders(newConf)
+			if err == nil {
+				err = d.pipe.SetEgressSources(ctx, newConf.Egress.Providers)
+			}
+		default:
+			result.RestartRequired = append(result.RestartRequired, key)
+			continue
+		}
+
+		if err != nil {
+			err = fmt.Errorf("applying %s: %w", key, err)
+			break
+		}
+
+		result.Applied = append(result.Applied, key)
+	}
+
+	// the settings applied before a failure stay in place, so the blacklist is resynced for them all the same
+	if resync {
+		resyncErr := d.pipe.Resync(ctx)
+		if resyncErr != nil {
+			err = errors.Join(err, fmt.Errorf("resyncing the blacklist: %w", resyncErr))
+		}
+	}
+
+	if err != nil {
+		// the running config records what was applied, so that the next reload retries only the rest
+		d.conf = config.Merge(d.conf, newConf, result.Applied)
+		metrics.ConfigReloads.Inc("failed")
+		return result, err
+	}
+
+	d.conf = newConf
+	metrics.ConfigReloads.Inc("applied")
+
+	slog.Info("configuration reloaded", "applied", result.Applied)
+	if len(result.RestartRequired) > 0 {
+		slog.Warn("some configuration changes only take effect after a restart", "settings", result.RestartRequired)
+	}
+
+	return result, nil
+}
+
+func (d *daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		control.WriteError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	d.mu.Lock()
+	backendName := d.conf.Backend
+	d.mu.Unlock()
+
+	health := d.fwc.Health()
+
+	state := control.StateRunning
+	if health.Degraded {
+		state = control.StateDegraded
+	}
+
+	control.WriteJSON(w, http.StatusOK, control.Status{
+		State:          state,
+		Backend:        backendName,
+		StartedAt:      d.startedAt,
+		PendingChanges: d.queue.Pending(),
+		BackendHealth:  health,
+	})
+}
+
+func (d *daemon) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		control.WriteError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	result, err := d.reload()
+	if err != nil {
+		status := http.StatusInternalServerError
+		if config.IsValidationError(err) || errors.Is(err, os.ErrNotExist) {
+			status = http.StatusBadRequest
+		}
+
+		slog.Error("configuration reload failed, keeping the running configuration", "details", err)
+		control.WriteError(w, status, err)
+
+		return
+	}
+
+	control.WriteJSON(w, http.StatusOK, result)
+}
+
+func (d *daemon) handleCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		control.WriteError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	addr, err := netip.ParseAddr(r.URL.Query().Get("ip"))
+	if err != nil {
+		control.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid IP: %w", err))
+		return
+	}
+
+	// the providers are queried without holding mu, a slow lookup must not stall reloads
+	d.mu.Lock()
+	asnProvider, abuseipdbProvider := d.asn, d.abuseipdb
+	d.mu.Unlock()
+
+	lookup := d.pipe.Lookup(addr)
+	result := control.CheckResult{
+		IP:          addr.Unmap().String(),
+		Blocked:     len(lookup.Enforced) > 0,
+		BlockedBy:   make([]string, 0, len(lookup.Enforced)),
+		Allowlisted: lookup.Allowlisted,
+		Sources:     make([]control.SourceMatch, 0, len(lookup.Matches)),
+	}
+
+	for _, prefix := range lookup.Enforced {
+		result.BlockedBy = append(result.BlockedBy, firewall.PrefixString(prefix))
+	}
+
+	if lookup.Egress != nil {
+		result.Egress = &control.EgressCheck{
+			Blocked:     len(lookup.Egress.Enforced) > 0,
+			BlockedBy:   make([]string, 0, len(lookup.Egress.Enforced)),
+			Allowlisted: lookup.Egress.Allowlisted,
+		}
+
+		for _, prefix := range lookup.Egress.Enforced {
+			result.Egress.BlockedBy = append(result.Egress.BlockedBy, firewall.PrefixString(prefix))
+		}
+	}
+
+	for _, match := range lookup.Matches {
+		sourceMatch := control.SourceMatch{
+			Source:        match.Source,
+			Prefix:        firewall.PrefixString(match.Prefix),
+			Enabled:       d.pipe.Enabled(match.Source),
+			EgressEnabled: d.pipe.EgressEnabled(match.Source),
+		}
+
+		if match.Source == asnSource {
+			sourceMatch.Details = asnDetails(asnProvider, addr, match.Prefix)
+		}
+
+		if match.Source == abuseipdbSource {
+			sourceMatch.Details = abuseipdbDetails(abuseipdbProvider, match.Prefix)
+		}
+
+		result.Sources = append(result.Sources, sourceMatch)
+	}
+
+	control.WriteJSON(w, http.StatusOK, result)
+}
+
+// keyFile leaves out a key file that does not exist yet in dry-run, a key pair lasting until exit is used instead of creating it
+func (d *daemon) keyFile(path string) string {
+	if !d.dryRun {
+		return path
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		slog.Info("dry-run: would create key file, using a temporary key pair instead", "path", path)
+		return ""
+	}
+
+	return path
+}
+
+func newBlocker(conf config.Config) (firewall.Blocker, error) {
+	// validated together with the rest of the config, so it cannot fail here
+	scope, _ := conf.Scope.Parse()
+
+	switch conf.Backend {
+	case "firewalld":
+		return firewalld.New(firewalld.Config{
+			ZoneTargetPolicy: conf.ZoneTargetPolicy,
+			RuleAction:       conf.RuleAction,
+			Scope:            scope,
+			Egress:           conf.Egress.Enabled,
+			Gateway:          conf.Gateway.Parse(),
+			DropLog:          conf.DropLog.Parse(),
+			HitCounters:      conf.Hits.Enabled,
+		})
+	case "route":
+		// validated together with the rest of the config, the table and priority fit
+		return route.New(route.Config{
+			Table:      uint32(conf.Route.Table),
+			Priority:   uint32(conf.Route.Priority),
+			RuleAction: conf.RuleAction,
+		})
+	case "xdp":
+		return xdp.New(xdp.Config{
+			Interfaces: conf.XDP.Interfaces,
+			Mode:       conf.XDP.Mode,
+			MaxEntries: conf.XDP.MaxEntries,
+		})
+	case "dryrun":
+		slog.Warn("running in dry-run mode, the host firewall will not be modified")
+		b := memory.New(!conf.DryRunQuiet)
+		if !scope.IsZero() {
+			_ = b.SetScope(scope)
+		}
+
+		if conf.DropLog.Enabled {
+			_ = b.SetDropLog(conf.DropLog.Parse())
+		}
+
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unknown firewall backend %q", conf.Backend)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"time"
+
+	"github.com/MatejLach/dynafire/config"
+	"github.com/MatejLach/dynafire/metrics"
+	"github.com/MatejLach/dynafire/provider/local"
+	"github.com/MatejLach/dynafire/provider/logwatch"
+)
+
+// startDetector follows the logs of the detection rules, blocking the addresses they detect as local detections for the ban time of the rule
+func (d *daemon) startDetector(ctx context.Context, conf config.Detector) error {
+	detector, err := logwatch.New(conf.Parse())
+	if err != nil {
+		return fmt.Errorf("unable to initialize the log detector: %w", err)
+	}
+
+	slog.Info("watching logs for attacks", "rules", len(conf.Rules))
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		detector.Run(ctx, func(detection logwatch.Detection) {
+			metrics.LocalDetections.Inc(detection.Rule)
+			slog.Warn("detected attack", "IP", detection.Addr.String(), "rule", detection.Rule, "hits", detection.Hits, "ban_time", detection.BanTime)
+
+			// only fails once dynafire stops
+			_ = d.local.Add(ctx, local.Detection{
+				Prefix:  netip.PrefixFrom(detection.Addr, detection.Addr.BitLen()),
+				Reason:  detection.Rule,
+				Expires: time.Now().Add(detection.BanTime),
+			})
+		})
+	}()
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/MatejLach/dynafire/audit"
+	"github.com/MatejLach/dynafire/config"
+	"github.com/MatejLach/dynafire/droplog"
+	"github.com/MatejLach/dynafire/firewall"
+	"github.com/MatejLach/dynafire/metrics"
+)
+
+// startDropLog reads the packets the firewall logs before dropping them back from the kernel log,
+// recording each as a blocked attempt
+func (d *daemon) startDropLog(ctx context.Context, conf config.DropLog) {
+	parser := droplog.NewParser(conf.Parse())
+
+	var reader droplog.Reader
+	switch conf.Reader {
+	case "journald":
+		reader = droplog.NewJournaldReader(parser)
+	case "kmsg":
+		reader = droplog.NewKmsgReader(parser)
+	default:
+		return
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		err := reader.Run(ctx, d.recordAttempt)
+		if err != nil {
+			slog.Error("stopped reading blocked attempts from the kernel log", "reader", conf.Reader, "details", err)
+		}
+	}()
+}
+
+func (d *daemon) recordAttempt(event droplog.Event) {
+	addr := event.Blocked()
+	source := d.attemptSource(event)
+
+	metrics.BlockedAttempts.Inc(event.Direction.String(), source)
+	slog.Debug("blocked attempt", "IP", addr.String(), "direction", event.Direction, "source", source, "protocol", event.Protocol, "port", event.Port)
+
+	direction := ""
+	if event.Direction == firewall.Egress {
+		direction = audit.DirectionEgress
+	}
+
+	err := d.audit.Write(audit.Record{
+		Time:      event.Time,
+		Action:    audit.ActionAttempt,
+		Prefix:    addr.String(),
+		Direction: direction,
+		Source:    source,
+		Protocol:  event.Protocol,
+		Port:      event.Port,
+		Result:    audit.ResultBlocked,
+	})
+	if err != nil {
+		slog.Error("unable to write audit log", "details", err)
+	}
+}
+
+// attemptSource attributes a blocked attempt to the first enabled source listing the address, if any still does
+func (d *daemon) attemptSource(event droplog.Event) string {
+	for _, match := range d.pipe.Lookup(event.Blocked()).Matches {
+		enabled := d.pipe.Enabled(match.Source)
+		if event.Direction == firewall.Egress {
+			enabled = d.pipe.EgressEnabled(match.Source)
+		}
+
+		if enabled {
+			return match.Source
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"time"
+
+	"github.com/MatejLach/dynafire/config"
+	"github.com/MatejLach/dynafire/pipeline"
+	"github.com/MatejLach/dynafire/provider/geoip"
+)
+
+const geoipSource = "geoip"
+
+// startGeoIP feeds the prefixes of the configured countries into the pipeline, reloading them whenever the database changes
+func (d *daemon) startGeoIP(ctx context.Context, conf config.GeoIP) error {
+	provider, err := geoip.New(geoip.Config{
+		Database:      conf.Database,
+		Countries:     conf.Countries,
+		CheckInterval: conf.CheckInterval.Duration(),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to initialize GeoIP provider: %w", err)
+	}
+
+	d.geoip = provider
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		provider.Run(ctx)
+	}()
+
+	forwardLists(ctx, d, provider.ListChan, "GeoIP prefixes", func(list geoip.List) (pipeline.Origin, []netip.Prefix) {
+		slog.Info(fmt.Sprintf("blocking %d GeoIP prefixes", len(list.Prefixes)), "countries", list.Countries, "database_build", list.BuildTime)
+
+		return pipeline.Origin{Source: geoipSource, ListVersion: list.BuildTime.UTC().Format(time.RFC3339)}, list.Prefixes
+	})
+
+	return nil
+}
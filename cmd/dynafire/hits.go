@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MatejLach/dynafire/config"
+	"github.com/MatejLach/dynafire/control"
+	"github.com/MatejLach/dynafire/firewall"
+	"github.com/MatejLach/dynafire/metrics"
+	"github.com/MatejLach/dynafire/pipeline"
+)
+
+// hitTracker accumulates the sampled hit counters; the backend's counters start from zero again whenever
+// it reloads a whole list, so the tracker adds up the increments between samples instead of keeping the raw values
+type hitTracker struct {
+	mu      sync.Mutex
+	last    map[firewall.Target]firewall.Hits
+	total   map[firewall.Target]firewall.Hits
+	sampled time.Time
+}
+
+func newHitTracker() *hitTracker {
+	return &hitTracker{
+		last:  make(map[firewall.Target]firewall.Hits),
+		total: make(map[firewall.Target]firewall.Hits),
+	}
+}
+
+// add records a sample, targets missing from it are no longer blocked and are forgotten
+func (t *hitTracker) add(sample map[firewall.Target]firewall.Hits) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	total := make(map[firewall.Target]firewall.Hits, len(sample))
+	for target, hits := range sample {
+		sum := t.total[target]
+		last := t.last[target]
+		if hits.Packets >= last.Packets && hits.Bytes >= last.Bytes {
+			sum.Packets += hits.Packets - last.Packets
+			sum.Bytes += hits.Bytes - last.Bytes
+		} else {
+			sum.Packets += hits.Packets
+			sum.Bytes += hits.Bytes
+		}
+
+		total[target] = sum
+	}
+
+	t.last = sample
+	t.total = total
+	t.sampled = time.Now()
+}
+
+func (t *hitTracker) snapshot() (map[firewall.Target]firewall.Hits, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make(map[firewall.Target]firewall.Hits, len(t.total))
+	for target, hits := range t.total {
+		result[target] = hits
+	}
+
+	return result, t.sampled
+}
+
+// startHitSampler samples the backend's hit counters every conf.SampleInterval, feeding them to the pipeline and the metrics
+// and logging a summary of the most hit entries every conf.SummaryInterval
+func (d *daemon) startHitSampler(ctx context.Context, conf config.Hits) {
+	counter, ok := d.fwc.Backend().(firewall.HitCounter)
+	if !ok {
+		slog.Warn("the firewall backend does not count hits, hits.enabled has no effect", "backend", d.conf.Backend)
+		return
+	}
+
+	d.hits = newHitTracker()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		sampleTicker := time.NewTicker(conf.SampleInterval.Duration())
+		defer sampleTicker.Stop()
+
+		var summary <-chan time.Time
+		if conf.SummaryInterval > 0 {
+			summaryTicker := time.NewTicker(conf.SummaryInterval.Duration())
+			defer summaryTicker.Stop()
+			summary = summaryTicker.C
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sampleTicker.C:
+				sample, err := counter.Hits()
+				if err != nil {
+					slog.Warn("unable to read hit counters", "details", err)
+					continue
+				}
+
+				d.hits.add(sample)
+				d.reportHits(conf.Top)
+			case <-summary:
+				d.logHitSummary(conf.Top)
+			}
+		}
+	}()
+}
+
+// reportHits hands the accumulated hits to the pipeline and the metrics
+func (d *daemon) reportHits(top int) {
+	hits, _ := d.hits.snapshot()
+
+	packets := make(map[firewall.Target]uint64, len(hits))
+	for target, h := range hits {
+		packets[target] = h.Packets
+	}
+	d.pipe.SetHits(packets)
+
+	for _, direction := range []firewall.Direction{firewall.Ingress, firewall.Egress} {
+		var sum firewall.Hits
+		hit := 0
+		for target, h := range hits {
+			if target.Direction != direction {
+				continue
+			}
+
+			sum.Packets += h.Packets
+			sum.Bytes += h.Bytes
+			if h.Packets > 0 {
+				hit++
+			}
+		}
+
+		metrics.BlockHitPackets.Set(float64(sum.Packets), direction.String())
+		metrics.BlockHitBytes.Set(float64(sum.Bytes), direction.String())
+		metrics.BlocksHit.Set(float64(hit), direction.String())
+	}
+
+	metrics.TopBlockHits.Reset()
+	for _, entry := range topHits(hits, top) {
+		metrics.TopBlockHits.Set(float64(entry.hits.Packets), entry.target.Direction.String(), firewall.PrefixString(entry.target.Prefix))
+	}
+}
+
+func (d *daemon) logHitSummary(top int) {
+	hits, _ := d.hits.snapshot()
+
+	hit := 0
+	for _, h := range hits {
+		if h.Packets > 0 {
+			hit++
+		}
+	}
+
+	most := make([]string, 0, top)
+	for _, entry := range topHits(hits, top) {
+		name := firewall.PrefixString(entry.target.Prefix)
+		if entry.target.Direction == firewall.Egress {
+			name += " (egress)"
+		}
+
+		most = append(most, fmt.Sprintf("%s=%d", name, entry.hits.Packets))
+	}
+
+	slog.Info("block hits summary", "entries", len(hits), "hit", hit, "never_hit", len(hits)-hit, "top", strings.Join(most, ", "))
+}
+
+type targetHits struct {
+	target firewall.Target
+	hits   firewall.Hits
+}
+
+// topHits returns up to n entries with the most packets, leaving out those that were never hit
+func topHits(hits map[firewall.Target]firewall.Hits, n int) []targetHits {
+	result := make([]targetHits, 0, len(hits))
+	for target, h := range hits {
+		if h.Packets > 0 {
+			result = append(result, targetHits{target: target, hits: h})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].hits.Packets != result[j].hits.Packets {
+			return result[i].hits.Packets > result[j].hits.Packets
+		}
+
+		return result[i].target.Prefix.Addr().Less(result[j].target.Prefix.Addr())
+	})
+
+	return result[:min(n, len(result))]
+}
+
+func (d *daemon) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		control.WriteError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	query := r.URL.Query()
+	sortBy := query.Get("sort")
+	if sortBy != "" && sortBy != "prefix" && sortBy != "hits" {
+		control.WriteError(w, http.StatusBadRequest, fmt.Errorf("unknown sort order %q, expected prefix or hits", sortBy))
+		return
+	}
+
+	limit := 0
+	if s := query.Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			control.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", s))
+			return
+		}
+	}
+
+	result := control.ListResult{Entries: make([]control.ListEntry, 0)}
+
+	var hits map[firewall.Target]firewall.Hits
+	if d.hits != nil {
+		hits, result.SampledAt = d.hits.snapshot()
+		result.HitsCounted = true
+	}
+
+	entries := d.pipe.Entries()
+	sort.Slice(entries, func(i, j int) bool {
+		if sortBy == "hits" {
+			hitsI, hitsJ := hits[entries[i].Target].Packets, hits[entries[j].Target].Packets
+			if hitsI != hitsJ {
+				return hitsI > hitsJ
+			}
+		}
+
+		return lessEntry(entries[i], entries[j])
+	})
+
+	if limit > 0 {
+		entries = entries[:min(limit, len(entries))]
+	}
+
+	for _, entry := range entries {
+		h := hits[entry.Target]
+		result.Entries = append(result.Entries, control.ListEntry{
+			Prefix:    firewall.PrefixString(entry.Prefix),
+			Direction: entry.Direction.String(),
+			Sources:   entry.Sources,
+			Packets:   h.Packets,
+			Bytes:     h.Bytes,
+		})
+	}
+
+	control.WriteJSON(w, http.StatusOK, result)
+}
+
+func lessEntry(a, b pipeline.Entry) bool {
+	if a.Direction != b.Direction {
+		return a.Direction < b.Direction
+	}
+
+	if c := a.Prefix.Addr().Compare(b.Prefix.Addr()); c != 0 {
+		return c < 0
+	}
+
+	return a.Prefix.Bits() < b.Prefix.Bits()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os/signal"
+	"syscall"
+
+	"github.com/MatejLach/dynafire/config"
+	"github.com/MatejLach/dynafire/hub"
+)
+
+// runHub subscribes to the feed configured under turris and re-publishes it on the endpoint configured under hub,
+// instead of running the daemon
+func runHub(conf config.Config) int {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	h, err := hub.New(hub.Config{
+		ListenAddress:  conf.Hub.ListenAddress,
+		Port:           conf.Hub.Port,
+		KeyFile:        conf.Hub.KeyFile,
+		AllowedClients: conf.Hub.AllowedClients,
+		ListInterval:   conf.Hub.ListInterval.Duration(),
+	})
+	if err != nil {
+		slog.Error("Unable to start the hub", "details", err)
+		return 1
+	}
+
+	slog.Info("hub listening", "address", conf.Hub.ListenAddress, "port", conf.Hub.Port, "public_key", h.PublicKey(), "allowed_clients", len(conf.Hub.AllowedClients))
+
+	tc, err := newTurrisClient(conf.Turris)
+	if err != nil {
+		slog.Error("Unable to start the hub", "details", err)
+		return 1
+	}
+
+	slog.Info("Turris client initialized", "public_key", tc.PublicKey())
+
+	err = tc.Connect()
+	if err != nil {
+		slog.Error("Unable to connect to Turris firewall update server", "details", err)
+		return 1
+	}
+
+	go tc.RequestMessages(ctx)
+
+	h.Run(ctx, tc)
+
+	return 0
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MatejLach/dynafire/control"
+)
+
+func runList(args []string, controlSocket string) int {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	sortBy := fs.String("sort", "prefix", "sort order; prefix, or hits for the most hit entries first")
+	limit := fs.Int("limit", 0, "only show this many entries, 0 shows all")
+	asJSON := fs.Bool("json", false, "print the entries as JSON lines")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	query := url.Values{}
+	query.Set("sort", *sortBy)
+	query.Set("limit", fmt.Sprint(*limit))
+
+	var result control.ListResult
+	err = control.NewClient(controlSocket).Get("/list?"+query.Encode(), &result)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, entry := range result.Entries {
+			err = enc.Encode(entry)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+
+		return 0
+	}
+
+	if !result.HitsCounted {
+		fmt.Fprintln(os.Stderr, "hits are not counted, either hits.enabled is off or the firewall backend does not count them")
+	} else if !result.SampledAt.IsZero() {
+		fmt.Fprintf(os.Stderr, "hits as of %s\n", result.SampledAt.Local().Format(time.RFC3339))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PREFIX\tDIRECTION\tSOURCES\tPACKETS\tBYTES")
+	for _, entry := range result.Entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n", entry.Prefix, entry.Direction, strings.Join(entry.Sources, ","), entry.Packets, entry.Bytes)
+	}
+
+	err = w.Flush()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
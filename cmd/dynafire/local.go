@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/MatejLach/dynafire/config"
+	"github.com/MatejLach/dynafire/control"
+	"github.com/MatejLach/dynafire/firewall"
+	"github.com/MatejLach/dynafire/pipeline"
+	"github.com/MatejLach/dynafire/provider/local"
+)
+
+const localSource = "local"
+
+// startLocal feeds the detections of this host into the pipeline and shares them with the peers, if there are any
+func (d *daemon) startLocal(ctx context.Context) {
+	d.local = local.New()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.local.Run(ctx)
+	}()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		for {
+			var update local.Update
+			select {
+			case <-ctx.Done():
+				return
+			case update = <-d.local.UpdateChan:
+			}
+
+			origin := pipeline.Origin{Source: localSource}
+
+			if update.Removed {
+				slog.Info("lifting local block", "prefix", firewall.PrefixString(update.Prefix), "reason", update.Reason)
+			} else {
+				args := []interface{}{"prefix", firewall.PrefixString(update.Prefix), "reason", update.Reason}
+				if !update.Expires.IsZero() {
+					args = append(args, "expires", update.Expires)
+				}
+
+				slog.Info("blocking locally", args...)
+			}
+
+			if !d.updateSource(ctx, origin, update.Prefix, update.Removed, "local block") {
+				continue
+			}
+
+			if mesh := d.peers.Load(); mesh != nil {
+				if update.Removed {
+					mesh.Withdraw(ctx, update.Prefix)
+				} else {
+					mesh.Share(ctx, update.Prefix, update.Reason, update.Expires)
+				}
+			}
+		}
+	}()
+}
+
+func (d *daemon) handleBlock(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeBlockRequest(w, r)
+	if !ok {
+		return
+	}
+
+	prefixes, err := config.ParsePrefixes([]string{req.Prefix})
+	if err != nil {
+		control.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid IP or prefix: %w", err))
+		return
+	}
+
+	detection := local.Detection{Prefix: prefixes[0], Reason: local.ReasonManual}
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			control.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid TTL %q, expected a positive duration, i.e. 1h", req.TTL))
+			return
+		}
+
+		detection.Expires = time.Now().Add(ttl)
+	}
+
+	// the block outlives the request, so it is only given up on when the daemon stops
+	err = d.local.Add(d.ctx, detection)
+	if err != nil {
+		control.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("dynafire is stopping: %w", err))
+		return
+	}
+
+	control.WriteJSON(w, http.StatusOK, control.BlockResult{Prefix: firewall.PrefixString(detection.Prefix), Expires: detection.Expires})
+}
+
+func (d *daemon) handleUnblock(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeBlockRequest(w, r)
+	if !ok {
+		return
+	}
+
+	prefixes, err := config.ParsePrefixes([]string{req.Prefix})
+	if err != nil {
+		control.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid IP or prefix: %w", err))
+		return
+	}
+
+	prefix := prefixes[0]
+	removed, err := d.local.Remove(d.ctx, prefix)
+	if err != nil {
+		control.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("dynafire is stopping: %w", err))
+		return
+	}
+
+	if !removed {
+		control.WriteError(w, http.StatusNotFound, fmt.Errorf("%s is not blocked locally", firewall.PrefixString(prefix)))
+		return
+	}
+
+	result := control.BlockResult{Prefix: firewall.PrefixString(prefix), BlockedBy: make([]string, 0)}
+	for _, match := range d.pipe.Lookup(prefix.Addr()).Matches {
+		if match.Prefix == prefix && match.Source != localSource {
+			result.BlockedBy = append(result.BlockedBy, match.Source)
+		}
+	}
+
+	control.WriteJSON(w, http.StatusOK, result)
+}
+
+func decodeBlockRequest(w http.ResponseWriter, r *http.Request) (control.BlockRequest, bool) {
+	if r.Method != http.MethodPost {
+		control.WriteError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return control.BlockRequest{}, false
+	}
+
+	var req control.BlockRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err == nil && req.Prefix == "" {
+		err = errors.New("no prefix given")
+	}
+
+	if err != nil {
+		control.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return control.BlockRequest{}, false
+	}
+
+	return req, true
+}
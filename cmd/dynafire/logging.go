@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/MatejLach/dynafire/config"
+	"github.com/MatejLach/dynafire/logging"
+)
+
+// logLevels holds the level of every log output, so that they can be changed by a reload
+type logLevels struct {
+	text     slog.LevelVar
+	json     slog.LevelVar
+	journald slog.LevelVar
+	syslog   slog.LevelVar
+}
+
+func (l *logLevels) set(conf config.Config) {
+	l.text.Set(outputLevel(conf, conf.Log.Text.Level))
+	l.json.Set(outputLevel(conf, conf.Log.JSON.Level))
+	l.journald.Set(outputLevel(conf, conf.Log.Journald.Level))
+	l.syslog.Set(outputLevel(conf, conf.Log.Syslog.Level))
+}
+
+// outputLevel falls back to log_level for outputs without a level of their own
+func outputLevel(conf config.Config, level string) slog.Level {
+	if level == "" {
+		level = conf.LogLevel
+	}
+
+	return config.ParseLogLevel(level)
+}
+
+// newLogHandler sets up every log output enabled in conf
+func newLogHandler(conf config.Config, levels *logLevels) (slog.Handler, error) {
+	levels.set(conf)
+	handlers := make(logging.Multi, 0)
+
+	if conf.Log.Text.Enabled {
+		out, err := logFile(conf.Log.Text.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		handlers = append(handlers, slog.NewTextHandler(out, &slog.HandlerOptions{Level: &levels.text}))
+	}
+
+	if conf.Log.JSON.Enabled {
+		out, err := logFile(conf.Log.JSON.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		handlers = append(handlers, slog.NewJSONHandler(out, &slog.HandlerOptions{Level: &levels.json}))
+	}
+
+	if conf.Log.Journald.Enabled {
+		h, err := logging.NewJournaldHandler("", "dynafire", &levels.journald)
+		if err != nil {
+			return nil, err
+		}
+
+		handlers = append(handlers, h)
+	}
+
+	if conf.Log.Syslog.Enabled {
+		h, err := logging.NewSyslogHandler(logging.SyslogConfig{
+			Network:  conf.Log.Syslog.Network,
+			Address:  conf.Log.Syslog.Address,
+			Facility: conf.Log.Syslog.Facility,
+			Tag:      conf.Log.Syslog.Tag,
+		}, &levels.syslog)
+		if err != nil {
+			return nil, err
+		}
+
+		handlers = append(handlers, h)
+	}
+
+	if len(handlers) == 1 {
+		return handlers[0], nil
+	}
+
+	return handlers, nil
+}
+
+func logFile(path string) (*os.File, error) {
+	if path == "" {
+		return os.Stderr, nil
+	}
+
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+}
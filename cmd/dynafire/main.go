@@ -1,101 +1,127 @@
 package main
 
 import (
-	"context"
+	"flag"
 	"fmt"
 	"log/slog"
 	"os"
-	"sync"
-	"time"
 
-	"github.com/MatejLach/dynafire/firewall/firewalld"
-	"github.com/MatejLach/dynafire/provider/turris"
+	"github.com/MatejLach/dynafire/config"
 )
 
 func main() {
+	configPath := flag.String("config", "", "path of the config file (.json, .yaml or .toml), defaults to the first existing one of "+fmt.Sprint(config.DefaultPaths))
+	dryRun := flag.Bool("dry-run", false, "keep the blacklist in memory only, without touching the host firewall; same as --backend=dryrun")
+	configFlags := config.RegisterFlags(flag.CommandLine)
+	flag.Usage = usage
+	flag.Parse()
+
 	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
 
-	fwc, err := firewalld.New()
-	if err != nil {
-		slog.Error("Initialization failed; host system pre-requisites not met", "details", err)
-		os.Exit(1)
+	switch flag.Arg(0) {
+	case "", "hub":
+	case "status":
+		os.Exit(runStatus(controlSocket(*configPath, configFlags)))
+	case "reload":
+		os.Exit(runReload(controlSocket(*configPath, configFlags)))
+	case "check":
+		os.Exit(runCheck(flag.Args()[1:], controlSocket(*configPath, configFlags)))
+	case "list":
+		os.Exit(runList(flag.Args()[1:], controlSocket(*configPath, configFlags)))
+	case "block":
+		os.Exit(runBlock(flag.Args()[1:], controlSocket(*configPath, configFlags)))
+	case "unblock":
+		os.Exit(runUnblock(flag.Args()[1:], controlSocket(*configPath, configFlags)))
+	case "audit":
+		os.Exit(runAudit(flag.Args()[1:], clientConfig(*configPath, configFlags)))
+	case "config":
+		os.Exit(runConfig(flag.Args()[1:], *configPath, configFlags))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	loadConfig := func() (config.Config, error) {
+		conf, path, err := config.Load(*configPath, configFlags, false)
+		if *dryRun {
+			conf.Backend = "dryrun"
+		}
+
+		// the default config file is only created when the daemon is about to touch the host anyway
+		if path == "" && conf.Backend != "dryrun" {
+			conf, _, err = config.Load(*configPath, configFlags, true)
+		}
+
+		if err != nil {
+			return config.Config{}, err
+		}
+
+		return conf, nil
 	}
 
-	tc, err := turris.NewClient(turris.Url, turris.Port)
+	conf, err := loadConfig()
 	if err != nil {
-		slog.Error("Unable to initialize Turris dynafire client", "details", err)
+		slog.Error("Unable to load configuration", "details", err)
 		os.Exit(1)
 	}
 
-	err = tc.Connect()
+	levels := &logLevels{}
+	handler, err := newLogHandler(conf, levels)
 	if err != nil {
-		slog.Error("Unable to connect to Turris firewall update server", "details", err)
+		slog.Error("Unable to set up logging", "details", err)
 		os.Exit(1)
 	}
+	slog.SetDefault(slog.New(handler))
 
-	wg := sync.WaitGroup{}
-	sem := make(chan struct{}, 1)
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		tc.RequestMessages(context.Background())
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for listMsg := range tc.ListChan {
-			slog.Info(fmt.Sprintf("adding %d IPs to the blacklist", len(listMsg.Blacklist)))
-			sem <- struct{}{}
-
-			err = fwc.ResetFirewallRules()
-			if err != nil {
-				slog.Error("unable to clear old IP blacklist", "details", err)
-				os.Exit(1)
-			}
-
-			err = fwc.BlockIPList(listMsg.Blacklist)
-			if err != nil {
-				slog.Error("unable to initialize IP blacklist", "details", err)
-				os.Exit(1)
-			}
-			slog.Info("Starting to process delta updates...")
-			<-sem
-		}
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		for deltaMsg := range tc.DeltaChan {
-			sem <- struct{}{}
-
-			// 'positive' operation adds an IP to the blacklist
-			// 'negative' removes an existing IP from the blacklist
-			switch deltaMsg.Operation {
-			case "positive":
-				err = fwc.BlockIP(deltaMsg.IP)
-				if err != nil {
-					slog.Error("unable to blacklist IP", "details", err)
-					os.Exit(1)
-				}
-
-				slog.Debug("blacklisting", "IP", deltaMsg.IP.String())
-			case "negative":
-				err = fwc.UnblockIP(deltaMsg.IP)
-				if err != nil {
-					slog.Error("unable to whitelist IP", "details", err)
-					os.Exit(1)
-				}
-
-				slog.Debug("whitelisting", "IP", deltaMsg.IP.String())
-			}
-			time.Sleep(250 * time.Millisecond)
-			<-sem
-		}
-	}()
+	if flag.Arg(0) == "hub" {
+		os.Exit(runHub(conf))
+	}
+
+	runDaemon(conf, loadConfig, levels)
+}
+
+// clientConfig loads the config for commands that talk to the running daemon or read its files;
+// the config does not have to be valid for that, i.e. when it is being fixed up before a reload
+func clientConfig(path string, flags *config.Flags) config.Config {
+	conf, _, err := config.Load(path, flags, false)
+	if err != nil && !config.IsValidationError(err) {
+		slog.Warn("unable to load configuration, using the defaults", "details", err)
+		return config.Default()
+	}
+
+	return conf
+}
+
+// controlSocket finds the control socket of the running daemon
+func controlSocket(path string, flags *config.Flags) string {
+	conf := clientConfig(path, flags)
+	if conf.ControlSocket == "" {
+		return config.Default().ControlSocket
+	}
+
+	return conf.ControlSocket
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: dynafire [flags] [command]
+
+Without a command, dynafire runs the daemon.
+
+Commands:
+  status            show the state of the running daemon
+  reload            make the running daemon re-read its configuration, same as sending it SIGHUP
+  check <ip>        show whether the running daemon blocks an IP and which providers list it
+  list              list the blocked addresses and prefixes, see dynafire list -h
+  block <ip>        block an IP or prefix as a local detection, see dynafire block -h
+  unblock <ip>      lift a block added with dynafire block
+  audit             query the audit log of firewall changes, see dynafire audit -h
+  config validate   check the configuration without starting the daemon
+  hub               re-publish the Turris feed to other dynafire instances instead of running the daemon, see the hub settings
+
+Every setting can also be overridden by a DYNAFIRE_* environment variable, i.e. DYNAFIRE_LOG_LEVEL or DYNAFIRE_TURRIS_SERVER_PORT.
 
-	wg.Wait()
+Flags:
+`)
+	flag.PrintDefaults()
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/MatejLach/dynafire/config"
+	"github.com/MatejLach/dynafire/firewall"
+	"github.com/MatejLach/dynafire/pipeline"
+	"github.com/MatejLach/dynafire/provider/peers"
+)
+
+const peersSource = "peers"
+
+// startPeers shares the local detections with the peers and feeds theirs into the pipeline, each peer as a source of its own
+func (d *daemon) startPeers(ctx context.Context, conf config.Peers) error {
+	name := conf.Name
+	if name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("unable to determine the peer name, set peers.name: %w", err)
+		}
+
+		name = hostname
+	}
+
+	// validated together with the rest of the config, so it cannot fail here
+	allowlist, _ := config.ParsePrefixes(conf.Allowlist)
+
+	meshPeers := make([]peers.Peer, 0, len(conf.Peers))
+	for _, peer := range conf.Peers {
+		meshPeers = append(meshPeers, peers.Peer{Name: peer.Name, Endpoint: peer.Endpoint, PublicKey: peer.PublicKey})
+	}
+
+	mesh, err := peers.New(peers.Config{
+		Name:              name,
+		ListenAddress:     conf.ListenAddress,
+		Port:              conf.Port,
+		KeyFile:           d.keyFile(conf.KeyFile),
+		Peers:             meshPeers,
+		MaxTTL:            conf.MaxTTL.Duration(),
+		AnnounceInterval:  conf.AnnounceInterval.Duration(),
+		Allowlist:         allowlist,
+		MinPrefixLengthV4: conf.MinPrefixLengthV4,
+		MinPrefixLengthV6: conf.MinPrefixLengthV6,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to initialize peer sharing: %w", err)
+	}
+
+	slog.Info("sharing detections with peers", "name", name, "port", conf.Port, "public_key", mesh.PublicKey(), "peers", len(meshPeers))
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		mesh.Run(ctx)
+	}()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		for update := range mesh.UpdateChan {
+			origin := pipeline.Origin{Source: peersSource + "/" + update.Peer}
+
+			if update.Removed {
+				slog.Debug("lifting peer detection", "peer", update.Peer, "prefix", firewall.PrefixString(update.Prefix))
+			}
+
+			d.updateSource(ctx, origin, update.Prefix, update.Removed, "peer detection")
+		}
+	}()
+
+	// detections made before the mesh started are shared right away rather than with the next announcement
+	d.peers.Store(mesh)
+	for _, detection := range d.local.Detections() {
+		mesh.Share(ctx, detection.Prefix, detection.Reason, detection.Expires)
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/MatejLach/dynafire/control"
+)
+
+func runReload(controlSocket string) int {
+	var result control.ReloadResult
+	err := control.NewClient(controlSocket).Post("/reload", nil, &result)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if len(result.Applied) == 0 && len(result.RestartRequired) == 0 {
+		fmt.Println("configuration reloaded, nothing changed")
+		return 0
+	}
+
+	if len(result.Applied) > 0 {
+		fmt.Printf("applied:          %s\n", strings.Join(result.Applied, ", "))
+	}
+
+	if len(result.RestartRequired) > 0 {
+		fmt.Printf("restart required: %s\n", strings.Join(result.RestartRequired, ", "))
+	}
+
+	return 0
+}
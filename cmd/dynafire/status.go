@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/MatejLach/dynafire/control"
+)
+
+func runStatus(controlSocket string) int {
+	var status control.Status
+	err := control.NewClient(controlSocket).Get("/status", &status)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("state:               %s\n", status.State)
+	fmt.Printf("backend:             %s\n", status.Backend)
+	fmt.Printf("running since:       %s\n", status.StartedAt.Format(time.RFC3339))
+	fmt.Printf("pending changes:     %d\n", status.PendingChanges)
+	fmt.Printf("circuit breaker:     %s\n", status.BackendHealth.CircuitBreaker)
+
+	if status.BackendHealth.LastError != "" {
+		fmt.Printf("consecutive errors:  %d\n", status.BackendHealth.ConsecutiveFailures)
+		fmt.Printf("last error:          %s (%s, %s)\n", status.BackendHealth.LastError, status.BackendHealth.LastErrorClass, status.BackendHealth.LastErrorTime.Format(time.RFC3339))
+	}
+
+	if status.State != control.StateRunning {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"github.com/MatejLach/dynafire/config"
+	"github.com/MatejLach/dynafire/firewall"
+	"github.com/MatejLach/dynafire/metrics"
+	"github.com/MatejLach/dynafire/pipeline"
+	"github.com/MatejLach/dynafire/provider/turris"
+)
+
+const turrisSource = "turris"
+
+// startTurris connects to the Sentinel server and feeds its list, deltas and events into the pipeline
+func (d *daemon) startTurris(ctx context.Context, conf config.Turris) error {
+	conf.ClientKeyFile = d.keyFile(conf.ClientKeyFile)
+
+	tc, err := newTurrisClient(conf)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Turris client initialized", "public_key", tc.PublicKey())
+
+	err = tc.Connect()
+	if err != nil {
+		return fmt.Errorf("unable to connect to Turris firewall update server: %w", err)
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		tc.RequestMessages(ctx)
+	}()
+
+	// deltas are audited with the version of the list they apply to
+	var listVersion atomic.Value
+	listVersion.Store("")
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for listMsg := range tc.ListChan {
+			slog.Info(fmt.Sprintf("adding %d IPs to the blacklist", len(listMsg.Blacklist)))
+
+			prefixes := make([]netip.Prefix, 0, len(listMsg.Blacklist))
+			for _, ip := range listMsg.Blacklist {
+				if prefix, ok := firewall.PrefixFromIP(ip); ok {
+					prefixes = append(prefixes, prefix)
+				}
+			}
+
+			version := listMsg.Version.UTC().Format(time.RFC3339)
+			listVersion.Store(version)
+
+			origin := pipeline.Origin{Source: turrisSource, Serial: uint64(listMsg.Serial), ListVersion: version}
+			if !d.replaceSource(ctx, origin, prefixes, "IP blacklist") {
+				// the same list is taken again, as it was never reported as applied
+				tc.RefreshList()
+				continue
+			}
+
+			tc.ListApplied(listMsg)
+			slog.Info("Starting to process delta updates...")
+		}
+	}()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		for deltaMsg := range tc.DeltaChan {
+			prefix, ok := firewall.PrefixFromIP(deltaMsg.IP)
+			if !ok {
+				slog.Warn("skipping delta with invalid IP", "serial", deltaMsg.Serial)
+				continue
+			}
+
+			origin := pipeline.Origin{Source: turrisSource, Serial: uint64(deltaMsg.Serial), ListVersion: listVersion.Load().(string)}
+
+			// 'positive' operation adds an IP to the blacklist
+			// 'negative' removes an existing IP from the blacklist
+			var queued bool
+			switch deltaMsg.Operation {
+			case "positive":
+				slog.Debug("blacklisting", "IP", deltaMsg.IP.String())
+				queued = d.updateSource(ctx, origin, prefix, false, "delta update")
+			case "negative":
+				slog.Debug("whitelisting", "IP", deltaMsg.IP.String())
+				queued = d.updateSource(ctx, origin, prefix, true, "delta update")
+			default:
+				slog.Warn("skipping delta with unknown operation", "operation", deltaMsg.Operation, "serial", deltaMsg.Serial)
+			}
+
+			if queued {
+				tc.DeltaApplied(deltaMsg)
+			}
+		}
+	}()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		for eventMsg := range tc.EventChan {
+			metrics.TurrisEvents.Inc(eventMsg.Name)
+
+			action := tc.EventAction(eventMsg)
+			slog.Info("received Turris event", "event", eventMsg.Name, "timestamp", eventMsg.Timestamp, "action", action.String(), "data", eventMsg.Data)
+
+			switch action {
+			case turris.EventActionRefreshList:
+				tc.RefreshList()
+			case turris.EventActionReconnect:
+				tc.Reconnect()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// newTurrisClient creates the client of the Sentinel server, or of a hub, conf points at
+func newTurrisClient(conf config.Turris) (*turris.Client, error) {
+	keyConf := turris.ServerKeyConfig{
+		PublicKey:     conf.ServerPublicKey,
+		PublicKeyFile: conf.ServerPublicKeyFile,
+		Fingerprint:   conf.ServerKeyFingerprint,
+		Fetch:         conf.FetchServerKey,
+		CertUrl:       conf.CertUrl,
+	}
+
+	serverPubKey, err := turris.ResolveServerPubKey(keyConf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine Turris server public key: %w", err)
+	}
+
+	tc, err := turris.NewClient(turris.ClientConfig{
+		ServerUrl:       conf.ServerUrl,
+		ServerPort:      conf.ServerPort,
+		ServerPublicKey: serverPubKey,
+		Topics:          conf.Topics,
+		ClientKeyFile:   conf.ClientKeyFile,
+		ResolveServerPublicKey: func() (string, error) {
+			return turris.ResolveServerPubKey(keyConf)
+		},
+		RefreshListEvents: conf.RefreshListEvents,
+		ReconnectEvents:   conf.ReconnectEvents,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize Turris dynafire client: %w", err)
+	}
+
+	return tc, nil
+}
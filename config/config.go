@@ -0,0 +1,745 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/MatejLach/dynafire/firewall"
+	"github.com/MatejLach/dynafire/firewall/route"
+	"github.com/MatejLach/dynafire/firewall/xdp"
+	"github.com/MatejLach/dynafire/provider/logwatch"
+)
+
+const DefaultDir = "/etc/dynafire"
+
+// DefaultPaths are tried in order when no config file is given explicitly
+var DefaultPaths = []string{
+	filepath.Join(DefaultDir, "config.json"),
+	filepath.Join(DefaultDir, "config.yaml"),
+	filepath.Join(DefaultDir, "config.yml"),
+	filepath.Join(DefaultDir, "config.toml"),
+}
+
+// Config is the dynafire configuration, see the Configuration section of README.md for the documented schema
+// Values are layered: defaults, then the config file, then DYNAFIRE_* environment variables, then command line flags
+type Config struct {
+	Backend              string       `json:"backend"`
+	DryRunQuiet          bool         `json:"dry_run_quiet"`
+	LogLevel             string       `json:"log_level"`
+	Log                  Log          `json:"log"`
+	ZoneTargetPolicy     string       `json:"zone_target_policy"`
+	Route                Route        `json:"route"`
+	XDP                  XDP          `json:"xdp"`
+	MetricsListenAddress string       `json:"metrics_listen_address"`
+	ControlSocket        string       `json:"control_socket"`
+	RuleAction           string       `json:"rule_action"`
+	Scope                Scope        `json:"scope"`
+	DropLog              DropLog      `json:"drop_log"`
+	Providers            []string     `json:"providers"`
+	Allowlist            []string     `json:"allowlist"`
+	Egress               Egress       `json:"egress"`
+	Containers           Containers   `json:"containers"`
+	Gateway              Gateway      `json:"gateway"`
+	Turris               Turris       `json:"turris"`
+	Hub                  Hub          `json:"hub"`
+	Peers                Peers        `json:"peers"`
+	Detector             Detector     `json:"detector"`
+	CrowdSec             CrowdSec     `json:"crowdsec"`
+	AbuseIPDB            AbuseIPDB    `json:"abuseipdb"`
+	GeoIP                GeoIP        `json:"geoip"`
+	ASN                  ASN          `json:"asn"`
+	Queue                Queue        `json:"queue"`
+	BackendPolicy        BackendRetry `json:"backend_policy"`
+	Audit                Audit        `json:"audit"`
+	Hits                 Hits         `json:"hits"`
+}
+
+// Log selects the log outputs; an empty level falls back to log_level
+type Log struct {
+	Text     LogStream `json:"text"`
+	JSON     LogStream `json:"json"`
+	Journald LogOutput `json:"journald"`
+	Syslog   Syslog    `json:"syslog"`
+}
+
+type LogOutput struct {
+	Enabled bool   `json:"enabled"`
+	Level   string `json:"level"`
+}
+
+type LogStream struct {
+	Enabled bool   `json:"enabled"`
+	Level   string `json:"level"`
+	// Path of the file to append to, empty writes to stderr
+	Path string `json:"path"`
+}
+
+type Syslog struct {
+	Enabled bool   `json:"enabled"`
+	Level   string `json:"level"`
+	// Network is unixgram or unix for a local socket, udp or tcp for a remote server
+	Network  string `json:"network"`
+	Address  string `json:"address"`
+	Facility string `json:"facility"`
+	Tag      string `json:"tag"`
+}
+
+// Scope limits blocking to some destinations on the host, leaving everything empty blocks all traffic from listed sources
+type Scope struct {
+	// Ports are written as i.e. "22/tcp" or "5000-5100/udp"
+	Ports     []string `json:"ports"`
+	Protocols []string `json:"protocols"`
+	// Services are firewalld service names, i.e. "ssh"
+	Services []string `json:"services"`
+}
+
+// Parse turns the scope into its firewall representation
+func (s Scope) Parse() (firewall.Scope, error) {
+	scope := firewall.Scope{
+		Ports:     make([]firewall.Port, 0, len(s.Ports)),
+		Protocols: s.Protocols,
+		Services:  s.Services,
+	}
+
+	for _, entry := range s.Ports {
+		port, err := firewall.ParsePort(entry)
+		if err != nil {
+			return firewall.Scope{}, err
+		}
+
+		scope.Ports = append(scope.Ports, port)
+	}
+
+	return scope, nil
+}
+
+// Egress blocks outbound and forwarded traffic to the prefixes listed by some providers, i.e. to stop compromised hosts calling home
+type Egress struct {
+	Enabled bool `json:"enabled"`
+	// Providers are the sources blocked in the egress direction, a subset of the enabled providers is typical but not required
+	Providers []string `json:"providers"`
+	// Allowlist is separate from the ingress one, so destinations can be reachable while still refusing connections from them
+	Allowlist []string `json:"allowlist"`
+}
+
+// Gateway blocks traffic the host forwards between its interfaces as well, for routers and hypervisor hosts
+type Gateway struct {
+	Enabled bool `json:"enabled"`
+	// IngressInterfaces face the blocked addresses, i.e. the uplink, empty means any
+	IngressInterfaces []string `json:"ingress_interfaces"`
+	// EgressInterfaces lead to the protected hosts, i.e. the LAN or the VM bridge, empty means any
+	EgressInterfaces []string `json:"egress_interfaces"`
+}
+
+// Parse turns the gateway settings into their firewall representation
+func (g Gateway) Parse() firewall.Gateway {
+	return firewall.Gateway{Enabled: g.Enabled, IngressInterfaces: g.IngressInterfaces, EgressInterfaces: g.EgressInterfaces}
+}
+
+// DropLog logs packets of blocked sources to the kernel log before dropping them, and reads them back as blocked attempts
+type DropLog struct {
+	Enabled bool   `json:"enabled"`
+	Prefix  string `json:"prefix"`
+	// Rate limits the logged packets per blocked address, as a count per s, m, h or d, i.e. "10/m"
+	Rate string `json:"rate"`
+	// Reader is where the logged packets are read back from; journald, kmsg, or none to leave them to other tools
+	Reader string `json:"reader"`
+}
+
+// Parse turns the drop log settings into their firewall representation, the zero DropLog when disabled
+func (l DropLog) Parse() firewall.DropLog {
+	if !l.Enabled {
+		return firewall.DropLog{}
+	}
+
+	return firewall.DropLog{Prefix: l.Prefix, Rate: l.Rate}
+}
+
+// Route holds the settings of the route backend
+type Route struct {
+	// Table is the routing table dedicated to the blackhole routes
+	Table int `json:"table"`
+	// Priority is the priority of the policy rule looking the table up, it has to come before the main table's
+	Priority int `json:"priority"`
+}
+
+// XDP holds the settings of the xdp backend
+type XDP struct {
+	// Interfaces are the interfaces the XDP program drops packets arriving on
+	Interfaces []string `json:"interfaces"`
+	// Mode is auto, native or generic; generic works with any driver, at the cost of most of the speed
+	Mode string `json:"mode"`
+	// MaxEntries caps the blocked prefixes per address family
+	MaxEntries int `json:"max_entries"`
+}
+
+// Containers extends blocking to traffic forwarded to containers, which bypasses the host's own rules
+type Containers struct {
+	// Runtimes are docker and/or podman, empty disables the integration
+	Runtimes      []string `json:"runtimes"`
+	CheckInterval Duration `json:"check_interval"`
+}
+
+type Turris struct {
+	ServerUrl            string   `json:"server_url"`
+	ServerPort           int      `json:"server_port"`
+	CertUrl              string   `json:"cert_url"`
+	Topics               []string `json:"topics"`
+	ClientKeyFile        string   `json:"client_key_file"`
+	ServerPublicKey      string   `json:"server_public_key"`
+	ServerPublicKeyFile  string   `json:"server_public_key_file"`
+	ServerKeyFingerprint string   `json:"server_key_fingerprint"`
+	FetchServerKey       bool     `json:"fetch_server_key"`
+	// RefreshListEvents and ReconnectEvents are the names of the dynfw/event messages that call for a list refresh or a reconnect;
+	// Sentinel does not document its event names, so none are acted upon by default
+	RefreshListEvents []string `json:"refresh_list_events"`
+	ReconnectEvents   []string `json:"reconnect_events"`
+}
+
+// Hub is the endpoint dynafire hub re-publishes the Turris feed on, agents connect to it as their turris.server_url
+type Hub struct {
+	ListenAddress string `json:"listen_address"`
+	Port          int    `json:"port"`
+	// KeyFile stores the hub's CURVE key pair, it is created if it does not exist; its public key is the agents' turris.server_public_key
+	KeyFile string `json:"key_file"`
+	// AllowedClients are the Z85 encoded public keys of the agents, empty allows any client that knows the hub's key
+	AllowedClients []string `json:"allowed_clients"`
+	// ListInterval is how often the full list is re-published, for agents that lost a delta and wait for the next list
+	ListInterval Duration `json:"list_interval"`
+}
+
+// Peers shares the local detections with other dynafire instances and receives theirs, while peers is in providers
+type Peers struct {
+	// Name identifies this instance to its peers, empty uses the hostname
+	Name          string `json:"name"`
+	ListenAddress string `json:"listen_address"`
+	Port          int    `json:"port"`
+	// KeyFile stores this instance's CURVE key pair, it is created if it does not exist
+	KeyFile string `json:"key_file"`
+	Peers   []Peer `json:"peers"`
+	// MaxTTL caps how long a detection received from a peer is blocked for
+	MaxTTL           Duration `json:"max_ttl"`
+	AnnounceInterval Duration `json:"announce_interval"`
+	// Allowlist are the addresses and prefixes never accepted from peers, on top of the allowlist
+	Allowlist []string `json:"allowlist"`
+	// MinPrefixLengthV4 and MinPrefixLengthV6 are the shortest prefixes accepted from peers, so that a peer cannot block whole networks
+	MinPrefixLengthV4 int `json:"min_prefix_length_v4"`
+	MinPrefixLengthV6 int `json:"min_prefix_length_v6"`
+}
+
+type Peer struct {
+	Name string `json:"name"`
+	// Endpoint is the host:port the peer listens on
+	Endpoint  string `json:"endpoint"`
+	PublicKey string `json:"public_key"`
+}
+
+// Detector blocks the addresses found misbehaving in the logs of the host, as local detections
+type Detector struct {
+	Enabled bool `json:"enabled"`
+	// MaxHits, Window and BanTime apply to the rules not setting their own
+	MaxHits int            `json:"max_hits"`
+	Window  Duration       `json:"window"`
+	BanTime Duration       `json:"ban_time"`
+	Rules   []DetectorRule `json:"rules"`
+}
+
+type DetectorRule struct {
+	Name string `json:"name"`
+	// JournaldUnit or File is the log the rule reads
+	JournaldUnit string `json:"journald_unit"`
+	File         string `json:"file"`
+	// Patterns are regular expressions capturing the address as <HOST> or a group named host
+	Patterns []string `json:"patterns"`
+	MaxHits  int      `json:"max_hits"`
+	Window   Duration `json:"window"`
+	BanTime  Duration `json:"ban_time"`
+}
+
+// Parse returns the rules with the defaults of the detector filled in
+func (d Detector) Parse() []logwatch.Rule {
+	rules := make([]logwatch.Rule, 0, len(d.Rules))
+	for _, r := range d.Rules {
+		rule := logwatch.Rule{
+			Name:         r.Name,
+			JournaldUnit: r.JournaldUnit,
+			File:         r.File,
+			Patterns:     r.Patterns,
+			MaxHits:      d.MaxHits,
+			Window:       d.Window.Duration(),
+			BanTime:      d.BanTime.Duration(),
+		}
+
+		if r.MaxHits > 0 {
+			rule.MaxHits = r.MaxHits
+		}
+
+		if r.Window > 0 {
+			rule.Window = r.Window.Duration()
+		}
+
+		if r.BanTime > 0 {
+			rule.BanTime = r.BanTime.Duration()
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// CrowdSec makes dynafire a bouncer of a CrowdSec Local API, enforcing its ban decisions while crowdsec is in providers
+type CrowdSec struct {
+	LAPIUrl string `json:"lapi_url"`
+	// APIKey is the bouncer key from cscli bouncers add, APIKeyFile takes precedence and keeps it out of the config
+	APIKey       string   `json:"api_key"`
+	APIKeyFile   string   `json:"api_key_file"`
+	PollInterval Duration `json:"poll_interval"`
+	// Scopes are ip, range or both
+	Scopes []string `json:"scopes"`
+	// Origins select the decisions by origin, i.e. crowdsec, cscli or CAPI; empty takes all of them
+	Origins []string `json:"origins"`
+}
+
+// AbuseIPDB fetches the blacklist of an AbuseIPDB compatible reputation API while abuseipdb is in providers
+type AbuseIPDB struct {
+	URL string `json:"url"`
+	// APIKeyFile takes precedence over APIKey and keeps it out of the config
+	APIKey     string `json:"api_key"`
+	APIKeyFile string `json:"api_key_file"`
+	// ConfidenceMinimum is the lowest abuse confidence score blocked, 25 to 100
+	ConfidenceMinimum int      `json:"confidence_minimum"`
+	Limit             int      `json:"limit"`
+	RefreshInterval   Duration `json:"refresh_interval"`
+	CacheFile         string   `json:"cache_file"`
+}
+
+type GeoIP struct {
+	// Database is a MaxMind format country database, i.e. GeoLite2-Country.mmdb or dbip-country-lite.mmdb
+	Database      string   `json:"database"`
+	Countries     []string `json:"countries"`
+	CheckInterval Duration `json:"check_interval"`
+}
+
+type ASN struct {
+	// ASNs are autonomous system numbers, with or without the AS prefix, i.e. "AS64500" or "64500"
+	ASNs []string `json:"asns"`
+	// Database is a MaxMind format ASN database, i.e. GeoLite2-ASN.mmdb, it takes precedence over PrefixFile
+	Database string `json:"database"`
+	// PrefixFile is a prefix to AS dump, i.e. CAIDA's routeviews pfx2as
+	PrefixFile      string   `json:"prefix_file"`
+	RefreshInterval Duration `json:"refresh_interval"`
+}
+
+type Queue struct {
+	Size          int      `json:"size"`
+	BatchSize     int      `json:"batch_size"`
+	FlushInterval Duration `json:"flush_interval"`
+}
+
+type BackendRetry struct {
+	RetryAttempts    int      `json:"retry_attempts"`
+	BreakerThreshold int      `json:"breaker_threshold"`
+	BreakerCooldown  Duration `json:"breaker_cooldown"`
+}
+
+// Hits counts the packets hitting every enforced entry, where the backend supports it
+type Hits struct {
+	Enabled         bool     `json:"enabled"`
+	SampleInterval  Duration `json:"sample_interval"`
+	SummaryInterval Duration `json:"summary_interval"`
+	// Top is the number of most hit entries in the summary and the metrics
+	Top int `json:"top"`
+	// MaxEntries limits the enforced set of each direction, leaving out entries that never hit first; 0 is unlimited
+	MaxEntries int `json:"max_entries"`
+}
+
+type Audit struct {
+	// Path of the JSON lines audit log, empty disables auditing
+	Path       string `json:"path"`
+	MaxSizeMB  int    `json:"max_size_mb"`
+	MaxBackups int    `json:"max_backups"`
+}
+
+// Duration is a time.Duration written as a string such as "2s" or "1m30s" in config files
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+
+	return nil
+}
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func Default() Config {
+	return Config{
+		Backend:          "firewalld",
+		LogLevel:         "INFO",
+		ZoneTargetPolicy: "ACCEPT",
+		ControlSocket:    "/run/dynafire/control.sock",
+		RuleAction:       "drop",
+		Providers:        []string{"turris", "local"},
+		Allowlist:        []string{},
+		DropLog: DropLog{
+			Prefix: "dynafire",
+			Rate:   "10/m",
+			Reader: "journald",
+		},
+		Route: Route{
+			Table:    route.DefaultTable,
+			Priority: route.DefaultPriority,
+		},
+		XDP: XDP{
+			Interfaces: []string{},
+			Mode:       xdp.ModeAuto,
+			MaxEntries: xdp.DefaultMaxEntries,
+		},
+		Containers: Containers{
+			Runtimes:      []string{},
+			CheckInterval: Duration(30 * time.Second),
+		},
+		Gateway: Gateway{
+			IngressInterfaces: []string{},
+			EgressInterfaces:  []string{},
+		},
+		Egress: Egress{
+			Providers: []string{"turris"},
+			Allowlist: []string{},
+		},
+		Scope: Scope{
+			Ports:     []string{},
+			Protocols: []string{},
+			Services:  []string{},
+		},
+		Log: Log{
+			Text: LogStream{Enabled: true},
+			Syslog: Syslog{
+				Network:  "unixgram",
+				Address:  "/dev/log",
+				Facility: "daemon",
+				Tag:      "dynafire",
+			},
+		},
+		Turris: Turris{
+			ServerUrl:         "sentinel.turris.cz",
+			ServerPort:        7087,
+			CertUrl:           "https://repo.turris.cz/sentinel/dynfw.pub",
+			Topics:            []string{"dynfw/"},
+			ClientKeyFile:     filepath.Join(DefaultDir, "turris_client.key"),
+			RefreshListEvents: []string{},
+			ReconnectEvents:   []string{},
+		},
+		Hub: Hub{
+			ListenAddress:  "*",
+			Port:           7087,
+			KeyFile:        filepath.Join(DefaultDir, "hub.key"),
+			AllowedClients: []string{},
+			ListInterval:   Duration(5 * time.Minute),
+		},
+		Peers: Peers{
+			ListenAddress:     "*",
+			Port:              7088,
+			KeyFile:           filepath.Join(DefaultDir, "peer.key"),
+			Peers:             []Peer{},
+			MaxTTL:            Duration(24 * time.Hour),
+			AnnounceInterval:  Duration(5 * time.Minute),
+			Allowlist:         []string{},
+			MinPrefixLengthV4: 24,
+			MinPrefixLengthV6: 48,
+		},
+		Detector: Detector{
+			MaxHits: 5,
+			Window:  Duration(10 * time.Minute),
+			BanTime: Duration(time.Hour),
+			Rules:   []DetectorRule{},
+		},
+		CrowdSec: CrowdSec{
+			LAPIUrl:      "http://127.0.0.1:8080",
+			PollInterval: Duration(10 * time.Second),
+			Scopes:       []string{"ip", "range"},
+			Origins:      []string{},
+		},
+		AbuseIPDB: AbuseIPDB{
+			URL:               "https://api.abuseipdb.com/api/v2/blacklist",
+			ConfidenceMinimum: 100,
+			Limit:             10000,
+			RefreshInterval:   Duration(6 * time.Hour),
+			CacheFile:         "/var/cache/dynafire/abuseipdb.json",
+		},
+		GeoIP: GeoIP{
+			Database:      "/usr/share/GeoIP/GeoLite2-Country.mmdb",
+			Countries:     []string{},
+			CheckInterval: Duration(time.Minute),
+		},
+		ASN: ASN{
+			ASNs:            []string{},
+			RefreshInterval: Duration(24 * time.Hour),
+		},
+		Queue: Queue{
+			Size:          4096,
+			BatchSize:     256,
+			FlushInterval: Duration(2 * time.Second),
+		},
+		BackendPolicy: BackendRetry{
+			RetryAttempts:    4,
+			BreakerThreshold: 5,
+			BreakerCooldown:  Duration(time.Minute),
+		},
+		Audit: Audit{
+			Path:       "/var/log/dynafire/audit.log",
+			MaxSizeMB:  50,
+			MaxBackups: 5,
+		},
+		Hits: Hits{
+			SampleInterval:  Duration(time.Minute),
+			SummaryInterval: Duration(time.Hour),
+			Top:             10,
+		},
+	}
+}
+
+// FindFile returns the first existing default config file, or an empty string if there is none
+func FindFile() string {
+	for _, path := range DefaultPaths {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// ReadFile layers the config file at path on top of the defaults
+// The format is picked by extension: .json, .yaml/.yml or .toml; unknown keys are an error
+func ReadFile(path string) (Config, error) {
+	conf := Default()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = decode(path, data, &conf)
+	if err != nil {
+		return Config{}, fmt.Errorf("unable to parse config file %s: %w", path, err)
+	}
+
+	return conf, nil
+}
+
+func decode(path string, data []byte, conf *Config) error {
+	var jsonData []byte
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		jsonData = data
+	case ".yaml", ".yml":
+		raw := make(map[string]interface{})
+		err := yaml.Unmarshal(data, &raw)
+		if err != nil {
+			return err
+		}
+
+		jsonData, err = json.Marshal(raw)
+		if err != nil {
+			return err
+		}
+	case ".toml":
+		raw := make(map[string]interface{})
+		err := toml.Unmarshal(data, &raw)
+		if err != nil {
+			return err
+		}
+
+		jsonData, err = json.Marshal(raw)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported config file format %q, expected .json, .yaml, .yml or .toml", filepath.Ext(path))
+	}
+
+	// YAML and TOML go through JSON as well, so that all formats share one schema and the same strictness
+	dec := json.NewDecoder(bytes.NewReader(jsonData))
+	dec.DisallowUnknownFields()
+
+	return dec.Decode(conf)
+}
+
+// WriteDefault saves the default config as JSON to path, readable by root only
+func WriteDefault(path string) error {
+	jsonBytes, err := json.MarshalIndent(Default(), "", "    ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(jsonBytes, '\n'), 0600)
+}
+
+// Load resolves the config file and applies environment and flag overrides on top of it, then validates the result
+// When path is empty and no default config file exists, one is created with the defaults if createDefault is set,
+// otherwise only the defaults are used
+func Load(path string, flags *Flags, createDefault bool) (Config, string, error) {
+	if path == "" {
+		path = FindFile()
+		if path == "" && !createDefault {
+			return finish(Default(), "", flags)
+		}
+
+		if path == "" {
+			path = DefaultPaths[0]
+			slog.Info("No config file found, creating new config...", "path", path)
+
+			err := WriteDefault(path)
+			if err != nil {
+				return Config{}, path, fmt.Errorf("unable to save default config file: %w", err)
+			}
+
+			slog.Info("New config created, feel free to modify the defaults, then reload dynafire for your changes to take effect.", "path", path)
+		}
+	}
+
+	conf, err := ReadFile(path)
+	if err != nil {
+		return Config{}, path, err
+	}
+
+	return finish(conf, path, flags)
+}
+
+func finish(conf Config, path string, flags *Flags) (Config, string, error) {
+	errs := ApplyEnv(&conf, os.Environ())
+	if flags != nil {
+		errs = append(errs, flags.Apply(&conf)...)
+	}
+
+	errs = append(errs, conf.validate()...)
+	if len(errs) > 0 {
+		// the invalid config is still returned, so that i.e. the control socket can be found to talk to a running daemon
+		return conf, path, ValidationErrors(errs)
+	}
+
+	return conf, path, nil
+}
+
+// ParsePrefixes parses a list of addresses and CIDR prefixes, single addresses become host prefixes
+func ParsePrefixes(list []string) ([]netip.Prefix, error) {
+	result := make([]netip.Prefix, 0, len(list))
+	for _, entry := range list {
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, err
+			}
+
+			result = append(result, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		addr = addr.Unmap()
+		result = append(result, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return result, nil
+}
+
+// Diff returns the keys of every setting that differs between old and new, i.e. "log_level" or "turris.server_port"
+func Diff(old, new Config) []string {
+	oldFields := leafFields(&old)
+	newFields := leafFields(&new)
+
+	changed := make([]string, 0)
+	for i, f := range oldFields {
+		if !reflect.DeepEqual(f.value.Interface(), newFields[i].value.Interface()) {
+			changed = append(changed, f.configKey())
+		}
+	}
+
+	return changed
+}
+
+// Merge returns base with the settings of keys taken from from, i.e. the part of a reload that was applied before it failed
+func Merge(base, from Config, keys []string) Config {
+	baseFields := leafFields(&base)
+	fromFields := leafFields(&from)
+
+	for i, f := range baseFields {
+		if slices.Contains(keys, f.configKey()) {
+			f.value.Set(fromFields[i].value)
+		}
+	}
+
+	return base
+}
+
+// ValidationErrors holds every problem found in a config, so that they can all be fixed in one go
+type ValidationErrors []error
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(v))
+	for _, err := range v {
+		msgs = append(msgs, err.Error())
+	}
+
+	return fmt.Sprintf("invalid configuration:\n  - %s", strings.Join(msgs, "\n  - "))
+}
+
+func (v ValidationErrors) Unwrap() []error {
+	return v
+}
+
+// IsValidationError reports whether err was caused by an invalid configuration, rather than i.e. an unreadable file
+func IsValidationError(err error) bool {
+	var v ValidationErrors
+	return errors.As(err, &v)
+}
+
+func ParseLogLevel(level string) slog.Level {
+	switch strings.ToUpper(level) {
+	case "DEBUG":
+		return slog.LevelDebug
+	case "INFO":
+		return slog.LevelInfo
+	case "WARN", "WARNING":
+		return slog.LevelWarn
+	case "ERROR":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
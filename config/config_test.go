@@ -0,0 +1,115 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("default config is invalid: %v", err)
+	}
+
+	conf := Default()
+	conf.Backend = "iptables"
+	conf.Turris.ServerPort = 0
+	conf.Turris.Topics = []string{"dynfw/delta"}
+	conf.Queue.BatchSize = 0
+
+	err := conf.Validate()
+	if !IsValidationError(err) {
+		t.Fatalf("Validate() = %v, want a validation error", err)
+	}
+
+	var errs ValidationErrors
+	errors.As(err, &errs)
+	if len(errs) != 4 {
+		t.Fatalf("Validate() found %d problems, want every one of the 4:\n%v", len(errs), err)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "dynafire.json", data: `{"log_level": "DEBUG", "queue": {"flush_interval": "5s"}}`},
+		{name: "dynafire.yaml", data: "log_level: DEBUG\nqueue:\n  flush_interval: 5s\n"},
+		{name: "dynafire.toml", data: "log_level = \"DEBUG\"\n[queue]\nflush_interval = \"5s\"\n"},
+		{name: "unknown.json", data: `{"log_levle": "DEBUG"}`, wantErr: true},
+		{name: "dynafire.ini", data: "log_level = DEBUG", wantErr: true},
+	}
+
+	dir := t.TempDir()
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		if err := os.WriteFile(path, []byte(test.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		conf, err := ReadFile(path)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: ReadFile() succeeded, want an error", test.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if conf.LogLevel != "DEBUG" || conf.Queue.FlushInterval.Duration() != 5*time.Second {
+			t.Errorf("%s: log_level %q, flush_interval %v, want DEBUG and 5s", test.name, conf.LogLevel, conf.Queue.FlushInterval.Duration())
+		}
+
+		// settings missing from the file keep their defaults
+		if conf.Queue.Size != Default().Queue.Size {
+			t.Errorf("%s: queue.size %d, want the default %d", test.name, conf.Queue.Size, Default().Queue.Size)
+		}
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	conf := Default()
+
+	errs := ApplyEnv(&conf, []string{"DYNAFIRE_TURRIS_SERVER_PORT=7000", "DYNAFIRE_TURRIS_TOPICS=dynfw/list, dynfw/delta", "PATH=/usr/bin"})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	fs := flag.NewFlagSet("dynafire", flag.ContinueOnError)
+	flags := RegisterFlags(fs)
+	if err := fs.Parse([]string{"--turris.server-port", "7001", "--queue.flush-interval", "1m"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := flags.Apply(&conf); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	// flags are applied after the environment and win over it
+	if conf.Turris.ServerPort != 7001 {
+		t.Errorf("turris.server_port %d, want the flag value 7001", conf.Turris.ServerPort)
+	}
+
+	if want := []string{"dynfw/list", "dynfw/delta"}; !slices.Equal(conf.Turris.Topics, want) {
+		t.Errorf("turris.topics %q, want %q", conf.Turris.Topics, want)
+	}
+
+	if conf.Queue.FlushInterval.Duration() != time.Minute {
+		t.Errorf("queue.flush_interval %v, want 1m", conf.Queue.FlushInterval.Duration())
+	}
+
+	errs = ApplyEnv(&conf, []string{"DYNAFIRE_TURRIS_SERVER_PORT=port"})
+	if len(errs) != 1 {
+		t.Fatalf("ApplyEnv() with an invalid number = %v, want one error", errs)
+	}
+}
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"encoding"
+	"flag"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const EnvPrefix = "DYNAFIRE_"
+
+// field is a single leaf setting of Config, addressed by the JSON names leading up to it
+type field struct {
+	path  []string
+	value reflect.Value
+}
+
+func (f field) envName() string {
+	return EnvPrefix + strings.ToUpper(strings.Join(f.path, "_"))
+}
+
+func (f field) flagName() string {
+	return strings.ReplaceAll(strings.Join(f.path, "."), "_", "-")
+}
+
+func (f field) configKey() string {
+	return strings.Join(f.path, ".")
+}
+
+func leafFields(conf *Config) []field {
+	return walkFields(reflect.ValueOf(conf).Elem(), nil)
+}
+
+func walkFields(v reflect.Value, path []string) []field {
+	result := make([]field, 0)
+
+	textUnmarshaler := reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		name, _, _ := strings.Cut(structField.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		fieldPath := append(append([]string(nil), path...), name)
+		fieldValue := v.Field(i)
+
+		if fieldValue.Kind() == reflect.Struct && !fieldValue.Addr().Type().Implements(textUnmarshaler) {
+			result = append(result, walkFields(fieldValue, fieldPath)...)
+			continue
+		}
+
+		result = append(result, field{path: fieldPath, value: fieldValue})
+	}
+
+	return result
+}
+
+// setField parses raw into the field; lists are comma separated
+func setField(f field, raw string) error {
+	if tu, ok := f.value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return tu.UnmarshalText([]byte(raw))
+	}
+
+	switch f.value.Kind() {
+	case reflect.String:
+		f.value.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+
+		f.value.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		f.value.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+
+		f.value.SetFloat(n)
+	case reflect.Slice:
+		if f.value.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", f.value.Type())
+		}
+
+		items := make([]string, 0)
+		for _, item := range strings.Split(raw, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+
+		f.value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.value.Type())
+	}
+
+	return nil
+}
+
+// ApplyEnv overrides settings from DYNAFIRE_* variables in environ, i.e. DYNAFIRE_LOG_LEVEL or DYNAFIRE_TURRIS_SERVER_PORT
+func ApplyEnv(conf *Config, environ []string) []error {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		name, value, found := strings.Cut(kv, "=")
+		if found && strings.HasPrefix(name, EnvPrefix) {
+			env[name] = value
+		}
+	}
+
+	errs := make([]error, 0)
+	for _, f := range leafFields(conf) {
+		raw, ok := env[f.envName()]
+		if !ok {
+			continue
+		}
+
+		err := setField(f, raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.envName(), err))
+		}
+	}
+
+	return errs
+}
+
+// Flags are command line overrides for every setting, i.e. --log-level or --turris.server-port
+type Flags struct {
+	set []flagValue
+}
+
+type flagValue struct {
+	flags  *Flags
+	path   []string
+	isBool bool
+	raw    string
+}
+
+func (v *flagValue) String() string {
+	return v.raw
+}
+
+func (v *flagValue) Set(raw string) error {
+	v.raw = raw
+	v.flags.set = append(v.flags.set, *v)
+
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+// RegisterFlags adds a flag for every setting to fs
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	flags := &Flags{}
+
+	conf := Default()
+	for _, f := range leafFields(&conf) {
+		fs.Var(&flagValue{
+			flags:  flags,
+			path:   f.path,
+			isBool: f.value.Kind() == reflect.Bool,
+		}, f.flagName(), fmt.Sprintf("override the %s setting", f.configKey()))
+	}
+
+	return flags
+}
+
+// Apply sets every flag given on the command line, in order
+func (flags *Flags) Apply(conf *Config) []error {
+	fields := make(map[string]field)
+	for _, f := range leafFields(conf) {
+		fields[f.configKey()] = f
+	}
+
+	errs := make([]error, 0)
+	for _, v := range flags.set {
+		f := fields[strings.Join(v.path, ".")]
+
+		err := setField(f, v.raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("--%s: %w", f.flagName(), err))
+		}
+	}
+
+	return errs
+}
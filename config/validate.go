@@ -0,0 +1,618 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/MatejLach/dynafire/firewall"
+	"github.com/MatejLach/dynafire/firewall/xdp"
+	"github.com/MatejLach/dynafire/logging"
+	"github.com/MatejLach/dynafire/provider/asn"
+	"github.com/MatejLach/dynafire/provider/logwatch"
+)
+
+var (
+	backends           = []string{"firewalld", "route", "xdp", "dryrun"}
+	logLevels          = []string{"DEBUG", "INFO", "WARN", "WARNING", "ERROR"}
+	zoneTargetPolicies = []string{"ACCEPT", "REJECT", "DROP"}
+	turrisTopics       = []string{"dynfw/list", "dynfw/delta", "dynfw/event"}
+	ruleActions        = []string{"drop", "reject"}
+	syslogNetworks     = []string{"unixgram", "unix", "udp", "tcp"}
+	dropLogReaders     = []string{"journald", "kmsg", "none"}
+	containerRuntimes  = []string{"docker", "podman"}
+	crowdsecScopes     = []string{"ip", "range"}
+	xdpModes           = []string{xdp.ModeAuto, xdp.ModeNative, xdp.ModeGeneric}
+	// Providers are the names of all blacklist sources, as used by the providers setting
+	Providers = []string{"turris", "geoip", "asn", "local", "peers", "crowdsec", "abuseipdb"}
+)
+
+const (
+	maxDropLogPrefix = 20
+	// maxInterfaceName is IFNAMSIZ without the terminating NUL
+	maxInterfaceName = 15
+)
+
+// Validate checks the whole config and reports every problem at once as ValidationErrors
+func (c Config) Validate() error {
+	errs := c.validate()
+	if len(errs) > 0 {
+		return ValidationErrors(errs)
+	}
+
+	return nil
+}
+
+func (c Config) validate() []error {
+	errs := make([]error, 0)
+	fail := func(key, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if !oneOf(c.Backend, backends, false) {
+		fail("backend", "unknown backend %q, expected one of %s", c.Backend, strings.Join(backends, ", "))
+	}
+
+	if c.Backend == "route" {
+		// 253 to 255 are the default, main and local tables
+		if c.Route.Table < 1 || int64(c.Route.Table) > math.MaxUint32 || c.Route.Table >= 253 && c.Route.Table <= 255 {
+			fail("route.table", "must be a routing table other than default, main or local, between 1 and %d", uint32(math.MaxUint32))
+		}
+
+		// the main table is looked up with priority 32766
+		if c.Route.Priority < 1 || c.Route.Priority >= 32766 {
+			fail("route.priority", "must be between 1 and 32765, ahead of the main table")
+		}
+
+		if len(c.Scope.Ports)+len(c.Scope.Protocols)+len(c.Scope.Services) > 0 {
+			fail("scope", "the route backend blocks whole addresses and cannot be scoped")
+		}
+
+		if c.DropLog.Enabled {
+			fail("drop_log.enabled", "the route backend cannot log blocked packets")
+		}
+
+		if c.Gateway.Enabled {
+			fail("gateway.enabled", "the route backend cannot filter forwarded traffic by interface")
+		}
+	}
+
+	if c.Backend == "xdp" {
+		if len(c.XDP.Interfaces) == 0 {
+			fail("xdp.interfaces", "at least one interface is required")
+		}
+
+		for _, iface := range c.XDP.Interfaces {
+			if !isInterfaceName(iface) {
+				fail("xdp.interfaces", "invalid interface name %q", iface)
+			}
+		}
+
+		if !oneOf(c.XDP.Mode, xdpModes, false) {
+			fail("xdp.mode", "unknown mode %q, expected one of %s", c.XDP.Mode, strings.Join(xdpModes, ", "))
+		}
+
+		if c.XDP.MaxEntries < 1 {
+			fail("xdp.max_entries", "must be at least 1")
+		}
+
+		if c.RuleAction != firewall.ActionDrop {
+			fail("rule_action", "the xdp backend can only drop")
+		}
+
+		if c.Gateway.Enabled {
+			fail("gateway.enabled", "the xdp backend only sees traffic arriving on xdp.interfaces and cannot filter forwarded traffic")
+		}
+
+		// the program matches sources, an egress block would drop everything from the destination, allowlisted traffic included
+		if c.Egress.Enabled {
+			fail("egress.enabled", "the xdp backend only matches the source of arriving packets and cannot block destinations")
+		}
+
+		if len(c.Scope.Ports)+len(c.Scope.Protocols)+len(c.Scope.Services) > 0 {
+			fail("scope", "the xdp backend blocks whole addresses and cannot be scoped")
+		}
+
+		if c.DropLog.Enabled {
+			fail("drop_log.enabled", "the xdp backend cannot log blocked packets")
+		}
+	}
+
+	if !oneOf(c.LogLevel, logLevels, true) {
+		fail("log_level", "unknown log level %q, expected one of %s", c.LogLevel, strings.Join(logLevels, ", "))
+	}
+
+	outputLevels := [][2]string{
+		{"log.text.level", c.Log.Text.Level},
+		{"log.json.level", c.Log.JSON.Level},
+		{"log.journald.level", c.Log.Journald.Level},
+		{"log.syslog.level", c.Log.Syslog.Level},
+	}
+	for _, output := range outputLevels {
+		if output[1] != "" && !oneOf(output[1], logLevels, true) {
+			fail(output[0], "unknown log level %q, expected one of %s", output[1], strings.Join(logLevels, ", "))
+		}
+	}
+
+	if !c.Log.Text.Enabled && !c.Log.JSON.Enabled && !c.Log.Journald.Enabled && !c.Log.Syslog.Enabled {
+		fail("log", "at least one log output must be enabled")
+	}
+
+	if c.Log.Syslog.Enabled {
+		if !oneOf(c.Log.Syslog.Network, syslogNetworks, false) {
+			fail("log.syslog.network", "unknown network %q, expected one of %s", c.Log.Syslog.Network, strings.Join(syslogNetworks, ", "))
+		}
+
+		if c.Log.Syslog.Address == "" {
+			fail("log.syslog.address", "must not be empty")
+		}
+
+		if !oneOf(c.Log.Syslog.Facility, logging.SyslogFacilities(), false) {
+			fail("log.syslog.facility", "unknown facility %q", c.Log.Syslog.Facility)
+		}
+	}
+
+	if !oneOf(c.ZoneTargetPolicy, zoneTargetPolicies, true) {
+		fail("zone_target_policy", "unknown zone target policy %q, expected one of %s", c.ZoneTargetPolicy, strings.Join(zoneTargetPolicies, ", "))
+	}
+
+	if !oneOf(c.RuleAction, ruleActions, false) {
+		fail("rule_action", "unknown rule action %q, expected one of %s", c.RuleAction, strings.Join(ruleActions, ", "))
+	}
+
+	for _, provider := range c.Providers {
+		if !oneOf(provider, Providers, false) {
+			fail("providers", "unknown provider %q, expected any of %s", provider, strings.Join(Providers, ", "))
+		}
+	}
+
+	for _, entry := range c.Scope.Ports {
+		if _, err := firewall.ParsePort(entry); err != nil {
+			fail("scope.ports", "%v", err)
+		}
+	}
+
+	for _, protocol := range c.Scope.Protocols {
+		if !isName(protocol) {
+			fail("scope.protocols", "%q is not a protocol name, i.e. icmp", protocol)
+		}
+	}
+
+	for _, service := range c.Scope.Services {
+		if !isName(service) {
+			fail("scope.services", "%q is not a firewalld service name, i.e. ssh", service)
+		}
+	}
+
+	if c.DropLog.Enabled {
+		// the kernel limits log prefixes to 29 bytes, with "-out: " appended to it
+		if !isName(c.DropLog.Prefix) || len(c.DropLog.Prefix) > maxDropLogPrefix {
+			fail("drop_log.prefix", "%q must be a name of at most %d characters, i.e. dynafire", c.DropLog.Prefix, maxDropLogPrefix)
+		}
+
+		if err := firewall.ParseDropLogRate(c.DropLog.Rate); err != nil {
+			fail("drop_log.rate", "%v", err)
+		}
+
+		if !oneOf(c.DropLog.Reader, dropLogReaders, false) {
+			fail("drop_log.reader", "unknown reader %q, expected one of %s", c.DropLog.Reader, strings.Join(dropLogReaders, ", "))
+		}
+	}
+
+	for _, runtime := range c.Containers.Runtimes {
+		if !oneOf(runtime, containerRuntimes, false) {
+			fail("containers.runtimes", "unknown container runtime %q, expected any of %s", runtime, strings.Join(containerRuntimes, ", "))
+		}
+	}
+
+	if len(c.Containers.Runtimes) > 0 && c.Containers.CheckInterval <= 0 {
+		fail("containers.check_interval", "must be positive")
+	}
+
+	for _, entry := range c.Allowlist {
+		if _, err := ParsePrefixes([]string{entry}); err != nil {
+			fail("allowlist", "%q is neither an IP address nor a CIDR prefix", entry)
+		}
+	}
+
+	for _, provider := range c.Egress.Providers {
+		if !oneOf(provider, Providers, false) {
+			fail("egress.providers", "unknown provider %q, expected any of %s", provider, strings.Join(Providers, ", "))
+		}
+	}
+
+	for _, entry := range c.Egress.Allowlist {
+		if _, err := ParsePrefixes([]string{entry}); err != nil {
+			fail("egress.allowlist", "%q is neither an IP address nor a CIDR prefix", entry)
+		}
+	}
+
+	for _, iface := range c.Gateway.IngressInterfaces {
+		if !isInterfaceName(iface) {
+			fail("gateway.ingress_interfaces", "invalid interface name %q", iface)
+		}
+	}
+
+	for _, iface := range c.Gateway.EgressInterfaces {
+		if !isInterfaceName(iface) {
+			fail("gateway.egress_interfaces", "invalid interface name %q", iface)
+		}
+	}
+
+	if c.MetricsListenAddress != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsListenAddress); err != nil {
+			fail("metrics_listen_address", "%v", err)
+		}
+	}
+
+	if c.ControlSocket == "" {
+		fail("control_socket", "must not be empty")
+	}
+
+	if c.Turris.ServerUrl == "" {
+		fail("turris.server_url", "must not be empty")
+	}
+
+	if c.Turris.ServerPort < 1 || c.Turris.ServerPort > 65535 {
+		fail("turris.server_port", "%d is not a valid port", c.Turris.ServerPort)
+	}
+
+	if len(c.Turris.Topics) == 0 {
+		fail("turris.topics", "at least one topic is required")
+	}
+
+	subscribed := make(map[string]bool)
+	for _, topic := range c.Turris.Topics {
+		matched := false
+		for _, known := range turrisTopics {
+			if strings.HasPrefix(known, topic) {
+				subscribed[known] = true
+				matched = true
+			}
+		}
+
+		if !matched {
+			fail("turris.topics", "unknown topic %q", topic)
+		}
+	}
+
+	if subscribed["dynfw/delta"] && !subscribed["dynfw/list"] {
+		fail("turris.topics", "subscribing to dynfw/delta requires subscribing to dynfw/list as well")
+	}
+
+	if c.Turris.ServerPublicKey != "" && len(c.Turris.ServerPublicKey) != 40 {
+		fail("turris.server_public_key", "expected a 40 character Z85 encoded key, got %d characters", len(c.Turris.ServerPublicKey))
+	}
+
+	if c.Turris.ServerKeyFingerprint != "" && len(c.Turris.ServerKeyFingerprint) != 64 {
+		fail("turris.server_key_fingerprint", "expected a 64 character hex encoded SHA-256 fingerprint")
+	}
+
+	if c.Turris.FetchServerKey && c.Turris.CertUrl == "" {
+		fail("turris.cert_url", "must be set when turris.fetch_server_key is enabled")
+	}
+
+	for _, name := range c.Turris.RefreshListEvents {
+		if name == "" {
+			fail("turris.refresh_list_events", "event names must not be empty")
+		}
+
+		if slices.Contains(c.Turris.ReconnectEvents, name) {
+			fail("turris.reconnect_events", "event %q is already listed in turris.refresh_list_events", name)
+		}
+	}
+
+	if slices.Contains(c.Turris.ReconnectEvents, "") {
+		fail("turris.reconnect_events", "event names must not be empty")
+	}
+
+	if c.Hub.ListenAddress == "" {
+		fail("hub.listen_address", "must not be empty, use * for every address")
+	}
+
+	if c.Hub.Port < 1 || c.Hub.Port > 65535 {
+		fail("hub.port", "%d is not a valid port", c.Hub.Port)
+	}
+
+	if c.Hub.KeyFile == "" {
+		fail("hub.key_file", "must not be empty")
+	}
+
+	for _, key := range c.Hub.AllowedClients {
+		if len(key) != 40 {
+			fail("hub.allowed_clients", "expected 40 character Z85 encoded keys, got %q", key)
+		}
+	}
+
+	if c.Hub.ListInterval <= 0 {
+		fail("hub.list_interval", "must be positive")
+	}
+
+	if oneOf("peers", c.Providers, false) || oneOf("peers", c.Egress.Providers, false) {
+		if len(c.Peers.Peers) == 0 {
+			fail("peers.peers", "at least one peer is required when the peers provider is enabled")
+		}
+
+		if c.Peers.ListenAddress == "" {
+			fail("peers.listen_address", "must not be empty, use * for every address")
+		}
+
+		if c.Peers.KeyFile == "" {
+			fail("peers.key_file", "must not be empty")
+		}
+	}
+
+	if c.Peers.Name != "" && !isName(c.Peers.Name) {
+		fail("peers.name", "%q must be a name, i.e. web1", c.Peers.Name)
+	}
+
+	if c.Peers.Port < 1 || c.Peers.Port > 65535 {
+		fail("peers.port", "%d is not a valid port", c.Peers.Port)
+	}
+
+	peerNames := make(map[string]bool, len(c.Peers.Peers))
+	for _, peer := range c.Peers.Peers {
+		if !isName(peer.Name) {
+			fail("peers.peers", "%q must be a name, i.e. web1", peer.Name)
+		}
+
+		if peerNames[peer.Name] {
+			fail("peers.peers", "duplicate peer %q", peer.Name)
+		}
+		peerNames[peer.Name] = true
+
+		if _, _, err := net.SplitHostPort(peer.Endpoint); err != nil {
+			fail("peers.peers", "endpoint of %q: %v", peer.Name, err)
+		}
+
+		if len(peer.PublicKey) != 40 {
+			fail("peers.peers", "public_key of %q: expected a 40 character Z85 encoded key, got %d characters", peer.Name, len(peer.PublicKey))
+		}
+	}
+
+	if c.Peers.MaxTTL <= 0 {
+		fail("peers.max_ttl", "must be positive")
+	}
+
+	if c.Peers.AnnounceInterval <= 0 {
+		fail("peers.announce_interval", "must be positive")
+	}
+
+	for _, entry := range c.Peers.Allowlist {
+		if _, err := ParsePrefixes([]string{entry}); err != nil {
+			fail("peers.allowlist", "%q is neither an IP address nor a CIDR prefix", entry)
+		}
+	}
+
+	if c.Peers.MinPrefixLengthV4 < 1 || c.Peers.MinPrefixLengthV4 > 32 {
+		fail("peers.min_prefix_length_v4", "must be between 1 and 32")
+	}
+
+	if c.Peers.MinPrefixLengthV6 < 1 || c.Peers.MinPrefixLengthV6 > 128 {
+		fail("peers.min_prefix_length_v6", "must be between 1 and 128")
+	}
+
+	if c.Detector.Enabled && len(c.Detector.Rules) == 0 {
+		fail("detector.rules", "at least one rule is required when the detector is enabled")
+	}
+
+	if c.Detector.MaxHits < 1 {
+		fail("detector.max_hits", "must be at least 1")
+	}
+
+	if c.Detector.Window <= 0 {
+		fail("detector.window", "must be positive")
+	}
+
+	if c.Detector.BanTime <= 0 {
+		fail("detector.ban_time", "must be positive")
+	}
+
+	ruleNames := make(map[string]bool, len(c.Detector.Rules))
+	for _, rule := range c.Detector.Rules {
+		if !isName(rule.Name) {
+			fail("detector.rules", "%q must be a name, i.e. sshd", rule.Name)
+		}
+
+		if ruleNames[rule.Name] {
+			fail("detector.rules", "duplicate rule %q", rule.Name)
+		}
+		ruleNames[rule.Name] = true
+
+		if (rule.JournaldUnit == "") == (rule.File == "") {
+			fail("detector.rules", "rule %q must set exactly one of journald_unit and file", rule.Name)
+		}
+
+		if len(rule.Patterns) == 0 {
+			fail("detector.rules", "rule %q has no patterns", rule.Name)
+		}
+
+		for _, pattern := range rule.Patterns {
+			if _, err := logwatch.CompilePattern(pattern); err != nil {
+				fail("detector.rules", "rule %q, pattern %q: %v", rule.Name, pattern, err)
+			}
+		}
+
+		if rule.MaxHits < 0 || rule.Window < 0 || rule.BanTime < 0 {
+			fail("detector.rules", "rule %q: max_hits, window and ban_time must not be negative", rule.Name)
+		}
+	}
+
+	if oneOf("crowdsec", c.Providers, false) || oneOf("crowdsec", c.Egress.Providers, false) {
+		if c.CrowdSec.APIKey == "" && c.CrowdSec.APIKeyFile == "" {
+			fail("crowdsec.api_key", "either crowdsec.api_key or crowdsec.api_key_file must be set when the crowdsec provider is enabled")
+		}
+	}
+
+	if lapiUrl, err := url.Parse(c.CrowdSec.LAPIUrl); err != nil || (lapiUrl.Scheme != "http" && lapiUrl.Scheme != "https") || lapiUrl.Host == "" {
+		fail("crowdsec.lapi_url", "%q is not an http or https URL", c.CrowdSec.LAPIUrl)
+	}
+
+	if c.CrowdSec.PollInterval <= 0 {
+		fail("crowdsec.poll_interval", "must be positive")
+	}
+
+	for _, scope := range c.CrowdSec.Scopes {
+		if !oneOf(scope, crowdsecScopes, true) {
+			fail("crowdsec.scopes", "unknown scope %q, expected any of %s", scope, strings.Join(crowdsecScopes, ", "))
+		}
+	}
+
+	if oneOf("abuseipdb", c.Providers, false) || oneOf("abuseipdb", c.Egress.Providers, false) {
+		if c.AbuseIPDB.APIKey == "" && c.AbuseIPDB.APIKeyFile == "" {
+			fail("abuseipdb.api_key", "either abuseipdb.api_key or abuseipdb.api_key_file must be set when the abuseipdb provider is enabled")
+		}
+	}
+
+	if abuseipdbUrl, err := url.Parse(c.AbuseIPDB.URL); err != nil || (abuseipdbUrl.Scheme != "http" && abuseipdbUrl.Scheme != "https") || abuseipdbUrl.Host == "" {
+		fail("abuseipdb.url", "%q is not an http or https URL", c.AbuseIPDB.URL)
+	}
+
+	if c.AbuseIPDB.ConfidenceMinimum < 25 || c.AbuseIPDB.ConfidenceMinimum > 100 {
+		fail("abuseipdb.confidence_minimum", "must be between 25 and 100, got %d", c.AbuseIPDB.ConfidenceMinimum)
+	}
+
+	if c.AbuseIPDB.Limit <= 0 {
+		fail("abuseipdb.limit", "must be positive")
+	}
+
+	if c.AbuseIPDB.RefreshInterval <= 0 {
+		fail("abuseipdb.refresh_interval", "must be positive")
+	}
+
+	if oneOf("geoip", c.Providers, false) {
+		if c.GeoIP.Database == "" {
+			fail("geoip.database", "must be set when the geoip provider is enabled")
+		}
+
+		if len(c.GeoIP.Countries) == 0 {
+			fail("geoip.countries", "at least one country is required when the geoip provider is enabled")
+		}
+	}
+
+	for _, country := range c.GeoIP.Countries {
+		if !isCountryCode(country) {
+			fail("geoip.countries", "%q is not an ISO 3166-1 alpha-2 country code", country)
+		}
+	}
+
+	if c.GeoIP.CheckInterval <= 0 {
+		fail("geoip.check_interval", "must be positive")
+	}
+
+	if oneOf("asn", c.Providers, false) {
+		if c.ASN.Database == "" && c.ASN.PrefixFile == "" {
+			fail("asn.database", "either asn.database or asn.prefix_file must be set when the asn provider is enabled")
+		}
+
+		if len(c.ASN.ASNs) == 0 {
+			fail("asn.asns", "at least one autonomous system is required when the asn provider is enabled")
+		}
+	}
+
+	for _, entry := range c.ASN.ASNs {
+		if _, err := asn.ParseASN(entry); err != nil {
+			fail("asn.asns", "%v", err)
+		}
+	}
+
+	if c.ASN.RefreshInterval <= 0 {
+		fail("asn.refresh_interval", "must be positive")
+	}
+
+	if c.Queue.Size < 1 {
+		fail("queue.size", "must be at least 1")
+	}
+
+	if c.Queue.BatchSize < 1 {
+		fail("queue.batch_size", "must be at least 1")
+	}
+
+	if c.Queue.FlushInterval <= 0 {
+		fail("queue.flush_interval", "must be positive")
+	}
+
+	if c.BackendPolicy.RetryAttempts < 1 {
+		fail("backend_policy.retry_attempts", "must be at least 1")
+	}
+
+	if c.BackendPolicy.BreakerThreshold < 1 {
+		fail("backend_policy.breaker_threshold", "must be at least 1")
+	}
+
+	if c.BackendPolicy.BreakerCooldown <= 0 {
+		fail("backend_policy.breaker_cooldown", "must be positive")
+	}
+
+	if c.Audit.MaxSizeMB < 1 {
+		fail("audit.max_size_mb", "must be at least 1")
+	}
+
+	if c.Audit.MaxBackups < 0 {
+		fail("audit.max_backups", "must not be negative")
+	}
+
+	if c.Hits.SampleInterval <= 0 {
+		fail("hits.sample_interval", "must be positive")
+	}
+
+	if c.Hits.SummaryInterval < 0 {
+		fail("hits.summary_interval", "must not be negative")
+	}
+
+	if c.Hits.Top < 0 {
+		fail("hits.top", "must not be negative")
+	}
+
+	if c.Hits.MaxEntries < 0 {
+		fail("hits.max_entries", "must not be negative")
+	}
+
+	return errs
+}
+
+func oneOf(value string, allowed []string, ignoreCase bool) bool {
+	for _, a := range allowed {
+		if value == a || (ignoreCase && strings.EqualFold(value, a)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isCountryCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+
+	for _, r := range strings.ToUpper(code) {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isName accepts protocol and service names, which never contain spaces or quotes that would break a rich rule
+func isName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' || r == '+') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isInterfaceName(name string) bool {
+	return isName(name) && len(name) <= maxInterfaceName
+}
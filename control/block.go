@@ -0,0 +1,19 @@
+package control
+
+import "time"
+
+// BlockRequest is the body of POST /block and POST /unblock, as sent by `dynafire block` and `dynafire unblock`
+type BlockRequest struct {
+	// Prefix is an IP address or a CIDR prefix
+	Prefix string `json:"prefix"`
+	// TTL is how long the block lasts, i.e. "1h"; empty does not expire. It is ignored by /unblock
+	TTL string `json:"ttl,omitempty"`
+}
+
+// BlockResult is the answer of POST /block and POST /unblock
+type BlockResult struct {
+	Prefix  string    `json:"prefix"`
+	Expires time.Time `json:"expires,omitempty"`
+	// BlockedBy are the other sources still listing the prefix after /unblock
+	BlockedBy []string `json:"blocked_by,omitempty"`
+}
@@ -0,0 +1,30 @@
+package control
+
+// CheckResult is the answer of GET /check and `dynafire check` for a single IP
+type CheckResult struct {
+	IP      string `json:"ip"`
+	Blocked bool   `json:"blocked"`
+	// BlockedBy are the enforced prefixes containing the IP
+	BlockedBy   []string      `json:"blocked_by"`
+	Allowlisted bool          `json:"allowlisted"`
+	Sources     []SourceMatch `json:"sources"`
+	// Egress is whether traffic to the IP is blocked, it is only set while egress blocking is enabled
+	Egress *EgressCheck `json:"egress,omitempty"`
+}
+
+type EgressCheck struct {
+	Blocked     bool     `json:"blocked"`
+	BlockedBy   []string `json:"blocked_by"`
+	Allowlisted bool     `json:"allowlisted"`
+}
+
+// SourceMatch is a prefix containing the IP listed by a provider
+type SourceMatch struct {
+	Source  string `json:"source"`
+	Prefix  string `json:"prefix"`
+	Enabled bool   `json:"enabled"`
+	// EgressEnabled is set if traffic to the source's prefixes is blocked
+	EgressEnabled bool `json:"egress_enabled,omitempty"`
+	// Details attribute the listing further, i.e. to the autonomous system a prefix belongs to
+	Details []string `json:"details,omitempty"`
+}
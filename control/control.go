@@ -0,0 +1,179 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const DefaultSocketPath = "/run/dynafire/control.sock"
+
+// Server is the local control API of a running daemon, served as HTTP over a unix socket only root can access
+type Server struct {
+	socketPath string
+	mux        *http.ServeMux
+}
+
+func NewServer(socketPath string) *Server {
+	if socketPath == "" {
+		socketPath = DefaultSocketPath
+	}
+
+	return &Server{
+		socketPath: socketPath,
+		mux:        http.NewServeMux(),
+	}
+}
+
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// Serve listens on the control socket until ctx is cancelled
+func (s *Server) Serve(ctx context.Context) error {
+	err := os.MkdirAll(filepath.Dir(s.socketPath), 0700)
+	if err != nil {
+		return err
+	}
+
+	// a stale socket from a previous run would make Listen fail
+	err = os.Remove(s.socketPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(s.socketPath, 0600)
+	if err != nil {
+		_ = listener.Close()
+		return err
+	}
+
+	srv := &http.Server{Handler: s.mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		err := srv.Close()
+		if err != nil {
+			slog.Error("unable to close control API server", "details", err)
+		}
+	}()
+
+	err = srv.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// WriteJSON writes v as the JSON response body
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(v)
+	if err != nil {
+		slog.Error("unable to write control API response", "details", err)
+	}
+}
+
+// WriteError writes err as a JSON error response
+func WriteError(w http.ResponseWriter, status int, err error) {
+	WriteJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Client talks to the control API of a running daemon
+type Client struct {
+	httpClient *http.Client
+}
+
+func NewClient(socketPath string) *Client {
+	if socketPath == "" {
+		socketPath = DefaultSocketPath
+	}
+
+	return &Client{
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// Get calls GET path and decodes the JSON response into v
+func (c *Client) Get(path string, v interface{}) error {
+	return c.do(http.MethodGet, path, nil, v)
+}
+
+// Post calls POST path with body encoded as JSON and decodes the JSON response into v
+func (c *Client) Post(path string, body, v interface{}) error {
+	return c.do(http.MethodPost, path, body, v)
+}
+
+func (c *Client) do(method, path string, body, v interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		bodyB, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		reqBody = bytes.NewReader(bodyB)
+	}
+
+	// the host part is ignored by the unix socket dialer
+	req, err := http.NewRequest(method, "http://dynafire"+path, reqBody)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to reach the dynafire daemon, is it running? %w", err)
+	}
+
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			slog.Error("unable to close HTTP response body", "details", err)
+		}
+	}()
+
+	if resp.StatusCode >= 300 {
+		var errResp errorResponse
+		if json.NewDecoder(resp.Body).Decode(&errResp) == nil && errResp.Error != "" {
+			return errors.New(errResp.Error)
+		}
+
+		return fmt.Errorf("unexpected control API response: %s", resp.Status)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
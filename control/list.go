@@ -0,0 +1,20 @@
+package control
+
+import "time"
+
+// ListResult is the answer of GET /list and `dynafire list`
+type ListResult struct {
+	// HitsCounted is set if the backend counts hits, otherwise Packets and Bytes are always 0
+	HitsCounted bool        `json:"hits_counted"`
+	SampledAt   time.Time   `json:"sampled_at,omitempty"`
+	Entries     []ListEntry `json:"entries"`
+}
+
+// ListEntry is an enforced prefix along with the hits counted since it was blocked
+type ListEntry struct {
+	Prefix    string   `json:"prefix"`
+	Direction string   `json:"direction"`
+	Sources   []string `json:"sources"`
+	Packets   uint64   `json:"packets"`
+	Bytes     uint64   `json:"bytes"`
+}
@@ -0,0 +1,9 @@
+package control
+
+// ReloadResult is the outcome of POST /reload and `dynafire reload`
+type ReloadResult struct {
+	// Applied lists the settings that changed and took effect immediately
+	Applied []string `json:"applied"`
+	// RestartRequired lists the settings that changed but only take effect after a restart
+	RestartRequired []string `json:"restart_required"`
+}
@@ -0,0 +1,21 @@
+package control
+
+import (
+	"time"
+
+	"github.com/MatejLach/dynafire/firewall/resilient"
+)
+
+// Status is the daemon state reported by GET /status and `dynafire status`
+type Status struct {
+	State          string           `json:"state"`
+	Backend        string           `json:"backend"`
+	StartedAt      time.Time        `json:"started_at"`
+	PendingChanges int              `json:"pending_changes"`
+	BackendHealth  resilient.Health `json:"backend_health"`
+}
+
+const (
+	StateRunning  = "running"
+	StateDegraded = "degraded"
+)
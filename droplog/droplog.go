@@ -0,0 +1,121 @@
+package droplog
+
+import (
+	"context"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+// Event is a blocked packet as logged by the kernel
+type Event struct {
+	Time      time.Time
+	Direction firewall.Direction
+	Src       netip.Addr
+	Dst       netip.Addr
+	// Protocol is lower case, i.e. "tcp"; Port is the destination port, 0 for protocols without ports
+	Protocol string
+	Port     int
+	// In and Out are the interfaces the packet arrived on and would have left through, either may be empty
+	In  string
+	Out string
+}
+
+// Blocked returns the listed address of the event, the source of a blocked incoming packet or the destination of an outgoing one
+func (e Event) Blocked() netip.Addr {
+	if e.Direction == firewall.Egress {
+		return e.Dst
+	}
+
+	return e.Src
+}
+
+// Reader follows a kernel log, calling handle for every blocked packet logged with its drop log prefix
+type Reader interface {
+	Run(ctx context.Context, handle func(Event)) error
+}
+
+// Parser picks the drop log lines of one drop log prefix out of kernel log lines
+type Parser struct {
+	prefixes []logPrefix
+}
+
+// logPrefix is the prefix the packets of direction are logged with
+type logPrefix struct {
+	direction firewall.Direction
+	prefix    string
+}
+
+func NewParser(log firewall.DropLog) *Parser {
+	return &Parser{
+		// checked in order, so that a line matches the same direction every time
+		prefixes: []logPrefix{
+			{direction: firewall.Egress, prefix: log.LogPrefix(firewall.Egress)},
+			{direction: firewall.Ingress, prefix: log.LogPrefix(firewall.Ingress)},
+		},
+	}
+}
+
+// Parse parses a netfilter log line, i.e. "dynafire-in: IN=eth0 OUT= SRC=192.0.2.1 DST=198.51.100.1 ... PROTO=TCP SPT=4711 DPT=22",
+// reporting false for lines of other origin
+func (p *Parser) Parse(line string) (Event, bool) {
+	for _, lp := range p.prefixes {
+		i := indexToken(line, lp.prefix)
+		if i < 0 {
+			continue
+		}
+
+		event := Event{Time: time.Now(), Direction: lp.direction}
+		for _, field := range strings.Fields(line[i+len(lp.prefix):]) {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+
+			switch key {
+			case "IN":
+				event.In = value
+			case "OUT":
+				event.Out = value
+			case "SRC":
+				event.Src, _ = netip.ParseAddr(value)
+			case "DST":
+				event.Dst, _ = netip.ParseAddr(value)
+			case "PROTO":
+				event.Protocol = strings.ToLower(value)
+			case "DPT":
+				event.Port, _ = strconv.Atoi(value)
+			}
+		}
+
+		if !event.Blocked().IsValid() {
+			return Event{}, false
+		}
+
+		return event, true
+	}
+
+	return Event{}, false
+}
+
+// indexToken returns the index of the first prefix in line that starts a token, i.e. after a space or the "]" closing a
+// kernel timestamp rather than inside another prefix ending in it, or -1
+func indexToken(line, prefix string) int {
+	offset := 0
+	for {
+		i := strings.Index(line[offset:], prefix)
+		if i < 0 {
+			return -1
+		}
+
+		i += offset
+		if i == 0 || line[i-1] == ' ' || line[i-1] == ']' {
+			return i
+		}
+
+		offset = i + 1
+	}
+}
@@ -0,0 +1,105 @@
+package droplog
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+func TestParserParse(t *testing.T) {
+	parser := NewParser(firewall.DropLog{Prefix: "dynafire", Rate: "10/m"})
+
+	tests := []struct {
+		name  string
+		line  string
+		ok    bool
+		event Event
+	}{
+		{
+			name: "ingress",
+			line: "[12345.678901] dynafire-in: IN=eth0 OUT= MAC=00:00 SRC=192.0.2.1 DST=198.51.100.1 LEN=60 PROTO=TCP SPT=4711 DPT=22 WINDOW=64240 SYN",
+			ok:   true,
+			event: Event{
+				Direction: firewall.Ingress,
+				Src:       netip.MustParseAddr("192.0.2.1"),
+				Dst:       netip.MustParseAddr("198.51.100.1"),
+				Protocol:  "tcp",
+				Port:      22,
+				In:        "eth0",
+			},
+		},
+		{
+			name: "egress",
+			line: "kernel: dynafire-out: IN= OUT=eth0 SRC=198.51.100.1 DST=2001:db8::1 PROTO=UDP SPT=5353 DPT=53",
+			ok:   true,
+			event: Event{
+				Direction: firewall.Egress,
+				Src:       netip.MustParseAddr("198.51.100.1"),
+				Dst:       netip.MustParseAddr("2001:db8::1"),
+				Protocol:  "udp",
+				Port:      53,
+				Out:       "eth0",
+			},
+		},
+		{
+			name: "without ports",
+			line: "dynafire-in: IN=eth0 OUT= SRC=192.0.2.1 DST=198.51.100.1 PROTO=ICMP TYPE=8 CODE=0",
+			ok:   true,
+			event: Event{
+				Direction: firewall.Ingress,
+				Src:       netip.MustParseAddr("192.0.2.1"),
+				Dst:       netip.MustParseAddr("198.51.100.1"),
+				Protocol:  "icmp",
+				In:        "eth0",
+			},
+		},
+		{
+			name: "other prefix",
+			line: "[12345.678901] other-in: IN=eth0 OUT= SRC=192.0.2.1 DST=198.51.100.1 PROTO=TCP DPT=22",
+		},
+		{
+			name: "prefix inside another one",
+			line: "[12345.678901] notdynafire-in: IN=eth0 OUT= SRC=192.0.2.1 DST=198.51.100.1 PROTO=TCP DPT=22",
+		},
+		{
+			name: "blocked address missing",
+			line: "dynafire-in: IN=eth0 OUT= SRC=invalid DST=198.51.100.1 PROTO=TCP DPT=22",
+		},
+		{
+			name: "unrelated",
+			line: "eth0: link up",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, ok := parser.Parse(tt.line)
+			if ok != tt.ok {
+				t.Fatalf("Parse() ok = %t, want %t", ok, tt.ok)
+			}
+
+			if !ok {
+				return
+			}
+
+			event.Time = tt.event.Time
+			if event != tt.event {
+				t.Errorf("Parse() = %+v, want %+v", event, tt.event)
+			}
+		})
+	}
+}
+
+func TestParserParseIsDeterministic(t *testing.T) {
+	parser := NewParser(firewall.DropLog{Prefix: "dynafire"})
+
+	// a line carrying both prefixes, i.e. a forwarded log line, is attributed to egress every time
+	line := "dynafire-out: dynafire-in: IN= OUT=eth0 SRC=198.51.100.1 DST=192.0.2.1 PROTO=TCP DPT=443"
+	for i := 0; i < 100; i++ {
+		event, ok := parser.Parse(line)
+		if !ok || event.Direction != firewall.Egress {
+			t.Fatalf("Parse() = %+v, %t, want egress", event, ok)
+		}
+	}
+}
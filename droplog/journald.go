@@ -0,0 +1,55 @@
+package droplog
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+)
+
+// JournaldReader follows the kernel messages in the journal via journalctl
+type JournaldReader struct {
+	parser *Parser
+}
+
+func NewJournaldReader(parser *Parser) *JournaldReader {
+	return &JournaldReader{parser: parser}
+}
+
+// Run follows the journal from its current end until ctx is cancelled
+func (r *JournaldReader) Run(ctx context.Context, handle func(Event)) error {
+	cmd := exec.CommandContext(ctx, "journalctl", "--dmesg", "--follow", "--lines=0", "--output=cat")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("unable to run journalctl: %w", err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if event, ok := r.parser.Parse(scanner.Text()); ok {
+			handle(event)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		slog.Error("reading journalctl output", "details", err)
+	}
+
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("journalctl stopped: %w", err)
+	}
+
+	return errors.New("journalctl stopped")
+}
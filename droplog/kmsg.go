@@ -0,0 +1,74 @@
+package droplog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"syscall"
+)
+
+const kmsgPath = "/dev/kmsg"
+
+// KmsgReader reads the kernel ring buffer directly, for hosts without journald
+type KmsgReader struct {
+	parser *Parser
+}
+
+func NewKmsgReader(parser *Parser) *KmsgReader {
+	return &KmsgReader{parser: parser}
+}
+
+// Run reads /dev/kmsg from its current end until ctx is cancelled
+func (r *KmsgReader) Run(ctx context.Context, handle func(Event)) error {
+	file, err := os.Open(kmsgPath)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		err := file.Close()
+		if err != nil {
+			slog.Error("unable to close kernel log", "path", kmsgPath, "details", err)
+		}
+	}()
+
+	// only packets blocked from now on are of interest, not whatever is left in the ring buffer
+	_, err = file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	// every read returns exactly one record, i.e. "4,1234,5678901,-;dynafire-in: IN=eth0 ..."
+	buf := make([]byte, 8192)
+	for {
+		n, err := file.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			// records were overwritten before they were read, the next read continues with the oldest one left
+			if errors.Is(err, syscall.EPIPE) {
+				slog.Debug("missed kernel log records, the ring buffer wrapped around")
+				continue
+			}
+
+			return err
+		}
+
+		_, message, ok := strings.Cut(string(buf[:n]), ";")
+		if !ok {
+			continue
+		}
+
+		// continuation lines of the record, i.e. " SUBSYSTEM=...", follow the message
+		message, _, _ = strings.Cut(message, "\n")
+		if event, ok := r.parser.Parse(message); ok {
+			handle(event)
+		}
+	}
+}
@@ -2,9 +2,36 @@ package firewall
 
 import "net"
 
+// Rule actions for blacklisted traffic
+const (
+	ActionDrop   = "drop"
+	ActionReject = "reject"
+)
+
+// Blocker enforces blocks; BlockIP, BlockIPList and UnblockIP apply to ingress traffic only, egress blocks go through ApplyBatch
 type Blocker interface {
 	BlockIP(address net.IP) error
 	BlockIPList(blacklist []net.IP) error
 	UnblockIP(address net.IP) error
+	// ResetFirewallRules removes every block, in both directions
 	ResetFirewallRules() error
+	// ApplyBatch applies many changes at once, in order; backends should do so in as few operations as possible
+	ApplyBatch(changes []Change) error
+}
+
+// RuleActionSetter is implemented by backends that can switch between dropping and rejecting blacklisted traffic
+// The new action applies to rules added afterwards, so callers resync the blacklist after changing it
+type RuleActionSetter interface {
+	SetRuleAction(action string) error
+}
+
+// Closer is implemented by backends that undo their setup once dynafire stops, rather than leave the blocks in place
+type Closer interface {
+	Close() error
+}
+
+// Wrapper is implemented by decorators that add to a backend, i.e. by mirroring its blocks elsewhere,
+// Unwrap gives access to the optional interfaces of the backend they decorate
+type Wrapper interface {
+	Unwrap() Blocker
 }
@@ -0,0 +1,88 @@
+package firewall
+
+import (
+	"net"
+	"net/netip"
+)
+
+type Operation int
+
+const (
+	Block Operation = iota
+	Unblock
+)
+
+func (o Operation) String() string {
+	switch o {
+	case Block:
+		return "block"
+	case Unblock:
+		return "unblock"
+	default:
+		return "unknown"
+	}
+}
+
+// Direction is the traffic a block applies to
+type Direction int
+
+const (
+	// Ingress blocks traffic coming from the prefix
+	Ingress Direction = iota
+	// Egress blocks traffic going to the prefix, both from the host itself and forwarded through it
+	Egress
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Ingress:
+		return "ingress"
+	case Egress:
+		return "egress"
+	default:
+		return "unknown"
+	}
+}
+
+// Change is a single block or unblock of an address or prefix, applied in batches via Blocker.ApplyBatch
+type Change struct {
+	Operation Operation
+	Direction Direction
+	Prefix    netip.Prefix
+}
+
+// Target is what a change applies to; blocks of the same prefix in both directions are separate targets
+type Target struct {
+	Direction Direction
+	Prefix    netip.Prefix
+}
+
+func (c Change) Target() Target {
+	return Target{Direction: c.Direction, Prefix: c.Prefix}
+}
+
+// PrefixFromIP turns a single address into a host prefix, /32 for IPv4 and /128 for IPv6
+func PrefixFromIP(address net.IP) (netip.Prefix, bool) {
+	addr, ok := netip.AddrFromSlice(address)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+
+	addr = addr.Unmap()
+
+	return netip.PrefixFrom(addr, addr.BitLen()), true
+}
+
+// IsSingleIP reports whether the prefix covers exactly one address
+func IsSingleIP(prefix netip.Prefix) bool {
+	return prefix.Bits() == prefix.Addr().BitLen()
+}
+
+// PrefixString formats single addresses without the prefix length, the way they appear in firewall rules
+func PrefixString(prefix netip.Prefix) string {
+	if IsSingleIP(prefix) {
+		return prefix.Addr().String()
+	}
+
+	return prefix.String()
+}
@@ -0,0 +1,359 @@
+package containers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+const (
+	DefaultCheckInterval = 30 * time.Second
+	// the sets hold whole blacklists, well above the default ipset size of 65536
+	ipsetMaxElem = 1048576
+)
+
+// Runtimes maps the supported container runtimes to the iptables chain their forwarded traffic passes first
+// Docker keeps DOCKER-USER for rules of its users; netavark, the network stack of Podman, has no such chain,
+// so the rules go to the top of the chain it forwards container traffic through
+var Runtimes = map[string]string{
+	"docker": "DOCKER-USER",
+	"podman": "NETAVARK_FORWARD",
+}
+
+// sets are the ipsets holding the enforced set, per direction and address family
+var sets = []ipset{
+	{name: "dynafire-ct-in4", family: "inet", direction: firewall.Ingress, match: "src"},
+	{name: "dynafire-ct-in6", family: "inet6", direction: firewall.Ingress, match: "src"},
+	{name: "dynafire-ct-out4", family: "inet", direction: firewall.Egress, match: "dst"},
+	{name: "dynafire-ct-out6", family: "inet6", direction: firewall.Egress, match: "dst"},
+}
+
+type ipset struct {
+	name      string
+	family    string
+	direction firewall.Direction
+	// match is the iptables set match flag, src for traffic from blocked addresses and dst for traffic to them
+	match string
+}
+
+// iptables returns the command managing the chains the set is matched in
+func (s ipset) iptables() string {
+	if s.family == "inet6" {
+		return "ip6tables"
+	}
+
+	return "iptables"
+}
+
+// rule is the iptables rule dropping traffic matching the set, without the chain
+func (s ipset) rule() []string {
+	return []string{"-m", "set", "--match-set", s.name, s.match, "-j", "DROP"}
+}
+
+func setFor(target firewall.Target) ipset {
+	for _, s := range sets {
+		if s.direction == target.Direction && (s.family == "inet") == target.Prefix.Addr().Is4() {
+			return s
+		}
+	}
+
+	return sets[0]
+}
+
+type Config struct {
+	// Runtimes are the container runtimes whose forwarded traffic is filtered, keys of Runtimes
+	Runtimes []string
+	// CheckInterval is how often the rules are checked, and re-applied if a runtime restart removed them
+	CheckInterval time.Duration
+}
+
+// Blocker decorates a firewall.Blocker, mirroring the enforced set into ipsets matched by rules in the container runtimes' chains,
+// as traffic to published container ports is forwarded rather than delivered to the host and never reaches the backend's rules
+// Mirroring is best effort; a failure is logged and the ipsets are restored as a whole with the next check
+type Blocker struct {
+	backend firewall.Blocker
+	conf    Config
+
+	mu       sync.Mutex
+	enforced map[firewall.Target]struct{}
+	dirty    bool
+}
+
+func New(backend firewall.Blocker, conf Config) (*Blocker, error) {
+	if conf.CheckInterval <= 0 {
+		conf.CheckInterval = DefaultCheckInterval
+	}
+
+	for _, runtime := range conf.Runtimes {
+		if _, ok := Runtimes[runtime]; !ok {
+			return nil, fmt.Errorf("unknown container runtime %q", runtime)
+		}
+	}
+
+	b := &Blocker{
+		backend:  backend,
+		conf:     conf,
+		enforced: make(map[firewall.Target]struct{}),
+	}
+
+	err := b.restore()
+	if err != nil {
+		return nil, err
+	}
+
+	b.checkRules()
+
+	return b, nil
+}
+
+func (b *Blocker) Unwrap() firewall.Blocker {
+	return b.backend
+}
+
+func (b *Blocker) BlockIP(address net.IP) error {
+	err := b.backend.BlockIP(address)
+	if err != nil {
+		return err
+	}
+
+	if prefix, ok := firewall.PrefixFromIP(address); ok {
+		b.mirror([]firewall.Change{{Operation: firewall.Block, Prefix: prefix}})
+	}
+
+	return nil
+}
+
+func (b *Blocker) BlockIPList(blacklist []net.IP) error {
+	err := b.backend.BlockIPList(blacklist)
+	if err != nil {
+		return err
+	}
+
+	changes := make([]firewall.Change, 0, len(blacklist))
+	for _, ip := range blacklist {
+		if prefix, ok := firewall.PrefixFromIP(ip); ok {
+			changes = append(changes, firewall.Change{Operation: firewall.Block, Prefix: prefix})
+		}
+	}
+	b.mirror(changes)
+
+	return nil
+}
+
+func (b *Blocker) UnblockIP(address net.IP) error {
+	err := b.backend.UnblockIP(address)
+	if err != nil {
+		return err
+	}
+
+	if prefix, ok := firewall.PrefixFromIP(address); ok {
+		b.mirror([]firewall.Change{{Operation: firewall.Unblock, Prefix: prefix}})
+	}
+
+	return nil
+}
+
+func (b *Blocker) ResetFirewallRules() error {
+	err := b.backend.ResetFirewallRules()
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	clear(b.enforced)
+	b.dirty = true
+	b.restoreLocked()
+
+	return nil
+}
+
+func (b *Blocker) ApplyBatch(changes []firewall.Change) error {
+	err := b.backend.ApplyBatch(changes)
+	if err != nil {
+		return err
+	}
+
+	b.mirror(changes)
+
+	return nil
+}
+
+// Run checks the rules every CheckInterval until ctx is cancelled, re-applying whatever a runtime restart removed
+func (b *Blocker) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.conf.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.mu.Lock()
+			if b.dirty {
+				b.restoreLocked()
+			}
+			b.mu.Unlock()
+
+			b.checkRules()
+		}
+	}
+}
+
+func (b *Blocker) mirror(changes []firewall.Change) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	script := new(strings.Builder)
+	for _, change := range changes {
+		target := change.Target()
+		set := setFor(target)
+
+		switch change.Operation {
+		case firewall.Block:
+			b.enforced[target] = struct{}{}
+			fmt.Fprintf(script, "add %s %s\n", set.name, change.Prefix.Masked())
+		case firewall.Unblock:
+			delete(b.enforced, target)
+			fmt.Fprintf(script, "del %s %s\n", set.name, change.Prefix.Masked())
+		}
+	}
+
+	// a failed update leaves the sets in an unknown state, they are restored as a whole later on
+	if b.dirty {
+		return
+	}
+
+	err := ipsetRestore(script.String())
+	if err != nil {
+		slog.Warn("unable to update container ipsets, they will be restored with the next check", "details", err)
+		b.dirty = true
+	}
+}
+
+func (b *Blocker) restore() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.dirty = true
+
+	return b.restoreLocked()
+}
+
+// restoreLocked creates the ipsets if needed and replaces their contents with the enforced set
+func (b *Blocker) restoreLocked() error {
+	script := new(strings.Builder)
+	for _, set := range sets {
+		fmt.Fprintf(script, "create %s hash:net family %s maxelem %d\n", set.name, set.family, ipsetMaxElem)
+		fmt.Fprintf(script, "flush %s\n", set.name)
+	}
+
+	for target := range b.enforced {
+		fmt.Fprintf(script, "add %s %s\n", setFor(target).name, target.Prefix.Masked())
+	}
+
+	err := ipsetRestore(script.String())
+	if err != nil {
+		slog.Warn("unable to restore container ipsets", "details", err)
+		return err
+	}
+
+	b.dirty = false
+
+	return nil
+}
+
+// checkRules makes sure the rules matching the ipsets come first in every runtime's chains
+func (b *Blocker) checkRules() {
+	for _, runtime := range b.conf.Runtimes {
+		chain := Runtimes[runtime]
+
+		for _, command := range []string{"iptables", "ip6tables"} {
+			applied, err := ensureRules(command, chain)
+			if err != nil {
+				slog.Warn("unable to apply container rules", "runtime", runtime, "chain", chain, "command", command, "details", err)
+				continue
+			}
+
+			if applied {
+				slog.Info("applied container rules", "runtime", runtime, "chain", chain, "command", command)
+			}
+		}
+	}
+}
+
+// ensureRules inserts the rules of the ipsets matched by command at the top of chain, unless they are there already,
+// reporting whether it changed anything; a missing chain means the runtime is not running yet and is not an error
+func ensureRules(command, chain string) (bool, error) {
+	out, err := exec.Command(command, "-w", "-S", chain).CombinedOutput()
+	if err != nil {
+		slog.Debug("container runtime chain not found", "command", command, "chain", chain, "output", strings.TrimSpace(string(out)))
+		return false, nil
+	}
+
+	wanted := make([]string, 0, 2)
+	for _, set := range sets {
+		if set.iptables() == command {
+			wanted = append(wanted, strings.Join(append([]string{"-A", chain}, set.rule()...), " "))
+		}
+	}
+
+	existing := make([]string, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if strings.HasPrefix(line, "-A ") {
+			existing = append(existing, line)
+		}
+	}
+
+	if len(existing) >= len(wanted) && strings.Join(existing[:len(wanted)], "\n") == strings.Join(wanted, "\n") {
+		return false, nil
+	}
+
+	// remove copies further down the chain, i.e. after the runtime inserted its own rules above them, then insert at the top
+	for _, set := range sets {
+		if set.iptables() != command {
+			continue
+		}
+
+		for {
+			args := append([]string{"-w", "-D", chain}, set.rule()...)
+			if exec.Command(command, args...).Run() != nil {
+				break
+			}
+		}
+	}
+
+	for i := len(sets) - 1; i >= 0; i-- {
+		if sets[i].iptables() != command {
+			continue
+		}
+
+		args := append([]string{"-w", "-I", chain, "1"}, sets[i].rule()...)
+		out, err := exec.Command(command, args...).CombinedOutput()
+		if err != nil {
+			return false, fmt.Errorf("%s %s: %w: %s", command, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	return true, nil
+}
+
+func ipsetRestore(script string) error {
+	cmd := exec.Command("ipset", "restore", "-exist")
+	cmd.Stdin = strings.NewReader(script)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ipset restore: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package containers
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MatejLach/dynafire/firewall"
+	"github.com/MatejLach/dynafire/firewall/memory"
+)
+
+// fakeIPSet puts an ipset on PATH that appends the scripts it restores to the returned log,
+// and fails while the returned fail file exists
+func fakeIPSet(t *testing.T) (log, fail string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	log = filepath.Join(dir, "scripts")
+	fail = filepath.Join(dir, "fail")
+
+	script := `#!/bin/sh
+if [ -e ` + fail + ` ]; then
+	echo "ipset v7.19: Kernel error received: Operation not permitted" >&2
+	exit 1
+fi
+cat >> ` + log + `
+`
+
+	if err := os.WriteFile(filepath.Join(dir, "ipset"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return log, fail
+}
+
+// readLog returns what was written to log since the last call and empties it
+func readLog(t *testing.T, log string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(log)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+
+	_ = os.Remove(log)
+
+	return string(data)
+}
+
+func TestBlockerMirror(t *testing.T) {
+	log, fail := fakeIPSet(t)
+
+	if _, err := New(memory.New(false), Config{Runtimes: []string{"lxc"}}); err == nil {
+		t.Fatal("New() accepted an unknown container runtime")
+	}
+
+	b, err := New(memory.New(false), Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if script := readLog(t, log); !strings.Contains(script, "create dynafire-ct-out6 hash:net family inet6") {
+		t.Fatalf("New() did not create the ipsets:\n%s", script)
+	}
+
+	err = b.ApplyBatch([]firewall.Change{
+		{Operation: firewall.Block, Direction: firewall.Ingress, Prefix: netip.MustParsePrefix("192.0.2.0/24")},
+		{Operation: firewall.Block, Direction: firewall.Egress, Prefix: netip.MustParsePrefix("2001:db8::1/128")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "add dynafire-ct-in4 192.0.2.0/24\nadd dynafire-ct-out6 2001:db8::1/128\n"
+	if script := readLog(t, log); script != want {
+		t.Fatalf("ipset script %q, want %q", script, want)
+	}
+
+	// a failed update is not retried change by change, the sets are restored as a whole instead
+	if err := os.WriteFile(fail, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.ApplyBatch([]firewall.Change{{Operation: firewall.Unblock, Direction: firewall.Ingress, Prefix: netip.MustParsePrefix("192.0.2.0/24")}})
+	if err != nil {
+		t.Fatalf("ApplyBatch() = %v, a failed mirror must not fail the backend change", err)
+	}
+
+	if err := os.Remove(fail); err != nil {
+		t.Fatal(err)
+	}
+
+	b.mu.Lock()
+	dirty := b.dirty
+	err = b.restoreLocked()
+	b.mu.Unlock()
+
+	if !dirty || err != nil {
+		t.Fatalf("dirty %v, restoreLocked() = %v, want a dirty blocker restored", dirty, err)
+	}
+
+	script := readLog(t, log)
+	if !strings.Contains(script, "flush dynafire-ct-in4\n") || !strings.HasSuffix(script, "add dynafire-ct-out6 2001:db8::1/128\n") || strings.Contains(script, "192.0.2.0/24") {
+		t.Fatalf("restored ipsets with\n%s\nwant only the egress block left", script)
+	}
+}
@@ -0,0 +1,44 @@
+package firewall
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var dropLogRate = regexp.MustCompile(`^[1-9][0-9]*/[smhd]$`)
+
+// DropLog makes backends log blocked packets to the kernel log before dropping them, the zero DropLog logs nothing
+type DropLog struct {
+	// Prefix identifies the log lines, backends log with LogPrefix
+	Prefix string
+	// Rate limits the logged packets per rule, as a count per second, minute, hour or day, i.e. "10/m"
+	Rate string
+}
+
+func (l DropLog) Enabled() bool {
+	return l.Prefix != ""
+}
+
+// LogPrefix is the kernel log prefix of packets blocked in direction, i.e. "dynafire-in: "
+func (l DropLog) LogPrefix(direction Direction) string {
+	if direction == Egress {
+		return l.Prefix + "-out: "
+	}
+
+	return l.Prefix + "-in: "
+}
+
+// ParseDropLogRate checks a rate limit the way firewalld takes it, i.e. "10/m"
+func ParseDropLogRate(rate string) error {
+	if !dropLogRate.MatchString(rate) {
+		return fmt.Errorf("invalid rate %q, expected a count per s, m, h or d, i.e. 10/m", rate)
+	}
+
+	return nil
+}
+
+// DropLogSetter is implemented by backends that can log blocked packets
+// Like the rule action, the new setting applies to rules added afterwards, so callers resync the blacklist after changing it
+type DropLogSetter interface {
+	SetDropLog(log DropLog) error
+}
@@ -0,0 +1,88 @@
+package firewall
+
+import (
+	"errors"
+	"fmt"
+)
+
+type ErrorClass int
+
+const (
+	// Transient errors are expected to go away on their own, i.e. firewalld being reloaded; the operation is retried
+	Transient ErrorClass = iota
+	// Fatal errors will not go away without operator intervention, i.e. a missing firewall-cmd binary
+	Fatal
+	// Rejected errors are the backend refusing the changes themselves, i.e. an invalid rule; retrying them cannot help,
+	// but the backend keeps working for other changes
+	Rejected
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case Transient:
+		return "transient"
+	case Fatal:
+		return "fatal"
+	case Rejected:
+		return "rejected"
+	default:
+		return "unknown"
+	}
+}
+
+// Error is a classified backend error, backends return it so that callers know whether retrying makes sense
+type Error struct {
+	Class ErrorClass
+	Op    string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func NewTransientError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &Error{Class: Transient, Op: op, Err: err}
+}
+
+func NewFatalError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &Error{Class: Fatal, Op: op, Err: err}
+}
+
+func NewRejectedError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &Error{Class: Rejected, Op: op, Err: err}
+}
+
+// ClassOf returns the class of err; errors a backend did not classify are considered transient
+func ClassOf(err error) ErrorClass {
+	var fwErr *Error
+	if errors.As(err, &fwErr) {
+		return fwErr.Class
+	}
+
+	return Transient
+}
+
+func IsFatal(err error) bool {
+	return err != nil && ClassOf(err) == Fatal
+}
+
+func IsRejected(err error) bool {
+	return err != nil && ClassOf(err) == Rejected
+}
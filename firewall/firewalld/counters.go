@@ -0,0 +1,329 @@
+package firewalld
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+const counterTable = "dynafire"
+
+var counterSets = []string{"in4", "in6", "in4net", "in6net", "out4", "out6", "out4net", "out6net"}
+
+// counterTableScript creates the counting table from scratch; it only counts, the packets are dropped by the firewalld rules
+// Single addresses and prefixes go to separate sets, as interval sets reject overlapping elements; for the same reason,
+// a prefix within another blocked prefix is counted by the wider one
+const counterTableScript = `table inet dynafire
+delete table inet dynafire
+table inet dynafire {
+	set in4 { type ipv4_addr; counter; }
+	set in6 { type ipv6_addr; counter; }
+	set in4net { type ipv4_addr; flags interval; counter; }
+	set in6net { type ipv6_addr; flags interval; counter; }
+	set out4 { type ipv4_addr; counter; }
+	set out6 { type ipv6_addr; counter; }
+	set out4net { type ipv4_addr; flags interval; counter; }
+	set out6net { type ipv6_addr; flags interval; counter; }
+
+	chain count_prerouting {
+		type filter hook prerouting priority raw - 10; policy accept;
+		ip saddr @in4
+		ip6 saddr @in6
+		ip saddr @in4net
+		ip6 saddr @in6net
+		ip daddr @out4
+		ip6 daddr @out6
+		ip daddr @out4net
+		ip6 daddr @out6net
+	}
+
+	chain count_output {
+		type filter hook output priority raw - 10; policy accept;
+		ip daddr @out4
+		ip6 daddr @out6
+		ip daddr @out4net
+		ip6 daddr @out6net
+	}
+}
+`
+
+// counters mirrors the blocks into nftables sets with per-element counters, as neither rich rules nor firewalld ipsets count hits
+// Counting is best effort: the sets are updated in the background, away from the firewall operations they accompany,
+// and failures are only logged
+type counters struct {
+	mu sync.Mutex
+
+	// pending holds the changes not counted yet; reset flushes the sets before them
+	pendingMu sync.Mutex
+	pending   []firewall.Change
+	reset     bool
+	wake      chan struct{}
+
+	// cover is only used by count
+	cover *prefixCover
+}
+
+func newCounters() (*counters, error) {
+	c := &counters{wake: make(chan struct{}, 1), cover: newPrefixCover()}
+
+	err := c.nft("creating the counting table", counterTableScript)
+	if err != nil {
+		return nil, err
+	}
+
+	go c.count()
+
+	return c, nil
+}
+
+// counterSet returns the set counting target
+func counterSet(target firewall.Target) string {
+	name := "in"
+	if target.Direction == firewall.Egress {
+		name = "out"
+	}
+
+	if target.Prefix.Addr().Is4() {
+		name += "4"
+	} else {
+		name += "6"
+	}
+
+	if !firewall.IsSingleIP(target.Prefix) {
+		name += "net"
+	}
+
+	return name
+}
+
+// flush empties the counting sets once the changes counted so far are through, the ones passed to apply afterwards are
+// counted from scratch
+func (c *counters) flush() {
+	c.pendingMu.Lock()
+	c.pending = nil
+	c.reset = true
+	c.pendingMu.Unlock()
+
+	c.notify()
+}
+
+// apply queues counting the targets of changes
+func (c *counters) apply(changes []firewall.Change) {
+	c.pendingMu.Lock()
+	c.pending = append(c.pending, changes...)
+	c.pendingMu.Unlock()
+
+	c.notify()
+}
+
+func (c *counters) notify() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// count counts the queued changes for as long as the process lives, in order, so that a flush never overtakes them
+func (c *counters) count() {
+	for range c.wake {
+		c.pendingMu.Lock()
+		changes, reset := c.pending, c.reset
+		c.pending, c.reset = nil, false
+		c.pendingMu.Unlock()
+
+		if reset {
+			c.cover = newPrefixCover()
+			c.flushSets()
+		}
+
+		// counting is best effort, elements nft failed on are not retried
+		changes, _ = c.cover.apply(changes)
+		if len(changes) == 0 {
+			continue
+		}
+
+		failed := c.update(changes)
+		if failed > 0 {
+			slog.Warn("unable to count hits of some blocks", "failed", failed)
+		}
+	}
+}
+
+func (c *counters) flushSets() {
+	script := new(strings.Builder)
+	for _, set := range counterSets {
+		fmt.Fprintf(script, "flush set inet %s %s\n", counterTable, set)
+	}
+
+	err := c.nft("flushing the counting sets", script.String())
+	if err != nil {
+		slog.Warn("unable to reset hit counters", "details", err)
+	}
+}
+
+// update adds and removes the counted targets of changes and returns how many of them failed; a batch that fails as a whole,
+// i.e. on an element deleted behind dynafire's back, is split in halves, so that a few bad elements cost a few nft runs rather than one per change
+func (c *counters) update(changes []firewall.Change) int {
+	script := new(strings.Builder)
+	for _, change := range changes {
+		writeElement(script, change)
+	}
+
+	if c.nft("updating the counting sets", script.String()) == nil {
+		return 0
+	}
+
+	if len(changes) == 1 {
+		return 1
+	}
+
+	half := len(changes) / 2
+
+	return c.update(changes[:half]) + c.update(changes[half:])
+}
+
+func writeElement(script *strings.Builder, change firewall.Change) {
+	operation := "add"
+	if change.Operation == firewall.Unblock {
+		operation = "delete"
+	}
+
+	fmt.Fprintf(script, "%s element inet %s %s { %s }\n", operation, counterTable, counterSet(change.Target()), change.Prefix.Masked())
+}
+
+// Hits reads the counters of every counted target, prefixes within another blocked prefix have no counters of their own
+func (c *counters) Hits() (map[firewall.Target]firewall.Hits, error) {
+	result := make(map[firewall.Target]firewall.Hits)
+	for _, set := range counterSets {
+		direction := firewall.Ingress
+		if strings.HasPrefix(set, "out") {
+			direction = firewall.Egress
+		}
+
+		out, err := c.run("reading the counting sets", nil, "-j", "list", "set", "inet", counterTable, set)
+		if err != nil {
+			return nil, err
+		}
+
+		elements, err := parseSetElements(out)
+		if err != nil {
+			return nil, fmt.Errorf("parsing the '%s' counting set: %w", set, err)
+		}
+
+		for prefix, hits := range elements {
+			result[firewall.Target{Direction: direction, Prefix: prefix}] = hits
+		}
+	}
+
+	return result, nil
+}
+
+// nftSet is the part of `nft -j list set` output holding the elements, i.e.
+// {"nftables": [{"metainfo": {...}}, {"set": {"elem": [{"elem": {"val": "192.0.2.1", "counter": {"packets": 1, "bytes": 60}}}]}}]}
+type nftSet struct {
+	Nftables []struct {
+		Set *struct {
+			Elem []struct {
+				Elem struct {
+					Val     json.RawMessage `json:"val"`
+					Counter struct {
+						Packets uint64 `json:"packets"`
+						Bytes   uint64 `json:"bytes"`
+					} `json:"counter"`
+				} `json:"elem"`
+			} `json:"elem"`
+		} `json:"set"`
+	} `json:"nftables"`
+}
+
+func parseSetElements(data []byte) (map[netip.Prefix]firewall.Hits, error) {
+	var set nftSet
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[netip.Prefix]firewall.Hits)
+	for _, item := range set.Nftables {
+		if item.Set == nil {
+			continue
+		}
+
+		for _, elem := range item.Set.Elem {
+			prefix, err := parseSetValue(elem.Elem.Val)
+			if err != nil {
+				return nil, err
+			}
+
+			result[prefix] = firewall.Hits{Packets: elem.Elem.Counter.Packets, Bytes: elem.Elem.Counter.Bytes}
+		}
+	}
+
+	return result, nil
+}
+
+// parseSetValue parses an element value, either an address or {"prefix": {"addr": "192.0.2.0", "len": 24}}
+func parseSetValue(val json.RawMessage) (netip.Prefix, error) {
+	var addr string
+	if json.Unmarshal(val, &addr) == nil {
+		parsed, err := netip.ParseAddr(addr)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+
+		return netip.PrefixFrom(parsed, parsed.BitLen()), nil
+	}
+
+	var prefix struct {
+		Prefix struct {
+			Addr string `json:"addr"`
+			Len  int    `json:"len"`
+		} `json:"prefix"`
+	}
+
+	err := json.Unmarshal(val, &prefix)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.ParsePrefix(fmt.Sprintf("%s/%d", prefix.Prefix.Addr, prefix.Prefix.Len))
+}
+
+func (c *counters) nft(op, script string) error {
+	_, err := c.run(op, strings.NewReader(script), "-f", "-")
+
+	return err
+}
+
+func (c *counters) run(op string, stdin *strings.Reader, args ...string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return runNft(op, stdin, args...)
+}
+
+// runNft runs nft, feeding it stdin unless it is nil, and returns its output
+func runNft(op string, stdin *strings.Reader, args ...string) ([]byte, error) {
+	cmd := exec.Command("nft", args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		slog.Debug(op, "command", "nft "+strings.Join(args, " "), "output", strings.TrimSpace(stderr.String()), "error", err)
+		return nil, execError(op, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String())))
+	}
+
+	return out, nil
+}
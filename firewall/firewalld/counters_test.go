@@ -0,0 +1,60 @@
+package firewalld
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+func TestParseSetElements(t *testing.T) {
+	data := []byte(`{"nftables": [
+		{"metainfo": {"version": "1.0.9", "json_schema_version": 1}},
+		{"set": {"family": "inet", "name": "in4net", "table": "dynafire", "elem": [
+			{"elem": {"val": "192.0.2.1", "counter": {"packets": 3, "bytes": 180}}},
+			{"elem": {"val": {"prefix": {"addr": "198.51.100.0", "len": 24}}, "counter": {"packets": 1, "bytes": 60}}}
+		]}}
+	]}`)
+
+	got, err := parseSetElements(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[netip.Prefix]firewall.Hits{
+		netip.MustParsePrefix("192.0.2.1/32"):    {Packets: 3, Bytes: 180},
+		netip.MustParsePrefix("198.51.100.0/24"): {Packets: 1, Bytes: 60},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("parseSetElements() = %v, want %v", got, want)
+	}
+
+	for prefix, hits := range want {
+		if got[prefix] != hits {
+			t.Errorf("%s has %+v, want %+v", prefix, got[prefix], hits)
+		}
+	}
+
+	if _, err := parseSetElements([]byte(`{"nftables": [{"set": {"elem": [{"elem": {"val": "not an address"}}]}}]}`)); err == nil {
+		t.Error("parseSetElements() accepted an element that is not an address")
+	}
+}
+
+func TestCounterSet(t *testing.T) {
+	tests := []struct {
+		target firewall.Target
+		want   string
+	}{
+		{firewall.Target{Direction: firewall.Ingress, Prefix: netip.MustParsePrefix("192.0.2.1/32")}, "in4"},
+		{firewall.Target{Direction: firewall.Ingress, Prefix: netip.MustParsePrefix("2001:db8::/32")}, "in6net"},
+		{firewall.Target{Direction: firewall.Egress, Prefix: netip.MustParsePrefix("198.51.100.0/24")}, "out4net"},
+		{firewall.Target{Direction: firewall.Egress, Prefix: netip.MustParsePrefix("2001:db8::1/128")}, "out6"},
+	}
+
+	for _, test := range tests {
+		if got := counterSet(test.target); got != test.want {
+			t.Errorf("counterSet(%v) = %s, want %s", test.target, got, test.want)
+		}
+	}
+}
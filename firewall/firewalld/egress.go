@@ -0,0 +1,305 @@
+package firewalld
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+const (
+	egressIPSet4 = "dynafire-egress4"
+	egressIPSet6 = "dynafire-egress6"
+	// the ipsets hold whole blacklists, well above the default ipset size of 65536
+	ipsetMaxElem = 1048576
+)
+
+// egressPolicies block traffic of the host itself and traffic forwarded through it; firewalld does not allow
+// HOST and ANY in the ingress zones of the same policy
+var egressPolicies = []policy{
+	{name: "dynafire-egress", ingressZones: []string{"HOST"}, egressZones: []string{"ANY"}},
+	{name: "dynafire-forward", ingressZones: []string{"ANY"}, egressZones: []string{"ANY"}},
+}
+
+type policy struct {
+	name         string
+	ingressZones []string
+	egressZones  []string
+}
+
+type ipset struct {
+	name   string
+	family string
+}
+
+// setupEgress creates the egress ipsets and the policies dropping traffic to them, unless they already exist
+// The ipsets are only ever filled at runtime, so reloading firewalld empties them like it drops the runtime rich rules
+func (fwc *FirewallCmd) setupEgress() error {
+	err := fwc.ensureIPSets([]ipset{{egressIPSet4, "inet"}, {egressIPSet6, "inet6"}})
+	if err != nil {
+		return err
+	}
+
+	for _, policy := range egressPolicies {
+		err = fwc.ensurePolicy(policy)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = fwc.setEgressRules()
+	if err != nil {
+		return err
+	}
+
+	return fwc.reloadHostFirewalldConfig()
+}
+
+// updatePolicyRules applies a changed rule action or drop log to the egress policies and the gateway rules, if they are enabled
+func (fwc *FirewallCmd) updatePolicyRules() error {
+	if fwc.Config.Gateway.Enabled {
+		err := fwc.setGatewayRules()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !fwc.Config.Egress {
+		return nil
+	}
+
+	err := fwc.setEgressRules()
+	if err != nil {
+		return err
+	}
+
+	return fwc.reloadHostFirewalldConfig()
+}
+
+// setEgressRules replaces the permanent rules of the egress policies with rules using the current rule action and drop log
+func (fwc *FirewallCmd) setEgressRules() error {
+	wanted := make([]string, 0, 2)
+	for _, ipset := range []string{egressIPSet4, egressIPSet6} {
+		wanted = append(wanted, fmt.Sprintf("rule destination ipset=%s%s %s", ipset, fwc.logRichRule(firewall.Egress), fwc.Config.RuleAction))
+	}
+
+	for _, policy := range egressPolicies {
+		err := fwc.setPolicyRules(policy.name, wanted)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureIPSets creates the permanent ipsets that do not exist yet
+func (fwc *FirewallCmd) ensureIPSets(ipsets []ipset) error {
+	existing, err := fwc.firewallCmd("listing firewalld ipsets", "--permanent", "--get-ipsets")
+	if err != nil {
+		return err
+	}
+
+	for _, ipset := range ipsets {
+		if slices.Contains(strings.Fields(existing), ipset.name) {
+			continue
+		}
+
+		_, err = fwc.firewallCmd(fmt.Sprintf("creating the '%s' firewalld ipset", ipset.name),
+			"--permanent", "--new-ipset="+ipset.name, "--type=hash:net", "--family="+ipset.family, fmt.Sprintf("--option=maxelem=%d", ipsetMaxElem))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensurePolicy creates the permanent policy unless it exists, and makes its zones match
+func (fwc *FirewallCmd) ensurePolicy(p policy) error {
+	policies, err := fwc.firewallCmd("listing firewalld policies", "--permanent", "--get-policies")
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(strings.Fields(policies), p.name) {
+		_, err = fwc.firewallCmd(fmt.Sprintf("creating the '%s' firewalld policy", p.name), "--permanent", "--new-policy="+p.name)
+		if err != nil {
+			return err
+		}
+	}
+
+	args := []string{"--permanent", "--policy=" + p.name}
+	for _, side := range []struct {
+		kind  string
+		zones []string
+	}{{"ingress", p.ingressZones}, {"egress", p.egressZones}} {
+		out, err := fwc.firewallCmd(fmt.Sprintf("listing the %s zones of the '%s' firewalld policy", side.kind, p.name), "--permanent", "--policy="+p.name, "--list-"+side.kind+"-zones")
+		if err != nil {
+			return err
+		}
+
+		current := strings.Fields(out)
+		// stale zones are removed before the new ones are added, firewalld rejects ANY or HOST next to other zones
+		for _, zone := range current {
+			if !slices.Contains(side.zones, zone) {
+				args = append(args, "--remove-"+side.kind+"-zone="+zone)
+			}
+		}
+
+		for _, zone := range side.zones {
+			if !slices.Contains(current, zone) {
+				args = append(args, "--add-"+side.kind+"-zone="+zone)
+			}
+		}
+	}
+
+	if len(args) == 2 {
+		return nil
+	}
+
+	_, err = fwc.firewallCmd(fmt.Sprintf("setting the zones of the '%s' firewalld policy", p.name), args...)
+
+	return err
+}
+
+// setPolicyRules replaces the permanent rich rules of a policy with wanted, leaving the rules that are there already alone
+func (fwc *FirewallCmd) setPolicyRules(policy string, wanted []string) error {
+	out, err := fwc.firewallCmd(fmt.Sprintf("listing the rules of the '%s' firewalld policy", policy), "--permanent", "--policy="+policy, "--list-rich-rules")
+	if err != nil {
+		return err
+	}
+
+	args := []string{"--permanent", "--policy=" + policy}
+	for _, rule := range wanted {
+		args = append(args, "--add-rich-rule", rule)
+	}
+
+	// firewall-cmd lists rules with every value quoted, compare them without quotes
+	unquoted := make([]string, 0, len(wanted))
+	for _, rule := range wanted {
+		unquoted = append(unquoted, strings.ReplaceAll(rule, `"`, ""))
+	}
+
+	for _, rule := range strings.Split(strings.TrimSpace(out), "\n") {
+		if rule != "" && !slices.Contains(unquoted, strings.ReplaceAll(rule, `"`, "")) {
+			args = append(args, "--remove-rich-rule", rule)
+		}
+	}
+
+	_, err = fwc.firewallCmd(fmt.Sprintf("setting the rules of the '%s' firewalld policy", policy), args...)
+
+	return err
+}
+
+// applyEgressBatch adds and removes egress ipset entries
+func (fwc *FirewallCmd) applyEgressBatch(changes []firewall.Change) error {
+	if !fwc.Config.Egress {
+		return firewall.NewFatalError("applying egress changes", fmt.Errorf("egress blocking is not enabled"))
+	}
+
+	return fwc.applyIPSetBatch(egressIPSet4, egressIPSet6, changes)
+}
+
+// applyIPSetBatch adds and removes the prefixes of changes to and from a pair of ipsets, in one firewall-cmd invocation per ipset and operation
+func (fwc *FirewallCmd) applyIPSetBatch(ipset4, ipset6 string, changes []firewall.Change) error {
+	type batchKey struct {
+		ipset     string
+		operation firewall.Operation
+	}
+
+	batches := make(map[batchKey][]string)
+	for _, change := range changes {
+		key := batchKey{ipset: ipset6, operation: change.Operation}
+		if change.Prefix.Addr().Is4() {
+			key.ipset = ipset4
+		}
+
+		batches[key] = append(batches[key], firewall.PrefixString(change.Prefix))
+	}
+
+	for key, entries := range batches {
+		option := "--add-entries-from-file"
+		if key.operation == firewall.Unblock {
+			option = "--remove-entries-from-file"
+		}
+
+		err := fwc.applyIPSetEntries(key.ipset, option, entries)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (fwc *FirewallCmd) applyIPSetEntries(ipset, option string, entries []string) error {
+	file, err := os.CreateTemp("", "dynafire-ipset-*")
+	if err != nil {
+		return fileError("creating an ipset entry file", err)
+	}
+
+	defer func() {
+		err := os.Remove(file.Name())
+		if err != nil {
+			slog.Error("unable to remove ipset entry file", "path", file.Name(), "details", err)
+		}
+	}()
+
+	_, err = file.WriteString(strings.Join(entries, "\n") + "\n")
+	if err != nil {
+		_ = file.Close()
+		return fileError("writing an ipset entry file", err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		return fileError("writing an ipset entry file", err)
+	}
+
+	_, err = fwc.firewallCmd(fmt.Sprintf("updating the '%s' firewalld ipset", ipset), "--ipset="+ipset, option+"="+file.Name())
+
+	return err
+}
+
+// firewallCmd runs firewall-cmd, expecting its output to end in 'success'; warnings about existing or missing entries are tolerated
+func (fwc *FirewallCmd) firewallCmd(op string, args ...string) (string, error) {
+	cmd := exec.Command("firewall-cmd", args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if exErr, ok := err.(*exec.ExitError); ok {
+			slog.Error(op, "command", "firewall-cmd "+strings.Join(args, " "), "output", strings.TrimSpace(string(out)), "error", exErr)
+		} else {
+			slog.Error("could not run firewall-cmd", "op", op, "error", err)
+		}
+
+		return "", execError(op, err)
+	}
+
+	output := strings.TrimSpace(string(out))
+	if isQuery(args) {
+		return output, nil
+	}
+
+	lines := strings.Split(output, "\n")
+	if lines[len(lines)-1] != "success" {
+		return "", fmt.Errorf("unexpected output while %s; expected 'success' but got %s", op, output)
+	}
+
+	return output, nil
+}
+
+// isQuery reports whether the firewall-cmd arguments list something rather than change it, queries do not print 'success'
+func isQuery(args []string) bool {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--get-") || strings.HasPrefix(arg, "--list-") {
+			return true
+		}
+	}
+
+	return false
+}
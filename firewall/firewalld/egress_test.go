@@ -0,0 +1,104 @@
+package firewalld
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+// fakeFirewallCmd puts a firewall-cmd on PATH that prints output and records each invocation to the returned log,
+// one line per invocation, followed by the entries of the file it was given
+func fakeFirewallCmd(t *testing.T, output string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	log := filepath.Join(dir, "invocations")
+
+	script := `#!/bin/sh
+echo "$@" >> ` + log + `
+for arg in "$@"; do
+	case "$arg" in
+	--*-entries-from-file=*) cat "${arg#*=}" >> ` + log + ` ;;
+	esac
+done
+echo "` + output + `"
+`
+
+	if err := os.WriteFile(filepath.Join(dir, "firewall-cmd"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return log
+}
+
+func TestApplyEgressBatch(t *testing.T) {
+	changes := []firewall.Change{
+		{Operation: firewall.Block, Direction: firewall.Egress, Prefix: netip.MustParsePrefix("192.0.2.0/24")},
+		{Operation: firewall.Block, Direction: firewall.Egress, Prefix: netip.MustParsePrefix("198.51.100.7/32")},
+		{Operation: firewall.Block, Direction: firewall.Egress, Prefix: netip.MustParsePrefix("2001:db8::/32")},
+		{Operation: firewall.Unblock, Direction: firewall.Egress, Prefix: netip.MustParsePrefix("203.0.113.9/32")},
+	}
+
+	fwc := &FirewallCmd{}
+	if err := fwc.applyEgressBatch(changes); !firewall.IsFatal(err) {
+		t.Fatalf("applyEgressBatch() with egress disabled = %v, want a fatal error", err)
+	}
+
+	log := fakeFirewallCmd(t, "success")
+	fwc.Config.Egress = true
+
+	if err := fwc.applyEgressBatch(changes); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// batches go out in map order, compare the invocations regardless of it
+	invocations := strings.Split(strings.TrimSpace(string(data)), "--ipset=")
+	got := make([]string, 0)
+	for _, invocation := range invocations[1:] {
+		ipset, rest, _ := strings.Cut(invocation, " ")
+		option, entries, _ := strings.Cut(rest, "=")
+		_, entries, _ = strings.Cut(entries, "\n")
+		got = append(got, ipset+" "+option+" "+strings.Join(strings.Fields(entries), ","))
+	}
+
+	slices.Sort(got)
+	want := []string{
+		"dynafire-egress4 --add-entries-from-file 192.0.2.0/24,198.51.100.7",
+		"dynafire-egress4 --remove-entries-from-file 203.0.113.9",
+		"dynafire-egress6 --add-entries-from-file 2001:db8::/32",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("firewall-cmd invocations %q, want %q", got, want)
+	}
+}
+
+func TestFirewallCmdOutput(t *testing.T) {
+	fakeFirewallCmd(t, "Warning: ALREADY_ENABLED: 192.0.2.1")
+	fwc := &FirewallCmd{}
+
+	if _, err := fwc.firewallCmd("updating an ipset", "--ipset=dynafire-egress4", "--add-entry=192.0.2.1"); err == nil {
+		t.Fatal("firewallCmd() accepted output without 'success'")
+	}
+
+	// queries print their result instead of 'success'
+	out, err := fwc.firewallCmd("listing firewalld ipsets", "--permanent", "--get-ipsets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out != "Warning: ALREADY_ENABLED: 192.0.2.1" {
+		t.Fatalf("firewallCmd() = %q, want the query output", out)
+	}
+}
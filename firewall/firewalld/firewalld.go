@@ -5,20 +5,43 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"net/netip"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"text/template"
+
+	"github.com/MatejLach/dynafire/firewall"
 )
 
 const (
+	// keeps the firewall-cmd argument list comfortably below the kernel's command line length limit
+	maxRichRulesPerCmd = 250
+	// firewall-cmd exits with these and the codes in between for its INVALID_* errors, i.e. INVALID_ADDR (105) or INVALID_RULE (122)
+	firstInvalidExitCode      = 100
+	lastInvalidExitCode       = 199
 	existingRulesZoneFilePath = "/etc/firewalld/zones/dynafire.xml"
 	oldRulesZoneFilePath      = "/etc/firewalld/zones/dynafire.xml.old"
 	richRuleTemplate          = `<?xml version="1.0" encoding="utf-8"?>
-<zone>
-{{ range . }}
+<zone{{ with .Target }} target="{{.}}"{{ end }}>
+{{ range .Rules }}
   <rule family="{{.IPFamily}}">
     <source address="{{.IP}}"/>
+{{- with .Port }}
+    <port port="{{.Range}}" protocol="{{.Protocol}}"/>
+{{- end }}
+{{- with .Protocol }}
+    <protocol value="{{.}}"/>
+{{- end }}
+{{- with .Service }}
+    <service name="{{.}}"/>
+{{- end }}
+{{- with .Log }}
+    <log prefix="{{.Prefix}}" level="info">
+      <limit value="{{.Rate}}"/>
+    </log>
+{{- end }}
     <{{.Rule}}/>
   </rule>
 {{ end }}
@@ -29,23 +52,55 @@ const (
 type FirewallCmd struct {
 	Config Config
 	rules  []RichRule
+	// counters is nil unless hit counting is enabled
+	counters *counters
+	// gatewayCover tracks the prefixes in the gateway sets, it is nil unless gateway mode is enabled
+	gatewayCover *prefixCover
 }
 
 type RichRule struct {
 	IPFamily string
 	IP       net.IP
 	Rule     string
+	// Log is set if blocked packets are logged before Rule applies
+	Log *ruleLog
+	destination
+}
+
+type ruleLog struct {
+	Prefix string
+	Rate   string
+}
+
+// destination limits a rule to traffic of the host matching one element of the firewall.Scope,
+// a rich rule cannot hold more than one of them; the zero destination matches all traffic
+type destination struct {
+	Port     *firewall.Port
+	Protocol string
+	Service  string
+}
+
+// key identifies a rule by what it matches, i.e. to skip adding it twice
+func (r RichRule) key() string {
+	return r.IP.String() + r.destination.richRule()
 }
 
-func New() (*FirewallCmd, error) {
-	// init config
-	if !configExists() {
-		initConfig()
+func (d destination) richRule() string {
+	switch {
+	case d.Port != nil:
+		return fmt.Sprintf(" port port=%s protocol=%s", d.Port.Range(), d.Port.Protocol)
+	case d.Protocol != "":
+		return fmt.Sprintf(" protocol value=%s", d.Protocol)
+	case d.Service != "":
+		return fmt.Sprintf(" service name=%s", d.Service)
+	default:
+		return ""
 	}
+}
 
-	conf, err := parseConfig()
-	if err != nil {
-		return nil, err
+func New(conf Config) (*FirewallCmd, error) {
+	if conf.RuleAction == "" {
+		conf.RuleAction = firewall.ActionDrop
 	}
 
 	cmd := &FirewallCmd{
@@ -106,6 +161,29 @@ func New() (*FirewallCmd, error) {
 		return nil, err
 	}
 
+	if conf.Egress {
+		err = cmd.setupEgress()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.Gateway.Enabled {
+		err = cmd.setupGateway()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		removeGateway()
+	}
+
+	if conf.HitCounters {
+		cmd.counters, err = newCounters()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	err = cmd.checkConfig()
 	if err != nil {
 		return nil, err
@@ -125,7 +203,7 @@ func (fwc *FirewallCmd) hostNetworkManagerRunning() (bool, error) {
 				slog.Error("could not run systemctl to check NetworkManager service status", "error", err)
 			}
 
-			return false, err
+			return false, execError("checking NetworkManager service status", err)
 		}
 	}
 
@@ -147,7 +225,7 @@ func (fwc *FirewallCmd) hostFirewalldRunning() (bool, error) {
 				slog.Error("could not run systemctl to check firewalld service status", "error", err)
 			}
 
-			return false, err
+			return false, execError("checking firewalld service status", err)
 		}
 	}
 
@@ -168,7 +246,7 @@ func (fwc *FirewallCmd) hostHasRequiredZone() (bool, error) {
 			slog.Error("could not run `firewall-cmd --get-zones` to check existing firewalld zones", "error", err)
 		}
 
-		return false, err
+		return false, execError("listing firewalld zones", err)
 	}
 
 	zones := strings.Split(strings.TrimSpace(string(out)), " ")
@@ -191,7 +269,7 @@ func (fwc *FirewallCmd) createRequiredZoneOnHost() error {
 			slog.Error("could not run `firewall-cmd --permanent --new-zone=dynafire` to create new 'dynafire' firewalld zone", "error", err)
 		}
 
-		return nil
+		return execError("creating the 'dynafire' firewalld zone", err)
 	}
 
 	if strings.TrimSpace(string(out)) != "success" {
@@ -211,7 +289,7 @@ func (fwc *FirewallCmd) reloadHostFirewalldConfig() error {
 			slog.Error("could not run `firewall-cmd --reload` to reload firewalld configuration", "error", err)
 		}
 
-		return nil
+		return execError("reloading firewalld", err)
 	}
 
 	if strings.TrimSpace(string(out)) != "success" {
@@ -227,22 +305,32 @@ func (fwc *FirewallCmd) ResetFirewallRules() error {
 	if _, err := os.Stat(existingRulesZoneFilePath); err == nil {
 		err = os.Remove(existingRulesZoneFilePath)
 		if err != nil {
-			return err
+			return fileError("removing the dynafire zone file", err)
 		}
 	} else if !errors.Is(err, os.ErrNotExist) {
-		return err
+		return fileError("checking the dynafire zone file", err)
 	}
 
 	if _, err := os.Stat(oldRulesZoneFilePath); err == nil {
 		err = os.Remove(oldRulesZoneFilePath)
 		if err != nil {
-			return err
+			return fileError("removing the old dynafire zone file", err)
 		}
 	} else if !errors.Is(err, os.ErrNotExist) {
+		return fileError("checking the old dynafire zone file", err)
+	}
+
+	fwc.rules = fwc.rules[:0]
+	if fwc.counters != nil {
+		fwc.counters.flush()
+	}
+
+	err := fwc.flushGateway()
+	if err != nil {
 		return err
 	}
 
-	err := fwc.reloadHostFirewalldConfig()
+	err = fwc.reloadHostFirewalldConfig()
 	if err != nil {
 		return err
 	}
@@ -274,7 +362,7 @@ func (fwc *FirewallCmd) saveHostRuntimeFirewalldConfig() error {
 			slog.Error("could not run `firewall-cmd --runtime-to-permanent` to save runtime firewalld configuration", "error", err)
 		}
 
-		return nil
+		return execError("saving runtime firewalld configuration as permanent", err)
 	}
 
 	if strings.TrimSpace(string(out)) != "success" {
@@ -294,7 +382,7 @@ func (fwc *FirewallCmd) isHostDefaultZoneDynafire() (bool, error) {
 			slog.Error("could not run `firewall-cmd --get-default-zone` to list firewalld default zone", "error", err)
 		}
 
-		return false, nil
+		return false, execError("listing firewalld default zone", err)
 	}
 
 	if strings.TrimSpace(string(out)) == "dynafire" {
@@ -314,7 +402,7 @@ func (fwc *FirewallCmd) setHostDefaultZone() error {
 			slog.Error("could not run `firewall-cmd --set-default-zone=dynafire` to set firewalld default zone", "error", err)
 		}
 
-		return nil
+		return execError("setting firewalld default zone", err)
 	}
 
 	if strings.TrimSpace(string(out)) != "success" {
@@ -341,7 +429,7 @@ func (fwc *FirewallCmd) setHostDefaultZonePolicy(policy string) error {
 			slog.Error("could not run `firewall-cmd --permanent --zone=dynafire --set-target=ACCEPT` to set firewalld default zone traffic acceptance policy", "error", err)
 		}
 
-		return nil
+		return execError("setting firewalld default zone traffic acceptance policy", err)
 	}
 
 	if strings.TrimSpace(string(out)) != "success" {
@@ -356,132 +444,341 @@ func (fwc *FirewallCmd) setHostDefaultZonePolicy(policy string) error {
 	return nil
 }
 
-func (fwc *FirewallCmd) ruleExists(address net.IP) bool {
-	var ruleStr string
-	if address.To4() != nil {
-		ruleStr = fmt.Sprintf("rule family=ipv4 source address=%s drop", address.String())
-	} else {
-		ruleStr = fmt.Sprintf("rule family=ipv6 source address=%s drop", address.String())
+func (fwc *FirewallCmd) BlockIP(address net.IP) error {
+	prefix, ok := firewall.PrefixFromIP(address)
+	if !ok {
+		return firewall.NewFatalError("blocking an IP", fmt.Errorf("invalid IP %v", address))
 	}
 
-	cmd := exec.Command("firewall-cmd", "--zone=dynafire", "--query-rich-rule", ruleStr)
+	return fwc.ApplyBatch([]firewall.Change{{Operation: firewall.Block, Prefix: prefix}})
+}
 
-	// ignoring the error here as it is sometimes non-zero but still gives the output we want
-	out, _ := cmd.CombinedOutput()
-	if strings.TrimSpace(string(out)) == "yes" {
-		return true
+func (fwc *FirewallCmd) BlockIPList(blacklist []net.IP) error {
+	present := make(map[string]bool, len(fwc.rules))
+	for _, rule := range fwc.rules {
+		present[rule.key()] = true
 	}
 
-	return false
-}
+	for _, ip := range blacklist {
+		var ipFamily string
+		if ip.To4() != nil {
+			ipFamily = "ipv4"
+		} else {
+			ipFamily = "ipv6"
+		}
 
-func (fwc *FirewallCmd) BlockIP(address net.IP) error {
-	var ruleStr string
-	if address.To4() != nil {
-		ruleStr = fmt.Sprintf("rule family=ipv4 source address=%s drop", address.String())
-	} else {
-		ruleStr = fmt.Sprintf("rule family=ipv6 source address=%s drop", address.String())
+		for _, dest := range fwc.destinations() {
+			rule := RichRule{
+				IPFamily:    ipFamily,
+				IP:          ip,
+				Rule:        fwc.Config.RuleAction,
+				Log:         fwc.ruleLog(firewall.Ingress),
+				destination: dest,
+			}
+
+			if present[rule.key()] {
+				continue
+			}
+
+			present[rule.key()] = true
+			fwc.rules = append(fwc.rules, rule)
+		}
 	}
 
-	if fwc.ruleExists(address) {
-		slog.Debug("skipping adding existing rule", "rule", ruleStr)
-		return nil
+	// For speed reasons, write out a new zone.xml rules file rather than using firewall-cmd
+	// The zone target is part of the file, so that firewalld only has to be reloaded once
+	err := writeZoneFile(existingRulesZoneFilePath, zoneTarget(fwc.Config.ZoneTargetPolicy), fwc.rules)
+	if err != nil {
+		return err
 	}
 
-	cmd := exec.Command("firewall-cmd", "--zone=dynafire", "--add-rich-rule", ruleStr)
-	out, err := cmd.CombinedOutput()
+	err = fwc.reloadHostFirewalldConfig()
 	if err != nil {
-		if exErr, ok := err.(*exec.ExitError); ok {
-			slog.Error("adding firewalld rich rule to block an IP", "command", fmt.Sprintf("firewall-cmd --zone=dynafire --add-rich-rule %s to blacklist an IP", ruleStr), "error", exErr)
-		} else {
-			slog.Error(fmt.Sprintf("could not run `firewall-cmd --zone=dynafire --add-rich-rule %s` to blacklist an IP", ruleStr), "error", err)
+		return err
+	}
+
+	changes := make([]firewall.Change, 0, len(blacklist))
+	for _, ip := range blacklist {
+		if prefix, ok := firewall.PrefixFromIP(ip); ok {
+			changes = append(changes, firewall.Change{Operation: firewall.Block, Prefix: prefix})
 		}
+	}
 
-		return nil
+	// resetting the rules above emptied the gateway sets
+	err = fwc.applyGatewayBatch(changes)
+	if err != nil {
+		return err
 	}
 
-	if strings.TrimSpace(string(out)) != "success" {
-		return fmt.Errorf("unexpected output while adding a firewalld rich rule to blacklist an IP; expected 'success' but got %s", strings.TrimSpace(string(out)))
+	if fwc.counters != nil {
+		fwc.counters.apply(changes)
 	}
 
 	return nil
 }
 
-func (fwc *FirewallCmd) BlockIPList(blacklist []net.IP) error {
-	// For speed reasons, write out a new zone.xml rules file rather than using firewall-cmd
-	zoneConfigFile, err := os.OpenFile(existingRulesZoneFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// writeZoneFile replaces the zone file at path with one holding rules, through a temporary file renamed over it,
+// so that firewalld never reads a partial zone
+func writeZoneFile(path, target string, rules []RichRule) error {
+	tmpl, err := template.New("dynafire.xml").Parse(richRuleTemplate)
 	if err != nil {
-		return err
+		return firewall.NewFatalError("parsing the dynafire zone template", err)
 	}
 
-	for _, ip := range blacklist {
-		var ipFamily string
-		if ip.To4() != nil {
-			ipFamily = "ipv4"
-		} else {
-			ipFamily = "ipv6"
-		}
+	// not ending in .xml, firewalld would load it as a zone of its own
+	zoneFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fileError("creating the dynafire zone file", err)
+	}
+
+	defer func() {
+		_ = os.Remove(zoneFile.Name())
+	}()
 
-		fwc.rules = append(fwc.rules, RichRule{
-			IPFamily: ipFamily,
-			IP:       ip,
-			Rule:     "drop",
-		})
+	err = tmpl.Execute(zoneFile, struct {
+		Target string
+		Rules  []RichRule
+	}{Target: target, Rules: rules})
+	if err == nil {
+		err = zoneFile.Chmod(0644)
 	}
 
-	tmpl, err := template.New("dynafire.xml").Parse(richRuleTemplate)
-	if err != nil {
-		return err
+	closeErr := zoneFile.Close()
+	if err == nil {
+		err = closeErr
 	}
 
-	err = tmpl.Execute(zoneConfigFile, fwc.rules)
 	if err != nil {
-		return err
+		return fileError("writing the dynafire zone file", err)
 	}
 
-	err = fwc.reloadHostFirewalldConfig()
+	err = os.Rename(zoneFile.Name(), path)
 	if err != nil {
-		return err
+		return fileError("replacing the dynafire zone file", err)
+	}
+
+	return nil
+}
+
+// zoneTarget is the target attribute of the dynafire zone for a zone target policy, firewalld stores REJECT as %%REJECT%%
+func zoneTarget(policy string) string {
+	switch policy = strings.ToUpper(policy); policy {
+	case "REJECT":
+		return "%%REJECT%%"
+	case "ACCEPT", "DROP":
+		return policy
+	default:
+		return ""
+	}
+}
+
+func (fwc *FirewallCmd) UnblockIP(address net.IP) error {
+	prefix, ok := firewall.PrefixFromIP(address)
+	if !ok {
+		return firewall.NewFatalError("unblocking an IP", fmt.Errorf("invalid IP %v", address))
+	}
+
+	return fwc.ApplyBatch([]firewall.Change{{Operation: firewall.Unblock, Prefix: prefix}})
+}
+
+func (fwc *FirewallCmd) ApplyBatch(changes []firewall.Change) error {
+	// a scoped change takes one rich rule per destination
+	args := make([]string, 0, 2*len(changes))
+	egress := make([]firewall.Change, 0)
+	ingress := make([]firewall.Change, 0, len(changes))
+	for _, change := range changes {
+		if change.Direction == firewall.Egress {
+			egress = append(egress, change)
+			continue
+		}
+
+		ingress = append(ingress, change)
+
+		for _, rule := range fwc.prefixRichRules(change.Prefix) {
+			switch change.Operation {
+			case firewall.Block:
+				args = append(args, "--add-rich-rule", rule)
+			case firewall.Unblock:
+				args = append(args, "--remove-rich-rule", rule)
+			}
+		}
+	}
+
+	// firewall-cmd accepts any number of --add-rich-rule / --remove-rich-rule arguments in one invocation,
+	// existing (or missing) rules are reported as warnings rather than failing the whole batch
+	for start := 0; start < len(args); start += 2 * maxRichRulesPerCmd {
+		end := min(start+2*maxRichRulesPerCmd, len(args))
+
+		args := append([]string{"--zone=dynafire"}, args[start:end]...)
+
+		cmd := exec.Command("firewall-cmd", args...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			if exErr, ok := err.(*exec.ExitError); ok {
+				slog.Error("applying a batch of firewalld rich rule changes", "command", "firewall-cmd --zone=dynafire --add-rich-rule/--remove-rich-rule ...", "rules", (end-start)/2, "output", strings.TrimSpace(string(out)), "error", exErr)
+			} else {
+				slog.Error("could not run `firewall-cmd --zone=dynafire` to apply a batch of rich rule changes", "error", err)
+			}
+
+			return execError("applying a batch of firewalld rich rule changes", err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		if lines[len(lines)-1] != "success" {
+			return fmt.Errorf("unexpected output while applying a batch of firewalld rich rule changes; expected 'success' but got %s", strings.TrimSpace(string(out)))
+		}
+	}
+
+	if len(egress) > 0 {
+		err := fwc.applyEgressBatch(egress)
+		if err != nil {
+			return err
+		}
 	}
 
-	err = fwc.setHostDefaultZonePolicy(strings.ToUpper(fwc.Config.ZoneTargetPolicy))
+	err := fwc.applyGatewayBatch(ingress)
 	if err != nil {
 		return err
 	}
 
+	if fwc.counters != nil {
+		fwc.counters.apply(changes)
+	}
+
 	return nil
 }
 
-func (fwc *FirewallCmd) UnblockIP(address net.IP) error {
-	var ruleStr string
-	if address.To4() != nil {
-		ruleStr = fmt.Sprintf("rule family=ipv4 source address=%s drop", address.String())
-	} else {
-		ruleStr = fmt.Sprintf("rule family=ipv6 source address=%s drop", address.String())
+// Hits returns the packets and bytes that hit every block since it was added, it fails unless hit counting is enabled
+func (fwc *FirewallCmd) Hits() (map[firewall.Target]firewall.Hits, error) {
+	if fwc.counters == nil {
+		return nil, firewall.NewFatalError("reading hit counters", errors.New("hit counting is not enabled"))
+	}
+
+	return fwc.counters.Hits()
+}
+
+func (fwc *FirewallCmd) prefixRichRules(prefix netip.Prefix) []string {
+	family := "ipv6"
+	if prefix.Addr().Is4() {
+		family = "ipv4"
+	}
+
+	rules := make([]string, 0, 1)
+	for _, dest := range fwc.destinations() {
+		rules = append(rules, fmt.Sprintf("rule family=%s source address=%s%s%s %s", family, firewall.PrefixString(prefix), dest.richRule(), fwc.logRichRule(firewall.Ingress), fwc.Config.RuleAction))
 	}
 
-	if !fwc.ruleExists(address) {
-		slog.Debug("skipping removing non-existent rule", "rule", ruleStr)
+	return rules
+}
+
+func (fwc *FirewallCmd) ruleLog(direction firewall.Direction) *ruleLog {
+	if !fwc.Config.DropLog.Enabled() {
 		return nil
 	}
 
-	cmd := exec.Command("firewall-cmd", "--zone=dynafire", "--remove-rich-rule", ruleStr)
-	out, err := cmd.CombinedOutput()
+	return &ruleLog{Prefix: fwc.Config.DropLog.LogPrefix(direction), Rate: fwc.Config.DropLog.Rate}
+}
+
+// logRichRule is the log element of rich rules blocking traffic in direction, empty unless blocked packets are logged
+func (fwc *FirewallCmd) logRichRule(direction firewall.Direction) string {
+	log := fwc.ruleLog(direction)
+	if log == nil {
+		return ""
+	}
+
+	return fmt.Sprintf(` log prefix="%s" level="info" limit value="%s"`, log.Prefix, log.Rate)
+}
+
+// destinations expands the scope into one destination per rich rule, the zero scope into a single rule matching all traffic
+func (fwc *FirewallCmd) destinations() []destination {
+	scope := fwc.Config.Scope
+	if scope.IsZero() {
+		return []destination{{}}
+	}
+
+	result := make([]destination, 0, len(scope.Ports)+len(scope.Protocols)+len(scope.Services))
+	for i := range scope.Ports {
+		result = append(result, destination{Port: &scope.Ports[i]})
+	}
+
+	for _, protocol := range scope.Protocols {
+		result = append(result, destination{Protocol: protocol})
+	}
+
+	for _, service := range scope.Services {
+		result = append(result, destination{Service: service})
+	}
+
+	return result
+}
+
+// SetZoneTargetPolicy changes the target of the dynafire zone; it reloads firewalld,
+// which drops runtime rules, so the caller has to resync the blacklist afterwards
+func (fwc *FirewallCmd) SetZoneTargetPolicy(policy string) error {
+	err := fwc.setHostDefaultZonePolicy(strings.ToUpper(policy))
 	if err != nil {
-		if exErr, ok := err.(*exec.ExitError); ok {
-			slog.Error("removing firewalld rich rule to whitelist an IP", "command", fmt.Sprintf("firewall-cmd --zone=dynafire --remove-rich-rule %s to block an IP", ruleStr), "error", exErr)
-		} else {
-			slog.Error(fmt.Sprintf("could not run `firewall-cmd --zone=dynafire --remove-rich-rule %s` to whitelist an IP", ruleStr), "error", err)
+		return err
+	}
+
+	fwc.Config.ZoneTargetPolicy = policy
+
+	return nil
+}
+
+// SetRuleAction changes the action of rules added from now on, existing rules are not touched,
+// so the caller has to resync the blacklist afterwards
+// The egress and gateway policies are changed right away, which reloads firewalld
+func (fwc *FirewallCmd) SetRuleAction(action string) error {
+	switch action {
+	case firewall.ActionDrop, firewall.ActionReject:
+	default:
+		return firewall.NewFatalError("setting rule action", fmt.Errorf("unknown rule action %q", action))
+	}
+
+	fwc.Config.RuleAction = action
+
+	return fwc.updatePolicyRules()
+}
+
+// SetDropLog changes the logging of rules added from now on, existing rules are not touched,
+// so the caller has to resync the blacklist afterwards
+// The egress and gateway policies are changed right away, which reloads firewalld
+func (fwc *FirewallCmd) SetDropLog(log firewall.DropLog) error {
+	fwc.Config.DropLog = log
+
+	return fwc.updatePolicyRules()
+}
+
+// SetScope changes the destinations rules added from now on apply to, existing rules are not touched,
+// so the caller has to resync the blacklist afterwards
+func (fwc *FirewallCmd) SetScope(scope firewall.Scope) error {
+	fwc.Config.Scope = scope
+
+	return nil
+}
+
+// execError classifies a failed firewall-cmd/systemctl invocation; a non-zero exit is worth retrying unless firewall-cmd
+// refused the arguments as invalid, not being able to run the command at all is not
+func execError(op string, err error) error {
+	var exErr *exec.ExitError
+	if errors.As(err, &exErr) {
+		code := exErr.ExitCode()
+		if code >= firstInvalidExitCode && code <= lastInvalidExitCode {
+			return firewall.NewRejectedError(op, err)
 		}
 
-		return nil
+		return firewall.NewTransientError(op, err)
 	}
 
-	if strings.TrimSpace(string(out)) != "success" {
-		return fmt.Errorf("unexpected output while removing a firewalld rich rule to whitelist an IP; expected 'success' but got %s", strings.TrimSpace(string(out)))
+	return firewall.NewFatalError(op, err)
+}
+
+func fileError(op string, err error) error {
+	if errors.Is(err, os.ErrPermission) {
+		return firewall.NewFatalError(op, err)
 	}
 
-	return nil
+	return firewall.NewTransientError(op, err)
 }
 
 func (fwc *FirewallCmd) checkConfig() error {
@@ -494,7 +791,7 @@ func (fwc *FirewallCmd) checkConfig() error {
 			slog.Error("could not run `firewall-cmd --check-config'`", "error", err)
 		}
 
-		return nil
+		return execError("checking firewalld configuration", err)
 	}
 
 	if strings.TrimSpace(string(out)) != "success" {
@@ -0,0 +1,116 @@
+package firewalld
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+func TestWriteZoneFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dynafire.xml")
+
+	rules := []RichRule{
+		{IPFamily: "ipv4", IP: net.ParseIP("192.0.2.1"), Rule: "drop"},
+		{IPFamily: "ipv6", IP: net.ParseIP("2001:db8::1"), Rule: "drop", destination: destination{Service: "ssh"}},
+	}
+
+	// writing twice replaces the zone rather than appending another one to it
+	for i := 0; i < 2; i++ {
+		if err := writeZoneFile(path, zoneTarget("reject"), rules); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zone := string(data)
+	for _, want := range []string{`<zone target="%%REJECT%%">`, `<source address="192.0.2.1"/>`, `<service name="ssh"/>`} {
+		if strings.Count(zone, want) != 1 {
+			t.Errorf("zone file holds %q %d times, want once:\n%s", want, strings.Count(zone, want), zone)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("%d files left in the zone directory, want only the zone file", len(entries))
+	}
+
+	info, err := entries[0].Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0644 {
+		t.Fatalf("zone file mode %v, want 0644", info.Mode().Perm())
+	}
+
+	// without a zone target policy firewalld keeps its default target
+	if err := writeZoneFile(path, zoneTarget(""), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), "target=") {
+		t.Fatalf("zone file sets a target without a policy:\n%s", data)
+	}
+}
+
+func TestRichRuleKey(t *testing.T) {
+	rule := RichRule{IPFamily: "ipv4", IP: net.ParseIP("192.0.2.1"), Rule: "drop", destination: destination{Protocol: "tcp"}}
+
+	same := rule
+	same.Rule = "reject"
+	if rule.key() != same.key() {
+		t.Fatal("rules matching the same traffic have different keys")
+	}
+
+	other := rule
+	other.destination = destination{Protocol: "udp"}
+	if rule.key() == other.key() {
+		t.Fatal("rules for different destinations share a key")
+	}
+}
+
+func TestPrefixRichRules(t *testing.T) {
+	fwc := &FirewallCmd{Config: Config{RuleAction: "drop"}}
+
+	got := fwc.prefixRichRules(netip.MustParsePrefix("192.0.2.0/24"))
+	if want := []string{"rule family=ipv4 source address=192.0.2.0/24 drop"}; !slices.Equal(got, want) {
+		t.Fatalf("prefixRichRules() without a scope = %q, want %q", got, want)
+	}
+
+	// a rich rule holds a single destination, so a scope takes one rule per element
+	fwc.Config.Scope = firewall.Scope{
+		Ports:     []firewall.Port{{From: 5000, To: 5100, Protocol: "udp"}},
+		Protocols: []string{"gre"},
+		Services:  []string{"ssh"},
+	}
+
+	got = fwc.prefixRichRules(netip.MustParsePrefix("2001:db8::1/128"))
+	want := []string{
+		"rule family=ipv6 source address=2001:db8::1 port port=5000-5100 protocol=udp drop",
+		"rule family=ipv6 source address=2001:db8::1 protocol value=gre drop",
+		"rule family=ipv6 source address=2001:db8::1 service name=ssh drop",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("prefixRichRules() with a scope = %q, want %q", got, want)
+	}
+}
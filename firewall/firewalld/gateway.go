@@ -0,0 +1,200 @@
+package firewalld
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+const gatewayTable = "dynafire_gateway"
+
+var gatewaySets = []string{"in4", "in6", "in4net", "in6net"}
+
+// gatewayTableScript creates the gateway table from scratch, with the chain but without its rules
+// firewalld policies can only match whole zones, so forwarded traffic is filtered in a table of its own that matches the
+// gateway interfaces by name; single addresses and prefixes go to separate sets, as interval sets reject overlapping elements,
+// and a prefix within another blocked prefix is left to the wider one
+const gatewayTableScript = `table inet dynafire_gateway
+delete table inet dynafire_gateway
+table inet dynafire_gateway {
+	set in4 { type ipv4_addr; }
+	set in6 { type ipv6_addr; }
+	set in4net { type ipv4_addr; flags interval; }
+	set in6net { type ipv6_addr; flags interval; }
+
+	chain forward {
+		type filter hook forward priority filter - 10; policy accept;
+	}
+}
+`
+
+// nftRateUnits spells out the units of a drop log rate, i.e. "10/m" is "10/minute" to nft
+var nftRateUnits = map[string]string{"s": "second", "m": "minute", "h": "hour", "d": "day"}
+
+// setupGateway creates the gateway table, whose sets mirror the ingress blocks, and the rules dropping forwarded traffic
+// from them between the gateway interfaces, and to them the other way round; it requires the nft command
+func (fwc *FirewallCmd) setupGateway() error {
+	fwc.gatewayCover = newPrefixCover()
+
+	_, err := runNft("creating the gateway table", strings.NewReader(gatewayTableScript), "-f", "-")
+	if err != nil {
+		return err
+	}
+
+	return fwc.setGatewayRules()
+}
+
+// removeGateway deletes the gateway table, so that disabling gateway mode stops filtering forwarded traffic
+// Without nft, there cannot be a gateway table to remove either
+func removeGateway() {
+	script := fmt.Sprintf("table inet %s\ndelete table inet %s\n", gatewayTable, gatewayTable)
+
+	_, err := runNft("removing the gateway table", strings.NewReader(script), "-f", "-")
+	if err != nil {
+		slog.Debug("unable to remove the gateway table", "details", err)
+	}
+}
+
+// setGatewayRules replaces the rules of the gateway chain with rules using the current rule action and drop log
+func (fwc *FirewallCmd) setGatewayRules() error {
+	script := new(strings.Builder)
+	fmt.Fprintf(script, "flush chain inet %s forward\n", gatewayTable)
+	for _, rule := range fwc.gatewayRules() {
+		fmt.Fprintf(script, "add rule inet %s forward %s\n", gatewayTable, rule)
+	}
+
+	_, err := runNft("setting the gateway rules", strings.NewReader(script.String()), "-f", "-")
+
+	return err
+}
+
+// gatewayRules drop traffic from the ingress interfaces to the egress ones coming from a blocked address,
+// and traffic the other way round going to one
+func (fwc *FirewallCmd) gatewayRules() []string {
+	gateway := fwc.Config.Gateway
+
+	rules := make([]string, 0)
+	for _, side := range []struct {
+		in, out   []string
+		match     string
+		direction firewall.Direction
+	}{
+		{in: gateway.IngressInterfaces, out: gateway.EgressInterfaces, match: "saddr", direction: firewall.Ingress},
+		{in: gateway.EgressInterfaces, out: gateway.IngressInterfaces, match: "daddr", direction: firewall.Egress},
+	} {
+		interfaces := interfaceMatch("iifname", side.in) + interfaceMatch("oifname", side.out)
+
+		for _, set := range gatewaySets {
+			family := "ip"
+			if strings.HasPrefix(set, "in6") {
+				family = "ip6"
+			}
+
+			match := fmt.Sprintf("%s%s %s @%s", interfaces, family, side.match, set)
+			if log := fwc.ruleLog(side.direction); log != nil {
+				rules = append(rules, fmt.Sprintf(`%s limit rate %s log prefix "%s" level info`, match, nftRate(log.Rate), log.Prefix))
+			}
+
+			rules = append(rules, match+" "+fwc.Config.RuleAction)
+		}
+	}
+
+	return rules
+}
+
+// interfaceMatch matches any of the interfaces by name, no interfaces at all stand for any interface
+func interfaceMatch(key string, interfaces []string) string {
+	if len(interfaces) == 0 {
+		return ""
+	}
+
+	quoted := make([]string, 0, len(interfaces))
+	for _, iface := range interfaces {
+		quoted = append(quoted, fmt.Sprintf("%q", iface))
+	}
+
+	return fmt.Sprintf("%s { %s } ", key, strings.Join(quoted, ", "))
+}
+
+func nftRate(rate string) string {
+	count, unit, _ := strings.Cut(rate, "/")
+
+	return count + "/" + nftRateUnits[unit]
+}
+
+// gatewaySet returns the set holding prefix
+func gatewaySet(prefix netip.Prefix) string {
+	name := "in6"
+	if prefix.Addr().Is4() {
+		name = "in4"
+	}
+
+	if !firewall.IsSingleIP(prefix) {
+		name += "net"
+	}
+
+	return name
+}
+
+// flushGateway empties the gateway sets, which reloading firewalld leaves alone
+func (fwc *FirewallCmd) flushGateway() error {
+	if !fwc.Config.Gateway.Enabled {
+		return nil
+	}
+
+	script := new(strings.Builder)
+	for _, set := range gatewaySets {
+		fmt.Fprintf(script, "flush set inet %s %s\n", gatewayTable, set)
+	}
+
+	_, err := runNft("flushing the gateway sets", strings.NewReader(script.String()), "-f", "-")
+	if err != nil {
+		return err
+	}
+
+	fwc.gatewayCover = newPrefixCover()
+
+	return nil
+}
+
+// applyGatewayBatch mirrors ingress changes into the gateway sets in one nft transaction, if gateway mode is enabled
+func (fwc *FirewallCmd) applyGatewayBatch(changes []firewall.Change) error {
+	if !fwc.Config.Gateway.Enabled || len(changes) == 0 {
+		return nil
+	}
+
+	script, undo := fwc.gatewayBatchScript(changes)
+	if script == "" {
+		return nil
+	}
+
+	_, err := runNft("updating the gateway sets", strings.NewReader(script), "-f", "-")
+	if err != nil {
+		undo()
+		return err
+	}
+
+	return nil
+}
+
+// gatewayBatchScript records changes in the gateway cover and returns the nft script writing them to the gateway sets, undo
+// forgets them again
+// An unblocked element is added before it is deleted, so that deleting one that is not there does not fail the transaction
+func (fwc *FirewallCmd) gatewayBatchScript(changes []firewall.Change) (script string, undo func()) {
+	elements, undo := fwc.gatewayCover.apply(changes)
+
+	builder := new(strings.Builder)
+	for _, change := range elements {
+		element := fmt.Sprintf("element inet %s %s { %s }", gatewayTable, gatewaySet(change.Prefix), firewall.PrefixString(change.Prefix))
+
+		fmt.Fprintf(builder, "add %s\n", element)
+		if change.Operation == firewall.Unblock {
+			fmt.Fprintf(builder, "delete %s\n", element)
+		}
+	}
+
+	return builder.String(), undo
+}
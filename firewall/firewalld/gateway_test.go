@@ -0,0 +1,88 @@
+package firewalld
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+// TestGatewayBatchScript blocks overlapping prefixes listed by two sources, which one interval set cannot hold side by side
+func TestGatewayBatchScript(t *testing.T) {
+	fwc := &FirewallCmd{gatewayCover: newPrefixCover()}
+
+	steps := []struct {
+		name    string
+		changes []firewall.Change
+		want    []string
+	}{
+		{
+			name:    "turris lists the /8, crowdsec the /16 and an address within both",
+			changes: []firewall.Change{block(firewall.Ingress, "10.0.0.0/8"), block(firewall.Ingress, "10.1.0.0/16"), block(firewall.Ingress, "10.1.2.3/32")},
+			want: []string{
+				"add element inet dynafire_gateway in4 { 10.1.2.3 }",
+				"add element inet dynafire_gateway in4net { 10.0.0.0/8 }",
+			},
+		},
+		{
+			name:    "turris drops the /8",
+			changes: []firewall.Change{unblock(firewall.Ingress, "10.0.0.0/8")},
+			want: []string{
+				"add element inet dynafire_gateway in4net { 10.0.0.0/8 }",
+				"delete element inet dynafire_gateway in4net { 10.0.0.0/8 }",
+				"add element inet dynafire_gateway in4net { 10.1.0.0/16 }",
+			},
+		},
+		{
+			name:    "crowdsec lists an IPv6 net and drops the address",
+			changes: []firewall.Change{block(firewall.Ingress, "2001:db8::/48"), unblock(firewall.Ingress, "10.1.2.3/32")},
+			want: []string{
+				"add element inet dynafire_gateway in4 { 10.1.2.3 }",
+				"delete element inet dynafire_gateway in4 { 10.1.2.3 }",
+				"add element inet dynafire_gateway in6net { 2001:db8::/48 }",
+			},
+		},
+	}
+
+	for _, step := range steps {
+		script, _ := fwc.gatewayBatchScript(step.changes)
+		if got := strings.Split(strings.TrimSpace(script), "\n"); strings.Join(got, "\n") != strings.Join(step.want, "\n") {
+			t.Fatalf("%s: script\n%s\nwant\n%s", step.name, script, strings.Join(step.want, "\n"))
+		}
+	}
+
+	// a batch nft failed on is forgotten
+	_, undo := fwc.gatewayBatchScript([]firewall.Change{block(firewall.Ingress, "10.0.0.0/8")})
+	undo()
+
+	script, _ := fwc.gatewayBatchScript([]firewall.Change{unblock(firewall.Ingress, "10.1.0.0/16")})
+	want := "add element inet dynafire_gateway in4net { 10.1.0.0/16 }\ndelete element inet dynafire_gateway in4net { 10.1.0.0/16 }\n"
+	if script != want {
+		t.Fatalf("script after undo\n%s\nwant\n%s", script, want)
+	}
+}
+
+func TestGatewayRules(t *testing.T) {
+	fwc := &FirewallCmd{Config: Config{
+		RuleAction: firewall.ActionDrop,
+		Gateway:    firewall.Gateway{Enabled: true, IngressInterfaces: []string{"wan"}, EgressInterfaces: []string{"lan0", "lan1"}},
+	}}
+
+	rules := fwc.gatewayRules()
+	if len(rules) != 2*len(gatewaySets) {
+		t.Fatalf("%d gateway rules, want %d:\n%s", len(rules), 2*len(gatewaySets), strings.Join(rules, "\n"))
+	}
+
+	want := map[string]bool{
+		`iifname { "wan" } oifname { "lan0", "lan1" } ip saddr @in4 drop`:     true,
+		`iifname { "lan0", "lan1" } oifname { "wan" } ip6 daddr @in6net drop`: true,
+	}
+
+	for _, rule := range rules {
+		delete(want, rule)
+	}
+
+	for rule := range want {
+		t.Errorf("missing gateway rule %s", rule)
+	}
+}
@@ -0,0 +1,140 @@
+package firewalld
+
+import (
+	"net/netip"
+	"sort"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+// prefixCover tracks the blocked prefixes going into nft interval sets, which reject an element overlapping another one;
+// only the prefixes not within another blocked prefix of the same direction are written to the sets, a prefix within
+// one is written once the one covering it is unblocked
+// Single addresses are passed through as they are, they go to sets of their own without the interval flag
+type prefixCover struct {
+	nets map[firewall.Target]bool
+}
+
+func newPrefixCover() *prefixCover {
+	return &prefixCover{nets: make(map[firewall.Target]bool)}
+}
+
+// apply records changes and returns the set elements to add and delete for them, deletions first, so that a wider prefix
+// is only added once the ones it covers are gone; undo forgets the changes again, i.e. once nft failed to apply the elements
+func (pc *prefixCover) apply(changes []firewall.Change) (elements []firewall.Change, undo func()) {
+	elements = make([]firewall.Change, 0, len(changes))
+	// candidates are the nets whose place in the cover may change, with whether they were written before the changes
+	candidates := make(map[firewall.Target]bool)
+	previous := make(map[firewall.Target]bool)
+
+	for _, change := range changes {
+		target := change.Target()
+		target.Prefix = target.Prefix.Masked()
+
+		if firewall.IsSingleIP(target.Prefix) {
+			elements = append(elements, firewall.Change{Operation: change.Operation, Direction: target.Direction, Prefix: target.Prefix})
+			continue
+		}
+
+		candidates[target] = false
+	}
+
+	if len(candidates) == 0 {
+		return elements, func() {}
+	}
+
+	// the nets within a changed one are uncovered when it is unblocked, and covered when it is blocked
+	for target := range pc.nets {
+		if pc.within(target, candidates) {
+			candidates[target] = false
+		}
+	}
+
+	for target := range candidates {
+		candidates[target] = pc.written(target)
+	}
+
+	for _, change := range changes {
+		target := change.Target()
+		target.Prefix = target.Prefix.Masked()
+
+		if firewall.IsSingleIP(target.Prefix) {
+			continue
+		}
+
+		if _, ok := previous[target]; !ok {
+			previous[target] = pc.nets[target]
+		}
+
+		if change.Operation == firewall.Block {
+			pc.nets[target] = true
+		} else {
+			delete(pc.nets, target)
+		}
+	}
+
+	deleted := make([]firewall.Change, 0)
+	added := make([]firewall.Change, 0)
+	for target, before := range candidates {
+		after := pc.written(target)
+
+		switch {
+		case before && !after:
+			deleted = append(deleted, firewall.Change{Operation: firewall.Unblock, Direction: target.Direction, Prefix: target.Prefix})
+		case !before && after:
+			added = append(added, firewall.Change{Operation: firewall.Block, Direction: target.Direction, Prefix: target.Prefix})
+		}
+	}
+
+	sortChanges(deleted)
+	sortChanges(added)
+	elements = append(append(elements, deleted...), added...)
+
+	undo = func() {
+		for target, present := range previous {
+			if present {
+				pc.nets[target] = true
+			} else {
+				delete(pc.nets, target)
+			}
+		}
+	}
+
+	return elements, undo
+}
+
+// written reports whether target is blocked and not within another blocked prefix of its direction
+func (pc *prefixCover) written(target firewall.Target) bool {
+	if !pc.nets[target] {
+		return false
+	}
+
+	return !pc.within(target, pc.nets)
+}
+
+// within reports whether target lies within another net of nets, in the same direction
+func (pc *prefixCover) within(target firewall.Target, nets map[firewall.Target]bool) bool {
+	for bits := 0; bits < target.Prefix.Bits(); bits++ {
+		wider := netip.PrefixFrom(target.Prefix.Addr(), bits).Masked()
+		if _, ok := nets[firewall.Target{Direction: target.Direction, Prefix: wider}]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortChanges orders changes by direction and prefix, so that the elements of a batch are written in a stable order
+func sortChanges(changes []firewall.Change) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Direction != changes[j].Direction {
+			return changes[i].Direction < changes[j].Direction
+		}
+
+		if changes[i].Prefix.Addr() != changes[j].Prefix.Addr() {
+			return changes[i].Prefix.Addr().Less(changes[j].Prefix.Addr())
+		}
+
+		return changes[i].Prefix.Bits() < changes[j].Prefix.Bits()
+	})
+}
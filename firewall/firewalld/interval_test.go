@@ -0,0 +1,91 @@
+package firewalld
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+func block(direction firewall.Direction, prefix string) firewall.Change {
+	return firewall.Change{Operation: firewall.Block, Direction: direction, Prefix: netip.MustParsePrefix(prefix)}
+}
+
+func unblock(direction firewall.Direction, prefix string) firewall.Change {
+	return firewall.Change{Operation: firewall.Unblock, Direction: direction, Prefix: netip.MustParsePrefix(prefix)}
+}
+
+func TestPrefixCover(t *testing.T) {
+	in, out := firewall.Ingress, firewall.Egress
+
+	tests := []struct {
+		name    string
+		changes []firewall.Change
+		want    []firewall.Change
+	}{
+		{
+			name:    "disjoint nets and single addresses",
+			changes: []firewall.Change{block(in, "10.0.0.0/8"), block(in, "192.0.2.1/32"), block(in, "2001:db8::/32")},
+			want:    []firewall.Change{block(in, "192.0.2.1/32"), block(in, "10.0.0.0/8"), block(in, "2001:db8::/32")},
+		},
+		{
+			name:    "a net within a blocked one is not written",
+			changes: []firewall.Change{block(in, "10.1.0.0/16")},
+			want:    []firewall.Change{},
+		},
+		{
+			name:    "single addresses go to their own set regardless",
+			changes: []firewall.Change{block(in, "10.1.2.3/32")},
+			want:    []firewall.Change{block(in, "10.1.2.3/32")},
+		},
+		{
+			name:    "unblocking the wider net writes the one within it",
+			changes: []firewall.Change{unblock(in, "10.0.0.0/8")},
+			want:    []firewall.Change{unblock(in, "10.0.0.0/8"), block(in, "10.1.0.0/16")},
+		},
+		{
+			name:    "a wider net replaces the ones within it",
+			changes: []firewall.Change{block(in, "10.0.0.0/12")},
+			want:    []firewall.Change{unblock(in, "10.1.0.0/16"), block(in, "10.0.0.0/12")},
+		},
+		{
+			name:    "directions do not cover each other",
+			changes: []firewall.Change{block(out, "10.2.0.0/16")},
+			want:    []firewall.Change{block(out, "10.2.0.0/16")},
+		},
+		{
+			name:    "a net blocked and unblocked in one batch",
+			changes: []firewall.Change{block(in, "198.51.100.0/24"), unblock(in, "198.51.100.0/24")},
+			want:    []firewall.Change{},
+		},
+		{
+			name:    "unblocking a covered net",
+			changes: []firewall.Change{unblock(in, "10.1.0.0/16"), unblock(in, "10.0.0.0/12")},
+			want:    []firewall.Change{unblock(in, "10.0.0.0/12")},
+		},
+	}
+
+	cover := newPrefixCover()
+	for _, test := range tests {
+		got, _ := cover.apply(test.changes)
+		if !slices.Equal(got, test.want) {
+			t.Fatalf("%s: apply() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPrefixCoverUndo(t *testing.T) {
+	cover := newPrefixCover()
+	cover.apply([]firewall.Change{block(firewall.Ingress, "10.1.0.0/16")})
+
+	_, undo := cover.apply([]firewall.Change{block(firewall.Ingress, "10.0.0.0/8"), unblock(firewall.Ingress, "10.1.0.0/16")})
+	undo()
+
+	// the failed batch is forgotten, so blocking the /8 again covers the /16 that is still written
+	got, _ := cover.apply([]firewall.Change{block(firewall.Ingress, "10.0.0.0/8")})
+	want := []firewall.Change{unblock(firewall.Ingress, "10.1.0.0/16"), block(firewall.Ingress, "10.0.0.0/8")}
+	if !slices.Equal(got, want) {
+		t.Fatalf("apply() after undo = %v, want %v", got, want)
+	}
+}
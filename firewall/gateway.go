@@ -0,0 +1,11 @@
+package firewall
+
+// Gateway makes backends filter traffic the host forwards as well, for hosts routing for a LAN or hypervisors routing for their VMs;
+// forwarded traffic from blocked addresses is dropped, as is forwarded traffic to them. The zero Gateway filters no forwarded traffic
+type Gateway struct {
+	Enabled bool
+	// IngressInterfaces face the blocked addresses, i.e. the uplink; empty means any interface
+	IngressInterfaces []string
+	// EgressInterfaces lead to the protected hosts, i.e. a LAN or a VM bridge; empty means any interface
+	EgressInterfaces []string
+}
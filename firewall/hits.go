@@ -0,0 +1,12 @@
+package firewall
+
+// Hits are the packets and bytes that matched a block since it was added
+type Hits struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+// HitCounter is implemented by backends that count the traffic matching every block
+type HitCounter interface {
+	Hits() (map[Target]Hits, error)
+}
@@ -0,0 +1,220 @@
+package memory
+
+import (
+	"log/slog"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+// Blocker is a dry-run firewall.Blocker, it keeps the enforced set in memory without touching the host firewall
+type Blocker struct {
+	logOperations bool
+	ruleAction    string
+	scope         firewall.Scope
+	dropLog       firewall.DropLog
+
+	mu       sync.Mutex
+	enforced map[firewall.Target]struct{}
+}
+
+func New(logOperations bool) *Blocker {
+	return &Blocker{
+		logOperations: logOperations,
+		ruleAction:    firewall.ActionDrop,
+		enforced:      make(map[firewall.Target]struct{}),
+	}
+}
+
+func (b *Blocker) BlockIP(address net.IP) error {
+	prefix, ok := firewall.PrefixFromIP(address)
+	if !ok {
+		slog.Debug("skipping invalid IP", "IP", address.String())
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.block(firewall.Target{Direction: firewall.Ingress, Prefix: prefix})
+
+	return nil
+}
+
+func (b *Blocker) BlockIPList(blacklist []net.IP) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ip := range blacklist {
+		if prefix, ok := firewall.PrefixFromIP(ip); ok {
+			b.enforced[firewall.Target{Direction: firewall.Ingress, Prefix: prefix}] = struct{}{}
+		}
+	}
+
+	if b.logOperations {
+		slog.Info("dry-run: would block IP list", "count", len(blacklist), "enforced", len(b.enforced))
+	}
+
+	return nil
+}
+
+func (b *Blocker) UnblockIP(address net.IP) error {
+	prefix, ok := firewall.PrefixFromIP(address)
+	if !ok {
+		slog.Debug("skipping invalid IP", "IP", address.String())
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.unblock(firewall.Target{Direction: firewall.Ingress, Prefix: prefix})
+
+	return nil
+}
+
+func (b *Blocker) ResetFirewallRules() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.logOperations {
+		slog.Info("dry-run: would reset firewall rules", "removed", len(b.enforced))
+	}
+
+	b.enforced = make(map[firewall.Target]struct{})
+
+	return nil
+}
+
+func (b *Blocker) ApplyBatch(changes []firewall.Change) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, change := range changes {
+		switch change.Operation {
+		case firewall.Block:
+			b.block(change.Target())
+		case firewall.Unblock:
+			b.unblock(change.Target())
+		}
+	}
+
+	return nil
+}
+
+func (b *Blocker) block(target firewall.Target) {
+	if _, ok := b.enforced[target]; ok {
+		slog.Debug("skipping adding existing rule", "prefix", firewall.PrefixString(target.Prefix), "direction", target.Direction)
+		return
+	}
+
+	b.enforced[target] = struct{}{}
+	if b.logOperations {
+		slog.Info("dry-run: would block", "prefix", firewall.PrefixString(target.Prefix), "direction", target.Direction)
+	}
+}
+
+func (b *Blocker) unblock(target firewall.Target) {
+	if _, ok := b.enforced[target]; !ok {
+		slog.Debug("skipping removing non-existent rule", "prefix", firewall.PrefixString(target.Prefix), "direction", target.Direction)
+		return
+	}
+
+	delete(b.enforced, target)
+	if b.logOperations {
+		slog.Info("dry-run: would unblock", "prefix", firewall.PrefixString(target.Prefix), "direction", target.Direction)
+	}
+}
+
+func (b *Blocker) SetRuleAction(action string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.logOperations && action != b.ruleAction {
+		slog.Info("dry-run: would switch rule action", "from", b.ruleAction, "to", action)
+	}
+
+	b.ruleAction = action
+
+	return nil
+}
+
+func (b *Blocker) SetScope(scope firewall.Scope) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.logOperations {
+		slog.Info("dry-run: would limit rules to", "ports", scope.Ports, "protocols", scope.Protocols, "services", scope.Services)
+	}
+
+	b.scope = scope
+
+	return nil
+}
+
+func (b *Blocker) SetDropLog(log firewall.DropLog) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.logOperations && log != b.dropLog {
+		slog.Info("dry-run: would log blocked packets", "enabled", log.Enabled(), "prefix", log.Prefix, "rate", log.Rate)
+	}
+
+	b.dropLog = log
+
+	return nil
+}
+
+// Enforced returns a sorted copy of the set currently enforced in direction
+func (b *Blocker) Enforced(direction firewall.Direction) []netip.Prefix {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	result := make([]netip.Prefix, 0, len(b.enforced))
+	for target := range b.enforced {
+		if target.Direction == direction {
+			result = append(result, target.Prefix)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if c := result[i].Addr().Compare(result[j].Addr()); c != 0 {
+			return c < 0
+		}
+
+		return result[i].Bits() < result[j].Bits()
+	})
+
+	return result
+}
+
+// IsBlocked reports whether traffic from the address is covered by any enforced prefix
+func (b *Blocker) IsBlocked(address net.IP) bool {
+	addr, ok := netip.AddrFromSlice(address)
+	if !ok {
+		return false
+	}
+
+	addr = addr.Unmap()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for target := range b.enforced {
+		if target.Direction == firewall.Ingress && target.Prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *Blocker) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.enforced)
+}
@@ -0,0 +1,53 @@
+package memory
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+func TestBlocker(t *testing.T) {
+	b := New(false)
+
+	if err := b.BlockIPList([]net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("::ffff:192.0.2.2"), nil}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := b.ApplyBatch([]firewall.Change{
+		{Operation: firewall.Block, Direction: firewall.Ingress, Prefix: netip.MustParsePrefix("198.51.100.0/24")},
+		{Operation: firewall.Block, Direction: firewall.Egress, Prefix: netip.MustParsePrefix("203.0.113.7/32")},
+		{Operation: firewall.Unblock, Direction: firewall.Ingress, Prefix: netip.MustParsePrefix("192.0.2.1/32")},
+		// unblocking what is not blocked is skipped, like the firewall backends do
+		{Operation: firewall.Unblock, Direction: firewall.Ingress, Prefix: netip.MustParsePrefix("192.0.2.9/32")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []netip.Prefix{netip.MustParsePrefix("192.0.2.2/32"), netip.MustParsePrefix("198.51.100.0/24")}
+	if got := b.Enforced(firewall.Ingress); !slices.Equal(got, want) {
+		t.Fatalf("Enforced(ingress) = %v, want %v", got, want)
+	}
+
+	if got := b.Enforced(firewall.Egress); len(got) != 1 || got[0] != netip.MustParsePrefix("203.0.113.7/32") {
+		t.Fatalf("Enforced(egress) = %v, want 203.0.113.7/32", got)
+	}
+
+	// only ingress blocks cover traffic from an address
+	for ip, want := range map[string]bool{"198.51.100.20": true, "192.0.2.1": false, "203.0.113.7": false} {
+		if got := b.IsBlocked(net.ParseIP(ip)); got != want {
+			t.Errorf("IsBlocked(%s) = %v, want %v", ip, got, want)
+		}
+	}
+
+	if err := b.ResetFirewallRules(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Len() != 0 {
+		t.Fatalf("%d blocks left after a reset", b.Len())
+	}
+}
@@ -0,0 +1,308 @@
+// Package netlink talks NETLINK_ROUTE to the kernel, for the backends that manage routes, rules and links rather than packet filter rules
+package netlink
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// maxBatch is how many requests go to the kernel in one write
+	maxBatch = 1000
+	// receiveBuffer has room for the errors of a whole batch, the kernel drops what does not fit
+	receiveBuffer = 4 << 20
+)
+
+// Request is a netlink message without its header, which Execute adds
+type Request struct {
+	Kind  uint16
+	Flags uint16
+	Body  []byte
+}
+
+// Execute runs requests on a socket of their own, a socket left with unread messages by a failure is never reused
+func Execute(requests []Request, tolerate func(unix.Errno) bool) error {
+	if len(requests) == 0 {
+		return nil
+	}
+
+	c, err := dial()
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	return c.execute(requests, tolerate)
+}
+
+// Dump returns the bodies of the messages the kernel answers a dump request with
+func Dump(kind uint16, body []byte) ([][]byte, error) {
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	return c.dump(kind, body)
+}
+
+// Get returns the body of the single message the kernel answers a request with
+func Get(kind uint16, body []byte) ([]byte, error) {
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	return c.get(kind, body)
+}
+
+// conn is a NETLINK_ROUTE socket; it sends requests in batches and waits for the kernel to acknowledge every one of them
+type conn struct {
+	fd  int
+	seq uint32
+	buf []byte
+}
+
+func dial() (*conn, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+
+	err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+
+	// errors leave out the failed request, and the buffer is raised past rmem_max if the process may do so
+	_ = unix.SetsockoptInt(fd, unix.SOL_NETLINK, unix.NETLINK_CAP_ACK, 1)
+	if unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUFFORCE, receiveBuffer) != nil {
+		_ = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, receiveBuffer)
+	}
+
+	return &conn{fd: fd, buf: make([]byte, 1<<16)}, nil
+}
+
+func (c *conn) close() error {
+	return unix.Close(c.fd)
+}
+
+// execute sends the requests and waits for the kernel to process them; the errors tolerate accepts, i.e. deleting a route that is gone already,
+// are ignored if it is not nil, and the first other error is returned once every request has been processed
+// Only the last request of a batch asks for an acknowledgement, the kernel processes a batch in order and reports the errors of the others on its own
+func (c *conn) execute(requests []Request, tolerate func(unix.Errno) bool) error {
+	var first error
+	failed := 0
+
+	for start := 0; start < len(requests); start += maxBatch {
+		batch := requests[start:min(start+maxBatch, len(requests))]
+
+		firstSeq := c.seq + 1
+		msg := make([]byte, 0, len(batch)*64)
+		for i, req := range batch {
+			c.seq++
+
+			flags := req.Flags | unix.NLM_F_REQUEST
+			if i == len(batch)-1 {
+				flags |= unix.NLM_F_ACK
+			}
+
+			msg = appendMessage(msg, req.Kind, flags, c.seq, req.Body)
+		}
+
+		err := unix.Sendto(c.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+		if err != nil {
+			return err
+		}
+
+		done := false
+		for !done {
+			messages, err := c.receive()
+			if err != nil {
+				return err
+			}
+
+			for _, m := range messages {
+				if m.kind != unix.NLMSG_ERROR || m.seq < firstSeq || m.seq > c.seq {
+					continue
+				}
+
+				done = done || m.seq == c.seq
+				if len(m.body) < 4 {
+					continue
+				}
+
+				errno := unix.Errno(-int32(binary.NativeEndian.Uint32(m.body)))
+				if errno == 0 || tolerate != nil && tolerate(errno) {
+					continue
+				}
+
+				failed++
+				if first == nil {
+					first = errno
+				}
+			}
+		}
+	}
+
+	if first != nil {
+		return fmt.Errorf("%d of %d requests failed, the first with: %w", failed, len(requests), first)
+	}
+
+	return nil
+}
+
+func (c *conn) dump(kind uint16, body []byte) ([][]byte, error) {
+	c.seq++
+	msg := appendMessage(nil, kind, unix.NLM_F_REQUEST|unix.NLM_F_DUMP, c.seq, body)
+
+	err := unix.Sendto(c.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([][]byte, 0)
+	for {
+		messages, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range messages {
+			if m.seq != c.seq {
+				continue
+			}
+
+			switch m.kind {
+			case unix.NLMSG_DONE:
+				return result, nil
+			case unix.NLMSG_ERROR:
+				if len(m.body) >= 4 {
+					if errno := unix.Errno(-int32(binary.NativeEndian.Uint32(m.body))); errno != 0 {
+						return nil, errno
+					}
+				}
+			default:
+				result = append(result, m.body)
+			}
+		}
+	}
+}
+
+func (c *conn) get(kind uint16, body []byte) ([]byte, error) {
+	c.seq++
+	msg := appendMessage(nil, kind, unix.NLM_F_REQUEST, c.seq, body)
+
+	err := unix.Sendto(c.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		messages, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range messages {
+			if m.seq != c.seq {
+				continue
+			}
+
+			if m.kind != unix.NLMSG_ERROR {
+				return m.body, nil
+			}
+
+			if len(m.body) >= 4 {
+				if errno := unix.Errno(-int32(binary.NativeEndian.Uint32(m.body))); errno != 0 {
+					return nil, errno
+				}
+			}
+		}
+	}
+}
+
+type message struct {
+	kind uint16
+	seq  uint32
+	body []byte
+}
+
+func (c *conn) receive() ([]message, error) {
+	n, _, err := unix.Recvfrom(c.fd, c.buf, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	data := c.buf[:n]
+	result := make([]message, 0)
+	for len(data) >= unix.NLMSG_HDRLEN {
+		length := int(binary.NativeEndian.Uint32(data[0:4]))
+		if length < unix.NLMSG_HDRLEN || length > len(data) {
+			return nil, errors.New("truncated netlink message")
+		}
+
+		// the body is copied, the buffer is reused by the next receive
+		result = append(result, message{
+			kind: binary.NativeEndian.Uint16(data[4:6]),
+			seq:  binary.NativeEndian.Uint32(data[8:12]),
+			body: append([]byte(nil), data[unix.NLMSG_HDRLEN:length]...),
+		})
+
+		data = data[min(align(length), len(data)):]
+	}
+
+	return result, nil
+}
+
+func appendMessage(msg []byte, kind, flags uint16, seq uint32, body []byte) []byte {
+	msg = binary.NativeEndian.AppendUint32(msg, uint32(unix.NLMSG_HDRLEN+len(body)))
+	msg = binary.NativeEndian.AppendUint16(msg, kind)
+	msg = binary.NativeEndian.AppendUint16(msg, flags)
+	msg = binary.NativeEndian.AppendUint32(msg, seq)
+	msg = binary.NativeEndian.AppendUint32(msg, 0)
+	msg = append(msg, body...)
+
+	return append(msg, make([]byte, align(len(body))-len(body))...)
+}
+
+func AppendAttr(body []byte, kind uint16, value []byte) []byte {
+	body = binary.NativeEndian.AppendUint16(body, uint16(unix.SizeofRtAttr+len(value)))
+	body = binary.NativeEndian.AppendUint16(body, kind)
+	body = append(body, value...)
+
+	return append(body, make([]byte, align(len(value))-len(value))...)
+}
+
+func Uint32Attr(value uint32) []byte {
+	return binary.NativeEndian.AppendUint32(nil, value)
+}
+
+func Uint32Value(value []byte) uint32 {
+	return binary.NativeEndian.Uint32(value)
+}
+
+// ParseAttrs splits the attributes following a fixed size header, later attributes of the same kind win
+func ParseAttrs(data []byte) map[uint16][]byte {
+	attrs := make(map[uint16][]byte)
+	for len(data) >= unix.SizeofRtAttr {
+		length := int(binary.NativeEndian.Uint16(data[0:2]))
+		if length < unix.SizeofRtAttr || length > len(data) {
+			break
+		}
+
+		attrs[binary.NativeEndian.Uint16(data[2:4])] = data[unix.SizeofRtAttr:length]
+		data = data[min(align(length), len(data)):]
+	}
+
+	return attrs
+}
+
+func align(length int) int {
+	return (length + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+}
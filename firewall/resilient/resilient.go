@@ -0,0 +1,266 @@
+package resilient
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/MatejLach/dynafire/firewall"
+	"github.com/MatejLach/dynafire/metrics"
+)
+
+const (
+	DefaultMaxAttempts      = 4
+	DefaultInitialBackoff   = 500 * time.Millisecond
+	DefaultMaxBackoff       = 30 * time.Second
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = time.Minute
+)
+
+// ErrCircuitOpen is returned without calling the backend while the circuit breaker is open
+var ErrCircuitOpen = errors.New("firewall backend circuit breaker is open")
+
+type BreakerState int
+
+const (
+	Closed BreakerState = iota
+	Open
+	HalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type Policy struct {
+	// MaxAttempts is how many times a transient failure is tried in total before giving up on an operation
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// BreakerThreshold is the number of consecutive failed operations that opens the circuit breaker
+	BreakerThreshold int
+	// BreakerCooldown is how long the breaker stays open before letting a trial operation through
+	BreakerCooldown time.Duration
+}
+
+// Health is a snapshot of the backend health, as shown by `dynafire status`
+type Health struct {
+	Degraded            bool      `json:"degraded"`
+	CircuitBreaker      string    `json:"circuit_breaker"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastErrorClass      string    `json:"last_error_class,omitempty"`
+	LastErrorTime       time.Time `json:"last_error_time,omitempty"`
+	LastSuccessTime     time.Time `json:"last_success_time,omitempty"`
+}
+
+// Blocker wraps a firewall.Blocker with per-operation retries and a circuit breaker
+// Fatal and rejected errors are never retried and are returned as they are
+type Blocker struct {
+	backend firewall.Blocker
+	policy  Policy
+
+	mu                  sync.Mutex
+	state               BreakerState
+	openedAt            time.Time
+	consecutiveFailures int
+	lastErr             error
+	lastErrTime         time.Time
+	lastSuccessTime     time.Time
+}
+
+func New(backend firewall.Blocker, policy Policy) *Blocker {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = DefaultMaxAttempts
+	}
+
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = DefaultInitialBackoff
+	}
+
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = DefaultMaxBackoff
+	}
+
+	if policy.BreakerThreshold <= 0 {
+		policy.BreakerThreshold = DefaultBreakerThreshold
+	}
+
+	if policy.BreakerCooldown <= 0 {
+		policy.BreakerCooldown = DefaultBreakerCooldown
+	}
+
+	metrics.BackendDegraded.Set(0)
+	metrics.BreakerState.Set(float64(Closed))
+
+	return &Blocker{
+		backend: backend,
+		policy:  policy,
+	}
+}
+
+// Backend returns the wrapped firewall.Blocker, looking through decorators, so that callers can check for optional interfaces
+func (b *Blocker) Backend() firewall.Blocker {
+	backend := b.backend
+	for {
+		wrapper, ok := backend.(firewall.Wrapper)
+		if !ok {
+			return backend
+		}
+
+		backend = wrapper.Unwrap()
+	}
+}
+
+func (b *Blocker) BlockIP(address net.IP) error {
+	return b.do("block IP", func() error { return b.backend.BlockIP(address) })
+}
+
+func (b *Blocker) BlockIPList(blacklist []net.IP) error {
+	return b.do("block IP list", func() error { return b.backend.BlockIPList(blacklist) })
+}
+
+func (b *Blocker) UnblockIP(address net.IP) error {
+	return b.do("unblock IP", func() error { return b.backend.UnblockIP(address) })
+}
+
+func (b *Blocker) ResetFirewallRules() error {
+	return b.do("reset firewall rules", b.backend.ResetFirewallRules)
+}
+
+func (b *Blocker) ApplyBatch(changes []firewall.Change) error {
+	return b.do("apply batch", func() error { return b.backend.ApplyBatch(changes) })
+}
+
+func (b *Blocker) Health() Health {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	h := Health{
+		Degraded:            b.state != Closed || b.consecutiveFailures > 0,
+		CircuitBreaker:      b.currentState().String(),
+		ConsecutiveFailures: b.consecutiveFailures,
+		LastErrorTime:       b.lastErrTime,
+		LastSuccessTime:     b.lastSuccessTime,
+	}
+
+	if b.lastErr != nil {
+		h.LastError = b.lastErr.Error()
+		h.LastErrorClass = firewall.ClassOf(b.lastErr).String()
+	}
+
+	return h
+}
+
+// currentState moves an open breaker to half-open once the cooldown has passed; b.mu must be held
+func (b *Blocker) currentState() BreakerState {
+	if b.state == Open && time.Since(b.openedAt) >= b.policy.BreakerCooldown {
+		b.state = HalfOpen
+		metrics.BreakerState.Set(float64(HalfOpen))
+		slog.Info("firewall backend circuit breaker half-open, letting a trial operation through")
+	}
+
+	return b.state
+}
+
+func (b *Blocker) do(op string, fn func() error) error {
+	b.mu.Lock()
+	state := b.currentState()
+	b.mu.Unlock()
+
+	if state == Open {
+		return fmt.Errorf("%s: %w", op, ErrCircuitOpen)
+	}
+
+	// a half-open breaker only gets a single attempt, it either closes or opens again
+	attempts := b.policy.MaxAttempts
+	if state == HalfOpen {
+		attempts = 1
+	}
+
+	backoff := b.policy.InitialBackoff
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = fn()
+		if err == nil {
+			b.recordSuccess()
+			return nil
+		}
+
+		class := firewall.ClassOf(err)
+		metrics.BackendErrors.Inc(class.String())
+
+		// the backend answered, it just refused the changes, which says nothing about its health
+		if class == firewall.Rejected {
+			return err
+		}
+
+		if class == firewall.Fatal || attempt == attempts {
+			break
+		}
+
+		slog.Warn("firewall backend operation failed, retrying", "operation", op, "attempt", attempt, "backoff", backoff, "details", err)
+		metrics.BackendRetries.Inc()
+
+		time.Sleep(backoff)
+		backoff = min(backoff*2, b.policy.MaxBackoff)
+	}
+
+	b.recordFailure(op, err)
+
+	return err
+}
+
+func (b *Blocker) recordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != Closed || b.consecutiveFailures > 0 {
+		slog.Info("firewall backend recovered", "consecutive_failures", b.consecutiveFailures)
+	}
+
+	b.state = Closed
+	b.consecutiveFailures = 0
+	b.lastSuccessTime = time.Now()
+
+	metrics.BackendDegraded.Set(0)
+	metrics.BreakerState.Set(float64(Closed))
+}
+
+func (b *Blocker) recordFailure(op string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.consecutiveFailures++
+	b.lastErr = err
+	b.lastErrTime = time.Now()
+
+	metrics.BackendDegraded.Set(1)
+
+	if b.state == HalfOpen || b.consecutiveFailures >= b.policy.BreakerThreshold {
+		if b.state != Open {
+			slog.Error("firewall backend keeps failing, opening circuit breaker", "operation", op, "consecutive_failures", b.consecutiveFailures, "cooldown", b.policy.BreakerCooldown, "details", err)
+		}
+
+		b.state = Open
+		b.openedAt = time.Now()
+		metrics.BreakerState.Set(float64(Open))
+
+		return
+	}
+
+	slog.Error("firewall backend operation failed, running degraded", "operation", op, "consecutive_failures", b.consecutiveFailures, "details", err)
+}
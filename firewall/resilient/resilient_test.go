@@ -0,0 +1,94 @@
+package resilient
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+// failingBackend fails its operations with err, counting the calls
+type failingBackend struct {
+	err   error
+	calls int
+}
+
+func (f *failingBackend) apply() error {
+	f.calls++
+	return f.err
+}
+
+func (f *failingBackend) BlockIP(net.IP) error               { return f.apply() }
+func (f *failingBackend) BlockIPList([]net.IP) error         { return f.apply() }
+func (f *failingBackend) UnblockIP(net.IP) error             { return f.apply() }
+func (f *failingBackend) ResetFirewallRules() error          { return f.apply() }
+func (f *failingBackend) ApplyBatch([]firewall.Change) error { return f.apply() }
+
+func TestBlockerRetries(t *testing.T) {
+	errBackend := errors.New("backend failed")
+
+	tests := []struct {
+		name      string
+		err       error
+		wantCalls int
+		degraded  bool
+	}{
+		{name: "transient errors are retried", err: firewall.NewTransientError("apply", errBackend), wantCalls: 3, degraded: true},
+		{name: "fatal errors are not retried", err: firewall.NewFatalError("apply", errBackend), wantCalls: 1, degraded: true},
+		// a rejected batch says nothing about the backend health
+		{name: "rejected errors are not retried", err: firewall.NewRejectedError("apply", errBackend), wantCalls: 1, degraded: false},
+	}
+
+	for _, test := range tests {
+		backend := &failingBackend{err: test.err}
+		b := New(backend, Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+
+		if err := b.ApplyBatch(nil); !errors.Is(err, errBackend) {
+			t.Fatalf("%s: ApplyBatch() = %v, want %v", test.name, err, errBackend)
+		}
+
+		if backend.calls != test.wantCalls {
+			t.Errorf("%s: backend called %d times, want %d", test.name, backend.calls, test.wantCalls)
+		}
+
+		if b.Health().Degraded != test.degraded {
+			t.Errorf("%s: degraded = %v, want %v", test.name, b.Health().Degraded, test.degraded)
+		}
+	}
+}
+
+func TestBlockerBreaker(t *testing.T) {
+	backend := &failingBackend{err: firewall.NewFatalError("apply", errors.New("firewall-cmd not found"))}
+	b := New(backend, Policy{BreakerThreshold: 2, BreakerCooldown: 50 * time.Millisecond})
+
+	for i := 0; i < 2; i++ {
+		_ = b.ApplyBatch(nil)
+	}
+
+	if state := b.Health().CircuitBreaker; state != Open.String() {
+		t.Fatalf("breaker %s after reaching the threshold, want open", state)
+	}
+
+	if err := b.ApplyBatch(nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("ApplyBatch() on an open breaker = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	if backend.calls != 2 {
+		t.Fatalf("backend called %d times, want the open breaker to skip it", backend.calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	// the trial operation after the cooldown closes the breaker again
+	backend.err = nil
+	if err := b.ApplyBatch(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	h := b.Health()
+	if h.CircuitBreaker != Closed.String() || h.Degraded || h.ConsecutiveFailures != 0 {
+		t.Fatalf("health after recovering = %+v, want a closed breaker", h)
+	}
+}
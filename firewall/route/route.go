@@ -0,0 +1,309 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/MatejLach/dynafire/firewall"
+	"github.com/MatejLach/dynafire/firewall/netlink"
+)
+
+const (
+	DefaultTable    = 1714
+	DefaultPriority = 1000
+	// protocol marks the routes dynafire owns, so that cleaning up never touches routes added by anything else;
+	// it is unassigned in iproute2's rt_protos
+	protocol = 213
+)
+
+// Config holds the route backend settings
+type Config struct {
+	// Table is the routing table holding the routes, it should not be used by anything else
+	Table uint32
+	// Priority is the priority of the policy rule looking the table up, rules with lower values are looked up first
+	Priority uint32
+	// RuleAction is drop for blackhole routes or reject for prohibit routes, which answer with ICMP administratively prohibited
+	RuleAction string
+}
+
+// Blocker is a firewall.Blocker that installs a blackhole or prohibit route per blocked prefix in a dedicated routing table,
+// looked up by a policy rule ahead of the main table; it leaves the packet filter alone
+// Routes match destinations, so whatever the direction of a block, traffic to the blocked addresses dies in the kernel:
+// ingress blocks stop the replies to blocked sources, egress blocks stop connections to blocked destinations
+type Blocker struct {
+	conf Config
+
+	mu sync.Mutex
+	// enforced holds the blocks, a prefix blocked in both directions takes a single route
+	enforced map[firewall.Target]struct{}
+}
+
+func New(conf Config) (*Blocker, error) {
+	if conf.Table == 0 {
+		conf.Table = DefaultTable
+	}
+
+	if conf.Priority == 0 {
+		conf.Priority = DefaultPriority
+	}
+
+	if conf.RuleAction == "" {
+		conf.RuleAction = firewall.ActionDrop
+	}
+
+	b := &Blocker{
+		conf:     conf,
+		enforced: make(map[firewall.Target]struct{}),
+	}
+
+	err := b.addRules()
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// addRules adds the policy rules looking the table up, for both address families, unless they exist already
+func (b *Blocker) addRules() error {
+	requests := make([]netlink.Request, 0, 2)
+	for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		requests = append(requests, netlink.Request{Kind: unix.RTM_NEWRULE, Flags: unix.NLM_F_CREATE | unix.NLM_F_EXCL, Body: b.ruleBody(family)})
+	}
+
+	err := netlink.Execute(requests, func(errno unix.Errno) bool { return errno == unix.EEXIST })
+	if err != nil {
+		return netlinkError(fmt.Sprintf("adding the policy rules looking up routing table %d", b.conf.Table), err)
+	}
+
+	return nil
+}
+
+func (b *Blocker) BlockIP(address net.IP) error {
+	prefix, ok := firewall.PrefixFromIP(address)
+	if !ok {
+		return firewall.NewFatalError("blocking an IP", fmt.Errorf("invalid IP %v", address))
+	}
+
+	return b.ApplyBatch([]firewall.Change{{Operation: firewall.Block, Prefix: prefix}})
+}
+
+func (b *Blocker) BlockIPList(blacklist []net.IP) error {
+	changes := make([]firewall.Change, 0, len(blacklist))
+	for _, ip := range blacklist {
+		if prefix, ok := firewall.PrefixFromIP(ip); ok {
+			changes = append(changes, firewall.Change{Operation: firewall.Block, Prefix: prefix})
+		}
+	}
+
+	return b.ApplyBatch(changes)
+}
+
+func (b *Blocker) UnblockIP(address net.IP) error {
+	prefix, ok := firewall.PrefixFromIP(address)
+	if !ok {
+		return firewall.NewFatalError("unblocking an IP", fmt.Errorf("invalid IP %v", address))
+	}
+
+	return b.ApplyBatch([]firewall.Change{{Operation: firewall.Unblock, Prefix: prefix}})
+}
+
+// ResetFirewallRules removes every route dynafire added to the table, as listed by the kernel rather than as remembered,
+// so that routes left behind by a previous run go as well, and routes of anything else stay
+func (b *Blocker) ResetFirewallRules() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// struct rtmsg, all zero: any family, any table
+	routes, err := netlink.Dump(unix.RTM_GETROUTE, make([]byte, unix.SizeofRtMsg))
+	if err != nil {
+		return netlinkError("listing routes", err)
+	}
+
+	requests := make([]netlink.Request, 0)
+	for _, route := range routes {
+		if len(route) < unix.SizeofRtMsg {
+			continue
+		}
+
+		attrs := netlink.ParseAttrs(route[unix.SizeofRtMsg:])
+		table := uint32(route[4])
+		if value, ok := attrs[unix.RTA_TABLE]; ok && len(value) == 4 {
+			table = netlink.Uint32Value(value)
+		}
+
+		if table != b.conf.Table || route[5] != protocol {
+			continue
+		}
+
+		requests = append(requests, netlink.Request{Kind: unix.RTM_DELROUTE, Body: b.routeBody(route[0], route[1], attrs[unix.RTA_DST], 0, unix.RT_SCOPE_NOWHERE)})
+	}
+
+	err = netlink.Execute(requests, func(errno unix.Errno) bool { return errno == unix.ESRCH })
+	if err != nil {
+		return netlinkError(fmt.Sprintf("flushing routing table %d", b.conf.Table), err)
+	}
+
+	clear(b.enforced)
+
+	return nil
+}
+
+func (b *Blocker) ApplyBatch(changes []firewall.Change) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	routeType := uint8(unix.RTN_BLACKHOLE)
+	if b.conf.RuleAction == firewall.ActionReject {
+		routeType = unix.RTN_PROHIBIT
+	}
+
+	// the blocks of the batch are staged and only recorded once it is applied, a failed batch leaves enforced as it was
+	staged := make(map[firewall.Target]bool, len(changes))
+	enforced := func(target firewall.Target) bool {
+		if blocked, ok := staged[target]; ok {
+			return blocked
+		}
+
+		_, ok := b.enforced[target]
+
+		return ok
+	}
+
+	requests := make([]netlink.Request, 0, len(changes))
+	for _, change := range changes {
+		target := change.Target()
+		other := firewall.Target{Direction: firewall.Egress, Prefix: target.Prefix}
+		if target.Direction == firewall.Egress {
+			other.Direction = firewall.Ingress
+		}
+
+		shared := enforced(other)
+		prefix := change.Prefix.Masked()
+
+		switch change.Operation {
+		case firewall.Block:
+			staged[target] = true
+			if !shared {
+				requests = append(requests, netlink.Request{
+					Kind:  unix.RTM_NEWROUTE,
+					Flags: unix.NLM_F_CREATE | unix.NLM_F_REPLACE,
+					Body:  b.routeBody(family(prefix), uint8(prefix.Bits()), prefix.Addr().AsSlice(), routeType, unix.RT_SCOPE_UNIVERSE),
+				})
+			}
+		case firewall.Unblock:
+			staged[target] = false
+			if !shared {
+				requests = append(requests, netlink.Request{
+					Kind: unix.RTM_DELROUTE,
+					Body: b.routeBody(family(prefix), uint8(prefix.Bits()), prefix.Addr().AsSlice(), 0, unix.RT_SCOPE_NOWHERE),
+				})
+			}
+		}
+	}
+
+	// replacing covers adding a route that exists, deleting one that does not is just as fine
+	err := netlink.Execute(requests, func(errno unix.Errno) bool { return errno == unix.ESRCH })
+	if err != nil {
+		return netlinkError(fmt.Sprintf("applying a batch of route changes to routing table %d", b.conf.Table), err)
+	}
+
+	for target, blocked := range staged {
+		if blocked {
+			b.enforced[target] = struct{}{}
+		} else {
+			delete(b.enforced, target)
+		}
+	}
+
+	return nil
+}
+
+// SetRuleAction switches between blackhole and prohibit routes for routes added from now on, existing routes are not touched,
+// so the caller has to resync the blacklist afterwards
+func (b *Blocker) SetRuleAction(action string) error {
+	switch action {
+	case firewall.ActionDrop, firewall.ActionReject:
+	default:
+		return firewall.NewFatalError("setting rule action", fmt.Errorf("unknown rule action %q", action))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.conf.RuleAction = action
+
+	return nil
+}
+
+// Close removes the routes and then the policy rules looking the table up, leaving nothing of the backend behind
+func (b *Blocker) Close() error {
+	err := b.ResetFirewallRules()
+	if err != nil {
+		return err
+	}
+
+	requests := make([]netlink.Request, 0, 2)
+	for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		requests = append(requests, netlink.Request{Kind: unix.RTM_DELRULE, Body: b.ruleBody(family)})
+	}
+
+	err = netlink.Execute(requests, func(errno unix.Errno) bool { return errno == unix.ENOENT })
+	if err != nil {
+		return netlinkError(fmt.Sprintf("removing the policy rules looking up routing table %d", b.conf.Table), err)
+	}
+
+	return nil
+}
+
+// ruleBody is the policy rule message looking the table up for family
+func (b *Blocker) ruleBody(family uint8) []byte {
+	// struct fib_rule_hdr: family, dst_len, src_len, tos, table, two reserved bytes, action and flags
+	body := []byte{family, 0, 0, 0, compatTable(b.conf.Table), 0, 0, unix.FR_ACT_TO_TBL, 0, 0, 0, 0}
+	body = netlink.AppendAttr(body, unix.FRA_TABLE, netlink.Uint32Attr(b.conf.Table))
+
+	return netlink.AppendAttr(body, unix.FRA_PRIORITY, netlink.Uint32Attr(b.conf.Priority))
+}
+
+// routeBody is a route message in the table; a zero routeType matches routes of any type when deleting
+func (b *Blocker) routeBody(family, bits uint8, dst []byte, routeType, scope uint8) []byte {
+	// struct rtmsg: family, dst_len, src_len, tos, table, protocol, scope, type and flags
+	body := []byte{family, bits, 0, 0, compatTable(b.conf.Table), protocol, scope, routeType, 0, 0, 0, 0}
+	if dst != nil {
+		body = netlink.AppendAttr(body, unix.RTA_DST, dst)
+	}
+
+	return netlink.AppendAttr(body, unix.RTA_TABLE, netlink.Uint32Attr(b.conf.Table))
+}
+
+// compatTable is the table for the 8 bit field of route and rule headers, larger tables only go in the table attribute
+func compatTable(table uint32) uint8 {
+	if table > 255 {
+		return unix.RT_TABLE_UNSPEC
+	}
+
+	return uint8(table)
+}
+
+// netlinkError classifies a failed netlink request; missing privileges will not go away by retrying, anything else might
+func netlinkError(op string, err error) error {
+	if errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES) {
+		return firewall.NewFatalError(op, err)
+	}
+
+	return firewall.NewTransientError(op, err)
+}
+
+// family is the address family of prefix
+func family(prefix netip.Prefix) uint8 {
+	if prefix.Addr().Is4() {
+		return unix.AF_INET
+	}
+
+	return unix.AF_INET6
+}
@@ -0,0 +1,79 @@
+package route
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/MatejLach/dynafire/firewall"
+	"github.com/MatejLach/dynafire/firewall/netlink"
+)
+
+func TestRouteBody(t *testing.T) {
+	tests := []struct {
+		table uint32
+		// header is the table in the 8 bit field of the route header
+		header uint8
+	}{
+		{table: 200, header: 200},
+		{table: DefaultTable, header: unix.RT_TABLE_UNSPEC},
+	}
+
+	prefix := netip.MustParsePrefix("2001:db8::/32")
+	for _, test := range tests {
+		b := &Blocker{conf: Config{Table: test.table}}
+
+		body := b.routeBody(family(prefix), uint8(prefix.Bits()), prefix.Addr().AsSlice(), unix.RTN_PROHIBIT, unix.RT_SCOPE_UNIVERSE)
+		if len(body) < unix.SizeofRtMsg {
+			t.Fatalf("route message of %d bytes is shorter than its header", len(body))
+		}
+
+		want := []byte{unix.AF_INET6, 32, 0, 0, test.header, protocol, unix.RT_SCOPE_UNIVERSE, unix.RTN_PROHIBIT}
+		if !bytes.Equal(body[:len(want)], want) {
+			t.Errorf("table %d: route header %v, want %v", test.table, body[:len(want)], want)
+		}
+
+		attrs := netlink.ParseAttrs(body[unix.SizeofRtMsg:])
+		if !bytes.Equal(attrs[unix.RTA_DST], prefix.Addr().AsSlice()) {
+			t.Errorf("table %d: destination %v, want %v", test.table, attrs[unix.RTA_DST], prefix.Addr().AsSlice())
+		}
+
+		if table := netlink.Uint32Value(attrs[unix.RTA_TABLE]); table != test.table {
+			t.Errorf("table %d: table attribute %d", test.table, table)
+		}
+	}
+}
+
+func TestApplyBatchShared(t *testing.T) {
+	ingress := firewall.Target{Direction: firewall.Ingress, Prefix: netip.MustParsePrefix("192.0.2.0/24")}
+	egress := firewall.Target{Direction: firewall.Egress, Prefix: ingress.Prefix}
+
+	b := &Blocker{enforced: map[firewall.Target]struct{}{egress: {}}}
+
+	// a prefix already routed for the other direction takes no netlink request, so these never reach the kernel
+	err := b.ApplyBatch([]firewall.Change{
+		{Operation: firewall.Block, Direction: firewall.Ingress, Prefix: ingress.Prefix},
+		{Operation: firewall.Unblock, Direction: firewall.Egress, Prefix: egress.Prefix},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := b.enforced[ingress]; !ok || len(b.enforced) != 1 {
+		t.Fatalf("enforced %v, want only the ingress block", b.enforced)
+	}
+}
+
+func TestNetlinkError(t *testing.T) {
+	if err := netlinkError("adding a route", unix.EPERM); !firewall.IsFatal(err) {
+		t.Errorf("netlinkError(EPERM) = %v, want a fatal error", err)
+	}
+
+	err := netlinkError("adding a route", unix.ENOBUFS)
+	if firewall.ClassOf(err) != firewall.Transient || !errors.Is(err, unix.ENOBUFS) {
+		t.Errorf("netlinkError(ENOBUFS) = %v, want a transient error wrapping it", err)
+	}
+}
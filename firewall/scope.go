@@ -0,0 +1,87 @@
+package firewall
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PortProtocols are the protocols a port can be given for
+var PortProtocols = []string{"tcp", "udp", "sctp", "dccp"}
+
+// Scope limits blocking to some destinations on the host, i.e. SSH and mail submission, while listed sources
+// can still reach everything else; the zero Scope blocks all traffic from listed sources
+type Scope struct {
+	Ports []Port
+	// Protocols block whole protocols, i.e. "icmp" or "gre"
+	Protocols []string
+	// Services are firewalld service names, i.e. "ssh"
+	Services []string
+}
+
+func (s Scope) IsZero() bool {
+	return len(s.Ports) == 0 && len(s.Protocols) == 0 && len(s.Services) == 0
+}
+
+// Port is a single port or a range of ports of a protocol
+type Port struct {
+	From     uint16
+	To       uint16
+	Protocol string
+}
+
+// Range formats the port the way firewalld and nftables take it, i.e. "22" or "5000-5100"
+func (p Port) Range() string {
+	if p.From == p.To {
+		return strconv.Itoa(int(p.From))
+	}
+
+	return fmt.Sprintf("%d-%d", p.From, p.To)
+}
+
+func (p Port) String() string {
+	return p.Range() + "/" + p.Protocol
+}
+
+// ParsePort parses ports written as "22/tcp" or "5000-5100/udp"
+func ParsePort(s string) (Port, error) {
+	ports, protocol, found := strings.Cut(strings.TrimSpace(s), "/")
+	if !found {
+		return Port{}, fmt.Errorf("port %q lacks a protocol, i.e. %s/tcp", s, ports)
+	}
+
+	protocol = strings.ToLower(protocol)
+	known := false
+	for _, p := range PortProtocols {
+		if protocol == p {
+			known = true
+		}
+	}
+
+	if !known {
+		return Port{}, fmt.Errorf("port %q has an unknown protocol, expected one of %s", s, strings.Join(PortProtocols, ", "))
+	}
+
+	fromStr, toStr, isRange := strings.Cut(ports, "-")
+	if !isRange {
+		toStr = fromStr
+	}
+
+	from, err := strconv.ParseUint(fromStr, 10, 16)
+	if err != nil || from == 0 {
+		return Port{}, fmt.Errorf("port %q is not a valid port or port range", s)
+	}
+
+	to, err := strconv.ParseUint(toStr, 10, 16)
+	if err != nil || to < from {
+		return Port{}, fmt.Errorf("port %q is not a valid port or port range", s)
+	}
+
+	return Port{From: uint16(from), To: uint16(to), Protocol: protocol}, nil
+}
+
+// ScopeSetter is implemented by backends that can limit blocking to a Scope
+// The new scope applies to rules added afterwards, so callers resync the blacklist after changing it
+type ScopeSetter interface {
+	SetScope(scope Scope) error
+}
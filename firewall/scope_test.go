@@ -0,0 +1,39 @@
+package firewall
+
+import "testing"
+
+func TestParsePort(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Port
+		wantErr bool
+	}{
+		{input: "22/tcp", want: Port{From: 22, To: 22, Protocol: "tcp"}},
+		{input: " 5000-5100/UDP", want: Port{From: 5000, To: 5100, Protocol: "udp"}},
+		{input: "22", wantErr: true},
+		{input: "22/icmp", wantErr: true},
+		{input: "0/tcp", wantErr: true},
+		{input: "70000/tcp", wantErr: true},
+		{input: "5100-5000/tcp", wantErr: true},
+		{input: "ssh/tcp", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := ParsePort(test.input)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParsePort(%q) = %v, want an error", test.input, got)
+			}
+
+			continue
+		}
+
+		if err != nil || got != test.want {
+			t.Errorf("ParsePort(%q) = %v, %v, want %v", test.input, got, err, test.want)
+		}
+	}
+
+	if got := (Port{From: 5000, To: 5100, Protocol: "udp"}).String(); got != "5000-5100/udp" {
+		t.Errorf("String() = %q, want 5000-5100/udp", got)
+	}
+}
@@ -0,0 +1,231 @@
+package xdp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+// bpf(2) attributes, laid out like the kernel's union bpf_attr members for each command
+
+type mapCreateAttr struct {
+	mapType    uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	mapFlags   uint32
+	innerMapFd uint32
+	numaNode   uint32
+	mapName    [16]byte
+}
+
+type mapElemAttr struct {
+	mapFd uint32
+	_     uint32
+	key   uint64
+	value uint64
+	flags uint64
+}
+
+type progLoadAttr struct {
+	progType           uint32
+	insnCnt            uint32
+	insns              uint64
+	license            uint64
+	logLevel           uint32
+	logSize            uint32
+	logBuf             uint64
+	kernVersion        uint32
+	progFlags          uint32
+	progName           [16]byte
+	progIfindex        uint32
+	expectedAttachType uint32
+}
+
+type getIDAttr struct {
+	id        uint32
+	nextID    uint32
+	openFlags uint32
+}
+
+type objInfoAttr struct {
+	fd      uint32
+	infoLen uint32
+	info    uint64
+}
+
+const (
+	// valueSize holds the packets and bytes dropped, two 64 bit counters
+	valueSize = 16
+	// progInfoNameOffset is the offset of the name in struct bpf_prog_info
+	progInfoNameOffset = 64
+	// BPF_NOEXIST, adding an entry keeps the counters of one that is there already
+	updateNoExist = 1
+)
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return int(fd), nil
+}
+
+// trie is an LPM trie map of the blocked prefixes of one address family, the value of every entry counts the packets it dropped
+type trie struct {
+	fd      int
+	addrLen int
+}
+
+func newTrie(name string, addrLen, maxEntries int) (*trie, error) {
+	attr := mapCreateAttr{
+		mapType: unix.BPF_MAP_TYPE_LPM_TRIE,
+		// struct bpf_lpm_trie_key: the prefix length followed by the address
+		keySize:    uint32(4 + addrLen),
+		valueSize:  valueSize,
+		maxEntries: uint32(maxEntries),
+		// LPM tries allocate their entries on demand, and require saying so
+		mapFlags: unix.BPF_F_NO_PREALLOC,
+	}
+	copy(attr.mapName[:], name)
+
+	fd, err := bpf(unix.BPF_MAP_CREATE, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return &trie{fd: fd, addrLen: addrLen}, nil
+}
+
+func (t *trie) key(prefix netip.Prefix) []byte {
+	key := binary.NativeEndian.AppendUint32(nil, uint32(prefix.Bits()))
+	return append(key, prefix.Masked().Addr().AsSlice()...)
+}
+
+// add adds prefix, keeping the counters if it is there already
+func (t *trie) add(prefix netip.Prefix) error {
+	key := t.key(prefix)
+	value := make([]byte, valueSize)
+
+	err := t.elem(unix.BPF_MAP_UPDATE_ELEM, key, value, updateNoExist)
+	if errors.Is(err, unix.EEXIST) {
+		return nil
+	}
+
+	return err
+}
+
+// remove removes prefix, unless it is gone already
+func (t *trie) remove(prefix netip.Prefix) error {
+	err := t.elem(unix.BPF_MAP_DELETE_ELEM, t.key(prefix), nil, 0)
+	if errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+
+	return err
+}
+
+// hits returns the counters of prefix, if it is in the map
+func (t *trie) hits(prefix netip.Prefix) (firewall.Hits, bool, error) {
+	value := make([]byte, valueSize)
+
+	err := t.elem(unix.BPF_MAP_LOOKUP_ELEM, t.key(prefix), value, 0)
+	if errors.Is(err, unix.ENOENT) {
+		return firewall.Hits{}, false, nil
+	}
+
+	if err != nil {
+		return firewall.Hits{}, false, err
+	}
+
+	return firewall.Hits{Packets: binary.NativeEndian.Uint64(value[0:8]), Bytes: binary.NativeEndian.Uint64(value[8:16])}, true, nil
+}
+
+func (t *trie) elem(cmd int, key, value []byte, flags uint64) error {
+	attr := mapElemAttr{
+		mapFd: uint32(t.fd),
+		key:   uint64(uintptr(unsafe.Pointer(&key[0]))),
+		flags: flags,
+	}
+
+	if value != nil {
+		attr.value = uint64(uintptr(unsafe.Pointer(&value[0])))
+	}
+
+	_, err := bpf(cmd, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(value)
+
+	return err
+}
+
+// loadProgram loads the XDP program, returning the verifier's log with the error if it is rejected
+func loadProgram(name string, insns []byte) (int, error) {
+	license := []byte("GPL\x00")
+	log := make([]byte, 64<<10)
+
+	attr := progLoadAttr{
+		progType: unix.BPF_PROG_TYPE_XDP,
+		insnCnt:  uint32(len(insns) / insnSize),
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1,
+		logSize:  uint32(len(log)),
+		logBuf:   uint64(uintptr(unsafe.Pointer(&log[0]))),
+	}
+	copy(attr.progName[:], name)
+
+	fd, err := bpf(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	runtime.KeepAlive(log)
+
+	if err != nil {
+		if end := bytes.IndexByte(log, 0); end > 0 {
+			return 0, fmt.Errorf("%w: %s", err, bytes.TrimSpace(log[:end]))
+		}
+
+		return 0, err
+	}
+
+	return fd, nil
+}
+
+// loadedProgramName returns the name of a loaded program by its ID
+func loadedProgramName(id uint32) (string, error) {
+	getAttr := getIDAttr{id: id}
+	fd, err := bpf(unix.BPF_PROG_GET_FD_BY_ID, unsafe.Pointer(&getAttr), unsafe.Sizeof(getAttr))
+	if err != nil {
+		return "", fmt.Errorf("opening program %d: %w", id, err)
+	}
+	defer unix.Close(fd)
+
+	info := make([]byte, progInfoNameOffset+16)
+	infoAttr := objInfoAttr{
+		fd:      uint32(fd),
+		infoLen: uint32(len(info)),
+		info:    uint64(uintptr(unsafe.Pointer(&info[0]))),
+	}
+
+	_, err = bpf(unix.BPF_OBJ_GET_INFO_BY_FD, unsafe.Pointer(&infoAttr), unsafe.Sizeof(infoAttr))
+	runtime.KeepAlive(info)
+	if err != nil {
+		return "", fmt.Errorf("reading the info of program %d: %w", id, err)
+	}
+
+	name := info[progInfoNameOffset:]
+	if end := bytes.IndexByte(name, 0); end >= 0 {
+		name = name[:end]
+	}
+
+	return string(name), nil
+}
@@ -0,0 +1,82 @@
+package xdp
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/MatejLach/dynafire/firewall/netlink"
+)
+
+// XDP attach modes, as reported in IFLA_XDP_ATTACHED
+const (
+	attachedNone    = 0
+	attachedDriver  = 1
+	attachedGeneric = 2
+	attachedHW      = 3
+)
+
+// linkBody is a link message for the interface, struct ifinfomsg followed by attrs
+func linkBody(ifindex int, attrs []byte) []byte {
+	body := []byte{unix.AF_UNSPEC, 0, 0, 0}
+	body = binary.NativeEndian.AppendUint32(body, uint32(int32(ifindex)))
+	body = binary.NativeEndian.AppendUint32(body, 0)
+	body = binary.NativeEndian.AppendUint32(body, 0)
+
+	return append(body, attrs...)
+}
+
+// attachedProgram returns the ID and the attach mode of the XDP program on the interface, a zero ID if there is none
+func attachedProgram(ifindex int) (uint32, uint8, error) {
+	reply, err := netlink.Get(unix.RTM_GETLINK, linkBody(ifindex, nil))
+	if err != nil {
+		return 0, attachedNone, err
+	}
+
+	if len(reply) < unix.SizeofIfInfomsg {
+		return 0, attachedNone, nil
+	}
+
+	xdp, ok := netlink.ParseAttrs(reply[unix.SizeofIfInfomsg:])[unix.IFLA_XDP]
+	if !ok {
+		return 0, attachedNone, nil
+	}
+
+	attrs := netlink.ParseAttrs(xdp)
+
+	mode := uint8(attachedNone)
+	if value, ok := attrs[unix.IFLA_XDP_ATTACHED]; ok && len(value) > 0 {
+		mode = value[0]
+	}
+
+	var id uint32
+	if value, ok := attrs[unix.IFLA_XDP_PROG_ID]; ok && len(value) == 4 {
+		id = netlink.Uint32Value(value)
+	}
+
+	return id, mode, nil
+}
+
+// setProgram attaches the program to the interface with flags selecting the mode, a program of -1 detaches the one attached in that mode
+func setProgram(ifindex, fd int, flags uint32) error {
+	xdp := netlink.AppendAttr(nil, unix.IFLA_XDP_FD, binary.NativeEndian.AppendUint32(nil, uint32(int32(fd))))
+	xdp = netlink.AppendAttr(xdp, unix.IFLA_XDP_FLAGS, netlink.Uint32Attr(flags))
+
+	body := linkBody(ifindex, netlink.AppendAttr(nil, unix.IFLA_XDP|unix.NLA_F_NESTED, xdp))
+
+	return netlink.Execute([]netlink.Request{{Kind: unix.RTM_SETLINK, Body: body}}, nil)
+}
+
+// modeFlags are the attach flags detaching a program attached in mode
+func modeFlags(mode uint8) uint32 {
+	switch mode {
+	case attachedDriver:
+		return unix.XDP_FLAGS_DRV_MODE
+	case attachedGeneric:
+		return unix.XDP_FLAGS_SKB_MODE
+	case attachedHW:
+		return unix.XDP_FLAGS_HW_MODE
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,188 @@
+package xdp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// insnSize is the size of struct bpf_insn
+const insnSize = 8
+
+// eBPF registers; r0 holds return values, r1 to r5 arguments and are clobbered by calls, r6 to r9 are preserved, r10 is the frame pointer
+const (
+	r0 = iota
+	r1
+	r2
+	r3
+	r4
+	r5
+	r6
+	r7
+	r8
+	r9
+	r10
+)
+
+// opcodes of the few instructions the program takes
+const (
+	opLdxW      = 0x61 // dst = *(u32 *)(src + off)
+	opLdxH      = 0x69 // dst = *(u16 *)(src + off)
+	opStW       = 0x62 // *(u32 *)(dst + off) = imm
+	opStxW      = 0x63 // *(u32 *)(dst + off) = src
+	opAtomicDW  = 0xdb // lock *(u64 *)(dst + off) += src, with imm BPF_ADD
+	opMovImm    = 0xb7 // dst = imm
+	opMovReg    = 0xbf // dst = src
+	opAddImm    = 0x07 // dst += imm
+	opSubReg    = 0x1f // dst -= src
+	opLdImm64   = 0x18 // dst = imm64, a map with src set to BPF_PSEUDO_MAP_FD; takes two instructions
+	opJa        = 0x05 // goto off
+	opJeqImm    = 0x15 // if dst == imm goto off
+	opJgtReg    = 0x2d // if dst > src goto off
+	opCall      = 0x85 // call helper imm
+	opExit      = 0x95 // return r0
+	pseudoMapFd = 1
+
+	helperMapLookupElem = 1
+
+	xdpDrop = 1
+	xdpPass = 2
+
+	ethHeaderLen   = 14
+	ethTypeOffset  = 12
+	ipv4SrcOffset  = ethHeaderLen + 12
+	ipv4HeaderLen  = 20
+	ipv6SrcOffset  = ethHeaderLen + 8
+	ipv6HeaderLen  = 40
+	ethTypeIPv4    = 0x0800
+	ethTypeIPv6    = 0x86dd
+	ipv4KeyOffset  = -8  // struct bpf_lpm_trie_key of 4 + 4 bytes on the stack
+	ipv6KeyOffset  = -24 // struct bpf_lpm_trie_key of 4 + 16 bytes on the stack
+	ipv6AddrChunks = 4
+)
+
+// assembler builds a program, resolving jumps to labels once every instruction is known
+type assembler struct {
+	insns  []insn
+	labels map[string]int
+}
+
+type insn struct {
+	code     uint8
+	dst, src uint8
+	off      int16
+	imm      int32
+	// target is the label a jump goes to
+	target string
+}
+
+func (a *assembler) emit(code, dst, src uint8, off int16, imm int32) {
+	a.insns = append(a.insns, insn{code: code, dst: dst, src: src, off: off, imm: imm})
+}
+
+func (a *assembler) jump(code, dst, src uint8, imm int32, target string) {
+	a.insns = append(a.insns, insn{code: code, dst: dst, src: src, imm: imm, target: target})
+}
+
+func (a *assembler) label(name string) {
+	a.labels[name] = len(a.insns)
+}
+
+// loadMap loads the address of a map, by its file descriptor
+func (a *assembler) loadMap(dst uint8, fd int) {
+	a.emit(opLdImm64, dst, pseudoMapFd, 0, int32(fd))
+	a.emit(0, 0, 0, 0, 0)
+}
+
+func (a *assembler) assemble() ([]byte, error) {
+	// struct bpf_insn packs the registers into one byte, dst in the bits that come first in memory
+	littleEndian := binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+
+	result := make([]byte, 0, len(a.insns)*insnSize)
+	for i, in := range a.insns {
+		if in.target != "" {
+			target, ok := a.labels[in.target]
+			if !ok {
+				return nil, fmt.Errorf("unknown label %q", in.target)
+			}
+
+			in.off = int16(target - i - 1)
+		}
+
+		regs := in.dst | in.src<<4
+		if !littleEndian {
+			regs = in.dst<<4 | in.src
+		}
+
+		result = append(result, in.code, regs)
+		result = binary.NativeEndian.AppendUint16(result, uint16(in.off))
+		result = binary.NativeEndian.AppendUint32(result, uint32(in.imm))
+	}
+
+	return result, nil
+}
+
+// networkOrder is how a 16 bit value in network byte order reads when loaded from a packet
+func networkOrder(value uint16) int32 {
+	return int32(binary.NativeEndian.Uint16(binary.BigEndian.AppendUint16(nil, value)))
+}
+
+// program is the XDP program: it looks the source address of IPv4 and IPv6 packets up in the map of its family
+// and drops the packets matching an entry, counting them in the entry; everything else passes
+// VLAN tagged frames pass too, the program goes on the VLAN interfaces for them
+func program(ipv4Map, ipv6Map int) ([]byte, error) {
+	a := &assembler{labels: make(map[string]int)}
+
+	// r2 = data, r3 = data_end, r9 = the length of the packet, kept for counting across the lookup
+	a.emit(opLdxW, r2, r1, 0, 0)
+	a.emit(opLdxW, r3, r1, 4, 0)
+	a.emit(opMovReg, r9, r3, 0, 0)
+	a.emit(opSubReg, r9, r2, 0, 0)
+
+	a.emit(opMovReg, r4, r2, 0, 0)
+	a.emit(opAddImm, r4, 0, 0, ethHeaderLen)
+	a.jump(opJgtReg, r4, r3, 0, "pass")
+	a.emit(opLdxH, r5, r2, ethTypeOffset, 0)
+	a.jump(opJeqImm, r5, 0, networkOrder(ethTypeIPv4), "ipv4")
+	a.jump(opJeqImm, r5, 0, networkOrder(ethTypeIPv6), "ipv6")
+	a.jump(opJa, 0, 0, 0, "pass")
+
+	a.label("ipv4")
+	a.emit(opMovReg, r4, r2, 0, 0)
+	a.emit(opAddImm, r4, 0, 0, ethHeaderLen+ipv4HeaderLen)
+	a.jump(opJgtReg, r4, r3, 0, "pass")
+	a.emit(opStW, r10, 0, ipv4KeyOffset, 32)
+	a.emit(opLdxW, r5, r2, ipv4SrcOffset, 0)
+	a.emit(opStxW, r10, r5, ipv4KeyOffset+4, 0)
+	a.loadMap(r1, ipv4Map)
+	a.emit(opMovReg, r2, r10, 0, 0)
+	a.emit(opAddImm, r2, 0, 0, ipv4KeyOffset)
+	a.jump(opJa, 0, 0, 0, "lookup")
+
+	a.label("ipv6")
+	a.emit(opMovReg, r4, r2, 0, 0)
+	a.emit(opAddImm, r4, 0, 0, ethHeaderLen+ipv6HeaderLen)
+	a.jump(opJgtReg, r4, r3, 0, "pass")
+	a.emit(opStW, r10, 0, ipv6KeyOffset, 128)
+	for i := 0; i < ipv6AddrChunks; i++ {
+		a.emit(opLdxW, r5, r2, int16(ipv6SrcOffset+4*i), 0)
+		a.emit(opStxW, r10, r5, int16(ipv6KeyOffset+4+4*i), 0)
+	}
+	a.loadMap(r1, ipv6Map)
+	a.emit(opMovReg, r2, r10, 0, 0)
+	a.emit(opAddImm, r2, 0, 0, ipv6KeyOffset)
+
+	a.label("lookup")
+	a.emit(opCall, 0, 0, 0, helperMapLookupElem)
+	a.jump(opJeqImm, r0, 0, 0, "pass")
+	a.emit(opMovImm, r1, 0, 0, 1)
+	a.emit(opAtomicDW, r0, r1, 0, 0)
+	a.emit(opAtomicDW, r0, r9, 8, 0)
+	a.emit(opMovImm, r0, 0, 0, xdpDrop)
+	a.emit(opExit, 0, 0, 0, 0)
+
+	a.label("pass")
+	a.emit(opMovImm, r0, 0, 0, xdpPass)
+	a.emit(opExit, 0, 0, 0, 0)
+
+	return a.assemble()
+}
@@ -0,0 +1,293 @@
+package xdp
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+const (
+	DefaultMaxEntries = 1048576
+	// progName identifies the program on the interfaces, a program of that name left behind by a previous run is replaced
+	progName = "dynafire"
+)
+
+// Attach modes; native runs the program in the driver, generic in the network stack, which works with any driver, i.e. veth
+const (
+	ModeAuto    = "auto"
+	ModeNative  = "native"
+	ModeGeneric = "generic"
+)
+
+// Config holds the XDP backend settings
+type Config struct {
+	// Interfaces are the interfaces the program drops packets on, as they arrive
+	Interfaces []string
+	// Mode is auto, native or generic; auto tries native first and falls back to generic
+	Mode string
+	// MaxEntries caps the entries of each map, per address family
+	MaxEntries int
+}
+
+// Blocker is a firewall.Blocker that drops packets from blocked prefixes with an XDP program on the configured interfaces,
+// before the kernel allocates anything for them; the program looks the source address up in an LPM trie map per address family,
+// which BlockIP, UnblockIP and the rest update, and counts the packets each entry drops
+// The program only sees packets arriving on the interfaces and matches their source, so egress blocking is not supported
+type Blocker struct {
+	conf Config
+
+	mu   sync.Mutex
+	ipv4 *trie
+	ipv6 *trie
+	// enforced holds the blocks, a prefix blocked in both directions takes a single entry
+	enforced map[firewall.Target]struct{}
+}
+
+func New(conf Config) (*Blocker, error) {
+	if conf.Mode == "" {
+		conf.Mode = ModeAuto
+	}
+
+	if conf.MaxEntries <= 0 {
+		conf.MaxEntries = DefaultMaxEntries
+	}
+
+	if len(conf.Interfaces) == 0 {
+		return nil, errors.New("no interfaces to attach the XDP program to")
+	}
+
+	ipv4, err := newTrie("dynafire_v4", net.IPv4len, conf.MaxEntries)
+	if err != nil {
+		return nil, bpfError("creating the XDP maps", err)
+	}
+
+	ipv6, err := newTrie("dynafire_v6", net.IPv6len, conf.MaxEntries)
+	if err != nil {
+		_ = unix.Close(ipv4.fd)
+		return nil, bpfError("creating the XDP maps", err)
+	}
+
+	b := &Blocker{
+		conf:     conf,
+		ipv4:     ipv4,
+		ipv6:     ipv6,
+		enforced: make(map[firewall.Target]struct{}),
+	}
+
+	insns, err := program(ipv4.fd, ipv6.fd)
+	if err != nil {
+		return nil, firewall.NewFatalError("assembling the XDP program", err)
+	}
+
+	prog, err := loadProgram(progName, insns)
+	if err != nil {
+		return nil, bpfError("loading the XDP program", err)
+	}
+
+	// the attached program keeps itself and the maps alive, the file descriptor is not needed afterwards
+	defer unix.Close(prog)
+
+	for _, name := range conf.Interfaces {
+		err = attach(name, prog, conf.Mode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// attach attaches the program to the interface, replacing a dynafire program left behind by a previous run
+func attach(name string, prog int, mode string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return firewall.NewFatalError(fmt.Sprintf("attaching the XDP program to %s", name), err)
+	}
+
+	id, attached, err := attachedProgram(iface.Index)
+	if err != nil {
+		return bpfError(fmt.Sprintf("looking up the XDP program of %s", name), err)
+	}
+
+	if id != 0 {
+		existing, err := loadedProgramName(id)
+		if err != nil {
+			return bpfError(fmt.Sprintf("looking up the XDP program of %s", name), err)
+		}
+
+		if existing != progName {
+			return firewall.NewFatalError(fmt.Sprintf("attaching the XDP program to %s", name), fmt.Errorf("the interface already runs the XDP program %q", existing))
+		}
+
+		// a previous run may have attached in another mode, and a program cannot be attached in two modes at once
+		err = setProgram(iface.Index, -1, modeFlags(attached))
+		if err != nil {
+			return bpfError(fmt.Sprintf("detaching the previous XDP program from %s", name), err)
+		}
+	}
+
+	if mode == ModeAuto || mode == ModeNative {
+		err = setProgram(iface.Index, prog, unix.XDP_FLAGS_DRV_MODE)
+		if err == nil {
+			slog.Info("attached XDP program", "interface", name, "mode", ModeNative)
+			return nil
+		}
+
+		if mode == ModeNative {
+			return bpfError(fmt.Sprintf("attaching the XDP program to %s in native mode", name), err)
+		}
+
+		slog.Debug("native XDP not supported, falling back to generic mode", "interface", name, "details", err)
+	}
+
+	err = setProgram(iface.Index, prog, unix.XDP_FLAGS_SKB_MODE)
+	if err != nil {
+		return bpfError(fmt.Sprintf("attaching the XDP program to %s in generic mode", name), err)
+	}
+
+	slog.Info("attached XDP program", "interface", name, "mode", ModeGeneric)
+
+	return nil
+}
+
+func (b *Blocker) BlockIP(address net.IP) error {
+	prefix, ok := firewall.PrefixFromIP(address)
+	if !ok {
+		return firewall.NewFatalError("blocking an IP", fmt.Errorf("invalid IP %v", address))
+	}
+
+	return b.ApplyBatch([]firewall.Change{{Operation: firewall.Block, Prefix: prefix}})
+}
+
+func (b *Blocker) BlockIPList(blacklist []net.IP) error {
+	changes := make([]firewall.Change, 0, len(blacklist))
+	for _, ip := range blacklist {
+		if prefix, ok := firewall.PrefixFromIP(ip); ok {
+			changes = append(changes, firewall.Change{Operation: firewall.Block, Prefix: prefix})
+		}
+	}
+
+	return b.ApplyBatch(changes)
+}
+
+func (b *Blocker) UnblockIP(address net.IP) error {
+	prefix, ok := firewall.PrefixFromIP(address)
+	if !ok {
+		return firewall.NewFatalError("unblocking an IP", fmt.Errorf("invalid IP %v", address))
+	}
+
+	return b.ApplyBatch([]firewall.Change{{Operation: firewall.Unblock, Prefix: prefix}})
+}
+
+// ResetFirewallRules removes every entry from the maps; they were created by this run, so the blocks are all there is in them
+func (b *Blocker) ResetFirewallRules() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for target := range b.enforced {
+		err := b.trieOf(target.Prefix).remove(target.Prefix)
+		if err != nil {
+			return bpfError("removing XDP map entries", err)
+		}
+
+		delete(b.enforced, target)
+	}
+
+	return nil
+}
+
+// ApplyBatch updates the maps entry by entry, LPM tries do not support batch updates
+func (b *Blocker) ApplyBatch(changes []firewall.Change) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, change := range changes {
+		target := change.Target()
+		_, shared := b.enforced[otherDirection(target)]
+
+		var err error
+		switch change.Operation {
+		case firewall.Block:
+			if !shared {
+				err = b.trieOf(change.Prefix).add(change.Prefix)
+			}
+
+			if err == nil {
+				b.enforced[target] = struct{}{}
+			}
+		case firewall.Unblock:
+			if !shared {
+				err = b.trieOf(change.Prefix).remove(change.Prefix)
+			}
+
+			if err == nil {
+				delete(b.enforced, target)
+			}
+		}
+
+		if err != nil {
+			return bpfError(fmt.Sprintf("updating the XDP map entry of %s", firewall.PrefixString(change.Prefix)), err)
+		}
+	}
+
+	return nil
+}
+
+// Hits returns the packets and bytes every entry dropped since it was added; a prefix blocked in both directions is counted once, as an ingress block
+func (b *Blocker) Hits() (map[firewall.Target]firewall.Hits, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	result := make(map[firewall.Target]firewall.Hits, len(b.enforced))
+	for target := range b.enforced {
+		if target.Direction == firewall.Egress {
+			if _, shared := b.enforced[otherDirection(target)]; shared {
+				continue
+			}
+		}
+
+		hits, ok, err := b.trieOf(target.Prefix).hits(target.Prefix)
+		if err != nil {
+			return nil, bpfError("reading the XDP drop counters", err)
+		}
+
+		if ok {
+			result[target] = hits
+		}
+	}
+
+	return result, nil
+}
+
+func (b *Blocker) trieOf(prefix netip.Prefix) *trie {
+	if prefix.Addr().Is4() {
+		return b.ipv4
+	}
+
+	return b.ipv6
+}
+
+func otherDirection(target firewall.Target) firewall.Target {
+	if target.Direction == firewall.Egress {
+		return firewall.Target{Direction: firewall.Ingress, Prefix: target.Prefix}
+	}
+
+	return firewall.Target{Direction: firewall.Egress, Prefix: target.Prefix}
+}
+
+// bpfError classifies a failed bpf or netlink operation; missing privileges or kernel support will not go away by retrying,
+// a full map or a failed update might
+func bpfError(op string, err error) error {
+	if errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES) || errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EOPNOTSUPP) {
+		return firewall.NewFatalError(op, err)
+	}
+
+	return firewall.NewTransientError(op, err)
+}
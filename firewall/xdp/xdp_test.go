@@ -0,0 +1,102 @@
+package xdp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/MatejLach/dynafire/firewall"
+)
+
+func TestAssemble(t *testing.T) {
+	a := &assembler{labels: make(map[string]int)}
+	a.jump(opJeqImm, r0, 0, 0, "done")
+	a.emit(opMovImm, r0, 0, 0, xdpDrop)
+	a.label("done")
+	a.emit(opExit, 0, 0, 0, 0)
+
+	insns, err := a.assemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(insns) != 3*insnSize {
+		t.Fatalf("assembled %d bytes, want 3 instructions", len(insns))
+	}
+
+	// jumps are relative to the instruction following them
+	if off := int16(binary.NativeEndian.Uint16(insns[2:4])); off != 1 {
+		t.Fatalf("jump offset %d, want 1", off)
+	}
+
+	a.jump(opJa, 0, 0, 0, "missing")
+	if _, err := a.assemble(); err == nil {
+		t.Fatal("assemble() accepted a jump to an unknown label")
+	}
+}
+
+func TestProgram(t *testing.T) {
+	insns, err := program(7, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	maps := make([]int32, 0, 2)
+	for i := 0; i < len(insns); i += insnSize {
+		if insns[i] == opLdImm64 {
+			maps = append(maps, int32(binary.NativeEndian.Uint32(insns[i+4:i+8])))
+		}
+	}
+
+	if len(maps) != 2 || maps[0] != 7 || maps[1] != 8 {
+		t.Fatalf("program loads maps %v, want the IPv4 map 7 and the IPv6 map 8", maps)
+	}
+
+	if last := insns[len(insns)-insnSize]; last != opExit {
+		t.Fatalf("program ends in opcode %#x, want exit", last)
+	}
+}
+
+func TestTrieKey(t *testing.T) {
+	key := (&trie{addrLen: 4}).key(netip.MustParsePrefix("192.0.2.77/24"))
+
+	want := append(binary.NativeEndian.AppendUint32(nil, 24), 192, 0, 2, 0)
+	if !bytes.Equal(key, want) {
+		t.Fatalf("key() = %v, want %v", key, want)
+	}
+}
+
+func TestApplyBatchShared(t *testing.T) {
+	ingress := firewall.Target{Direction: firewall.Ingress, Prefix: netip.MustParsePrefix("198.51.100.0/24")}
+	egress := firewall.Target{Direction: firewall.Egress, Prefix: ingress.Prefix}
+
+	// without maps, so any map update fails the test with a nil dereference
+	b := &Blocker{enforced: map[firewall.Target]struct{}{egress: {}}}
+
+	err := b.ApplyBatch([]firewall.Change{
+		{Operation: firewall.Block, Direction: firewall.Ingress, Prefix: ingress.Prefix},
+		{Operation: firewall.Unblock, Direction: firewall.Egress, Prefix: egress.Prefix},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := b.enforced[ingress]; !ok || len(b.enforced) != 1 {
+		t.Fatalf("enforced %v, want only the ingress block", b.enforced)
+	}
+}
+
+func TestBpfError(t *testing.T) {
+	for _, errno := range []unix.Errno{unix.EPERM, unix.EINVAL, unix.EOPNOTSUPP} {
+		if err := bpfError("loading the XDP program", errno); !firewall.IsFatal(err) {
+			t.Errorf("bpfError(%v) = %v, want a fatal error", errno, err)
+		}
+	}
+
+	if err := bpfError("updating the XDP map", unix.E2BIG); firewall.ClassOf(err) != firewall.Transient {
+		t.Errorf("bpfError(E2BIG) = %v, want a transient error", err)
+	}
+}
@@ -3,8 +3,21 @@ module github.com/MatejLach/dynafire
 go 1.21
 
 require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/oschwald/maxminddb-golang v1.13.1
 	github.com/pebbe/zmq4 v1.2.9
+	github.com/prometheus/client_golang v1.19.1
 	github.com/vmihailenco/msgpack/v5 v5.3.5
+	golang.org/x/sys v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+)
@@ -0,0 +1,268 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+
+	zmq "github.com/pebbe/zmq4"
+
+	"github.com/MatejLach/dynafire/provider/turris"
+)
+
+const (
+	// authDomain is the ZAP domain the allowed client keys are registered for
+	authDomain = "dynafire-hub"
+	// subscriptionPoll is how often new subscriptions are picked up, and answered with the list
+	subscriptionPoll = 200 * time.Millisecond
+)
+
+// Config describes the endpoint the hub re-publishes the feed on
+type Config struct {
+	// ListenAddress is an address or interface name to bind to, * for every address
+	ListenAddress string
+	Port          int
+	// KeyFile stores the hub's CURVE key pair, it is created if it does not exist
+	KeyFile string
+	// AllowedClients are the Z85 encoded public keys of the agents, empty allows any client
+	AllowedClients []string
+	// ListInterval is how often the full list is re-published
+	ListInterval time.Duration
+}
+
+// Hub re-publishes the Turris feed it receives once upstream to any number of agents, which connect to it with turris.NewClient
+// It keeps the current list with the deltas applied, so that a new subscriber gets the list right away
+// instead of waiting for the next one from upstream
+type Hub struct {
+	conf      Config
+	socket    *zmq.Socket
+	publicKey string
+
+	// the current list; serial is the one of the last delta applied to it
+	synced    bool
+	version   time.Time
+	serial    uint32
+	blacklist map[netip.Addr]struct{}
+}
+
+func New(conf Config) (*Hub, error) {
+	publicKey, secretKey, err := turris.LoadOrCreateRelayKeypair(conf.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the hub key pair: %w", err)
+	}
+
+	// XPUB rather than PUB, to learn about new subscribers; in the default context, the one the authenticator serves
+	socket, err := zmq.NewSocket(zmq.XPUB)
+	if err != nil {
+		return nil, err
+	}
+
+	// the authenticator always runs, so that a client is only let in by the keys registered here
+	err = turris.StartAuth()
+	if err != nil {
+		return nil, fmt.Errorf("unable to start the ZMQ authenticator: %w", err)
+	}
+
+	if len(conf.AllowedClients) > 0 {
+		zmq.AuthCurveAdd(authDomain, conf.AllowedClients...)
+	} else {
+		zmq.AuthCurveAdd(authDomain, zmq.CURVE_ALLOW_ANY)
+	}
+
+	err = socket.ServerAuthCurve(authDomain, secretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// report every subscription, also the repeated ones of agents reconnecting
+	err = socket.SetXpubVerbose(1)
+	if err != nil {
+		return nil, err
+	}
+
+	err = socket.Bind(fmt.Sprintf("tcp://%s:%d", conf.ListenAddress, conf.Port))
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s port %d: %w", conf.ListenAddress, conf.Port, err)
+	}
+
+	return &Hub{
+		conf:      conf,
+		socket:    socket,
+		publicKey: publicKey,
+		blacklist: make(map[netip.Addr]struct{}),
+	}, nil
+}
+
+// PublicKey returns the Z85 encoded public key of the hub, which agents configure as the server public key
+func (h *Hub) PublicKey() string {
+	return h.publicKey
+}
+
+// Run re-publishes what tc receives until ctx is cancelled or tc stops; tc must be connected, with RequestMessages running
+// Events are acted upon like the daemon does, but not re-published, the hub is the only one talking to upstream
+func (h *Hub) Run(ctx context.Context, tc *turris.Client) {
+	defer h.socket.Close()
+
+	poll := time.NewTicker(subscriptionPoll)
+	defer poll.Stop()
+
+	republish := time.NewTicker(h.conf.ListInterval)
+	defer republish.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case list, ok := <-tc.ListChan:
+			if !ok {
+				return
+			}
+
+			h.replace(list)
+			tc.ListApplied(list)
+			slog.Info("received list from upstream", "entries", len(h.blacklist), "serial", list.Serial)
+			h.publishList(turris.TopicList)
+		case delta, ok := <-tc.DeltaChan:
+			if !ok {
+				return
+			}
+
+			if !h.apply(delta) {
+				slog.Warn("skipping delta with invalid IP or unknown operation", "operation", delta.Operation, "serial", delta.Serial)
+				continue
+			}
+
+			tc.DeltaApplied(delta)
+			h.publishDelta(delta)
+		case event, ok := <-tc.EventChan:
+			if !ok {
+				return
+			}
+
+			action := tc.EventAction(event)
+			slog.Info("received Turris event", "event", event.Name, "timestamp", event.Timestamp, "action", action.String())
+
+			switch action {
+			case turris.EventActionRefreshList:
+				tc.RefreshList()
+			case turris.EventActionReconnect:
+				tc.Reconnect()
+			}
+		case <-poll.C:
+			// only the new subscribers are answered, each on the snapshot topic it alone subscribed to
+			for _, topic := range h.newSnapshotTopics() {
+				h.publishList(topic)
+			}
+		case <-republish.C:
+			h.publishList(turris.TopicList)
+		}
+	}
+}
+
+func (h *Hub) replace(list turris.List) {
+	h.blacklist = make(map[netip.Addr]struct{}, len(list.Blacklist))
+	for _, ip := range list.Blacklist {
+		if addr, ok := netip.AddrFromSlice(ip); ok {
+			h.blacklist[addr.Unmap()] = struct{}{}
+		}
+	}
+
+	h.version = list.Version
+	h.serial = list.Serial
+	h.synced = true
+}
+
+func (h *Hub) apply(delta turris.Delta) bool {
+	addr, ok := netip.AddrFromSlice(delta.IP)
+	if !ok {
+		return false
+	}
+
+	switch delta.Operation {
+	case "positive":
+		h.blacklist[addr.Unmap()] = struct{}{}
+	case "negative":
+		delete(h.blacklist, addr.Unmap())
+	default:
+		return false
+	}
+
+	h.serial = delta.Serial
+
+	return true
+}
+
+// newSnapshotTopics drains the subscription messages and returns the snapshot topics subscribed to
+func (h *Hub) newSnapshotTopics() []string {
+	topics := make([]string, 0)
+	for {
+		msg, err := h.socket.RecvMessageBytes(zmq.DONTWAIT)
+		if err != nil {
+			if zmq.AsErrno(err) != zmq.Errno(syscall.EAGAIN) {
+				slog.Warn("unable to receive subscriptions", "details", err)
+			}
+
+			return topics
+		}
+
+		// a subscription is a single frame of 1 followed by the topic, 0 unsubscribes
+		if len(msg) != 1 || len(msg[0]) == 0 || msg[0][0] != 1 {
+			continue
+		}
+
+		topic := string(msg[0][1:])
+		slog.Debug("new subscription", "topic", topic)
+		if strings.HasPrefix(topic, turris.TopicSnapshot) && len(topic) > len(turris.TopicSnapshot) {
+			topics = append(topics, topic)
+		}
+	}
+}
+
+// publishList publishes the current list on topic, either to every agent or to the one subscribed to a snapshot topic
+func (h *Hub) publishList(topic string) {
+	// nothing to answer with until upstream sent the first list, which is published as soon as it arrives
+	if !h.synced {
+		return
+	}
+
+	blacklist := make([]net.IP, 0, len(h.blacklist))
+	for addr := range h.blacklist {
+		blacklist = append(blacklist, addr.AsSlice())
+	}
+
+	body, err := turris.EncodeList(turris.List{
+		Version:   h.version,
+		Serial:    h.serial,
+		Blacklist: blacklist,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		slog.Error("unable to publish list", "details", err)
+		return
+	}
+
+	h.publish(topic, body)
+}
+
+func (h *Hub) publishDelta(delta turris.Delta) {
+	body, err := turris.EncodeDelta(delta)
+	if err != nil {
+		slog.Error("unable to publish delta", "serial", delta.Serial, "details", err)
+		return
+	}
+
+	h.publish(turris.TopicDelta, body)
+}
+
+func (h *Hub) publish(topic string, body []byte) {
+	// XPUB drops the messages of subscribers that cannot keep up rather than blocking
+	_, err := h.socket.SendMessage(topic, body)
+	if err != nil {
+		slog.Error("unable to publish message", "topic", topic, "details", err)
+	}
+}
@@ -0,0 +1,48 @@
+package hub
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/MatejLach/dynafire/provider/turris"
+)
+
+func TestHubKeepsList(t *testing.T) {
+	h := &Hub{blacklist: make(map[netip.Addr]struct{})}
+
+	version := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	h.replace(turris.List{Version: version, Serial: 4, Blacklist: []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("::ffff:192.0.2.2"), net.ParseIP("2001:db8::1")}})
+
+	deltas := []struct {
+		delta turris.Delta
+		ok    bool
+	}{
+		{turris.Delta{Operation: "positive", IP: net.ParseIP("198.51.100.7"), Serial: 5}, true},
+		{turris.Delta{Operation: "negative", IP: net.ParseIP("192.0.2.1"), Serial: 6}, true},
+		{turris.Delta{Operation: "neutral", IP: net.ParseIP("203.0.113.1"), Serial: 7}, false},
+		{turris.Delta{Operation: "positive", IP: net.IP{1, 2}, Serial: 7}, false},
+	}
+
+	for _, test := range deltas {
+		if ok := h.apply(test.delta); ok != test.ok {
+			t.Fatalf("apply(%s %s) = %v, want %v", test.delta.Operation, test.delta.IP, ok, test.ok)
+		}
+	}
+
+	want := []string{"192.0.2.2", "2001:db8::1", "198.51.100.7"}
+	if len(h.blacklist) != len(want) {
+		t.Fatalf("hub holds %v, want %v", h.blacklist, want)
+	}
+
+	for _, addr := range want {
+		if _, ok := h.blacklist[netip.MustParseAddr(addr)]; !ok {
+			t.Errorf("hub does not hold %s", addr)
+		}
+	}
+
+	if !h.synced || !h.version.Equal(version) || h.serial != 6 {
+		t.Fatalf("hub is at version %v serial %d, synced %v, want %v serial 6", h.version, h.serial, h.synced, version)
+	}
+}
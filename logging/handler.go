@@ -0,0 +1,169 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Multi fans every record out to all handlers enabled for its level, each handler filters by its own level
+type Multi []slog.Handler
+
+func (m Multi) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m Multi) Handle(ctx context.Context, r slog.Record) error {
+	errs := make([]error, 0)
+	for _, h := range m {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+
+		err := h.Handle(ctx, r.Clone())
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (m Multi) WithAttrs(attrs []slog.Attr) slog.Handler {
+	result := make(Multi, 0, len(m))
+	for _, h := range m {
+		result = append(result, h.WithAttrs(attrs))
+	}
+
+	return result
+}
+
+func (m Multi) WithGroup(name string) slog.Handler {
+	result := make(Multi, 0, len(m))
+	for _, h := range m {
+		result = append(result, h.WithGroup(name))
+	}
+
+	return result
+}
+
+// field is an attribute flattened to its group qualified key, i.e. "details" or "request.path"
+type field struct {
+	key   []string
+	value string
+}
+
+// sink writes a record along with its flattened attributes to a destination that has no notion of nesting
+type sink interface {
+	write(r slog.Record, fields []field) error
+}
+
+// flatHandler is the slog.Handler of outputs with flat key/value fields, such as journald and syslog
+type flatHandler struct {
+	level  slog.Leveler
+	sink   sink
+	groups []string
+	fields []field
+}
+
+func newFlatHandler(s sink, level slog.Leveler) *flatHandler {
+	if level == nil {
+		level = slog.LevelInfo
+	}
+
+	return &flatHandler{level: level, sink: s}
+}
+
+func (h *flatHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *flatHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := append([]field(nil), h.fields...)
+	r.Attrs(func(a slog.Attr) bool {
+		fields = appendAttr(fields, h.groups, a)
+		return true
+	})
+
+	return h.sink.write(r, fields)
+}
+
+func (h *flatHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.fields = append([]field(nil), h.fields...)
+	for _, a := range attrs {
+		clone.fields = appendAttr(clone.fields, h.groups, a)
+	}
+
+	return &clone
+}
+
+func (h *flatHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	clone := *h
+	clone.groups = append(append([]string(nil), h.groups...), name)
+
+	return &clone
+}
+
+func appendAttr(fields []field, groups []string, a slog.Attr) []field {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			groups = append(append([]string(nil), groups...), a.Key)
+		}
+
+		for _, groupAttr := range a.Value.Group() {
+			fields = appendAttr(fields, groups, groupAttr)
+		}
+
+		return fields
+	}
+
+	value := a.Value.String()
+	if a.Value.Kind() == slog.KindTime {
+		value = a.Value.Time().Format(time.RFC3339Nano)
+	}
+
+	return append(fields, field{key: append(append([]string(nil), groups...), a.Key), value: value})
+}
+
+// sanitizeKey joins the key path and replaces every character not accepted by valid with an underscore
+func sanitizeKey(key []string, valid func(r rune) bool) string {
+	return strings.Map(func(r rune) rune {
+		if valid(r) {
+			return r
+		}
+
+		return '_'
+	}, strings.Join(key, "_"))
+}
+
+// severity maps a slog level to a syslog severity, which journald uses as well
+func severity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
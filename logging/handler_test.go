@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receive returns the next datagram arriving on conn
+func receive(t *testing.T, conn net.PacketConn) string {
+	t.Helper()
+
+	buf := make([]byte, 64*1024)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(buf[:n])
+}
+
+func TestSyslogHandler(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	h, err := NewSyslogHandler(SyslogConfig{Network: "udp", Address: conn.LocalAddr().String(), Facility: "local0", Tag: "dynafire"}, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(h).With("source", "turris")
+	logger.Debug("not sent below the level")
+	logger.WithGroup("request").Warn("blocked", "ip", "192.0.2.1", "details", `say "hi"]`)
+
+	msg := receive(t, conn)
+	// local0 (16) * 8 + warning (4)
+	if !strings.HasPrefix(msg, "<132>1 ") {
+		t.Fatalf("message %q does not start with the local0.warning priority", msg)
+	}
+
+	for _, want := range []string{" dynafire ", `[dynafire@32473 source="turris" request_ip="192.0.2.1" request_details="say \"hi\"\]"]`, "] blocked"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message %q does not contain %q", msg, want)
+		}
+	}
+
+	if _, err := NewSyslogHandler(SyslogConfig{Network: "udp", Address: conn.LocalAddr().String(), Facility: "local9"}, nil); err == nil {
+		t.Fatal("NewSyslogHandler() accepted an unknown facility")
+	}
+}
+
+func TestJournaldHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.sock")
+
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	h, err := NewJournaldHandler(path, "dynafire", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slog.New(h).Error("unable to block", "ip", "192.0.2.1", "details", "line one\nline two")
+
+	msg := receive(t, conn)
+	for _, want := range []string{"MESSAGE=unable to block\n", "PRIORITY=3\n", "SYSLOG_IDENTIFIER=dynafire\n", "DYNAFIRE_IP=192.0.2.1\n"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("journal entry %q does not contain %q", msg, want)
+		}
+	}
+
+	// values spanning several lines are length prefixed
+	if !strings.Contains(msg, "DYNAFIRE_DETAILS\n\x11\x00\x00\x00\x00\x00\x00\x00line one\nline two\n") {
+		t.Errorf("journal entry %q does not hold the binary encoded details", msg)
+	}
+}
+
+func TestMulti(t *testing.T) {
+	var debug, errs bytes.Buffer
+	logger := slog.New(Multi{
+		slog.NewTextHandler(&debug, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		slog.NewTextHandler(&errs, &slog.HandlerOptions{Level: slog.LevelError}),
+	})
+
+	if !logger.Handler().Enabled(context.Background(), slog.LevelDebug) {
+		t.Fatal("Multi is not enabled for the lowest level of its handlers")
+	}
+
+	logger.Info("list applied")
+	logger.Error("unable to apply list")
+
+	if strings.Count(debug.String(), "\n") != 2 || strings.Count(errs.String(), "\n") != 1 {
+		t.Fatalf("handlers got\n%s\nand\n%s\nwant both records and only the error", debug.String(), errs.String())
+	}
+}
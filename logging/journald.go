@@ -0,0 +1,139 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	DefaultJournaldSocket = "/run/systemd/journal/socket"
+	// JournaldFieldPrefix is prepended to the key of every attribute, i.e. "IP" becomes DYNAFIRE_IP
+	JournaldFieldPrefix = "DYNAFIRE_"
+)
+
+// journald sends records to the systemd journal over its native protocol, keeping attributes as separate fields,
+// so that i.e. `journalctl DYNAFIRE_IP=192.0.2.1` finds every line about that address
+type journald struct {
+	conn       *net.UnixConn
+	identifier string
+}
+
+// NewJournaldHandler connects to the journal socket, an empty socketPath uses DefaultJournaldSocket
+func NewJournaldHandler(socketPath, identifier string, level slog.Leveler) (slog.Handler, error) {
+	if socketPath == "" {
+		socketPath = DefaultJournaldSocket
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	return newFlatHandler(&journald{conn: conn, identifier: identifier}, level), nil
+}
+
+func (j *journald) write(r slog.Record, fields []field) error {
+	var buf bytes.Buffer
+	writeJournaldField(&buf, "MESSAGE", r.Message)
+	writeJournaldField(&buf, "PRIORITY", strconv.Itoa(severity(r.Level)))
+	writeJournaldField(&buf, "SYSLOG_IDENTIFIER", j.identifier)
+
+	for _, f := range fields {
+		writeJournaldField(&buf, journaldFieldName(f.key), f.value)
+	}
+
+	_, err := j.conn.Write(buf.Bytes())
+	if err == nil {
+		return nil
+	}
+
+	// records that do not fit into a datagram are passed as a file descriptor instead
+	if errors.Is(err, syscall.EMSGSIZE) || errors.Is(err, syscall.ENOBUFS) {
+		return j.writeFd(buf.Bytes())
+	}
+
+	return err
+}
+
+// writeFd passes data in an unlinked temporary file, which journald reads the record from
+func (j *journald) writeFd(data []byte) error {
+	file, err := os.CreateTemp("/dev/shm", "dynafire-journal-")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	err = os.Remove(file.Name())
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err != nil {
+		return err
+	}
+
+	rawConn, err := j.conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	// net refuses WriteMsgUnix on a connected datagram socket, so the descriptor is sent on the raw socket
+	rights := syscall.UnixRights(int(file.Fd()))
+	var sendErr error
+	err = rawConn.Write(func(fd uintptr) bool {
+		sendErr = syscall.Sendmsg(int(fd), nil, rights, nil, 0)
+		return sendErr != syscall.EAGAIN
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendErr
+}
+
+// writeJournaldField uses the binary encoding for values spanning several lines, as the plain KEY=value one cannot hold them
+func writeJournaldField(buf *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		buf.WriteString(name)
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+
+		return
+	}
+
+	buf.WriteString(name)
+	buf.WriteByte('\n')
+	_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// journaldFieldName turns a key into a valid journal field name: upper case letters, digits and underscores, at most 64 characters
+func journaldFieldName(key []string) string {
+	upper := make([]string, 0, len(key))
+	for _, k := range key {
+		upper = append(upper, strings.ToUpper(k))
+	}
+
+	name := JournaldFieldPrefix + sanitizeKey(upper, func(r rune) bool {
+		return (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_'
+	})
+
+	if len(name) > 64 {
+		name = name[:64]
+	}
+
+	return name
+}
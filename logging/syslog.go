@@ -0,0 +1,173 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSyslogSocket = "/dev/log"
+	// SyslogSDID is the structured data element holding the attributes of a record,
+	// under the enterprise number reserved for documentation by RFC 5612
+	SyslogSDID = "dynafire@32473"
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogFacilities returns the names of the facilities accepted by SyslogConfig
+func SyslogFacilities() []string {
+	result := make([]string, 0, len(syslogFacilities))
+	for name := range syslogFacilities {
+		result = append(result, name)
+	}
+
+	return result
+}
+
+type SyslogConfig struct {
+	// Network is "unixgram" or "unix" for a local socket, "udp" or "tcp" for a remote server
+	// When empty, the local socket is used
+	Network string
+	// Address is the socket path or host:port, the local socket defaults to DefaultSyslogSocket
+	Address  string
+	Facility string
+	// Tag is the APP-NAME of every message
+	Tag string
+}
+
+// syslog sends RFC 5424 messages, attributes go into a structured data element
+// Stream connections (tcp, unix) use octet counting framing as of RFC 6587
+type syslog struct {
+	conf     SyslogConfig
+	facility int
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func NewSyslogHandler(conf SyslogConfig, level slog.Leveler) (slog.Handler, error) {
+	if conf.Network == "" {
+		conf.Network = "unixgram"
+	}
+
+	if conf.Address == "" && strings.HasPrefix(conf.Network, "unix") {
+		conf.Address = DefaultSyslogSocket
+	}
+
+	if conf.Facility == "" {
+		conf.Facility = "daemon"
+	}
+
+	if conf.Tag == "" {
+		conf.Tag = "-"
+	}
+
+	facility, ok := syslogFacilities[conf.Facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", conf.Facility)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	s := &syslog{conf: conf, facility: facility, hostname: hostname}
+
+	err = s.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return newFlatHandler(s, level), nil
+}
+
+func (s *syslog) connect() error {
+	conn, err := net.DialTimeout(s.conf.Network, s.conf.Address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+
+	s.conn = conn
+
+	return nil
+}
+
+func (s *syslog) write(r slog.Record, fields []field) error {
+	msg := s.format(r, fields)
+
+	if s.conf.Network == "tcp" || s.conf.Network == "unix" {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.conn.Write(msg)
+	if err == nil {
+		return nil
+	}
+
+	// the syslog daemon may have been restarted, retry once on a new connection
+	_ = s.conn.Close()
+	err = s.connect()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn.Write(msg)
+
+	return err
+}
+
+func (s *syslog) format(r slog.Record, fields []field) []byte {
+	var buf bytes.Buffer
+
+	timestamp := r.Time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s %d - ", s.facility*8+severity(r.Level), timestamp.Format(time.RFC3339Nano), s.hostname, s.conf.Tag, os.Getpid())
+
+	if len(fields) == 0 {
+		buf.WriteString("-")
+	} else {
+		buf.WriteString("[" + SyslogSDID)
+		for _, f := range fields {
+			buf.WriteString(" " + syslogParamName(f.key) + `="` + syslogEscaper.Replace(f.value) + `"`)
+		}
+		buf.WriteString("]")
+	}
+
+	buf.WriteString(" " + r.Message)
+
+	return buf.Bytes()
+}
+
+var syslogEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogParamName turns a key into a valid SD-PARAM name: printable ASCII except '=', ' ', ']' and '"', at most 32 characters
+func syslogParamName(key []string) string {
+	name := sanitizeKey(key, func(r rune) bool {
+		return r > 32 && r < 127 && r != '=' && r != ']' && r != '"'
+	})
+
+	if len(name) > 32 {
+		name = name[:32]
+	}
+
+	return name
+}
@@ -0,0 +1,33 @@
+package metrics
+
+var (
+	TurrisEvents = NewCounter("dynafire_turris_events_total", "Number of dynfw/event messages received from the Turris server.", "event")
+
+	QueueDepth             = NewGauge("dynafire_queue_depth", "Number of changes waiting in the delta queue.")
+	ChangesCoalesced       = NewCounter("dynafire_changes_coalesced_total", "Number of changes dropped because a later change for the same prefix superseded or cancelled them.")
+	ChangesApplied         = NewCounter("dynafire_changes_applied_total", "Number of changes applied to the firewall backend.", "operation")
+	ChangesDropped         = NewCounter("dynafire_changes_dropped_total", "Number of changes given up on, because the firewall backend rejected them or they kept failing.", "operation")
+	BatchesApplied         = NewCounter("dynafire_batches_applied_total", "Number of change batches applied to the firewall backend.")
+	EnforcedSetSize        = NewGauge("dynafire_enforced_set_size", "Number of entries in the last full list applied to the firewall backend.")
+	EffectiveSetSize       = NewGauge("dynafire_effective_set_size", "Number of prefixes that should currently be blocked, across all enabled sources.")
+	EgressEffectiveSetSize = NewGauge("dynafire_egress_effective_set_size", "Number of prefixes outbound and forwarded traffic should currently be blocked to, across all egress-enabled sources.")
+	EntriesLeftOut         = NewGauge("dynafire_entries_left_out", "Number of prefixes not blocked because the enforced set reached hits.max_entries.", "direction")
+	SourceSize             = NewGauge("dynafire_source_size", "Number of prefixes listed by a source.", "source")
+
+	BackendDegraded = NewGauge("dynafire_backend_degraded", "Whether the firewall backend is currently failing (1) or healthy (0).")
+	BreakerState    = NewGauge("dynafire_backend_circuit_breaker_state", "State of the firewall backend circuit breaker; 0 closed, 1 open, 2 half-open.")
+	BackendErrors   = NewCounter("dynafire_backend_errors_total", "Number of failed firewall backend calls.", "class")
+	BackendRetries  = NewCounter("dynafire_backend_retries_total", "Number of retried firewall backend calls.")
+	PendingChanges  = NewGauge("dynafire_pending_changes", "Number of coalesced changes waiting to be applied to the firewall backend.")
+
+	LocalDetections = NewCounter("dynafire_local_detections_total", "Number of addresses the log detector blocked, by rule.", "rule")
+
+	BlockedAttempts = NewCounter("dynafire_blocked_attempts_total", "Number of blocked packets read back from the kernel log, by direction and the source listing the address.", "direction", "source")
+
+	BlockHitPackets = NewGauge("dynafire_block_hit_packets", "Number of packets that hit a block, summed over all enforced entries, as of the last sample.", "direction")
+	BlockHitBytes   = NewGauge("dynafire_block_hit_bytes", "Number of bytes that hit a block, summed over all enforced entries, as of the last sample.", "direction")
+	BlocksHit       = NewGauge("dynafire_blocks_hit", "Number of enforced entries hit at least once.", "direction")
+	TopBlockHits    = NewGauge("dynafire_top_block_hit_packets", "Number of packets that hit the most hit entries, see hits.top.", "direction", "prefix")
+
+	ConfigReloads = NewCounter("dynafire_config_reloads_total", "Number of configuration reloads by outcome; applied, rejected or failed.", "result")
+)
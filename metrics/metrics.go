@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// registry holds the dynafire metrics, and only those, served by Handler
+var registry = prometheus.NewRegistry()
+
+// Counter is a Prometheus counter vector; unlabelled counters are vectors without label names
+type Counter struct {
+	vec *prometheus.CounterVec
+}
+
+// Gauge is a Prometheus gauge vector; unlabelled gauges are vectors without label names
+type Gauge struct {
+	vec *prometheus.GaugeVec
+}
+
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labelNames)
+	registry.MustRegister(vec)
+
+	return &Counter{vec: vec}
+}
+
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labelNames)
+	registry.MustRegister(vec)
+
+	return &Gauge{vec: vec}
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.vec.WithLabelValues(labelValues...).Inc()
+}
+
+// Add increases the counter by delta; negative values are ignored as counters only go up
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+
+	c.vec.WithLabelValues(labelValues...).Add(delta)
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Set(value)
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Inc()
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Dec()
+}
+
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Add(delta)
+}
+
+// Reset removes every label combination, for gauges whose set of labels changes over time, i.e. a top list
+func (g *Gauge) Reset() {
+	g.vec.Reset()
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	return recorder.Body.String()
+}
+
+func TestHandler(t *testing.T) {
+	TurrisEvents.Inc("list_reset")
+	TurrisEvents.Inc("list_reset")
+	ChangesApplied.Add(3, "block")
+	ChangesApplied.Add(-1, "block")
+	QueueDepth.Set(7)
+
+	TopBlockHits.Set(10, "ingress", "192.0.2.1/32")
+	TopBlockHits.Reset()
+	TopBlockHits.Set(5, "ingress", "198.51.100.0/24")
+
+	body := scrape(t)
+	for _, want := range []string{
+		"# TYPE dynafire_turris_events_total counter",
+		`dynafire_turris_events_total{event="list_reset"} 2`,
+		`dynafire_changes_applied_total{operation="block"} 3`,
+		"# TYPE dynafire_queue_depth gauge",
+		"dynafire_queue_depth 7",
+		`dynafire_top_block_hit_packets{direction="ingress",prefix="198.51.100.0/24"} 5`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics lack %q:\n%s", want, body)
+		}
+	}
+
+	if strings.Contains(body, "192.0.2.1/32") {
+		t.Errorf("reset gauge still exposes its old labels:\n%s", body)
+	}
+}
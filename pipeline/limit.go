@@ -0,0 +1,111 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"sort"
+
+	"github.com/MatejLach/dynafire/firewall"
+	"github.com/MatejLach/dynafire/metrics"
+)
+
+// SetHits records how many packets hit every enforced target, it decides what goes first once MaxEntries is reached
+func (p *Pipeline) SetHits(hits map[firewall.Target]uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.hits = hits
+}
+
+// LimitEntries is SetMaxEntries for a pipeline that has no sources yet, it must be called before any source is added
+func (p *Pipeline) LimitEntries(max int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.maxEntries = max
+}
+
+// SetMaxEntries limits the enforced set of each direction to max entries, 0 being unlimited, and brings the firewall in line with it
+// Entries that never hit are left out first, then those with the fewest hits
+func (p *Pipeline) SetMaxEntries(ctx context.Context, max int) error {
+	p.mu.Lock()
+
+	p.maxEntries = max
+
+	applied, err := p.resync(ctx, Origin{Source: ConfigSource}, false)
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return await(ctx, applied)
+}
+
+// limit reduces a new effective set to the maximum size, keeping the entries with the most hits
+// and, among those with equal hits, the ones that are already enforced
+func (p *Pipeline) limit(v *view, effective map[netip.Prefix]struct{}) map[netip.Prefix]struct{} {
+	if p.maxEntries <= 0 || len(effective) <= p.maxEntries {
+		metrics.EntriesLeftOut.Set(0, v.direction.String())
+		return effective
+	}
+
+	candidates := make([]netip.Prefix, 0, len(effective))
+	for prefix := range effective {
+		candidates = append(candidates, prefix)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		hitsI, hitsJ := p.hitsOf(v, candidates[i]), p.hitsOf(v, candidates[j])
+		if hitsI != hitsJ {
+			return hitsI > hitsJ
+		}
+
+		_, enforcedI := v.effective[candidates[i]]
+		_, enforcedJ := v.effective[candidates[j]]
+		if enforcedI != enforcedJ {
+			return enforcedI
+		}
+
+		return candidates[i].Addr().Less(candidates[j].Addr())
+	})
+
+	limited := make(map[netip.Prefix]struct{}, p.maxEntries)
+	for _, prefix := range candidates[:p.maxEntries] {
+		limited[prefix] = struct{}{}
+	}
+
+	leftOut := len(candidates) - p.maxEntries
+	metrics.EntriesLeftOut.Set(float64(leftOut), v.direction.String())
+	slog.Warn("enforced set is full, leaving out the entries with the fewest hits", "direction", v.direction, "max_entries", p.maxEntries, "left_out", leftOut)
+
+	return limited
+}
+
+// makeRoom unblocks an enforced entry that never hit so that a new one fits, reporting false if the set is full of entries that did
+func (p *Pipeline) makeRoom(ctx context.Context, v *view) (bool, error) {
+	if p.maxEntries <= 0 || len(v.effective) < p.maxEntries {
+		return true, nil
+	}
+
+	for prefix := range v.effective {
+		if p.hitsOf(v, prefix) > 0 {
+			continue
+		}
+
+		delete(v.effective, prefix)
+
+		return true, p.queue.Enqueue(ctx, Update{
+			Change: firewall.Change{Operation: firewall.Unblock, Direction: v.direction, Prefix: prefix},
+			Origin: Origin{Source: ConfigSource},
+		})
+	}
+
+	metrics.EntriesLeftOut.Inc(v.direction.String())
+
+	return false, nil
+}
+
+func (p *Pipeline) hitsOf(v *view, prefix netip.Prefix) uint64 {
+	return p.hits[firewall.Target{Direction: v.direction, Prefix: prefix}]
+}
@@ -0,0 +1,527 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/MatejLach/dynafire/firewall"
+	"github.com/MatejLach/dynafire/metrics"
+)
+
+// ConfigSource is the origin of changes caused by a configuration reload, i.e. of unblocks after extending the allowlist
+const ConfigSource = "config"
+
+// Pipeline merges the blacklists of all providers (sources) into the set enforced by the firewall
+// A prefix is enforced while at least one enabled source lists it and it does not overlap the allowlist
+// Sources keep being tracked while disabled, so re-enabling one does not have to wait for its next full list
+// Egress blocking is a second view of the same sources, with its own enabled sources and allowlist
+type Pipeline struct {
+	queue *Queue
+
+	mu      sync.Mutex
+	sources map[string]map[netip.Prefix]struct{}
+	ingress *view
+	// egress is nil unless egress blocking is enabled
+	egress *view
+
+	hits       map[firewall.Target]uint64
+	maxEntries int
+
+	// synced is set once the firewall was loaded with a full list, from then on resyncs only apply their difference
+	// to what is enforced, unless Resync asks for a full one
+	synced bool
+}
+
+// view is the enforcement of one direction
+type view struct {
+	direction firewall.Direction
+	enabled   map[string]bool
+	allowlist []netip.Prefix
+	effective map[netip.Prefix]struct{}
+}
+
+func newView(direction firewall.Direction, enabledSources []string, allowlist []netip.Prefix) *view {
+	v := &view{
+		direction: direction,
+		enabled:   enabledSet(enabledSources),
+		allowlist: allowlist,
+		effective: make(map[netip.Prefix]struct{}),
+	}
+
+	return v
+}
+
+func New(queue *Queue, enabledSources []string, allowlist []netip.Prefix) *Pipeline {
+	return &Pipeline{
+		queue:   queue,
+		sources: make(map[string]map[netip.Prefix]struct{}),
+		ingress: newView(firewall.Ingress, enabledSources, allowlist),
+	}
+}
+
+// EnableEgress additionally blocks traffic to the prefixes listed by enabledSources, it must be called before any source is added
+func (p *Pipeline) EnableEgress(enabledSources []string, allowlist []netip.Prefix) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.egress = newView(firewall.Egress, enabledSources, allowlist)
+}
+
+// ReplaceSource replaces everything the origin's source lists with prefixes and brings the firewall in line with the new effective set
+func (p *Pipeline) ReplaceSource(ctx context.Context, origin Origin, prefixes []netip.Prefix) error {
+	p.mu.Lock()
+
+	set := make(map[netip.Prefix]struct{}, len(prefixes))
+	for _, prefix := range prefixes {
+		set[prefix.Masked()] = struct{}{}
+	}
+	p.sources[origin.Source] = set
+	metrics.SourceSize.Set(float64(len(set)), origin.Source)
+
+	applied, err := p.resync(ctx, origin, false)
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return await(ctx, applied)
+}
+
+// Add records that the origin's source lists prefix, blocking it unless it is already enforced or allowlisted
+func (p *Pipeline) Add(ctx context.Context, origin Origin, prefix netip.Prefix) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	prefix = prefix.Masked()
+
+	set, ok := p.sources[origin.Source]
+	if !ok {
+		set = make(map[netip.Prefix]struct{})
+		p.sources[origin.Source] = set
+	}
+	set[prefix] = struct{}{}
+	metrics.SourceSize.Set(float64(len(set)), origin.Source)
+
+	return p.update(ctx, origin, prefix)
+}
+
+// Remove records that the origin's source no longer lists prefix, unblocking it unless another source still lists it
+func (p *Pipeline) Remove(ctx context.Context, origin Origin, prefix netip.Prefix) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	prefix = prefix.Masked()
+
+	set := p.sources[origin.Source]
+	delete(set, prefix)
+	metrics.SourceSize.Set(float64(len(set)), origin.Source)
+
+	return p.update(ctx, origin, prefix)
+}
+
+// SetAllowlist replaces the allowlist, unblocking newly allowlisted and re-blocking no longer allowlisted prefixes
+func (p *Pipeline) SetAllowlist(ctx context.Context, allowlist []netip.Prefix) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.setAllowlist(ctx, p.ingress, allowlist)
+}
+
+// SetEgressAllowlist replaces the egress allowlist, it is a no-op unless egress blocking is enabled
+func (p *Pipeline) SetEgressAllowlist(ctx context.Context, allowlist []netip.Prefix) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.egress == nil {
+		return nil
+	}
+
+	return p.setAllowlist(ctx, p.egress, allowlist)
+}
+
+func (p *Pipeline) setAllowlist(ctx context.Context, v *view, allowlist []netip.Prefix) error {
+	v.allowlist = allowlist
+
+	candidates := make(map[netip.Prefix]struct{}, len(v.effective))
+	for prefix := range v.effective {
+		candidates[prefix] = struct{}{}
+	}
+
+	for source, set := range p.sources {
+		if !v.enables(source) {
+			continue
+		}
+
+		for prefix := range set {
+			candidates[prefix] = struct{}{}
+		}
+	}
+
+	for prefix := range candidates {
+		err := p.updateView(ctx, v, Origin{Source: ConfigSource}, prefix)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetEnabledSources replaces the set of enforced sources and brings the firewall in line with it
+func (p *Pipeline) SetEnabledSources(ctx context.Context, sources []string) error {
+	p.mu.Lock()
+
+	p.ingress.enabled = enabledSet(sources)
+
+	applied, err := p.resync(ctx, Origin{Source: ConfigSource}, false)
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return await(ctx, applied)
+}
+
+// SetEgressSources replaces the set of sources blocked in the egress direction, it is a no-op unless egress blocking is enabled
+func (p *Pipeline) SetEgressSources(ctx context.Context, sources []string) error {
+	p.mu.Lock()
+
+	if p.egress == nil {
+		p.mu.Unlock()
+		return nil
+	}
+
+	p.egress.enabled = enabledSet(sources)
+
+	applied, err := p.resync(ctx, Origin{Source: ConfigSource}, false)
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return await(ctx, applied)
+}
+
+// Resync replaces whatever the firewall enforces with the effective set, i.e. after the backend changed its rule format
+func (p *Pipeline) Resync(ctx context.Context) error {
+	p.mu.Lock()
+	applied, err := p.resync(ctx, Origin{Source: ConfigSource}, true)
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return await(ctx, applied)
+}
+
+// Match is a prefix listed by a source
+type Match struct {
+	Source string
+	Prefix netip.Prefix
+}
+
+// LookupResult explains why an address is or is not blocked
+type LookupResult struct {
+	// Matches are the prefixes containing the address along with the sources listing them, including disabled ones
+	Matches []Match
+	// Enforced are the prefixes containing the address that the firewall blocks
+	Enforced []netip.Prefix
+	// Allowlisted is set if the address is covered by the allowlist
+	Allowlisted bool
+	// Egress is the egress counterpart of Enforced and Allowlisted, nil unless egress blocking is enabled
+	Egress *EgressResult
+}
+
+// EgressResult explains whether traffic to an address is blocked
+type EgressResult struct {
+	Enforced    []netip.Prefix
+	Allowlisted bool
+}
+
+// Lookup reports every source listing a prefix that contains addr and whether the firewall blocks it
+func (p *Pipeline) Lookup(addr netip.Addr) LookupResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	addr = addr.Unmap()
+	result := LookupResult{
+		Matches:  make([]Match, 0),
+		Enforced: make([]netip.Prefix, 0),
+	}
+
+	for source, set := range p.sources {
+		for prefix := range set {
+			if prefix.Contains(addr) {
+				result.Matches = append(result.Matches, Match{Source: source, Prefix: prefix})
+			}
+		}
+	}
+
+	sort.Slice(result.Matches, func(i, j int) bool {
+		if result.Matches[i].Source != result.Matches[j].Source {
+			return result.Matches[i].Source < result.Matches[j].Source
+		}
+
+		return result.Matches[i].Prefix.Bits() > result.Matches[j].Prefix.Bits()
+	})
+
+	result.Enforced, result.Allowlisted = p.ingress.lookup(addr)
+	if p.egress != nil {
+		result.Egress = &EgressResult{}
+		result.Egress.Enforced, result.Egress.Allowlisted = p.egress.lookup(addr)
+	}
+
+	return result
+}
+
+// Entry is an enforced prefix along with the enabled sources listing it
+type Entry struct {
+	firewall.Target
+	Sources []string
+}
+
+// Entries returns every enforced prefix of both directions
+func (p *Pipeline) Entries() []Entry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	result := make([]Entry, 0, len(p.ingress.effective))
+	for _, v := range p.views() {
+		for prefix := range v.effective {
+			entry := Entry{Target: firewall.Target{Direction: v.direction, Prefix: prefix}, Sources: make([]string, 0, 1)}
+			for source, set := range p.sources {
+				if _, ok := set[prefix]; ok && v.enables(source) {
+					entry.Sources = append(entry.Sources, source)
+				}
+			}
+			sort.Strings(entry.Sources)
+
+			result = append(result, entry)
+		}
+	}
+
+	return result
+}
+
+// Enabled reports whether source is enforced
+func (p *Pipeline) Enabled(source string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.ingress.enables(source)
+}
+
+// EgressEnabled reports whether traffic to the prefixes listed by source is blocked
+func (p *Pipeline) EgressEnabled(source string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.egress != nil && p.egress.enables(source)
+}
+
+func (v *view) lookup(addr netip.Addr) ([]netip.Prefix, bool) {
+	enforced := make([]netip.Prefix, 0)
+	for prefix := range v.effective {
+		if prefix.Contains(addr) {
+			enforced = append(enforced, prefix)
+		}
+	}
+
+	allowlisted := false
+	for _, allowed := range v.allowlist {
+		if allowed.Contains(addr) {
+			allowlisted = true
+		}
+	}
+
+	return enforced, allowlisted
+}
+
+// views returns the directions being enforced
+func (p *Pipeline) views() []*view {
+	if p.egress == nil {
+		return []*view{p.ingress}
+	}
+
+	return []*view{p.ingress, p.egress}
+}
+
+// resync recomputes the effective sets; the first time and when full is set, the firewall is reloaded with them,
+// which is queued while p.mu is held so that no later change overtakes it, and the returned channel receives the outcome
+// once applied; otherwise only the difference to what is enforced is queued, and the channel is nil
+func (p *Pipeline) resync(ctx context.Context, origin Origin, full bool) (<-chan error, error) {
+	list := make([]firewall.Target, 0)
+	diff := make([]Update, 0)
+
+	for _, v := range p.views() {
+		effective := make(map[netip.Prefix]struct{})
+		for source, set := range p.sources {
+			if !v.enables(source) {
+				continue
+			}
+
+			for prefix := range set {
+				if !v.allowlisted(prefix) {
+					effective[prefix] = struct{}{}
+				}
+			}
+		}
+		effective = p.limit(v, effective)
+
+		for prefix := range effective {
+			list = append(list, firewall.Target{Direction: v.direction, Prefix: prefix})
+			if _, ok := v.effective[prefix]; !ok {
+				diff = append(diff, Update{
+					Change: firewall.Change{Operation: firewall.Block, Direction: v.direction, Prefix: prefix},
+					Origin: p.blockOrigin(v, prefix, origin),
+				})
+			}
+		}
+
+		for prefix := range v.effective {
+			if _, ok := effective[prefix]; !ok {
+				diff = append(diff, Update{
+					Change: firewall.Change{Operation: firewall.Unblock, Direction: v.direction, Prefix: prefix},
+					Origin: origin,
+				})
+			}
+		}
+
+		v.effective = effective
+	}
+	p.updateMetrics()
+
+	if p.synced && !full {
+		return nil, p.queue.EnqueueBatch(ctx, diff)
+	}
+
+	applied, err := p.queue.Replace(ctx, origin, list, diff)
+	if err != nil {
+		return nil, err
+	}
+	p.synced = true
+
+	return applied, nil
+}
+
+// update enqueues whatever changes bring the enforcement of prefix in line with the sources and the allowlists
+func (p *Pipeline) update(ctx context.Context, origin Origin, prefix netip.Prefix) error {
+	for _, v := range p.views() {
+		err := p.updateView(ctx, v, origin, prefix)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Pipeline) updateView(ctx context.Context, v *view, origin Origin, prefix netip.Prefix) error {
+	_, enforced := v.effective[prefix]
+	wanted := p.wanted(v, prefix)
+
+	switch {
+	case wanted && !enforced:
+		ok, err := p.makeRoom(ctx, v)
+		if !ok || err != nil {
+			return err
+		}
+
+		v.effective[prefix] = struct{}{}
+		p.updateMetrics()
+
+		return p.queue.Enqueue(ctx, Update{
+			Change: firewall.Change{Operation: firewall.Block, Direction: v.direction, Prefix: prefix},
+			Origin: p.blockOrigin(v, prefix, origin),
+		})
+	case !wanted && enforced:
+		delete(v.effective, prefix)
+		p.updateMetrics()
+
+		return p.queue.Enqueue(ctx, Update{
+			Change: firewall.Change{Operation: firewall.Unblock, Direction: v.direction, Prefix: prefix},
+			Origin: origin,
+		})
+	default:
+		return nil
+	}
+}
+
+func (p *Pipeline) wanted(v *view, prefix netip.Prefix) bool {
+	if v.allowlisted(prefix) {
+		slog.Debug("not blocking allowlisted prefix", "prefix", firewall.PrefixString(prefix), "direction", v.direction)
+		return false
+	}
+
+	for source, set := range p.sources {
+		if !v.enables(source) {
+			continue
+		}
+
+		if _, ok := set[prefix]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// blockOrigin attributes a block to origin if its source lists prefix, otherwise to the first enabled source that does,
+// i.e. a prefix that is blocked again after being removed from the allowlist is attributed to the provider listing it
+func (p *Pipeline) blockOrigin(v *view, prefix netip.Prefix, origin Origin) Origin {
+	if _, ok := p.sources[origin.Source][prefix]; ok {
+		return origin
+	}
+
+	listing := make([]string, 0)
+	for source, set := range p.sources {
+		if _, ok := set[prefix]; ok && v.enables(source) {
+			listing = append(listing, source)
+		}
+	}
+
+	if len(listing) == 0 {
+		return origin
+	}
+	sort.Strings(listing)
+
+	return Origin{Source: listing[0]}
+}
+
+func (p *Pipeline) updateMetrics() {
+	metrics.EffectiveSetSize.Set(float64(len(p.ingress.effective)))
+	if p.egress != nil {
+		metrics.EgressEffectiveSetSize.Set(float64(len(p.egress.effective)))
+	}
+}
+
+// allowlisted reports whether prefix overlaps any allowlist entry; overlapping prefixes are never blocked as a whole
+func (v *view) allowlisted(prefix netip.Prefix) bool {
+	for _, allowed := range v.allowlist {
+		if allowed.Overlaps(prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// enables reports whether source is enforced; a source of the form provider/name, i.e. the detections of one peer, is enforced along with its provider
+func (v *view) enables(source string) bool {
+	provider, _, _ := strings.Cut(source, "/")
+
+	return v.enabled[source] || v.enabled[provider]
+}
+
+func enabledSet(sources []string) map[string]bool {
+	enabled := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		enabled[source] = true
+	}
+
+	return enabled
+}
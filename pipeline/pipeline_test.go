@@ -0,0 +1,197 @@
+package pipeline
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/MatejLach/dynafire/firewall"
+	"github.com/MatejLach/dynafire/firewall/memory"
+)
+
+func prefixes(s ...string) []netip.Prefix {
+	result := make([]netip.Prefix, 0, len(s))
+	for _, prefix := range s {
+		result = append(result, netip.MustParsePrefix(prefix))
+	}
+
+	return result
+}
+
+// newTestPipeline runs a pipeline against the in-memory backend; its queue flushes only when the test calls settle
+func newTestPipeline(t *testing.T, enabledSources []string, allowlist []netip.Prefix) (*Pipeline, *memory.Blocker, func()) {
+	t.Helper()
+
+	blocker := memory.New(false)
+	queue := NewQueue(blocker, QueueConfig{FlushInterval: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- queue.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("queue stopped with %v", err)
+		}
+	})
+
+	settle := func() {
+		t.Helper()
+
+		if err := queue.Do(ctx, func() error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return New(queue, enabledSources, allowlist), blocker, settle
+}
+
+func expectEnforced(t *testing.T, blocker *memory.Blocker, direction firewall.Direction, want []netip.Prefix) {
+	t.Helper()
+
+	if got := blocker.Enforced(direction); !slices.Equal(got, want) {
+		t.Fatalf("%s enforces %v, want %v", direction, got, want)
+	}
+}
+
+func TestPipelineSources(t *testing.T) {
+	ctx := context.Background()
+	p, blocker, settle := newTestPipeline(t, []string{"turris", "abuseipdb"}, nil)
+
+	if err := p.ReplaceSource(ctx, Origin{Source: "turris"}, prefixes("192.0.2.1/32", "192.0.2.2/32")); err != nil {
+		t.Fatal(err)
+	}
+	expectEnforced(t, blocker, firewall.Ingress, prefixes("192.0.2.1/32", "192.0.2.2/32"))
+
+	// a prefix stays blocked as long as one enabled source lists it
+	if err := p.ReplaceSource(ctx, Origin{Source: "abuseipdb"}, prefixes("192.0.2.2/32", "198.51.100.7/24")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ReplaceSource(ctx, Origin{Source: "turris"}, prefixes("192.0.2.2/32")); err != nil {
+		t.Fatal(err)
+	}
+	settle()
+	expectEnforced(t, blocker, firewall.Ingress, prefixes("192.0.2.2/32", "198.51.100.0/24"))
+
+	if err := p.Remove(ctx, Origin{Source: "abuseipdb"}, netip.MustParsePrefix("192.0.2.2/32")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Add(ctx, Origin{Source: "crowdsec"}, netip.MustParsePrefix("203.0.113.1/32")); err != nil {
+		t.Fatal(err)
+	}
+	settle()
+	expectEnforced(t, blocker, firewall.Ingress, prefixes("192.0.2.2/32", "198.51.100.0/24"))
+
+	// disabled sources are still tracked, and blocked as soon as they are enabled
+	if err := p.SetEnabledSources(ctx, []string{"abuseipdb", "crowdsec"}); err != nil {
+		t.Fatal(err)
+	}
+	settle()
+	expectEnforced(t, blocker, firewall.Ingress, prefixes("198.51.100.0/24", "203.0.113.1/32"))
+}
+
+func TestPipelineResync(t *testing.T) {
+	ctx := context.Background()
+	p, blocker, settle := newTestPipeline(t, []string{"turris"}, nil)
+
+	if err := p.ReplaceSource(ctx, Origin{Source: "turris"}, prefixes("192.0.2.1/32", "198.51.100.0/24")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the firewall drifted away from the effective set, i.e. its rules were reloaded by someone else
+	err := blocker.ApplyBatch([]firewall.Change{
+		{Operation: firewall.Unblock, Direction: firewall.Ingress, Prefix: netip.MustParsePrefix("192.0.2.1/32")},
+		{Operation: firewall.Block, Direction: firewall.Ingress, Prefix: netip.MustParsePrefix("203.0.113.0/24")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replacing a source only applies the difference to what the pipeline believes to be enforced
+	if err := p.ReplaceSource(ctx, Origin{Source: "turris"}, prefixes("192.0.2.1/32", "198.51.100.0/24", "192.0.2.3/32")); err != nil {
+		t.Fatal(err)
+	}
+	settle()
+	expectEnforced(t, blocker, firewall.Ingress, prefixes("192.0.2.3/32", "198.51.100.0/24", "203.0.113.0/24"))
+
+	if err := p.Resync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectEnforced(t, blocker, firewall.Ingress, prefixes("192.0.2.1/32", "192.0.2.3/32", "198.51.100.0/24"))
+}
+
+func TestPipelineAllowlist(t *testing.T) {
+	ctx := context.Background()
+	p, blocker, settle := newTestPipeline(t, []string{"turris"}, prefixes("192.0.2.0/25"))
+
+	// prefixes overlapping the allowlist are not blocked as a whole
+	err := p.ReplaceSource(ctx, Origin{Source: "turris"}, prefixes("192.0.2.0/24", "192.0.2.5/32", "192.0.2.200/32"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEnforced(t, blocker, firewall.Ingress, prefixes("192.0.2.200/32"))
+
+	if err := p.Add(ctx, Origin{Source: "turris"}, netip.MustParsePrefix("192.0.2.6/32")); err != nil {
+		t.Fatal(err)
+	}
+	settle()
+	expectEnforced(t, blocker, firewall.Ingress, prefixes("192.0.2.200/32"))
+
+	result := p.Lookup(netip.MustParseAddr("192.0.2.6"))
+	if !result.Allowlisted || len(result.Enforced) != 0 || len(result.Matches) != 2 {
+		t.Fatalf("Lookup() = %+v, want an allowlisted address matched by 2 prefixes", result)
+	}
+
+	if err := p.SetAllowlist(ctx, prefixes("192.0.2.200/32")); err != nil {
+		t.Fatal(err)
+	}
+	settle()
+	expectEnforced(t, blocker, firewall.Ingress, prefixes("192.0.2.5/32", "192.0.2.6/32"))
+
+	if err := p.SetAllowlist(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	settle()
+	expectEnforced(t, blocker, firewall.Ingress, prefixes("192.0.2.0/24", "192.0.2.5/32", "192.0.2.6/32", "192.0.2.200/32"))
+
+	if !blocker.IsBlocked(net.ParseIP("192.0.2.100")) {
+		t.Fatal("192.0.2.100 is not blocked once the allowlist is empty")
+	}
+}
+
+func TestPipelineEgress(t *testing.T) {
+	ctx := context.Background()
+	p, blocker, settle := newTestPipeline(t, []string{"turris", "abuseipdb"}, nil)
+	p.EnableEgress([]string{"abuseipdb"}, prefixes("198.51.100.0/24"))
+
+	if err := p.ReplaceSource(ctx, Origin{Source: "turris"}, prefixes("192.0.2.1/32")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ReplaceSource(ctx, Origin{Source: "abuseipdb"}, prefixes("198.51.100.7/32", "203.0.113.7/32")); err != nil {
+		t.Fatal(err)
+	}
+	settle()
+	expectEnforced(t, blocker, firewall.Ingress, prefixes("192.0.2.1/32", "198.51.100.7/32", "203.0.113.7/32"))
+	expectEnforced(t, blocker, firewall.Egress, prefixes("203.0.113.7/32"))
+
+	if err := p.SetEgressAllowlist(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetEgressSources(ctx, []string{"turris", "abuseipdb"}); err != nil {
+		t.Fatal(err)
+	}
+	settle()
+	expectEnforced(t, blocker, firewall.Egress, prefixes("192.0.2.1/32", "198.51.100.7/32", "203.0.113.7/32"))
+
+	if err := p.Resync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectEnforced(t, blocker, firewall.Ingress, prefixes("192.0.2.1/32", "198.51.100.7/32", "203.0.113.7/32"))
+	expectEnforced(t, blocker, firewall.Egress, prefixes("192.0.2.1/32", "198.51.100.7/32", "203.0.113.7/32"))
+}
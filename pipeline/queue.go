@@ -0,0 +1,611 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/MatejLach/dynafire/audit"
+	"github.com/MatejLach/dynafire/firewall"
+	"github.com/MatejLach/dynafire/firewall/resilient"
+	"github.com/MatejLach/dynafire/metrics"
+)
+
+const (
+	DefaultQueueSize     = 4096
+	DefaultBatchSize     = 256
+	DefaultFlushInterval = 2 * time.Second
+	// maxAttempts is how many flushes a change may fail in before its batch is split up to find the changes that keep
+	// failing, which are dropped; flushes turned away by the open circuit breaker do not count
+	maxAttempts = 20
+)
+
+type QueueConfig struct {
+	// Size bounds the number of changes waiting to be coalesced, Enqueue blocks once it is reached
+	Size int
+	// BatchSize flushes the pending changes once that many distinct prefixes are pending
+	BatchSize int
+	// FlushInterval flushes the pending changes at least this often
+	FlushInterval time.Duration
+	// Audit records the outcome of every change, nil disables auditing
+	Audit *audit.Log
+}
+
+// Origin describes where a change came from, it is recorded in the audit log
+type Origin struct {
+	Source string
+	// Serial and ListVersion identify the provider message behind the change, if the provider has such a notion
+	Serial      uint64
+	ListVersion string
+}
+
+// Update is a firewall change along with its origin
+type Update struct {
+	firewall.Change
+	Origin Origin
+}
+
+// Queue coalesces block/unblock changes per prefix and hands them to the firewall.Blocker in batches
+// Full list replacements go through the same queue so that they are serialized with the batches
+type Queue struct {
+	blocker firewall.Blocker
+	conf    QueueConfig
+	in      chan queueItem
+
+	pending map[firewall.Target]pendingUpdate
+	order   []firewall.Target
+	// pendingList is a full list whose replacement failed, it is retried before any pending changes
+	pendingList  *listReplacement
+	pendingCount atomic.Int64
+}
+
+type pendingUpdate struct {
+	Update
+	// failed is set once the failure of the update has been audited, so that retries are not audited again
+	failed bool
+	// attempts counts the flushes the update failed in, while the backend was being tried
+	attempts int
+}
+
+// listReplacement is a full list along with the changes it makes to the enforced set, which are what gets audited
+type listReplacement struct {
+	origin Origin
+	list   []firewall.Target
+	diff   []Update
+	failed bool
+}
+
+type queueItem struct {
+	update Update
+	batch  []Update
+	list   *listReplacement
+	fn     func() error
+	done   chan error
+}
+
+func NewQueue(blocker firewall.Blocker, conf QueueConfig) *Queue {
+	if conf.Size <= 0 {
+		conf.Size = DefaultQueueSize
+	}
+
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = DefaultBatchSize
+	}
+
+	if conf.FlushInterval <= 0 {
+		conf.FlushInterval = DefaultFlushInterval
+	}
+
+	return &Queue{
+		blocker: blocker,
+		conf:    conf,
+		in:      make(chan queueItem, conf.Size),
+		pending: make(map[firewall.Target]pendingUpdate),
+	}
+}
+
+// Enqueue adds a change to the queue, blocking while the queue is full
+func (q *Queue) Enqueue(ctx context.Context, update Update) error {
+	select {
+	case q.in <- queueItem{update: update}:
+		metrics.QueueDepth.Set(float64(len(q.in)))
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// EnqueueBatch adds changes to the queue at once, i.e. the difference a new list of a source makes to the enforced set
+func (q *Queue) EnqueueBatch(ctx context.Context, updates []Update) error {
+	if len(updates) == 0 {
+		return nil
+	}
+
+	select {
+	case q.in <- queueItem{batch: updates}:
+		metrics.QueueDepth.Set(float64(len(q.in)))
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Replace queues the replacement of the enforced set with list, which discards all pending changes; it returns
+// once the replacement is queued, the returned channel receives its outcome once the list is applied
+// diff lists the blocks and unblocks the new list amounts to, they are audited individually
+func (q *Queue) Replace(ctx context.Context, origin Origin, list []firewall.Target, diff []Update) (<-chan error, error) {
+	item := queueItem{list: &listReplacement{origin: origin, list: list, diff: diff}, done: make(chan error, 1)}
+
+	return item.done, q.submit(ctx, item)
+}
+
+// Do runs fn in between batches, i.e. to reconfigure the backend without racing the changes being applied
+// Pending changes are flushed first, Do returns once fn has run
+func (q *Queue) Do(ctx context.Context, fn func() error) error {
+	item := queueItem{fn: fn, done: make(chan error, 1)}
+
+	err := q.submit(ctx, item)
+	if err != nil {
+		return err
+	}
+
+	return await(ctx, item.done)
+}
+
+func (q *Queue) submit(ctx context.Context, item queueItem) error {
+	select {
+	case q.in <- item:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// await waits for the outcome of a queued item, a nil done has nothing to wait for
+func await(ctx context.Context, done <-chan error) error {
+	if done == nil {
+		return nil
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pending returns the number of changes waiting to be applied, including those of a failed full list
+func (q *Queue) Pending() int {
+	return int(q.pendingCount.Load())
+}
+
+// Run applies queued changes until ctx is cancelled or the backend fails with a fatal error
+// Changes that failed transiently stay queued and are retried with the next flush
+func (q *Queue) Run(ctx context.Context) error {
+	ticker := time.NewTicker(q.conf.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return q.flush()
+		case <-ticker.C:
+			err := q.flush()
+			if err != nil {
+				return err
+			}
+		case item := <-q.in:
+			metrics.QueueDepth.Set(float64(len(q.in)))
+
+			if item.fn != nil {
+				err := q.flush()
+				if err != nil {
+					item.done <- err
+					return err
+				}
+
+				item.done <- item.fn()
+				continue
+			}
+
+			if item.list != nil {
+				err := q.replace(item.list)
+				item.done <- err
+				if firewall.IsFatal(err) {
+					return err
+				}
+
+				continue
+			}
+
+			if item.batch != nil {
+				for _, update := range item.batch {
+					q.add(pendingUpdate{Update: update})
+				}
+			} else {
+				q.add(pendingUpdate{Update: item.update})
+			}
+
+			q.updatePendingCount()
+			if len(q.pending) >= q.conf.BatchSize {
+				err := q.flush()
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// add coalesces a change into the pending set; an opposite change for a pending target cancels both out
+func (q *Queue) add(update pendingUpdate) {
+	target := update.Target()
+
+	pending, ok := q.pending[target]
+	if !ok {
+		q.pending[target] = update
+		q.order = append(q.order, target)
+		return
+	}
+
+	if pending.Operation != update.Operation {
+		delete(q.pending, target)
+		metrics.ChangesCoalesced.Add(2)
+		return
+	}
+
+	// the latest origin wins, but a failure that was already audited stays audited
+	update.failed = update.failed || pending.failed
+	update.attempts = max(update.attempts, pending.attempts)
+	q.pending[target] = update
+	metrics.ChangesCoalesced.Inc()
+}
+
+func (q *Queue) flush() error {
+	defer q.updatePendingCount()
+
+	if q.pendingList != nil {
+		err := q.applyList(q.pendingList)
+		if err != nil {
+			if firewall.IsFatal(err) {
+				return err
+			}
+
+			return nil
+		}
+	}
+
+	if len(q.pending) == 0 {
+		q.order = q.order[:0]
+		return nil
+	}
+
+	updates := make([]pendingUpdate, 0, len(q.pending))
+	for _, target := range q.order {
+		update, ok := q.pending[target]
+		if !ok {
+			continue
+		}
+
+		updates = append(updates, update)
+		// a target may have been cancelled out and re-added, only apply it once
+		delete(q.pending, target)
+	}
+	q.order = q.order[:0]
+
+	start := time.Now()
+	result := q.applyBatch(updates)
+
+	if len(result.applied) > 0 {
+		metrics.BatchesApplied.Inc()
+
+		records := make([]audit.Record, 0, len(result.applied))
+		for _, update := range result.applied {
+			metrics.ChangesApplied.Inc(update.Operation.String())
+			records = append(records, auditRecord(update.Update, nil))
+		}
+		q.audit(records)
+
+		slog.Debug("applied batch of firewall changes", "changes", len(result.applied), "duration", time.Since(start))
+	}
+
+	q.drop(result.dropped)
+
+	if result.err == nil {
+		return nil
+	}
+
+	q.auditFailures(result.failed, result.err)
+
+	if firewall.IsFatal(result.err) {
+		return result.err
+	}
+
+	// keep the failed changes for the next flush; anything queued in the meantime is coalesced on top of them
+	counted := !errors.Is(result.err, resilient.ErrCircuitOpen)
+	for _, update := range result.failed {
+		update.failed = true
+		if counted {
+			update.attempts++
+		}
+
+		q.add(update)
+	}
+
+	return nil
+}
+
+// batchResult is the outcome of applying a batch, which may be applied in parts
+type batchResult struct {
+	applied []pendingUpdate
+	dropped []droppedUpdate
+	// failed are the updates not applied because of err, they are worth retrying unless err is fatal
+	failed []pendingUpdate
+	err    error
+}
+
+type droppedUpdate struct {
+	pendingUpdate
+	err error
+}
+
+// applyBatch applies updates as one batch; when the backend rejects it, or it contains updates that failed too often,
+// it is split in halves until the updates at fault are isolated and dropped, so that they do not hold up the others
+func (q *Queue) applyBatch(updates []pendingUpdate) batchResult {
+	batch := make([]firewall.Change, 0, len(updates))
+	for _, update := range updates {
+		batch = append(batch, update.Change)
+	}
+
+	err := q.blocker.ApplyBatch(batch)
+	if err == nil {
+		return batchResult{applied: updates}
+	}
+
+	isolate := firewall.IsRejected(err)
+	if !isolate && !firewall.IsFatal(err) && !errors.Is(err, resilient.ErrCircuitOpen) {
+		for _, update := range updates {
+			isolate = isolate || update.attempts+1 >= maxAttempts
+		}
+	}
+
+	if !isolate {
+		return batchResult{failed: updates, err: err}
+	}
+
+	if len(updates) == 1 {
+		return batchResult{dropped: []droppedUpdate{{pendingUpdate: updates[0], err: err}}}
+	}
+
+	mid := len(updates) / 2
+	result := q.applyBatch(updates[:mid])
+	if firewall.IsFatal(result.err) {
+		result.failed = append(result.failed, updates[mid:]...)
+		return result
+	}
+
+	second := q.applyBatch(updates[mid:])
+	result.applied = append(result.applied, second.applied...)
+	result.dropped = append(result.dropped, second.dropped...)
+	result.failed = append(result.failed, second.failed...)
+	if second.err != nil {
+		result.err = second.err
+	}
+
+	return result
+}
+
+// drop gives up on updates the backend rejected or that kept failing, they are audited and stay out of the firewall
+// until the next full list or resync lists them again
+func (q *Queue) drop(dropped []droppedUpdate) {
+	records := make([]audit.Record, 0, len(dropped))
+	for _, update := range dropped {
+		slog.Error("giving up on firewall change", "operation", update.Operation.String(), "prefix", firewall.PrefixString(update.Prefix),
+			"direction", update.Direction, "source", update.Origin.Source, "attempts", update.attempts+1, "details", update.err)
+		metrics.ChangesDropped.Inc(update.Operation.String())
+
+		record := auditRecord(update.Update, update.err)
+		record.Result = audit.ResultDropped
+		records = append(records, record)
+	}
+
+	q.audit(records)
+}
+
+func (q *Queue) replace(replacement *listReplacement) error {
+	defer q.updatePendingCount()
+
+	// changes that were never applied are not part of the diff against the enforced set, but they are
+	// applied by the new list all the same if it agrees with them, so they are audited along with it
+	superseded := make([]Update, 0, len(q.pending))
+	if q.pendingList != nil {
+		superseded = append(superseded, q.pendingList.diff...)
+	}
+
+	for _, target := range q.order {
+		if update, ok := q.pending[target]; ok {
+			superseded = append(superseded, update.Update)
+		}
+	}
+
+	if len(q.pending) > 0 {
+		slog.Debug("discarding pending changes superseded by a full list", "changes", len(q.pending))
+		clear(q.pending)
+		q.order = q.order[:0]
+	}
+
+	replacement.diff = mergeSuperseded(replacement.list, replacement.diff, superseded)
+
+	return q.applyList(replacement)
+}
+
+// applyList replaces the enforced set with the list; until it succeeds, the list stays pending
+// and is retried with the next flush, ahead of any changes queued after it
+func (q *Queue) applyList(replacement *listReplacement) error {
+	q.pendingList = replacement
+	list := replacement.list
+
+	err := q.applyListToBackend(replacement)
+	if err != nil {
+		if !replacement.failed {
+			replacement.failed = true
+			q.audit([]audit.Record{replacementRecord(replacement, err)})
+		}
+
+		return err
+	}
+
+	q.pendingList = nil
+	metrics.EnforcedSetSize.Set(float64(len(list)))
+
+	records := make([]audit.Record, 0, len(replacement.diff)+1)
+	records = append(records, replacementRecord(replacement, nil))
+	for _, update := range replacement.diff {
+		records = append(records, auditRecord(update, nil))
+	}
+	q.audit(records)
+
+	return nil
+}
+
+func (q *Queue) applyListToBackend(replacement *listReplacement) error {
+	// single ingress addresses go through the backend's bulk load, anything else is added as a batch on top of it
+	addresses := make([]net.IP, 0, len(replacement.list))
+	prefixes := make([]pendingUpdate, 0)
+	for _, target := range replacement.list {
+		if target.Direction == firewall.Ingress && firewall.IsSingleIP(target.Prefix) {
+			addresses = append(addresses, net.IP(target.Prefix.Addr().AsSlice()))
+		} else {
+			change := firewall.Change{Operation: firewall.Block, Direction: target.Direction, Prefix: target.Prefix}
+			prefixes = append(prefixes, pendingUpdate{Update: Update{Change: change, Origin: replacement.origin}})
+		}
+	}
+
+	err := q.blocker.ResetFirewallRules()
+	if err != nil {
+		return err
+	}
+
+	err = q.blocker.BlockIPList(addresses)
+	if err != nil {
+		return err
+	}
+
+	if len(prefixes) == 0 {
+		return nil
+	}
+
+	// a prefix the backend rejects is left out rather than failing the whole list over and over
+	result := q.applyBatch(prefixes)
+	q.drop(result.dropped)
+
+	return result.err
+}
+
+// auditFailures records a failed batch, leaving out updates whose failure has already been recorded
+func (q *Queue) auditFailures(updates []pendingUpdate, err error) {
+	records := make([]audit.Record, 0, len(updates))
+	for _, update := range updates {
+		if !update.failed {
+			records = append(records, auditRecord(update.Update, err))
+		}
+	}
+
+	q.audit(records)
+}
+
+func (q *Queue) audit(records []audit.Record) {
+	err := q.conf.Audit.Write(records...)
+	if err != nil {
+		slog.Error("unable to write audit log", "details", err)
+	}
+}
+
+func (q *Queue) updatePendingCount() {
+	count := len(q.pending)
+	if q.pendingList != nil {
+		count += len(q.pendingList.list)
+	}
+
+	q.pendingCount.Store(int64(count))
+	metrics.PendingChanges.Set(float64(count))
+}
+
+// mergeSuperseded adds the superseded updates the new list agrees with to its diff, unless the diff covers their target already
+func mergeSuperseded(list []firewall.Target, diff, superseded []Update) []Update {
+	if len(superseded) == 0 {
+		return diff
+	}
+
+	listed := make(map[firewall.Target]struct{}, len(list))
+	for _, target := range list {
+		listed[target] = struct{}{}
+	}
+
+	covered := make(map[firewall.Target]struct{}, len(diff))
+	for _, update := range diff {
+		covered[update.Target()] = struct{}{}
+	}
+
+	for _, update := range superseded {
+		target := update.Target()
+		if _, ok := covered[target]; ok {
+			continue
+		}
+
+		_, inList := listed[target]
+		if inList == (update.Operation == firewall.Block) {
+			diff = append(diff, update)
+			covered[target] = struct{}{}
+		}
+	}
+
+	return diff
+}
+
+func auditRecord(update Update, err error) audit.Record {
+	action := audit.ActionBlock
+	if update.Operation == firewall.Unblock {
+		action = audit.ActionUnblock
+	}
+
+	direction := ""
+	if update.Direction == firewall.Egress {
+		direction = audit.DirectionEgress
+	}
+
+	return withResult(audit.Record{
+		Time:        time.Now(),
+		Action:      action,
+		Prefix:      firewall.PrefixString(update.Prefix),
+		Direction:   direction,
+		Source:      update.Origin.Source,
+		Serial:      update.Origin.Serial,
+		ListVersion: update.Origin.ListVersion,
+	}, err)
+}
+
+func replacementRecord(replacement *listReplacement, err error) audit.Record {
+	return withResult(audit.Record{
+		Time:        time.Now(),
+		Action:      audit.ActionReplace,
+		Source:      replacement.origin.Source,
+		Serial:      replacement.origin.Serial,
+		ListVersion: replacement.origin.ListVersion,
+		Entries:     len(replacement.list),
+	}, err)
+}
+
+func withResult(record audit.Record, err error) audit.Record {
+	record.Result = audit.ResultApplied
+	if err != nil {
+		record.Result = audit.ResultFailed
+		record.Error = err.Error()
+	}
+
+	return record
+}
@@ -0,0 +1,55 @@
+package pipeline
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/MatejLach/dynafire/firewall"
+	"github.com/MatejLach/dynafire/firewall/memory"
+)
+
+func TestQueueCoalesces(t *testing.T) {
+	blocker := memory.New(false)
+	queue := NewQueue(blocker, QueueConfig{BatchSize: 2, FlushInterval: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- queue.Run(ctx)
+	}()
+
+	a, b, c := netip.MustParsePrefix("192.0.2.1/32"), netip.MustParsePrefix("198.51.100.0/24"), netip.MustParsePrefix("203.0.113.7/32")
+
+	// the unblock cancels the pending block out and the repeated block is applied once; the third prefix fills the batch
+	changes := []firewall.Change{
+		{Operation: firewall.Block, Prefix: a},
+		{Operation: firewall.Unblock, Prefix: a},
+		{Operation: firewall.Block, Prefix: b},
+		{Operation: firewall.Block, Prefix: b},
+		{Operation: firewall.Block, Prefix: c},
+	}
+
+	for _, change := range changes {
+		if err := queue.Enqueue(ctx, Update{Change: change}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []netip.Prefix{b, c}
+	deadline := time.Now().Add(5 * time.Second)
+	for !slices.Equal(blocker.Enforced(firewall.Ingress), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("enforced %v, want %v", blocker.Enforced(firewall.Ingress), want)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() = %v after cancellation, want nil", err)
+	}
+}
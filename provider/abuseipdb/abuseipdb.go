@@ -0,0 +1,330 @@
+package abuseipdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultURL               = "https://api.abuseipdb.com/api/v2/blacklist"
+	DefaultConfidenceMinimum = 100
+	DefaultLimit             = 10000
+	// DefaultRefreshInterval stays within the daily blacklist quota of the free plan
+	DefaultRefreshInterval = 6 * time.Hour
+	// retryDelay is how long a failed fetch waits before it is retried, unless upstream says otherwise
+	retryDelay   = 5 * time.Minute
+	requestLimit = time.Minute
+)
+
+// Config points the provider at an AbuseIPDB compatible blacklist endpoint
+type Config struct {
+	URL    string
+	APIKey string
+	// ConfidenceMinimum is the lowest abuse confidence score, 25 to 100, of the addresses fetched
+	ConfidenceMinimum int
+	// Limit caps how many addresses are fetched, the most recently reported first
+	Limit           int
+	RefreshInterval time.Duration
+	// CacheFile keeps the last fetched list, so that a restart neither waits for nor spends a request on it
+	CacheFile string
+	// ReadOnlyCache starts from CacheFile without ever writing it, i.e. in dry-run
+	ReadOnlyCache bool
+}
+
+// Entry is a blacklisted address along with what upstream knows about it
+type Entry struct {
+	Prefix       netip.Prefix
+	Confidence   int
+	CountryCode  string
+	LastReported time.Time
+}
+
+// List is the blacklist as fetched at Generated
+type List struct {
+	Entries   map[netip.Prefix]Entry
+	Generated time.Time
+}
+
+// Prefixes returns the blacklisted prefixes
+func (l List) Prefixes() []netip.Prefix {
+	result := make([]netip.Prefix, 0, len(l.Entries))
+	for prefix := range l.Entries {
+		result = append(result, prefix)
+	}
+
+	return result
+}
+
+// response is the JSON the blacklist endpoint answers with
+type response struct {
+	Meta struct {
+		GeneratedAt time.Time `json:"generatedAt"`
+	} `json:"meta"`
+	Data []struct {
+		IPAddress            string    `json:"ipAddress"`
+		CountryCode          string    `json:"countryCode"`
+		AbuseConfidenceScore int       `json:"abuseConfidenceScore"`
+		LastReportedAt       time.Time `json:"lastReportedAt"`
+	} `json:"data"`
+}
+
+// cache is the content of the cache file; the parameters tell whether the list still matches the config
+type cache struct {
+	FetchedAt         time.Time       `json:"fetched_at"`
+	ConfidenceMinimum int             `json:"confidence_minimum"`
+	Limit             int             `json:"limit"`
+	Response          json.RawMessage `json:"response"`
+}
+
+// rateLimitError is a fetch turned down until retryAt
+type rateLimitError struct {
+	retryAt time.Time
+}
+
+func (e rateLimitError) Error() string {
+	return fmt.Sprintf("rate limited until %s", e.retryAt.Format(time.RFC3339))
+}
+
+// Provider fetches the blacklist every refresh interval, starting from the cached one
+type Provider struct {
+	conf       Config
+	httpClient *http.Client
+	ListChan   chan List
+
+	mu      sync.Mutex
+	current List
+}
+
+func New(conf Config) (*Provider, error) {
+	if conf.APIKey == "" {
+		return nil, errors.New("no AbuseIPDB API key configured")
+	}
+
+	if conf.URL == "" {
+		conf.URL = DefaultURL
+	}
+
+	if conf.ConfidenceMinimum <= 0 {
+		conf.ConfidenceMinimum = DefaultConfidenceMinimum
+	}
+
+	if conf.Limit <= 0 {
+		conf.Limit = DefaultLimit
+	}
+
+	if conf.RefreshInterval <= 0 {
+		conf.RefreshInterval = DefaultRefreshInterval
+	}
+
+	return &Provider{
+		conf:       conf,
+		httpClient: &http.Client{Timeout: requestLimit},
+		ListChan:   make(chan List),
+	}, nil
+}
+
+// Lookup returns the entry of the most recently sent list for prefix, for attributing a block to its confidence score
+func (p *Provider) Lookup(prefix netip.Prefix) (Entry, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.current.Entries[prefix]
+
+	return entry, ok
+}
+
+// Run sends the cached list to ListChan on start, then a fetched one every RefreshInterval until ctx is cancelled
+// A cached list younger than RefreshInterval is not fetched again; a failed fetch keeps the previously sent list in place
+// and is retried, after as long as upstream asks for when it is rate limited
+func (p *Provider) Run(ctx context.Context) {
+	defer close(p.ListChan)
+
+	next := time.Now()
+	if list, fetchedAt, fresh, err := p.loadCache(); err != nil {
+		slog.Warn("unable to load cached AbuseIPDB blacklist", "path", p.conf.CacheFile, "details", err)
+	} else if list.Entries != nil {
+		slog.Info("loaded cached AbuseIPDB blacklist", "entries", len(list.Entries), "fetched_at", fetchedAt)
+		p.send(ctx, list)
+
+		if fresh {
+			next = fetchedAt.Add(p.conf.RefreshInterval)
+		}
+	}
+
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		list, retryAt, err := p.fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			var rateLimited rateLimitError
+			if errors.As(err, &rateLimited) {
+				slog.Warn("AbuseIPDB blacklist request rate limited, keeping the current list", "retry_at", rateLimited.retryAt)
+				timer.Reset(time.Until(rateLimited.retryAt))
+			} else {
+				slog.Warn("unable to fetch AbuseIPDB blacklist, keeping the current list", "details", err)
+				timer.Reset(min(retryDelay, p.conf.RefreshInterval))
+			}
+
+			continue
+		}
+
+		slog.Info("fetched AbuseIPDB blacklist", "entries", len(list.Entries), "generated_at", list.Generated)
+		p.send(ctx, list)
+
+		// a quota used up by this request is not spent again before it resets
+		timer.Reset(max(p.conf.RefreshInterval, time.Until(retryAt)))
+	}
+}
+
+func (p *Provider) send(ctx context.Context, list List) {
+	p.mu.Lock()
+	p.current = list
+	p.mu.Unlock()
+
+	select {
+	case p.ListChan <- list:
+	case <-ctx.Done():
+	}
+}
+
+// fetch requests the blacklist and caches it; retryAt is set once the quota is used up
+func (p *Provider) fetch(ctx context.Context) (List, time.Time, error) {
+	query := url.Values{}
+	query.Set("confidenceMinimum", strconv.Itoa(p.conf.ConfidenceMinimum))
+	query.Set("limit", strconv.Itoa(p.conf.Limit))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.conf.URL+"?"+query.Encode(), nil)
+	if err != nil {
+		return List{}, time.Time{}, err
+	}
+
+	req.Header.Set("Key", p.conf.APIKey)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return List{}, time.Time{}, err
+	}
+
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			slog.Error("unable to close HTTP response body", "details", err)
+		}
+	}()
+
+	now := time.Now()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		// a missing, or past, time to retry at is waited for like any other failure rather than retried right away
+		retryAt := retryAfter(resp.Header, now)
+		if retryAt.Before(now.Add(retryDelay)) {
+			retryAt = now.Add(retryDelay)
+		}
+
+		return List{}, time.Time{}, rateLimitError{retryAt: retryAt}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return List{}, time.Time{}, fmt.Errorf("unexpected response %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return List{}, time.Time{}, err
+	}
+
+	list, err := parseResponse(body)
+	if err != nil {
+		return List{}, time.Time{}, err
+	}
+
+	err = p.writeCache(cache{
+		FetchedAt:         now,
+		ConfidenceMinimum: p.conf.ConfidenceMinimum,
+		Limit:             p.conf.Limit,
+		Response:          body,
+	})
+	if err != nil {
+		slog.Warn("unable to cache AbuseIPDB blacklist", "path", p.conf.CacheFile, "details", err)
+	}
+
+	var retryAt time.Time
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		retryAt = retryAfter(resp.Header, now)
+	}
+
+	return list, retryAt, nil
+}
+
+// retryAfter reads when requests are allowed again from Retry-After, in seconds or as a date, or from X-RateLimit-Reset;
+// it is zero if neither is there
+func retryAfter(header http.Header, now time.Time) time.Time {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return now.Add(time.Duration(seconds) * time.Second)
+		}
+
+		if date, err := http.ParseTime(value); err == nil {
+			return date
+		}
+	}
+
+	if value := header.Get("X-RateLimit-Reset"); value != "" {
+		if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Unix(epoch, 0)
+		}
+	}
+
+	return time.Time{}
+}
+
+func parseResponse(body []byte) (List, error) {
+	var resp response
+	err := json.Unmarshal(body, &resp)
+	if err != nil {
+		return List{}, fmt.Errorf("unable to decode the blacklist: %w", err)
+	}
+
+	list := List{Entries: make(map[netip.Prefix]Entry, len(resp.Data)), Generated: resp.Meta.GeneratedAt}
+	for _, data := range resp.Data {
+		addr, err := netip.ParseAddr(data.IPAddress)
+		if err != nil {
+			slog.Warn("skipping invalid AbuseIPDB blacklist entry", "ip", data.IPAddress)
+			continue
+		}
+
+		addr = addr.Unmap()
+		prefix := netip.PrefixFrom(addr, addr.BitLen())
+		list.Entries[prefix] = Entry{
+			Prefix:       prefix,
+			Confidence:   data.AbuseConfidenceScore,
+			CountryCode:  data.CountryCode,
+			LastReported: data.LastReportedAt,
+		}
+	}
+
+	return list, nil
+}
@@ -0,0 +1,209 @@
+package abuseipdb
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testKey is the API key the stand-in upstream accepts
+const testKey = "0123456789abcdef"
+
+const testResponse = `{
+  "meta": {"generatedAt": "2024-01-01T12:00:00+00:00"},
+  "data": [
+    {"ipAddress": "192.0.2.1", "countryCode": "XX", "abuseConfidenceScore": 100, "lastReportedAt": "2024-01-01T11:00:00+00:00"},
+    {"ipAddress": "::ffff:198.51.100.7", "countryCode": "XX", "abuseConfidenceScore": 100, "lastReportedAt": "2024-01-01T11:00:00+00:00"},
+    {"ipAddress": "not an IP", "countryCode": "XX", "abuseConfidenceScore": 100, "lastReportedAt": "2024-01-01T11:00:00+00:00"}
+  ]
+}`
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Time
+	}{
+		{name: "seconds", header: http.Header{"Retry-After": {"120"}}, want: now.Add(2 * time.Minute)},
+		{name: "date", header: http.Header{"Retry-After": {"Mon, 01 Jan 2024 13:00:00 GMT"}}, want: now.Add(time.Hour)},
+		{name: "reset", header: http.Header{"X-Ratelimit-Reset": {"1704114000"}}, want: now.Add(time.Hour)},
+		{name: "retry after first", header: http.Header{"Retry-After": {"60"}, "X-Ratelimit-Reset": {"1704114000"}}, want: now.Add(time.Minute)},
+		{name: "invalid retry after", header: http.Header{"Retry-After": {"soon"}, "X-Ratelimit-Reset": {"1704114000"}}, want: now.Add(time.Hour)},
+		{name: "neither", header: http.Header{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryAfter(tt.header, now); !got.Equal(tt.want) {
+				t.Errorf("retryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newProvider creates a provider of conf authenticating with the key upstream accepts
+func newProvider(t *testing.T, conf Config) *Provider {
+	t.Helper()
+
+	conf.APIKey = testKey
+	p, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+// upstream is a stand-in blacklist endpoint answering with status and testResponse
+func upstream(t *testing.T, status int, header http.Header) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Key") != testKey || r.URL.Query().Get("confidenceMinimum") == "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		for key, values := range header {
+			w.Header()[key] = values
+		}
+
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			_, _ = w.Write([]byte(testResponse))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestFetchAndCache(t *testing.T) {
+	server := upstream(t, http.StatusOK, http.Header{"X-Ratelimit-Remaining": {"0"}, "Retry-After": {"3600"}})
+	cacheFile := filepath.Join(t.TempDir(), "abuseipdb.json")
+	p := newProvider(t, Config{URL: server.URL, CacheFile: cacheFile, RefreshInterval: time.Hour})
+
+	list, retryAt, err := p.fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Entries) != 2 {
+		t.Fatalf("fetched %d entries, want 2", len(list.Entries))
+	}
+
+	// mapped addresses are blocked as the IPv4 addresses they are
+	if _, ok := list.Entries[netip.MustParsePrefix("198.51.100.7/32")]; !ok {
+		t.Errorf("fetched %v, want 198.51.100.7/32 among them", list.Prefixes())
+	}
+
+	if time.Until(retryAt) < 59*time.Minute {
+		t.Errorf("the last request of the quota retries at %v, want about an hour from now", retryAt)
+	}
+
+	cached, fetchedAt, fresh, err := p.loadCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !fresh || len(cached.Entries) != 2 || time.Since(fetchedAt) > time.Minute {
+		t.Fatalf("cache holds %d entries fetched at %v, fresh %t; want the 2 just fetched", len(cached.Entries), fetchedAt, fresh)
+	}
+}
+
+func TestFetchRateLimited(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{name: "retry after", header: http.Header{"Retry-After": {"600"}}, want: 10 * time.Minute},
+		{name: "sooner than the retry delay", header: http.Header{"Retry-After": {"1"}}, want: retryDelay},
+		{name: "past date", header: http.Header{"Retry-After": {"Mon, 01 Jan 2024 13:00:00 GMT"}}, want: retryDelay},
+		{name: "neither", header: http.Header{}, want: retryDelay},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := upstream(t, http.StatusTooManyRequests, tt.header)
+			p := newProvider(t, Config{URL: server.URL})
+
+			_, _, err := p.fetch(context.Background())
+
+			rateLimited, ok := err.(rateLimitError)
+			if !ok {
+				t.Fatalf("fetch() error = %v, want a rate limit error", err)
+			}
+
+			if wait := time.Until(rateLimited.retryAt); wait < tt.want-time.Minute || wait > tt.want {
+				t.Errorf("retrying in %v, want %v", wait, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheFreshness(t *testing.T) {
+	tests := []struct {
+		name      string
+		age       time.Duration
+		modify    func(*Config)
+		wantFresh bool
+	}{
+		{name: "young", age: time.Minute, wantFresh: true},
+		{name: "older than the refresh interval", age: 2 * time.Hour},
+		{name: "other confidence minimum", age: time.Minute, modify: func(c *Config) { c.ConfidenceMinimum = 90 }},
+		{name: "other limit", age: time.Minute, modify: func(c *Config) { c.Limit = 500 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Config{CacheFile: filepath.Join(t.TempDir(), "abuseipdb.json"), RefreshInterval: time.Hour}
+			writer := newProvider(t, conf)
+
+			err := writer.writeCache(cache{
+				FetchedAt:         time.Now().Add(-tt.age),
+				ConfidenceMinimum: writer.conf.ConfidenceMinimum,
+				Limit:             writer.conf.Limit,
+				Response:          []byte(testResponse),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.modify != nil {
+				tt.modify(&conf)
+			}
+
+			list, _, fresh, err := newProvider(t, conf).loadCache()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// a stale cache is still loaded, to block something until the fetch is through
+			if fresh != tt.wantFresh || len(list.Entries) != 2 {
+				t.Errorf("loadCache() = %d entries, fresh %t; want 2 entries, fresh %t", len(list.Entries), fresh, tt.wantFresh)
+			}
+		})
+	}
+}
+
+func TestReadOnlyCache(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "abuseipdb.json")
+	p := newProvider(t, Config{CacheFile: cacheFile, ReadOnlyCache: true})
+
+	err := p.writeCache(cache{FetchedAt: time.Now(), Response: []byte(testResponse)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, _, _, err := p.loadCache()
+	if err != nil || list.Entries != nil {
+		t.Fatalf("loadCache() = %d entries, %v; want no cache written", len(list.Entries), err)
+	}
+}
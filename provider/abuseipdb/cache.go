@@ -0,0 +1,68 @@
+package abuseipdb
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// loadCache reads the cached list; fresh is false if it is older than the refresh interval or was fetched with other parameters
+// It returns an empty list without error if there is no cache
+func (p *Provider) loadCache() (List, time.Time, bool, error) {
+	if p.conf.CacheFile == "" {
+		return List{}, time.Time{}, false, nil
+	}
+
+	cacheB, err := os.ReadFile(p.conf.CacheFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return List{}, time.Time{}, false, nil
+	}
+
+	if err != nil {
+		return List{}, time.Time{}, false, err
+	}
+
+	var cached cache
+	err = json.Unmarshal(cacheB, &cached)
+	if err != nil {
+		return List{}, time.Time{}, false, err
+	}
+
+	list, err := parseResponse(cached.Response)
+	if err != nil {
+		return List{}, time.Time{}, false, err
+	}
+
+	fresh := cached.ConfidenceMinimum == p.conf.ConfidenceMinimum && cached.Limit == p.conf.Limit &&
+		time.Since(cached.FetchedAt) < p.conf.RefreshInterval
+
+	return list, cached.FetchedAt, fresh, nil
+}
+
+// writeCache replaces the cache file at once, so that a crash cannot leave half of it behind
+func (p *Provider) writeCache(cached cache) error {
+	if p.conf.CacheFile == "" || p.conf.ReadOnlyCache {
+		return nil
+	}
+
+	cacheB, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(p.conf.CacheFile), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp := p.conf.CacheFile + ".tmp"
+	err = os.WriteFile(tmp, cacheB, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, p.conf.CacheFile)
+}
@@ -0,0 +1,204 @@
+package asn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MatejLach/dynafire/provider/filewatch"
+)
+
+const DefaultRefreshInterval = 24 * time.Hour
+
+// Config selects the autonomous systems to block and where their prefixes are resolved from,
+// either a MaxMind format ASN database (i.e. GeoLite2-ASN) or a prefix to AS dump (i.e. CAIDA pfx2as)
+type Config struct {
+	ASNs []uint32
+	// Database is a MaxMind format ASN database, it takes precedence over PrefixFile
+	Database string
+	// PrefixFile has a prefix and its origin AS per line, either "192.0.2.0 24 64500" (pfx2as) or "192.0.2.0/24 64500"
+	PrefixFile string
+	// RefreshInterval is how often the source file is checked for changes and reloaded
+	RefreshInterval time.Duration
+}
+
+// List is every prefix originated by one of the selected autonomous systems
+type List struct {
+	Prefixes []netip.Prefix
+	// Origins maps every prefix to the selected autonomous systems announcing it
+	Origins map[netip.Prefix][]uint32
+	// Names holds the organization names of the selected autonomous systems, if the source has them
+	Names map[uint32]string
+	// Version identifies the data the list was resolved from, the database build time or the modification time of the prefix file
+	Version time.Time
+}
+
+// Match is a blocked prefix containing an address, along with the autonomous systems it is blocked for
+type Match struct {
+	Prefix netip.Prefix
+	ASN    uint32
+	Name   string
+}
+
+// Provider resolves the prefixes of the selected autonomous systems and reloads them on a schedule
+type Provider struct {
+	conf     Config
+	asns     chan []uint32
+	ListChan chan List
+
+	mu      sync.Mutex
+	current List
+}
+
+func New(conf Config) (*Provider, error) {
+	if conf.Database == "" && conf.PrefixFile == "" {
+		return nil, errors.New("neither an ASN database nor a prefix file is configured")
+	}
+
+	if conf.RefreshInterval <= 0 {
+		conf.RefreshInterval = DefaultRefreshInterval
+	}
+
+	return &Provider{
+		conf:     conf,
+		asns:     make(chan []uint32, 1),
+		ListChan: make(chan List),
+	}, nil
+}
+
+// SetASNs replaces the selected autonomous systems, the prefixes are reloaded right away
+// It is safe to call from any goroutine
+func (p *Provider) SetASNs(asns []uint32) {
+	select {
+	case <-p.asns:
+	default:
+	}
+
+	p.asns <- asns
+}
+
+// Lookup returns the prefixes of the most recently sent list that contain addr, for attributing a block to an AS
+func (p *Provider) Lookup(addr netip.Addr) []Match {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	addr = addr.Unmap()
+	result := make([]Match, 0)
+	for prefix, origins := range p.current.Origins {
+		if !prefix.Contains(addr) {
+			continue
+		}
+
+		for _, asn := range origins {
+			result = append(result, Match{Prefix: prefix, ASN: asn, Name: p.current.Names[asn]})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Prefix.Bits() != result[j].Prefix.Bits() {
+			return result[i].Prefix.Bits() > result[j].Prefix.Bits()
+		}
+
+		return result[i].ASN < result[j].ASN
+	})
+
+	return result
+}
+
+// Run sends the prefixes of the selected autonomous systems to ListChan on start and whenever the source file
+// or the selection changed, checking the file every RefreshInterval, until ctx is cancelled
+// A source that cannot be read keeps the previously sent prefixes in place
+func (p *Provider) Run(ctx context.Context) {
+	defer close(p.ListChan)
+
+	ticker := time.NewTicker(p.conf.RefreshInterval)
+	defer ticker.Stop()
+
+	poller := filewatch.NewPoller(p.path())
+	asns := p.conf.ASNs
+
+	for {
+		changed, err := poller.Changed()
+		if err != nil {
+			slog.Warn("unable to check ASN prefix source for changes", "path", p.path(), "details", err)
+		}
+
+		if changed {
+			slog.Info("loading ASN prefixes", "path", p.path(), "asns", asns)
+			p.send(ctx, asns)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case asns = <-p.asns:
+			slog.Info("blocked autonomous systems changed", "asns", asns)
+			p.send(ctx, asns)
+		}
+	}
+}
+
+func (p *Provider) path() string {
+	if p.conf.Database != "" {
+		return p.conf.Database
+	}
+
+	return p.conf.PrefixFile
+}
+
+func (p *Provider) send(ctx context.Context, asns []uint32) {
+	var list List
+	var err error
+	if p.conf.Database != "" {
+		list, err = LoadDatabase(p.conf.Database, asns)
+	} else {
+		list, err = LoadPrefixFile(p.conf.PrefixFile, asns)
+	}
+
+	if err != nil {
+		slog.Error("unable to load ASN prefixes, keeping the previous ones", "path", p.path(), "details", err)
+		return
+	}
+
+	p.mu.Lock()
+	p.current = list
+	p.mu.Unlock()
+
+	select {
+	case p.ListChan <- list:
+	case <-ctx.Done():
+	}
+}
+
+// ParseASN accepts autonomous system numbers with or without the AS prefix, i.e. "AS64500" or "64500"
+func ParseASN(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	if len(s) > 2 && strings.EqualFold(s[:2], "AS") {
+		s = s[2:]
+	}
+
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid autonomous system number %q", s)
+	}
+
+	return uint32(n), nil
+}
+
+// newList collects the prefixes of origins into a List
+func newList(origins map[netip.Prefix][]uint32, names map[uint32]string, version time.Time) List {
+	prefixes := make([]netip.Prefix, 0, len(origins))
+	for prefix := range origins {
+		prefixes = append(prefixes, prefix)
+	}
+
+	return List{Prefixes: prefixes, Origins: origins, Names: names, Version: version}
+}
@@ -0,0 +1,168 @@
+package asn
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/MatejLach/dynafire/provider/geoip"
+)
+
+type asnRecord struct {
+	Number       uint32 `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// LoadDatabase returns every network the MaxMind format ASN database at path attributes to one of asns
+func LoadDatabase(path string, asns []uint32) (List, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return List{}, err
+	}
+
+	defer func() {
+		err := reader.Close()
+		if err != nil {
+			slog.Error("unable to close ASN database", "path", path, "details", err)
+		}
+	}()
+
+	wanted := asnSet(asns)
+	origins := make(map[netip.Prefix][]uint32)
+	names := make(map[uint32]string)
+
+	networks := reader.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		var record asnRecord
+		network, err := networks.Network(&record)
+		if err != nil {
+			return List{}, fmt.Errorf("unable to decode ASN database: %w", err)
+		}
+
+		if !wanted[record.Number] {
+			continue
+		}
+
+		prefix, ok := geoip.PrefixFromIPNet(network)
+		if !ok {
+			continue
+		}
+
+		origins[prefix] = append(origins[prefix], record.Number)
+		names[record.Number] = record.Organization
+	}
+
+	if networks.Err() != nil {
+		return List{}, fmt.Errorf("unable to read ASN database: %w", networks.Err())
+	}
+
+	return newList(origins, names, time.Unix(int64(reader.Metadata.BuildEpoch), 0)), nil
+}
+
+// LoadPrefixFile returns every prefix the dump at path attributes to one of asns
+// Lines are either "192.0.2.0 24 64500" as in CAIDA's pfx2as, or "192.0.2.0/24 64500";
+// multi-origin prefixes ("64500_64501") and AS sets ("64500,64501") count for each of their ASNs
+// Empty lines and lines starting with # are skipped
+func LoadPrefixFile(path string, asns []uint32) (List, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return List{}, err
+	}
+
+	defer func() {
+		err := file.Close()
+		if err != nil {
+			slog.Error("unable to close ASN prefix file", "path", path, "details", err)
+		}
+	}()
+
+	info, err := file.Stat()
+	if err != nil {
+		return List{}, err
+	}
+
+	wanted := asnSet(asns)
+	origins := make(map[netip.Prefix][]uint32)
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		prefix, lineASNs, err := parsePrefixLine(text)
+		if err != nil {
+			return List{}, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+
+		for _, asn := range lineASNs {
+			if wanted[asn] {
+				origins[prefix] = append(origins[prefix], asn)
+			}
+		}
+	}
+
+	if scanner.Err() != nil {
+		return List{}, scanner.Err()
+	}
+
+	return newList(origins, make(map[uint32]string), info.ModTime()), nil
+}
+
+func parsePrefixLine(text string) (netip.Prefix, []uint32, error) {
+	fields := strings.Fields(text)
+
+	var prefix netip.Prefix
+	var asnField string
+	var err error
+	switch len(fields) {
+	case 2:
+		prefix, err = netip.ParsePrefix(fields[0])
+		asnField = fields[1]
+	case 3:
+		var bits int
+		bits, err = strconv.Atoi(fields[1])
+		if err == nil {
+			prefix, err = netip.ParsePrefix(fields[0] + "/" + strconv.Itoa(bits))
+		}
+		asnField = fields[2]
+	default:
+		return netip.Prefix{}, nil, fmt.Errorf("expected a prefix and its origin AS, got %q", text)
+	}
+
+	if err != nil {
+		return netip.Prefix{}, nil, err
+	}
+
+	asns := make([]uint32, 0, 1)
+	for _, part := range strings.FieldsFunc(asnField, func(r rune) bool { return r == '_' || r == ',' }) {
+		asn, err := ParseASN(part)
+		if err != nil {
+			return netip.Prefix{}, nil, err
+		}
+
+		asns = append(asns, asn)
+	}
+
+	return prefix.Masked(), asns, nil
+}
+
+func asnSet(asns []uint32) map[uint32]bool {
+	result := make(map[uint32]bool, len(asns))
+	for _, asn := range asns {
+		result[asn] = true
+	}
+
+	return result
+}
@@ -0,0 +1,69 @@
+package asn
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParsePrefixLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		prefix  netip.Prefix
+		asns    []uint32
+		wantErr bool
+	}{
+		{name: "pfx2as", line: "192.0.2.0\t24\t64500", prefix: netip.MustParsePrefix("192.0.2.0/24"), asns: []uint32{64500}},
+		{name: "slash notation", line: "2001:db8::/32 AS64501", prefix: netip.MustParsePrefix("2001:db8::/32"), asns: []uint32{64501}},
+		{name: "host bits masked", line: "192.0.2.7/24 64500", prefix: netip.MustParsePrefix("192.0.2.0/24"), asns: []uint32{64500}},
+		{name: "multi-origin", line: "198.51.100.0 24 64500_64501", prefix: netip.MustParsePrefix("198.51.100.0/24"), asns: []uint32{64500, 64501}},
+		{name: "AS set", line: "198.51.100.0 24 64500,64502", prefix: netip.MustParsePrefix("198.51.100.0/24"), asns: []uint32{64500, 64502}},
+		{name: "missing AS", line: "192.0.2.0/24", wantErr: true},
+		{name: "too many fields", line: "192.0.2.0 24 64500 extra", wantErr: true},
+		{name: "invalid length", line: "192.0.2.0 x 64500", wantErr: true},
+		{name: "length out of range", line: "192.0.2.0 33 64500", wantErr: true},
+		{name: "invalid prefix", line: "192.0.2/24 64500", wantErr: true},
+		{name: "invalid AS", line: "192.0.2.0/24 ASX", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, asns, err := parsePrefixLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePrefixLine() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if prefix != tt.prefix || !slices.Equal(asns, tt.asns) {
+				t.Errorf("parsePrefixLine() = %v, %v; want %v, %v", prefix, asns, tt.prefix, tt.asns)
+			}
+		})
+	}
+}
+
+func TestLoadPrefixFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pfx2as")
+	content := "# prefix length origin\n\n192.0.2.0\t24\t64500\n198.51.100.0\t24\t64501_64500\n203.0.113.0\t24\t64502\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := LoadPrefixFile(path, []uint32{64500})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Prefixes) != 2 || !slices.Equal(list.Origins[netip.MustParsePrefix("198.51.100.0/24")], []uint32{64500}) {
+		t.Fatalf("LoadPrefixFile() = %v with origins %v, want 192.0.2.0/24 and 198.51.100.0/24 of AS64500", list.Prefixes, list.Origins)
+	}
+
+	if err := os.WriteFile(path, []byte("192.0.2.0/24 64500\nbroken\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadPrefixFile(path, []uint32{64500}); err == nil || err.Error() != path+`:2: expected a prefix and its origin AS, got "broken"` {
+		t.Fatalf("LoadPrefixFile() error = %v, want it to name line 2", err)
+	}
+}
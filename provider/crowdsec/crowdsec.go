@@ -0,0 +1,315 @@
+package crowdsec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPollInterval = 10 * time.Second
+	// userAgent identifies the bouncer in the logs of the Local API
+	userAgent    = "dynafire-bouncer"
+	requestLimit = 15 * time.Second
+	// decisionBan is the only decision type a firewall can enforce, others such as captcha are left to other bouncers
+	decisionBan = "ban"
+)
+
+// Config points the bouncer at a CrowdSec Local API
+type Config struct {
+	// URL is the base URL of the Local API, i.e. "http://127.0.0.1:8080"
+	URL string
+	// APIKey is the key of the bouncer, as created by cscli bouncers add
+	APIKey       string
+	PollInterval time.Duration
+	// Scopes select the decisions by scope, i.e. "ip" and "range"; empty takes both
+	Scopes []string
+	// Origins select the decisions by origin, i.e. "crowdsec", "cscli" or "CAPI"; empty takes all of them
+	Origins []string
+}
+
+// decision is a decision as the Local API reports it
+type decision struct {
+	ID       int64  `json:"id"`
+	Origin   string `json:"origin"`
+	Type     string `json:"type"`
+	Scope    string `json:"scope"`
+	Value    string `json:"value"`
+	Duration string `json:"duration"`
+	Scenario string `json:"scenario"`
+}
+
+type streamResponse struct {
+	New     []decision `json:"new"`
+	Deleted []decision `json:"deleted"`
+}
+
+// ban is an enforced decision
+type ban struct {
+	prefix  netip.Prefix
+	expires time.Time
+}
+
+// Update is a change of the banned prefixes; the first one after a (re)start of the stream lists every banned prefix in Added
+type Update struct {
+	Startup bool
+	Added   []netip.Prefix
+	Removed []netip.Prefix
+}
+
+// Provider polls the decision stream of the Local API as a bouncer
+// A prefix is banned while at least one ban decision on it is in effect, decisions expire on their own
+// even if the Local API is not reachable to report them deleted
+type Provider struct {
+	conf       Config
+	httpClient *http.Client
+
+	bans map[int64]ban
+	// banned counts the decisions on every banned prefix
+	banned map[netip.Prefix]int
+	// UpdateChan receives the changes of the banned prefixes; it is closed when Run returns
+	UpdateChan chan Update
+}
+
+func New(conf Config) (*Provider, error) {
+	if conf.URL == "" {
+		return nil, errors.New("no CrowdSec Local API URL configured")
+	}
+
+	if conf.APIKey == "" {
+		return nil, errors.New("no CrowdSec bouncer API key configured")
+	}
+
+	if conf.PollInterval <= 0 {
+		conf.PollInterval = DefaultPollInterval
+	}
+
+	return &Provider{
+		conf:       conf,
+		httpClient: &http.Client{Timeout: requestLimit},
+		bans:       make(map[int64]ban),
+		banned:     make(map[netip.Prefix]int),
+		UpdateChan: make(chan Update),
+	}, nil
+}
+
+// Run polls the decision stream every poll interval until ctx is cancelled
+func (p *Provider) Run(ctx context.Context) {
+	defer close(p.UpdateChan)
+
+	ticker := time.NewTicker(p.conf.PollInterval)
+	defer ticker.Stop()
+
+	startup := true
+	for {
+		update, err := p.poll(ctx, startup)
+		if err != nil {
+			slog.Warn("unable to poll CrowdSec decisions, keeping the current ones", "details", err)
+		} else {
+			startup = false
+		}
+
+		update.Removed = append(update.Removed, p.expire(time.Now())...)
+		if update.Startup || len(update.Added) > 0 || len(update.Removed) > 0 {
+			select {
+			case p.UpdateChan <- update:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll fetches the decision stream; startup asks for every decision in effect rather than the changes since the last poll
+func (p *Provider) poll(ctx context.Context, startup bool) (Update, error) {
+	query := url.Values{}
+	query.Set("startup", fmt.Sprint(startup))
+	if len(p.conf.Scopes) > 0 {
+		query.Set("scopes", strings.Join(p.conf.Scopes, ","))
+	}
+
+	if len(p.conf.Origins) > 0 {
+		query.Set("origins", strings.Join(p.conf.Origins, ","))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(p.conf.URL, "/")+"/v1/decisions/stream?"+query.Encode(), nil)
+	if err != nil {
+		return Update{}, err
+	}
+
+	req.Header.Set("X-Api-Key", p.conf.APIKey)
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return Update{}, err
+	}
+
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			slog.Error("unable to close HTTP response body", "details", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return Update{}, fmt.Errorf("unexpected Local API response %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var stream streamResponse
+	err = json.NewDecoder(resp.Body).Decode(&stream)
+	if err != nil {
+		return Update{}, fmt.Errorf("unable to decode the decision stream: %w", err)
+	}
+
+	if startup {
+		return p.replace(stream.New), nil
+	}
+
+	return p.apply(stream), nil
+}
+
+// replace starts over with the decisions in effect
+func (p *Provider) replace(decisions []decision) Update {
+	p.bans = make(map[int64]ban)
+	p.banned = make(map[netip.Prefix]int)
+
+	p.apply(streamResponse{New: decisions})
+
+	update := Update{Startup: true, Added: make([]netip.Prefix, 0, len(p.banned))}
+	for prefix := range p.banned {
+		update.Added = append(update.Added, prefix)
+	}
+
+	slog.Info("received CrowdSec decisions", "bans", len(p.bans), "prefixes", len(p.banned))
+
+	return update
+}
+
+// apply applies the deletions before the new decisions, a decision that was extended comes back as deleted and new under another ID
+func (p *Provider) apply(stream streamResponse) Update {
+	update := Update{}
+
+	for _, d := range stream.Deleted {
+		if prefix, ok := p.remove(d.ID); ok {
+			update.Removed = append(update.Removed, prefix)
+		}
+	}
+
+	now := time.Now()
+	for _, d := range stream.New {
+		if !strings.EqualFold(d.Type, decisionBan) {
+			continue
+		}
+
+		prefix, err := parseValue(d.Scope, d.Value)
+		if err != nil {
+			slog.Warn("skipping CrowdSec decision", "id", d.ID, "scope", d.Scope, "value", d.Value, "details", err)
+			continue
+		}
+
+		duration, err := time.ParseDuration(d.Duration)
+		if err != nil {
+			slog.Warn("skipping CrowdSec decision with invalid duration", "id", d.ID, "duration", d.Duration)
+			continue
+		}
+
+		if duration <= 0 {
+			continue
+		}
+
+		if _, known := p.bans[d.ID]; known {
+			continue
+		}
+
+		p.bans[d.ID] = ban{prefix: prefix, expires: now.Add(duration)}
+		p.banned[prefix]++
+		if p.banned[prefix] == 1 {
+			slog.Debug("CrowdSec ban", "prefix", prefix, "origin", d.Origin, "scenario", d.Scenario, "duration", duration)
+			update.Added = append(update.Added, prefix)
+		}
+	}
+
+	// a prefix that was deleted and banned again in the same poll stays banned
+	removed := update.Removed[:0]
+	for _, prefix := range update.Removed {
+		if p.banned[prefix] == 0 {
+			removed = append(removed, prefix)
+		}
+	}
+	update.Removed = removed
+
+	return update
+}
+
+// remove forgets a decision, reporting its prefix if no other decision bans it
+func (p *Provider) remove(id int64) (netip.Prefix, bool) {
+	b, ok := p.bans[id]
+	if !ok {
+		return netip.Prefix{}, false
+	}
+
+	delete(p.bans, id)
+	p.banned[b.prefix]--
+	if p.banned[b.prefix] > 0 {
+		return netip.Prefix{}, false
+	}
+
+	delete(p.banned, b.prefix)
+
+	return b.prefix, true
+}
+
+func (p *Provider) expire(now time.Time) []netip.Prefix {
+	removed := make([]netip.Prefix, 0)
+	for id, b := range p.bans {
+		if now.Before(b.expires) {
+			continue
+		}
+
+		if prefix, ok := p.remove(id); ok {
+			removed = append(removed, prefix)
+		}
+	}
+
+	return removed
+}
+
+// parseValue parses the value of a decision of the Ip or Range scope
+func parseValue(scope, value string) (netip.Prefix, error) {
+	switch strings.ToLower(scope) {
+	case "ip":
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+
+		addr = addr.Unmap()
+
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	case "range":
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+
+		return prefix.Masked(), nil
+	default:
+		return netip.Prefix{}, fmt.Errorf("unsupported scope %q", scope)
+	}
+}
@@ -0,0 +1,166 @@
+package crowdsec
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func ipBan(id int64, value, duration string) decision {
+	return decision{ID: id, Origin: "crowdsec", Type: "ban", Scope: "Ip", Value: value, Duration: duration, Scenario: "ssh-bf"}
+}
+
+func sortedPrefixes(prefixes []netip.Prefix) []string {
+	result := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		result = append(result, prefix.String())
+	}
+	slices.Sort(result)
+
+	return result
+}
+
+func TestApply(t *testing.T) {
+	p, err := New(Config{URL: "http://127.0.0.1", APIKey: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update := p.apply(streamResponse{New: []decision{
+		ipBan(1, "192.0.2.1", "4h"),
+		ipBan(2, "192.0.2.1", "1h"),
+		{ID: 3, Type: "ban", Scope: "Range", Value: "198.51.100.7/24", Duration: "1h"},
+		{ID: 4, Type: "captcha", Scope: "Ip", Value: "192.0.2.2", Duration: "1h"},
+		{ID: 5, Type: "ban", Scope: "Country", Value: "XX", Duration: "1h"},
+		ipBan(6, "192.0.2.3", "-1s"),
+		ipBan(7, "not an IP", "1h"),
+	}})
+
+	if got, want := sortedPrefixes(update.Added), []string{"192.0.2.1/32", "198.51.100.0/24"}; !slices.Equal(got, want) {
+		t.Fatalf("added %v, want %v", got, want)
+	}
+
+	// the prefix stays banned while another decision bans it
+	update = p.apply(streamResponse{Deleted: []decision{ipBan(1, "192.0.2.1", "4h")}})
+	if len(update.Added)+len(update.Removed) != 0 {
+		t.Fatalf("deleting one of two decisions changed %+v", update)
+	}
+
+	update = p.apply(streamResponse{Deleted: []decision{ipBan(2, "192.0.2.1", "1h")}})
+	if got, want := sortedPrefixes(update.Removed), []string{"192.0.2.1/32"}; !slices.Equal(got, want) {
+		t.Fatalf("removed %v, want %v", got, want)
+	}
+
+	// an extended decision comes back as deleted and new in the same poll, the prefix is not lifted in between
+	update = p.apply(streamResponse{
+		Deleted: []decision{{ID: 3, Type: "ban", Scope: "Range", Value: "198.51.100.0/24", Duration: "1h"}},
+		New:     []decision{{ID: 8, Type: "ban", Scope: "Range", Value: "198.51.100.0/24", Duration: "2h"}},
+	})
+	if len(update.Removed) != 0 {
+		t.Fatalf("extending a decision lifted %v", update.Removed)
+	}
+
+	// a decision reported again is not counted twice
+	p.apply(streamResponse{New: []decision{{ID: 8, Type: "ban", Scope: "Range", Value: "198.51.100.0/24", Duration: "2h"}}})
+	update = p.apply(streamResponse{Deleted: []decision{{ID: 8}}})
+	if got, want := sortedPrefixes(update.Removed), []string{"198.51.100.0/24"}; !slices.Equal(got, want) {
+		t.Fatalf("removed %v, want %v", got, want)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	p, err := New(Config{URL: "http://127.0.0.1", APIKey: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.apply(streamResponse{New: []decision{
+		ipBan(1, "192.0.2.1", "1h"),
+		ipBan(2, "192.0.2.1", "3h"),
+		ipBan(3, "192.0.2.2", "2h"),
+	}})
+
+	now := time.Now()
+	if removed := p.expire(now); len(removed) != 0 {
+		t.Fatalf("expired %v before any decision ran out", removed)
+	}
+
+	if removed := p.expire(now.Add(90 * time.Minute)); len(removed) != 0 {
+		t.Fatalf("expired %v while another decision still bans it", removed)
+	}
+
+	if got, want := sortedPrefixes(p.expire(now.Add(150*time.Minute))), []string{"192.0.2.2/32"}; !slices.Equal(got, want) {
+		t.Fatalf("expired %v, want %v", got, want)
+	}
+
+	if got, want := sortedPrefixes(p.expire(now.Add(4*time.Hour))), []string{"192.0.2.1/32"}; !slices.Equal(got, want) {
+		t.Fatalf("expired %v, want %v", got, want)
+	}
+
+	if len(p.bans) != 0 || len(p.banned) != 0 {
+		t.Fatalf("left %d bans on %d prefixes behind", len(p.bans), len(p.banned))
+	}
+}
+
+// TestRun polls a stand-in Local API, which answers the startup poll with every decision and later polls with the changes
+func TestRun(t *testing.T) {
+	var polls atomic.Int32
+	startups := make(chan string, 1)
+	lapi := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/decisions/stream" || r.Header.Get("X-Api-Key") != "key" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		startup := r.URL.Query().Get("startup")
+		stream := streamResponse{}
+		switch polls.Add(1) {
+		case 1:
+			startups <- startup
+			stream.New = []decision{ipBan(1, "192.0.2.1", "1h"), ipBan(2, "2001:db8::1", "1h")}
+		case 2:
+			stream.Deleted = []decision{ipBan(1, "192.0.2.1", "1h")}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(stream)
+	}))
+	defer lapi.Close()
+
+	p, err := New(Config{URL: lapi.URL, APIKey: "key", PollInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go p.Run(ctx)
+
+	update := <-p.UpdateChan
+	if !update.Startup {
+		t.Fatalf("first update %+v is not the startup one", update)
+	}
+
+	if got, want := sortedPrefixes(update.Added), []string{"192.0.2.1/32", "2001:db8::1/128"}; !slices.Equal(got, want) {
+		t.Fatalf("startup added %v, want %v", got, want)
+	}
+
+	update = <-p.UpdateChan
+	if update.Startup || len(update.Added) != 0 || !slices.Equal(sortedPrefixes(update.Removed), []string{"192.0.2.1/32"}) {
+		t.Fatalf("second update %+v, want 192.0.2.1 removed", update)
+	}
+
+	if startup := <-startups; startup != "true" {
+		t.Fatalf("first poll asked for startup=%s", startup)
+	}
+
+	cancel()
+	for range p.UpdateChan {
+	}
+}
@@ -0,0 +1,35 @@
+package filewatch
+
+import (
+	"os"
+	"time"
+)
+
+// Poller detects changes of a file by polling its modification time and size,
+// which also catches the file being replaced by a rename, the way database updaters usually install new versions
+type Poller struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+func NewPoller(path string) *Poller {
+	return &Poller{path: path}
+}
+
+// Changed reports whether the file changed since the previous call, the first call always reports a change
+func (p *Poller) Changed() (bool, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return false, err
+	}
+
+	if info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return false, nil
+	}
+
+	p.modTime = info.ModTime()
+	p.size = info.Size()
+
+	return true, nil
+}
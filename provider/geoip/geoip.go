@@ -0,0 +1,214 @@
+package geoip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/MatejLach/dynafire/provider/filewatch"
+)
+
+const DefaultCheckInterval = time.Minute
+
+// Config selects the countries to block from a MaxMind format country database, i.e. GeoLite2-Country or DB-IP Country Lite
+type Config struct {
+	Database string
+	// Countries are ISO 3166-1 alpha-2 codes, i.e. "CN"
+	Countries []string
+	// CheckInterval is how often the database file is checked for changes
+	CheckInterval time.Duration
+}
+
+// List is every prefix the database locates in one of the countries
+type List struct {
+	Prefixes  []netip.Prefix
+	Countries []string
+	// BuildTime is when the database was built, as recorded in its metadata
+	BuildTime time.Time
+}
+
+// Provider reloads the country prefixes whenever the database file or the selected countries change
+type Provider struct {
+	conf      Config
+	countries chan []string
+	ListChan  chan List
+}
+
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// New fails if the database cannot be opened
+func New(conf Config) (*Provider, error) {
+	if conf.Database == "" {
+		return nil, errors.New("no GeoIP database configured")
+	}
+
+	if conf.CheckInterval <= 0 {
+		conf.CheckInterval = DefaultCheckInterval
+	}
+
+	conf.Countries = normalizeCountries(conf.Countries)
+
+	// fail early on a missing or unreadable database, rather than running without the country blocks
+	reader, err := maxminddb.Open(conf.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	err = reader.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{
+		conf:      conf,
+		countries: make(chan []string, 1),
+		ListChan:  make(chan List),
+	}, nil
+}
+
+// SetCountries replaces the selected countries, the prefixes are reloaded right away
+// It is safe to call from any goroutine
+func (p *Provider) SetCountries(countries []string) {
+	// only the latest selection matters, drop one that was not picked up yet
+	select {
+	case <-p.countries:
+	default:
+	}
+
+	p.countries <- normalizeCountries(countries)
+}
+
+// Run sends the prefixes of the selected countries to ListChan on start and after every change until ctx is cancelled
+// A database that cannot be read keeps the previously sent prefixes in place
+func (p *Provider) Run(ctx context.Context) {
+	defer close(p.ListChan)
+
+	ticker := time.NewTicker(p.conf.CheckInterval)
+	defer ticker.Stop()
+
+	poller := filewatch.NewPoller(p.conf.Database)
+	countries := p.conf.Countries
+
+	for {
+		changed, err := poller.Changed()
+		if err != nil {
+			slog.Warn("unable to check GeoIP database for changes", "path", p.conf.Database, "details", err)
+		}
+
+		if changed {
+			slog.Info("loading GeoIP database", "path", p.conf.Database, "countries", countries)
+			p.send(ctx, countries)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case countries = <-p.countries:
+			slog.Info("GeoIP countries changed", "countries", countries)
+			p.send(ctx, countries)
+		}
+	}
+}
+
+func (p *Provider) send(ctx context.Context, countries []string) {
+	list, err := Load(p.conf.Database, countries)
+	if err != nil {
+		slog.Error("unable to load GeoIP database, keeping the previous prefixes", "path", p.conf.Database, "details", err)
+		return
+	}
+
+	select {
+	case p.ListChan <- list:
+	case <-ctx.Done():
+	}
+}
+
+// Load returns every network the database at path locates in one of countries
+func Load(path string, countries []string) (List, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return List{}, err
+	}
+
+	defer func() {
+		err := reader.Close()
+		if err != nil {
+			slog.Error("unable to close GeoIP database", "path", path, "details", err)
+		}
+	}()
+
+	wanted := make(map[string]bool, len(countries))
+	for _, country := range countries {
+		wanted[country] = true
+	}
+
+	prefixes := make([]netip.Prefix, 0)
+	networks := reader.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		var record countryRecord
+		network, err := networks.Network(&record)
+		if err != nil {
+			return List{}, fmt.Errorf("unable to decode GeoIP database: %w", err)
+		}
+
+		if !wanted[record.Country.ISOCode] {
+			continue
+		}
+
+		prefix, ok := PrefixFromIPNet(network)
+		if ok {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	if networks.Err() != nil {
+		return List{}, fmt.Errorf("unable to read GeoIP database: %w", networks.Err())
+	}
+
+	return List{
+		Prefixes:  prefixes,
+		Countries: countries,
+		BuildTime: time.Unix(int64(reader.Metadata.BuildEpoch), 0),
+	}, nil
+}
+
+// PrefixFromIPNet converts a network of the database, IPv4 networks of an IPv6 database become plain IPv4 prefixes
+func PrefixFromIPNet(network *net.IPNet) (netip.Prefix, bool) {
+	addr, ok := netip.AddrFromSlice(network.IP)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+
+	bits, _ := network.Mask.Size()
+	if addr.Is4In6() {
+		addr = addr.Unmap()
+		bits -= 96
+	}
+
+	if bits < 0 {
+		return netip.Prefix{}, false
+	}
+
+	return netip.PrefixFrom(addr, bits).Masked(), true
+}
+
+func normalizeCountries(countries []string) []string {
+	result := make([]string, 0, len(countries))
+	for _, country := range countries {
+		result = append(result, strings.ToUpper(strings.TrimSpace(country)))
+	}
+
+	return result
+}
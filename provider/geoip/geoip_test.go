@@ -0,0 +1,57 @@
+package geoip
+
+import (
+	"net"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPrefixFromIPNet(t *testing.T) {
+	tests := []struct {
+		network string
+		want    string
+	}{
+		{network: "192.0.2.0/24", want: "192.0.2.0/24"},
+		// IPv4 networks of an IPv6 database are stored as IPv4-mapped addresses
+		{network: "::ffff:198.51.100.0/120", want: "198.51.100.0/24"},
+		{network: "2001:db8::/32", want: "2001:db8::/32"},
+	}
+
+	for _, test := range tests {
+		_, network, err := net.ParseCIDR(test.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, ok := PrefixFromIPNet(network)
+		if !ok || got != netip.MustParsePrefix(test.want) {
+			t.Errorf("PrefixFromIPNet(%s) = %s, %v, want %s", test.network, got, ok, test.want)
+		}
+	}
+
+	// the IPv4-mapped range is shorter than 96 bits here, which has no IPv4 equivalent
+	_, network, _ := net.ParseCIDR("::ffff:0:0/80")
+	network.IP = net.ParseIP("::ffff:0.0.0.0")
+	if got, ok := PrefixFromIPNet(network); ok {
+		t.Errorf("PrefixFromIPNet(%s) = %s, want no prefix", network, got)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{Countries: []string{"CN"}}); err == nil {
+		t.Fatal("New() without a database succeeded")
+	}
+
+	if _, err := New(Config{Database: filepath.Join(t.TempDir(), "missing.mmdb"), Countries: []string{"CN"}}); err == nil {
+		t.Fatal("New() with a missing database succeeded")
+	}
+}
+
+func TestNormalizeCountries(t *testing.T) {
+	got := normalizeCountries([]string{" cn", "Ru ", "KP"})
+	if want := []string{"CN", "RU", "KP"}; !slices.Equal(got, want) {
+		t.Fatalf("normalizeCountries() = %q, want %q", got, want)
+	}
+}
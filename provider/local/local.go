@@ -0,0 +1,145 @@
+package local
+
+import (
+	"context"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// expiryCheck is how often expired detections are looked for
+const expiryCheck = time.Second
+
+// ReasonManual is the reason of blocks added with dynafire block
+const ReasonManual = "manual"
+
+// Detection is an address or prefix this host decided to block on its own
+type Detection struct {
+	Prefix netip.Prefix
+	// Reason is what caused the block, "manual" or the name of the detection rule
+	Reason string
+	// Expires is when the block lifts, zero for never
+	Expires time.Time
+}
+
+// Update is a detection that was added or renewed, or removed once it expired or was lifted
+type Update struct {
+	Detection
+	Removed bool
+}
+
+// Store keeps the local detections and lifts them once they expire
+type Store struct {
+	// sendMu is held from a change until its update is sent, so that updates arrive in the order they were made;
+	// mu only guards detections, so that Detections does not wait for the receiver of UpdateChan
+	sendMu     sync.Mutex
+	mu         sync.Mutex
+	detections map[netip.Prefix]Detection
+	// UpdateChan receives every change, in order
+	UpdateChan chan Update
+}
+
+func New() *Store {
+	return &Store{
+		detections: make(map[netip.Prefix]Detection),
+		UpdateChan: make(chan Update),
+	}
+}
+
+// Add adds or renews a detection, replacing the expiry and reason of one for the same prefix
+// It blocks until the update is received from UpdateChan, or fails once ctx is cancelled
+func (s *Store) Add(ctx context.Context, d Detection) error {
+	d.Prefix = d.Prefix.Masked()
+
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	s.mu.Lock()
+	s.detections[d.Prefix] = d
+	s.mu.Unlock()
+
+	return s.send(ctx, Update{Detection: d})
+}
+
+// Remove lifts the detection of prefix, it reports false if there is none
+// It blocks until the update is received from UpdateChan, or fails once ctx is cancelled
+func (s *Store) Remove(ctx context.Context, prefix netip.Prefix) (bool, error) {
+	prefix = prefix.Masked()
+
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	s.mu.Lock()
+	d, ok := s.detections[prefix]
+	delete(s.detections, prefix)
+	s.mu.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+
+	return true, s.send(ctx, Update{Detection: d, Removed: true})
+}
+
+func (s *Store) send(ctx context.Context, u Update) error {
+	select {
+	case s.UpdateChan <- u:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Detections returns the current detections, ordered by prefix
+func (s *Store) Detections() []Detection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]Detection, 0, len(s.detections))
+	for _, d := range s.detections {
+		result = append(result, d)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Prefix.String() < result[j].Prefix.String()
+	})
+
+	return result
+}
+
+// Run lifts expired detections until ctx is cancelled
+func (s *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(expiryCheck)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.expire(ctx, now)
+		}
+	}
+}
+
+func (s *Store) expire(ctx context.Context, now time.Time) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	s.mu.Lock()
+	expired := make([]Detection, 0)
+	for prefix, d := range s.detections {
+		if !d.Expires.IsZero() && !now.Before(d.Expires) {
+			delete(s.detections, prefix)
+			expired = append(expired, d)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, d := range expired {
+		if s.send(ctx, Update{Detection: d, Removed: true}) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	received := make(chan Update, 4)
+	go func() {
+		for u := range s.UpdateChan {
+			received <- u
+		}
+	}()
+
+	next := func() Update {
+		t.Helper()
+
+		select {
+		case u := <-received:
+			return u
+		case <-time.After(5 * time.Second):
+			t.Fatal("no update received")
+			return Update{}
+		}
+	}
+
+	expires := time.Now().Add(time.Minute)
+	if err := s.Add(ctx, Detection{Prefix: netip.MustParsePrefix("192.0.2.77/24"), Reason: ReasonManual, Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+
+	if u := next(); u.Removed || u.Prefix != netip.MustParsePrefix("192.0.2.0/24") {
+		t.Fatalf("update %+v, want the masked prefix added", u)
+	}
+
+	if err := s.Add(ctx, Detection{Prefix: netip.MustParsePrefix("198.51.100.1/32"), Reason: "sshd"}); err != nil {
+		t.Fatal(err)
+	}
+	next()
+
+	if got := s.Detections(); len(got) != 2 || got[0].Prefix != netip.MustParsePrefix("192.0.2.0/24") {
+		t.Fatalf("Detections() = %+v, want both detections ordered by prefix", got)
+	}
+
+	// only the detection that expired is lifted
+	s.expire(ctx, expires)
+	if u := next(); !u.Removed || u.Prefix != netip.MustParsePrefix("192.0.2.0/24") {
+		t.Fatalf("update %+v, want the expired detection removed", u)
+	}
+
+	removed, err := s.Remove(ctx, netip.MustParsePrefix("198.51.100.1/32"))
+	if err != nil || !removed {
+		t.Fatalf("Remove() = %v, %v, want true", removed, err)
+	}
+
+	if u := next(); !u.Removed || u.Reason != "sshd" {
+		t.Fatalf("update %+v, want the sshd detection removed", u)
+	}
+
+	removed, err = s.Remove(ctx, netip.MustParsePrefix("198.51.100.1/32"))
+	if err != nil || removed {
+		t.Fatalf("Remove() of a lifted detection = %v, %v, want false", removed, err)
+	}
+}
+
+// TestStoreStopped adds a detection nobody receives, which gives up once ctx is cancelled rather than blocking the store
+func TestStoreStopped(t *testing.T) {
+	s := New()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Add(ctx, Detection{Prefix: netip.MustParsePrefix("192.0.2.1/32"), Reason: ReasonManual})
+	}()
+
+	// the pending update does not hold up readers of the detections
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.Detections()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the detection was never added")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Add() = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Add() blocks after ctx was cancelled")
+	}
+}
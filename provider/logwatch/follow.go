@@ -0,0 +1,157 @@
+package logwatch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// maxLine caps the length of a log line, longer lines are cut
+const maxLine = 64 << 10
+
+// followJournald hands the messages journald logs for unit to emit, from the current end of the journal until ctx is cancelled
+// emit reports false once ctx is cancelled
+func followJournald(ctx context.Context, unit string, emit func(string) bool) error {
+	cmd := exec.CommandContext(ctx, "journalctl", "--follow", "--lines=0", "--output=cat", "--unit="+unit)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("unable to run journalctl: %w", err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 4096), maxLine)
+	for scanner.Scan() {
+		if !emit(scanner.Text()) {
+			break
+		}
+	}
+
+	// journalctl keeps running after a line too long to scan, and would block on the full pipe
+	scanErr := scanner.Err()
+	if scanErr != nil {
+		_ = cmd.Process.Kill()
+	}
+
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	if scanErr != nil {
+		return fmt.Errorf("reading journalctl output: %w", scanErr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("journalctl stopped: %w", err)
+	}
+
+	return errors.New("journalctl stopped")
+}
+
+// followFile hands the lines appended to the file at path to emit, starting at its current end, until ctx is cancelled
+// The file is polled, and read from its start again once it was rotated, i.e. replaced or truncated
+func followFile(ctx context.Context, path string, poll time.Duration, emit func(string) bool) error {
+	var f *os.File
+	var reader *bufio.Reader
+	var offset int64
+	defer func() {
+		if f != nil {
+			_ = f.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	first := true
+	pending := make([]byte, 0)
+	for {
+		if f == nil {
+			var err error
+			f, err = os.Open(path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+
+			if f != nil {
+				offset = 0
+				// lines logged before dynafire started were dealt with by whoever ran back then
+				if first {
+					offset, err = f.Seek(0, io.SeekEnd)
+					if err != nil {
+						return err
+					}
+				}
+
+				reader = bufio.NewReader(f)
+				pending = pending[:0]
+			}
+
+			first = false
+		}
+
+		if f != nil {
+			for {
+				chunk, err := reader.ReadSlice('\n')
+				offset += int64(len(chunk))
+				if len(pending)+len(chunk) <= maxLine {
+					pending = append(pending, chunk...)
+				}
+
+				if errors.Is(err, bufio.ErrBufferFull) {
+					continue
+				}
+
+				if err != nil {
+					// an incomplete line stays pending until the rest of it is written
+					break
+				}
+
+				if !emit(string(bytes.TrimRight(pending, "\r\n"))) {
+					return nil
+				}
+
+				pending = pending[:0]
+			}
+
+			if rotated(f, path, offset) {
+				_ = f.Close()
+				f = nil
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// rotated reports whether path no longer refers to f, or f was truncated below what was read of it
+func rotated(f *os.File, path string, offset int64) bool {
+	current, err := os.Stat(path)
+	if err != nil {
+		// gone for now, keep reading what is left of the old file until a new one shows up
+		return false
+	}
+
+	opened, err := f.Stat()
+	if err != nil {
+		return true
+	}
+
+	return !os.SameFile(opened, current) || current.Size() < offset
+}
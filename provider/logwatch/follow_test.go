@@ -0,0 +1,109 @@
+package logwatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testPoll = 10 * time.Millisecond
+
+func appendLine(t *testing.T, path, text string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	if _, err := f.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFollowFile follows a file through appended lines, a line written in two parts, a rotation and a truncation
+func TestFollowFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	appendLine(t, path, "logged before\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lines := make(chan string)
+	done := make(chan error, 1)
+	go func() {
+		done <- followFile(ctx, path, testPoll, func(text string) bool {
+			select {
+			case lines <- text:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+
+	// followFile starts at the end of the file, so the test keeps appending until it is sure the file was opened
+	ready := false
+	for !ready {
+		appendLine(t, path, "ready\n")
+		select {
+		case text := <-lines:
+			if text != "ready" {
+				t.Fatalf("first line %q, want ready", text)
+			}
+			ready = true
+		case <-time.After(10 * testPoll):
+		}
+	}
+
+	next := func() string {
+		t.Helper()
+
+		for {
+			select {
+			case text := <-lines:
+				if text != "ready" {
+					return text
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no line followed")
+			}
+		}
+	}
+
+	appendLine(t, path, "first\r\n")
+	if text := next(); text != "first" {
+		t.Fatalf("followed %q, want first", text)
+	}
+
+	appendLine(t, path, "par")
+	time.Sleep(3 * testPoll)
+	appendLine(t, path, "tial\n")
+	if text := next(); text != "partial" {
+		t.Fatalf("followed %q, want partial", text)
+	}
+
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLine(t, path, "rotated\n")
+	if text := next(); text != "rotated" {
+		t.Fatalf("followed %q after the rotation, want rotated", text)
+	}
+
+	if err := os.WriteFile(path, []byte("cut\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if text := next(); text != "cut" {
+		t.Fatalf("followed %q after the truncation, want cut", text)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("followFile() = %v after cancellation, want nil", err)
+	}
+}
@@ -0,0 +1,208 @@
+package logwatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"regexp"
+	"time"
+)
+
+const (
+	// filePoll is how often log files are checked for new lines
+	filePoll = time.Second
+	// restartDelay is how long a failed input waits before it is followed again
+	restartDelay = 10 * time.Second
+)
+
+// Rule counts the lines of one input matching any of its patterns per address, and blocks an address once it reaches MaxHits within Window
+type Rule struct {
+	Name string
+	// Input is exactly one of JournaldUnit, i.e. "sshd.service", or File, i.e. "/var/log/nginx/access.log"
+	JournaldUnit string
+	File         string
+	// Patterns are regular expressions, see CompilePattern
+	Patterns []string
+	MaxHits  int
+	Window   time.Duration
+	BanTime  time.Duration
+}
+
+func (r Rule) input() string {
+	if r.JournaldUnit != "" {
+		return "journald:" + r.JournaldUnit
+	}
+
+	return "file:" + r.File
+}
+
+// Detection is an address that reached the hits of a rule
+type Detection struct {
+	Rule    string
+	Addr    netip.Addr
+	Hits    int
+	BanTime time.Duration
+}
+
+type rule struct {
+	Rule
+	patterns []*regexp.Regexp
+	// hits are the times of the matching lines of every address within the window, oldest first
+	hits map[netip.Addr][]time.Time
+}
+
+type line struct {
+	input string
+	text  string
+}
+
+// Detector follows the inputs of its rules and reports the addresses reaching the hits of a rule
+type Detector struct {
+	// rules are grouped by their input, every input is followed once for all of its rules
+	rules  map[string][]*rule
+	inputs map[string]Rule
+}
+
+// New fails if a rule has no input or a pattern does not compile
+func New(rules []Rule) (*Detector, error) {
+	if len(rules) == 0 {
+		return nil, errors.New("no detection rules configured")
+	}
+
+	d := &Detector{
+		rules:  make(map[string][]*rule),
+		inputs: make(map[string]Rule),
+	}
+
+	for _, r := range rules {
+		if (r.JournaldUnit == "") == (r.File == "") {
+			return nil, fmt.Errorf("rule %s: exactly one of a journald unit or a file is required", r.Name)
+		}
+
+		if r.MaxHits < 1 || r.Window <= 0 || r.BanTime <= 0 {
+			return nil, fmt.Errorf("rule %s: the hits, window and ban time must be positive", r.Name)
+		}
+
+		compiled := &rule{Rule: r, hits: make(map[netip.Addr][]time.Time)}
+		for _, pattern := range r.Patterns {
+			re, err := CompilePattern(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: pattern %q: %w", r.Name, pattern, err)
+			}
+
+			compiled.patterns = append(compiled.patterns, re)
+		}
+
+		d.rules[r.input()] = append(d.rules[r.input()], compiled)
+		d.inputs[r.input()] = r
+	}
+
+	return d, nil
+}
+
+// Run follows the inputs until ctx is cancelled, calling handle for every detection
+// An address is counted from scratch once it was detected, so one that keeps going is detected again, renewing its block
+func (d *Detector) Run(ctx context.Context, handle func(Detection)) {
+	lines := make(chan line)
+	for input, r := range d.inputs {
+		go d.follow(ctx, input, r, lines)
+	}
+
+	// addresses that went quiet are forgotten once they fell out of every window
+	sweep := time.NewTicker(time.Minute)
+	defer sweep.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case l := <-lines:
+			for _, r := range d.rules[l.input] {
+				if detection, ok := r.count(l.text, time.Now()); ok {
+					handle(detection)
+				}
+			}
+		case now := <-sweep.C:
+			for _, rules := range d.rules {
+				for _, r := range rules {
+					r.sweep(now)
+				}
+			}
+		}
+	}
+}
+
+// follow follows an input, restarting it whenever it fails
+func (d *Detector) follow(ctx context.Context, input string, r Rule, lines chan<- line) {
+	emit := func(text string) bool {
+		select {
+		case lines <- line{input: input, text: text}:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		var err error
+		if r.JournaldUnit != "" {
+			err = followJournald(ctx, r.JournaldUnit, emit)
+		} else {
+			err = followFile(ctx, r.File, filePoll, emit)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		slog.Error("unable to follow log, retrying", "input", input, "retry_in", restartDelay, "details", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(restartDelay):
+		}
+	}
+}
+
+func (r *rule) count(text string, now time.Time) (Detection, bool) {
+	addr, ok := match(r.patterns, text)
+	// the host itself shows up in i.e. the access logs of health checks, and must never be blocked
+	if !ok || addr.IsLoopback() || addr.IsUnspecified() {
+		return Detection{}, false
+	}
+
+	hits := append(r.recent(r.hits[addr], now), now)
+	if len(hits) < r.MaxHits {
+		r.hits[addr] = hits
+		return Detection{}, false
+	}
+
+	delete(r.hits, addr)
+
+	return Detection{Rule: r.Name, Addr: addr, Hits: len(hits), BanTime: r.BanTime}, true
+}
+
+// recent drops the hits that fell out of the window
+func (r *rule) recent(hits []time.Time, now time.Time) []time.Time {
+	start := now.Add(-r.Window)
+	for len(hits) > 0 && !hits[0].After(start) {
+		hits = hits[1:]
+	}
+
+	return hits
+}
+
+func (r *rule) sweep(now time.Time) {
+	for addr, hits := range r.hits {
+		hits = r.recent(hits, now)
+		if len(hits) == 0 {
+			delete(r.hits, addr)
+			continue
+		}
+
+		r.hits[addr] = hits
+	}
+}
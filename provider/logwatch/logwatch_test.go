@@ -0,0 +1,113 @@
+package logwatch
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func newTestRule(t *testing.T) *rule {
+	t.Helper()
+
+	d, err := New([]Rule{{
+		Name:         "sshd",
+		JournaldUnit: "sshd.service",
+		Patterns:     []string{`Failed password for .* from <HOST> port`},
+		MaxHits:      3,
+		Window:       time.Minute,
+		BanTime:      time.Hour,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d.rules["journald:sshd.service"][0]
+}
+
+func failed(addr string) string {
+	return "Failed password for root from " + addr + " port 4711 ssh2"
+}
+
+func TestRuleCount(t *testing.T) {
+	r := newTestRule(t)
+	start := time.Now()
+
+	tests := []struct {
+		name   string
+		line   string
+		after  time.Duration
+		detect bool
+	}{
+		{name: "first hit", line: failed("192.0.2.1"), after: 0},
+		{name: "other address", line: failed("192.0.2.2"), after: time.Second},
+		{name: "unrelated line", line: "Accepted publickey for root from 192.0.2.1 port 4711 ssh2", after: 2 * time.Second},
+		{name: "second hit", line: failed("192.0.2.1"), after: 3 * time.Second},
+		{name: "loopback is never counted", line: failed("127.0.0.1"), after: 4 * time.Second},
+		{name: "third hit", line: failed("::ffff:192.0.2.1"), after: 5 * time.Second, detect: true},
+		// detected addresses are counted from scratch
+		{name: "hit after detection", line: failed("192.0.2.1"), after: 6 * time.Second},
+		// the hits of 192.0.2.2 fall out of the window one by one
+		{name: "second hit of other", line: failed("192.0.2.2"), after: 50 * time.Second},
+		{name: "first hit of other outside window", line: failed("192.0.2.2"), after: 62 * time.Second},
+		{name: "third hit of other", line: failed("192.0.2.2"), after: 63 * time.Second, detect: true},
+	}
+
+	for _, tt := range tests {
+		detection, ok := r.count(tt.line, start.Add(tt.after))
+		if ok != tt.detect {
+			t.Fatalf("%s: count() detected %t, want %t", tt.name, ok, tt.detect)
+		}
+
+		if ok && (detection.Hits != 3 || detection.Rule != "sshd" || detection.BanTime != time.Hour) {
+			t.Fatalf("%s: count() = %+v", tt.name, detection)
+		}
+	}
+
+	if got := r.hits[netip.MustParseAddr("192.0.2.1")]; len(got) != 1 {
+		t.Fatalf("192.0.2.1 has %d hits after its detection, want 1", len(got))
+	}
+
+	if _, ok := r.hits[netip.MustParseAddr("127.0.0.1")]; ok {
+		t.Fatal("loopback was counted")
+	}
+}
+
+func TestRuleSweep(t *testing.T) {
+	r := newTestRule(t)
+	start := time.Now()
+
+	r.count(failed("192.0.2.1"), start)
+	r.count(failed("192.0.2.2"), start)
+	r.count(failed("192.0.2.2"), start.Add(30*time.Second))
+
+	r.sweep(start.Add(45 * time.Second))
+	if len(r.hits) != 2 {
+		t.Fatalf("sweeping within the window left %d addresses, want 2", len(r.hits))
+	}
+
+	r.sweep(start.Add(75 * time.Second))
+	if len(r.hits) != 1 || len(r.hits[netip.MustParseAddr("192.0.2.2")]) != 1 {
+		t.Fatalf("sweeping left %v, want the last hit of 192.0.2.2", r.hits)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{name: "no input", rule: Rule{Name: "a", Patterns: []string{"<HOST>"}, MaxHits: 1, Window: time.Minute, BanTime: time.Hour}},
+		{name: "two inputs", rule: Rule{Name: "a", JournaldUnit: "a", File: "/a", Patterns: []string{"<HOST>"}, MaxHits: 1, Window: time.Minute, BanTime: time.Hour}},
+		{name: "no window", rule: Rule{Name: "a", File: "/a", Patterns: []string{"<HOST>"}, MaxHits: 1, BanTime: time.Hour}},
+		{name: "no address captured", rule: Rule{Name: "a", File: "/a", Patterns: []string{"Failed password"}, MaxHits: 1, Window: time.Minute, BanTime: time.Hour}},
+		{name: "invalid pattern", rule: Rule{Name: "a", File: "/a", Patterns: []string{"(<HOST>"}, MaxHits: 1, Window: time.Minute, BanTime: time.Hour}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New([]Rule{tt.rule}); err == nil {
+				t.Fatal("New() succeeded, want an error")
+			}
+		})
+	}
+}
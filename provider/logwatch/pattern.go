@@ -0,0 +1,49 @@
+package logwatch
+
+import (
+	"errors"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+// HostPlaceholder stands for the offending address in a pattern, the way fail2ban writes it, i.e. "Failed password for .* from <HOST>"
+const HostPlaceholder = "<HOST>"
+
+// hostGroup matches an IPv4 or IPv6 address, which is then parsed for real
+const hostGroup = `(?P<host>[0-9A-Fa-f:.]+)`
+
+// CompilePattern compiles a rule pattern; it either contains HostPlaceholder or a group named host capturing the address
+func CompilePattern(pattern string) (*regexp.Regexp, error) {
+	pattern = strings.ReplaceAll(pattern, HostPlaceholder, hostGroup)
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if re.SubexpIndex("host") < 0 {
+		return nil, errors.New("the pattern captures no address, use " + HostPlaceholder + " or a group named host")
+	}
+
+	return re, nil
+}
+
+// match returns the address the first matching pattern captures from line
+func match(patterns []*regexp.Regexp, line string) (netip.Addr, bool) {
+	for _, re := range patterns {
+		groups := re.FindStringSubmatch(line)
+		if groups == nil {
+			continue
+		}
+
+		addr, err := netip.ParseAddr(groups[re.SubexpIndex("host")])
+		if err != nil {
+			continue
+		}
+
+		return addr.Unmap(), true
+	}
+
+	return netip.Addr{}, false
+}
@@ -0,0 +1,462 @@
+package peers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"syscall"
+	"time"
+
+	zmq "github.com/pebbe/zmq4"
+
+	"github.com/MatejLach/dynafire/provider/turris"
+)
+
+const (
+	Topic = "dynafire/detection"
+	// authDomain is the ZAP domain the peer keys are registered for
+	authDomain = "dynafire-peers"
+	// receiveTimeout bounds how long a receiver blocks, so that it notices when ctx is cancelled
+	receiveTimeout = time.Second
+	// expiryCheck is how often expired detections of the peers are looked for
+	expiryCheck = time.Second
+	// DefaultMinPrefixLengthV4 and DefaultMinPrefixLengthV6 keep a peer from blocking whole networks
+	DefaultMinPrefixLengthV4 = 24
+	DefaultMinPrefixLengthV6 = 48
+	// DefaultMaxTTL and DefaultAnnounceInterval stand in for zero values, a zero MaxTTL would lift every detection right away
+	DefaultMaxTTL           = 24 * time.Hour
+	DefaultAnnounceInterval = 5 * time.Minute
+
+	opBlock   = "block"
+	opUnblock = "unblock"
+)
+
+// Peer is another dynafire instance whose detections are received
+type Peer struct {
+	// Name attributes the peer's detections, they are enforced as the source peers/<name>
+	Name string
+	// Endpoint is the host:port the peer shares its detections on
+	Endpoint string
+	// PublicKey is the Z85 encoded public key of the peer's key file
+	PublicKey string
+}
+
+// Config describes this instance's place in the mesh
+type Config struct {
+	// Name identifies this instance in the detections it shares
+	Name          string
+	ListenAddress string
+	Port          int
+	// KeyFile stores this instance's CURVE key pair, it is created if it does not exist; without it, the key pair lasts until exit
+	KeyFile string
+	Peers   []Peer
+	// MaxTTL caps how long a received detection is blocked for; detections that do not expire are shared with it
+	// Zero falls back to DefaultMaxTTL
+	MaxTTL time.Duration
+	// AnnounceInterval is how often every shared detection is announced again, i.e. for peers that restarted
+	// Zero falls back to DefaultAnnounceInterval
+	AnnounceInterval time.Duration
+	// Allowlist are the prefixes never accepted from peers
+	Allowlist []netip.Prefix
+	// MinPrefixLengthV4 and MinPrefixLengthV6 are the shortest prefixes accepted from peers, per address family
+	MinPrefixLengthV4 int
+	MinPrefixLengthV6 int
+}
+
+// Detection is a block received from a peer
+type Detection struct {
+	Peer    string
+	Prefix  netip.Prefix
+	Reason  string
+	Expires time.Time
+}
+
+// Update is a received detection that was added or renewed, or removed once it expired or the peer lifted it
+type Update struct {
+	Detection
+	Removed bool
+}
+
+// message is what peers exchange, as JSON
+type message struct {
+	Peer   string `json:"peer"`
+	Op     string `json:"op"`
+	Prefix string `json:"prefix"`
+	// TTL is in seconds, 0 does not expire
+	TTL    int64  `json:"ttl,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// shared is a detection of this instance
+type shared struct {
+	reason  string
+	expires time.Time
+}
+
+// received is a message along with the peer it came from, as configured rather than as the message claims
+type received struct {
+	peer string
+	msg  message
+}
+
+// Mesh shares the detections of this instance with its peers and receives theirs
+// Every instance publishes on its own endpoint and subscribes to the endpoint of every peer; both ends authenticate
+// with CURVE, the subscriber pins the key of the peer and the publisher only lets in the keys of its peers
+type Mesh struct {
+	conf      Config
+	publicKey string
+	secretKey string
+	pub       *zmq.Socket
+
+	outbox   chan message
+	inbox    chan received
+	shared   map[netip.Prefix]shared
+	received map[string]map[netip.Prefix]Detection
+	// UpdateChan receives the detections of the peers, buffered so that a busy receiver does not hold up sharing;
+	// it is closed when Run returns
+	UpdateChan chan Update
+}
+
+func New(conf Config) (*Mesh, error) {
+	if len(conf.Peers) == 0 {
+		return nil, errors.New("no peers configured")
+	}
+
+	if conf.MinPrefixLengthV4 <= 0 {
+		conf.MinPrefixLengthV4 = DefaultMinPrefixLengthV4
+	}
+
+	if conf.MinPrefixLengthV6 <= 0 {
+		conf.MinPrefixLengthV6 = DefaultMinPrefixLengthV6
+	}
+
+	if conf.MaxTTL <= 0 {
+		conf.MaxTTL = DefaultMaxTTL
+	}
+
+	if conf.AnnounceInterval <= 0 {
+		conf.AnnounceInterval = DefaultAnnounceInterval
+	}
+
+	var publicKey, secretKey string
+	var err error
+	if conf.KeyFile != "" {
+		publicKey, secretKey, err = turris.LoadOrCreatePeerKeypair(conf.KeyFile)
+	} else {
+		publicKey, secretKey, err = zmq.NewCurveKeypair()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the peer key pair: %w", err)
+	}
+
+	// in the default context, the one the authenticator serves
+	pub, err := zmq.NewSocket(zmq.PUB)
+	if err != nil {
+		return nil, err
+	}
+
+	err = turris.StartAuth()
+	if err != nil {
+		return nil, fmt.Errorf("unable to start the ZMQ authenticator: %w", err)
+	}
+
+	for _, peer := range conf.Peers {
+		zmq.AuthCurveAdd(authDomain, peer.PublicKey)
+	}
+
+	err = pub.ServerAuthCurve(authDomain, secretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pub.Bind(fmt.Sprintf("tcp://%s:%d", conf.ListenAddress, conf.Port))
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s port %d: %w", conf.ListenAddress, conf.Port, err)
+	}
+
+	return &Mesh{
+		conf:       conf,
+		publicKey:  publicKey,
+		secretKey:  secretKey,
+		pub:        pub,
+		outbox:     make(chan message, 64),
+		inbox:      make(chan received),
+		shared:     make(map[netip.Prefix]shared),
+		received:   make(map[string]map[netip.Prefix]Detection),
+		UpdateChan: make(chan Update, 64),
+	}, nil
+}
+
+// PublicKey returns the Z85 encoded public key of this instance, which its peers configure
+func (m *Mesh) PublicKey() string {
+	return m.publicKey
+}
+
+// Share publishes a detection of this instance to the peers, expires is zero for a block that does not expire
+// It is safe to call from any goroutine, it waits for room in the outbox until ctx is cancelled
+func (m *Mesh) Share(ctx context.Context, prefix netip.Prefix, reason string, expires time.Time) {
+	m.queue(ctx, m.blockMessage(prefix, shared{reason: reason, expires: expires}))
+}
+
+// Withdraw lifts a detection of this instance on the peers
+// It is safe to call from any goroutine, it waits for room in the outbox until ctx is cancelled
+func (m *Mesh) Withdraw(ctx context.Context, prefix netip.Prefix) {
+	m.queue(ctx, message{Peer: m.conf.Name, Op: opUnblock, Prefix: prefix.String()})
+}
+
+func (m *Mesh) queue(ctx context.Context, msg message) {
+	select {
+	case m.outbox <- msg:
+	case <-ctx.Done():
+	}
+}
+
+// send hands an update to UpdateChan, reporting false once ctx is cancelled
+func (m *Mesh) send(ctx context.Context, update Update) bool {
+	select {
+	case m.UpdateChan <- update:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Run publishes the shared detections and receives those of the peers until ctx is cancelled
+func (m *Mesh) Run(ctx context.Context) {
+	defer close(m.UpdateChan)
+	defer m.pub.Close()
+
+	for _, peer := range m.conf.Peers {
+		go m.receive(ctx, peer)
+	}
+
+	announce := time.NewTicker(m.conf.AnnounceInterval)
+	defer announce.Stop()
+
+	expiry := time.NewTicker(expiryCheck)
+	defer expiry.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-m.outbox:
+			m.track(msg)
+			m.publish(msg)
+		case in := <-m.inbox:
+			m.apply(ctx, in)
+		case <-announce.C:
+			for prefix, detection := range m.shared {
+				m.publish(m.blockMessage(prefix, detection))
+			}
+		case now := <-expiry.C:
+			m.expire(ctx, now)
+		}
+	}
+}
+
+func (m *Mesh) blockMessage(prefix netip.Prefix, detection shared) message {
+	msg := message{Peer: m.conf.Name, Op: opBlock, Prefix: prefix.String(), Reason: detection.reason}
+	if !detection.expires.IsZero() {
+		// a detection about to expire is still sent with the shortest TTL there is, the peers lift it on their own
+		msg.TTL = max(int64(time.Until(detection.expires).Seconds()), 1)
+	}
+
+	return msg
+}
+
+// track keeps what is shared, to announce it again
+func (m *Mesh) track(msg message) {
+	prefix, err := netip.ParsePrefix(msg.Prefix)
+	if err != nil {
+		return
+	}
+
+	switch msg.Op {
+	case opBlock:
+		var expires time.Time
+		if msg.TTL > 0 {
+			expires = time.Now().Add(time.Duration(msg.TTL) * time.Second)
+		}
+
+		m.shared[prefix] = shared{reason: msg.Reason, expires: expires}
+	case opUnblock:
+		delete(m.shared, prefix)
+	}
+}
+
+func (m *Mesh) publish(msg message) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("unable to encode detection", "details", err)
+		return
+	}
+
+	_, err = m.pub.SendMessage(Topic, body)
+	if err != nil {
+		slog.Error("unable to share detection", "prefix", msg.Prefix, "details", err)
+	}
+}
+
+// receive subscribes to a peer and hands its messages to Run, each peer has its own socket so that its messages are attributed to it
+func (m *Mesh) receive(ctx context.Context, peer Peer) {
+	sub, err := m.subscribe(peer)
+	if err != nil {
+		slog.Error("unable to subscribe to peer, its detections will not be received", "peer", peer.Name, "endpoint", peer.Endpoint, "details", err)
+		return
+	}
+	defer sub.Close()
+
+	for {
+		payloadB, err := sub.RecvMessageBytes(0)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			if zmq.AsErrno(err) != zmq.Errno(syscall.EAGAIN) {
+				slog.Warn("unable to receive detection from peer", "peer", peer.Name, "details", err)
+			}
+
+			continue
+		}
+
+		if len(payloadB) != 2 || string(payloadB[0]) != Topic {
+			slog.Warn("malformed message from peer", "peer", peer.Name)
+			continue
+		}
+
+		var msg message
+		err = json.Unmarshal(payloadB[1], &msg)
+		if err != nil {
+			slog.Warn("unable to decode detection from peer", "peer", peer.Name, "details", err)
+			continue
+		}
+
+		select {
+		case m.inbox <- received{peer: peer.Name, msg: msg}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *Mesh) subscribe(peer Peer) (*zmq.Socket, error) {
+	sub, err := zmq.NewSocket(zmq.SUB)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sub.ClientAuthCurve(peer.PublicKey, m.publicKey, m.secretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sub.SetRcvtimeo(receiveTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sub.SetSubscribe(Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sub.Connect("tcp://" + peer.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+// apply records a message from a peer, sending the resulting change to UpdateChan
+func (m *Mesh) apply(ctx context.Context, in received) {
+	prefix, err := netip.ParsePrefix(in.msg.Prefix)
+	if err != nil {
+		slog.Warn("skipping detection with invalid prefix from peer", "peer", in.peer, "prefix", in.msg.Prefix)
+		return
+	}
+	prefix = prefix.Masked()
+
+	if in.msg.Op == opBlock && prefix.Bits() < m.minPrefixLength(prefix) {
+		slog.Warn("skipping detection with too short a prefix from peer", "peer", in.peer, "prefix", prefix, "min_prefix_length", m.minPrefixLength(prefix))
+		return
+	}
+
+	detections, ok := m.received[in.peer]
+	if !ok {
+		detections = make(map[netip.Prefix]Detection)
+		m.received[in.peer] = detections
+	}
+
+	switch in.msg.Op {
+	case opBlock:
+		if m.allowlisted(prefix) {
+			slog.Debug("not accepting allowlisted detection from peer", "peer", in.peer, "prefix", prefix)
+			return
+		}
+
+		ttl := m.conf.MaxTTL
+		if in.msg.TTL > 0 {
+			ttl = min(time.Duration(in.msg.TTL)*time.Second, m.conf.MaxTTL)
+		}
+
+		detection := Detection{Peer: in.peer, Prefix: prefix, Reason: in.msg.Reason, Expires: time.Now().Add(ttl)}
+		_, renewed := detections[prefix]
+		detections[prefix] = detection
+
+		// announcements of known detections only renew them
+		if !renewed {
+			slog.Info("received detection from peer", "peer", in.peer, "prefix", prefix, "reason", detection.Reason, "ttl", ttl)
+			m.send(ctx, Update{Detection: detection})
+		}
+	case opUnblock:
+		detection, ok := detections[prefix]
+		if !ok {
+			return
+		}
+
+		delete(detections, prefix)
+		slog.Info("peer lifted detection", "peer", in.peer, "prefix", prefix)
+		m.send(ctx, Update{Detection: detection, Removed: true})
+	default:
+		slog.Warn("skipping detection with unknown operation from peer", "peer", in.peer, "operation", in.msg.Op)
+	}
+}
+
+func (m *Mesh) expire(ctx context.Context, now time.Time) {
+	for _, detections := range m.received {
+		for prefix, detection := range detections {
+			if !now.Before(detection.Expires) {
+				delete(detections, prefix)
+				if !m.send(ctx, Update{Detection: detection, Removed: true}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// minPrefixLength is the shortest prefix of prefix's address family accepted from peers
+func (m *Mesh) minPrefixLength(prefix netip.Prefix) int {
+	if prefix.Addr().Is4() {
+		return m.conf.MinPrefixLengthV4
+	}
+
+	return m.conf.MinPrefixLengthV6
+}
+
+func (m *Mesh) allowlisted(prefix netip.Prefix) bool {
+	for _, allowed := range m.conf.Allowlist {
+		if allowed.Overlaps(prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,101 @@
+package peers
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func newTestMesh() *Mesh {
+	return &Mesh{
+		conf: Config{
+			MaxTTL:            time.Hour,
+			Allowlist:         []netip.Prefix{netip.MustParsePrefix("192.0.2.0/28")},
+			MinPrefixLengthV4: DefaultMinPrefixLengthV4,
+			MinPrefixLengthV6: DefaultMinPrefixLengthV6,
+		},
+		received:   make(map[string]map[netip.Prefix]Detection),
+		UpdateChan: make(chan Update, 64),
+	}
+}
+
+func TestMeshApply(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMesh()
+
+	messages := []message{
+		{Op: opBlock, Prefix: "198.51.100.7/32", TTL: 60, Reason: "sshd"},
+		// an announcement of a known detection only renews it
+		{Op: opBlock, Prefix: "198.51.100.7/32", TTL: 60, Reason: "sshd"},
+		// detections that do not expire are capped to the max TTL, like ones with a longer TTL
+		{Op: opBlock, Prefix: "203.0.113.9/32"},
+		{Op: opBlock, Prefix: "2001:db8::/64", TTL: 7 * 24 * 3600},
+		{Op: opBlock, Prefix: "192.0.2.5/32"},
+		{Op: opBlock, Prefix: "10.0.0.0/8"},
+		{Op: opBlock, Prefix: "not a prefix"},
+		{Op: "quarantine", Prefix: "198.51.100.8/32"},
+		{Op: opUnblock, Prefix: "198.51.100.7/32"},
+		{Op: opUnblock, Prefix: "198.51.100.9/32"},
+	}
+
+	start := time.Now()
+	for _, msg := range messages {
+		m.apply(ctx, received{peer: "web1", msg: msg})
+	}
+
+	want := []struct {
+		prefix  string
+		removed bool
+		ttl     time.Duration
+	}{
+		{"198.51.100.7/32", false, time.Minute},
+		{"203.0.113.9/32", false, time.Hour},
+		{"2001:db8::/64", false, time.Hour},
+		{"198.51.100.7/32", true, time.Minute},
+	}
+
+	if len(m.UpdateChan) != len(want) {
+		t.Fatalf("%d updates, want %d", len(m.UpdateChan), len(want))
+	}
+
+	for _, w := range want {
+		u := <-m.UpdateChan
+		if u.Peer != "web1" || u.Prefix != netip.MustParsePrefix(w.prefix) || u.Removed != w.removed {
+			t.Fatalf("update %+v, want %s removed %v", u, w.prefix, w.removed)
+		}
+
+		if ttl := u.Expires.Sub(start); ttl < w.ttl || ttl > w.ttl+time.Minute {
+			t.Errorf("%s expires after %v, want %v", w.prefix, ttl, w.ttl)
+		}
+	}
+}
+
+func TestMeshExpire(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMesh()
+
+	m.apply(ctx, received{peer: "web1", msg: message{Op: opBlock, Prefix: "198.51.100.7/32", TTL: 60}})
+	m.apply(ctx, received{peer: "web2", msg: message{Op: opBlock, Prefix: "203.0.113.9/32", TTL: 600}})
+	<-m.UpdateChan
+	<-m.UpdateChan
+
+	m.expire(ctx, time.Now().Add(5*time.Minute))
+
+	if len(m.UpdateChan) != 1 {
+		t.Fatalf("%d updates, want only the detection of web1 lifted", len(m.UpdateChan))
+	}
+
+	if u := <-m.UpdateChan; !u.Removed || u.Peer != "web1" {
+		t.Fatalf("update %+v, want the detection of web1 lifted", u)
+	}
+
+	// a cancelled ctx stops the sends rather than blocking on a full UpdateChan
+	for i := 0; i < cap(m.UpdateChan); i++ {
+		m.UpdateChan <- Update{}
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	m.expire(cancelled, time.Now().Add(time.Hour))
+}
@@ -0,0 +1,24 @@
+package turris
+
+import (
+	"sync"
+
+	zmq "github.com/pebbe/zmq4"
+)
+
+var (
+	authOnce sync.Once
+	authErr  error
+)
+
+// StartAuth starts the ZAP authenticator the CURVE servers of this process share, i.e. the hub and the peers' publisher;
+// it is started once, later calls return the outcome of the first
+// The authenticator only serves sockets of the default context, so servers create theirs with zmq.NewSocket; once it runs,
+// a client whose key is not registered for the server's domain is turned away, an unknown domain included
+func StartAuth() error {
+	authOnce.Do(func() {
+		authErr = zmq.AuthStart()
+	})
+
+	return authErr
+}
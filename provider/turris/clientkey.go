@@ -0,0 +1,144 @@
+package turris
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	zmq "github.com/pebbe/zmq4"
+)
+
+const keyFileTemplate = `#   dynafire %s CURVE key pair
+#   Keep this file private, the public-key can be shared, %s.
+
+curve
+    public-key = "%s"
+    secret-key = "%s"
+`
+
+// keyRole describes what a key file is for, in its header and in the logs
+type keyRole struct {
+	name  string
+	share string
+}
+
+var (
+	clientKeyRole = keyRole{name: "Turris client", share: "i.e. to allowlist this client on a relay"}
+	relayKeyRole  = keyRole{name: "relay", share: "it is the server_public_key of the clients"}
+	peerKeyRole   = keyRole{name: "peer", share: "it is the public_key of this instance on its peers"}
+)
+
+// loadOrCreateClientKeypair reads the client CURVE key pair from path, generating and saving a new one
+// with 0600 permissions if the file does not exist yet
+func loadOrCreateClientKeypair(path string) (string, string, error) {
+	return loadOrCreateKeypair(path, clientKeyRole)
+}
+
+// LoadOrCreateRelayKeypair reads the CURVE key pair a relay serves the feed with from path, the same way as the client key file
+func LoadOrCreateRelayKeypair(path string) (string, string, error) {
+	return loadOrCreateKeypair(path, relayKeyRole)
+}
+
+// LoadOrCreatePeerKeypair reads the CURVE key pair an instance shares its detections with from path, the same way as the client key file
+func LoadOrCreatePeerKeypair(path string) (string, string, error) {
+	return loadOrCreateKeypair(path, peerKeyRole)
+}
+
+func loadOrCreateKeypair(path string, role keyRole) (string, string, error) {
+	certB, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		pubKey, privKey, err := zmq.NewCurveKeypair()
+		if err != nil {
+			return "", "", err
+		}
+
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			return "", "", err
+		}
+
+		err = os.WriteFile(path, []byte(fmt.Sprintf(keyFileTemplate, role.name, role.share, pubKey, privKey)), 0600)
+		if err != nil {
+			return "", "", err
+		}
+
+		slog.Info(fmt.Sprintf("generated new %s key pair", role.name), "path", path, "public_key", pubKey)
+
+		return pubKey, privKey, nil
+	} else if err != nil {
+		return "", "", err
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	if fi.Mode().Perm()&0077 != 0 {
+		slog.Warn(fmt.Sprintf("%s key file is accessible by other users, restricting its permissions to 0600", role.name), "path", path, "mode", fi.Mode().Perm().String())
+		err = os.Chmod(path, 0600)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	pubKey, privKey, err := parseClientKeypair(certB)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid %s key file %s: %w", role.name, path, err)
+	}
+
+	return pubKey, privKey, nil
+}
+
+func parseClientKeypair(certB []byte) (string, string, error) {
+	var pubKey, privKey string
+
+	scanner := bufio.NewScanner(bytes.NewReader(certB))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		switch strings.TrimSpace(name) {
+		case "public-key":
+			pubKey = value
+		case "secret-key":
+			privKey = value
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+
+	if err := validateZ85Key(pubKey); err != nil {
+		return "", "", fmt.Errorf("public-key: %w", err)
+	}
+
+	if err := validateZ85Key(privKey); err != nil {
+		return "", "", fmt.Errorf("secret-key: %w", err)
+	}
+
+	derivedPubKey, err := zmq.AuthCurvePublic(privKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	if derivedPubKey != pubKey {
+		return "", "", errors.New("public-key does not belong to secret-key")
+	}
+
+	return pubKey, privKey, nil
+}
@@ -0,0 +1,48 @@
+package turris
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testServerKey and testOtherKey are well-formed Z85 keys, of no key pair in particular
+const (
+	testServerKey = "rJ.7ieEC%H6@W^fAEH/rL0r5#Y?Uz)I7Aj9b1k3!"
+	testOtherKey  = "Yne@$w-vo<fVvi]a<NY6T1ed:M$fCG*[IaLV{hID"
+)
+
+func TestLoadClientKeypairInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{name: "missing secret key", file: fmt.Sprintf("curve\n    public-key = %q\n", testServerKey)},
+		{name: "short public key", file: fmt.Sprintf("curve\n    public-key = \"abc\"\n    secret-key = %q\n", testServerKey)},
+		{name: "keys of different pairs", file: fmt.Sprintf("curve\n    public-key = %q\n    secret-key = %q\n", testServerKey, testOtherKey)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "client.key")
+			if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, _, err := loadOrCreateClientKeypair(path); err == nil {
+				t.Fatal("loadOrCreateClientKeypair() accepted an invalid key file")
+			}
+
+			// the file is made private before it is even parsed
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.Mode().Perm() != 0o600 {
+				t.Fatalf("key file mode %v, want 0600", info.Mode().Perm())
+			}
+		})
+	}
+}
@@ -36,14 +36,25 @@ func (c *Client) decodeDelta(rawMsg []byte) (Delta, error) {
 		}
 	}
 
-	operation := rawMap["delta"].(string)
-	ip := rawMap["ip"].(string)
-	serial, ok := rawMap["serial"].(uint32)
+	operation, ok := rawMap["delta"].(string)
 	if !ok {
-		serial = uint32(rawMap["serial"].(uint16))
+		return Delta{}, errors.New("malformed delta message; operation is not a string")
 	}
 
-	ts := rawMap["ts"].(uint32)
+	ip, ok := rawMap["ip"].(string)
+	if !ok {
+		return Delta{}, errors.New("malformed delta message; IP is not a string")
+	}
+
+	serial, ok := toUint32(rawMap["serial"])
+	if !ok {
+		return Delta{}, errors.New("malformed delta message; serial is not an unsigned 32-bit integer")
+	}
+
+	ts, ok := toUint32(rawMap["ts"])
+	if !ok {
+		return Delta{}, errors.New("malformed delta message; timestamp is not an unsigned 32-bit integer")
+	}
 
 	return Delta{
 		Operation: operation,
@@ -52,3 +63,26 @@ func (c *Client) decodeDelta(rawMsg []byte) (Delta, error) {
 		Timestamp: time.Unix(int64(ts), 0),
 	}, nil
 }
+
+// EncodeDelta encodes delta as a dynfw/delta message body, the way the Sentinel server does
+func EncodeDelta(delta Delta) ([]byte, error) {
+	var buf bytes.Buffer
+	e := msgpack.NewEncoder(&buf)
+
+	err := errors.Join(
+		e.EncodeMapLen(len(deltaMapExpectedKeys)),
+		e.EncodeString("delta"),
+		e.EncodeString(delta.Operation),
+		e.EncodeString("ip"),
+		e.EncodeString(delta.IP.String()),
+		e.EncodeString("serial"),
+		e.EncodeUint32(delta.Serial),
+		e.EncodeString("ts"),
+		e.EncodeUint32(uint32(delta.Timestamp.Unix())),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode delta message: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
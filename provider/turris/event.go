@@ -0,0 +1,125 @@
+package turris
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Event is a dynfw/event message; events are notices from the Sentinel server that are not part of the list/delta stream itself
+type Event struct {
+	Name      string
+	Timestamp time.Time
+	Data      map[string]interface{}
+}
+
+// EventAction is what the daemon should do in response to an Event
+type EventAction int
+
+const (
+	EventActionNone EventAction = iota
+	EventActionRefreshList
+	EventActionReconnect
+)
+
+var eventMapExpectedKeys = []string{
+	"event", "ts",
+}
+
+func (c *Client) decodeEvent(rawMsg []byte) (Event, error) {
+	buf := bytes.NewReader(rawMsg)
+	d := msgpack.NewDecoder(buf)
+
+	rawMap, err := d.DecodeMap()
+	if err != nil {
+		return Event{}, fmt.Errorf("unable to decode event message: %w", err)
+	}
+
+	for _, key := range eventMapExpectedKeys {
+		if _, ok := rawMap[key]; !ok {
+			return Event{}, errors.New("malformed event message")
+		}
+	}
+
+	name, ok := rawMap["event"].(string)
+	if !ok {
+		return Event{}, errors.New("malformed event message; event name is not a string")
+	}
+
+	ts, ok := toUint32(rawMap["ts"])
+	if !ok {
+		return Event{}, errors.New("malformed event message; timestamp is not an unsigned 32-bit integer")
+	}
+
+	data := make(map[string]interface{})
+	for key, value := range rawMap {
+		if key == "event" || key == "ts" {
+			continue
+		}
+
+		data[key] = value
+	}
+
+	return Event{
+		Name:      name,
+		Timestamp: time.Unix(int64(ts), 0),
+		Data:      data,
+	}, nil
+}
+
+// EventAction returns what the events configured in ClientConfig call for
+// Sentinel does not document the names of its events, so an event that is not configured is only logged
+func (c *Client) EventAction(e Event) EventAction {
+	return c.eventActions[e.Name]
+}
+
+func (a EventAction) String() string {
+	switch a {
+	case EventActionRefreshList:
+		return "refresh_list"
+	case EventActionReconnect:
+		return "reconnect"
+	default:
+		return "none"
+	}
+}
+
+// msgpack picks the smallest integer type able to hold a value, so accept all of them;
+// negative values and ones beyond uint32 are rejected rather than wrapped
+func toUint32(v interface{}) (uint32, bool) {
+	var n int64
+	switch i := v.(type) {
+	case uint8:
+		return uint32(i), true
+	case uint16:
+		return uint32(i), true
+	case uint32:
+		return i, true
+	case uint64:
+		if i > math.MaxUint32 {
+			return 0, false
+		}
+
+		return uint32(i), true
+	case int8:
+		n = int64(i)
+	case int16:
+		n = int64(i)
+	case int32:
+		n = int64(i)
+	case int64:
+		n = i
+	default:
+		return 0, false
+	}
+
+	if n < 0 || n > math.MaxUint32 {
+		return 0, false
+	}
+
+	return uint32(n), true
+}
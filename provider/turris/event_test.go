@@ -0,0 +1,68 @@
+package turris
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecodeEvent(t *testing.T) {
+	c := &Client{}
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		wantErr bool
+	}{
+		{name: "event", msg: encodeMap(t, "event", "maintenance", "ts", uint32(1704110400), "until", "2024-01-01T14:00:00Z")},
+		{name: "small timestamp", msg: encodeMap(t, "event", "maintenance", "ts", int8(5))},
+		{name: "missing timestamp", msg: encodeMap(t, "event", "maintenance"), wantErr: true},
+		{name: "name not a string", msg: encodeMap(t, "event", 1, "ts", uint32(1704110400)), wantErr: true},
+		{name: "negative timestamp", msg: encodeMap(t, "event", "maintenance", "ts", int64(-1)), wantErr: true},
+		{name: "timestamp beyond uint32", msg: encodeMap(t, "event", "maintenance", "ts", uint64(1)<<32), wantErr: true},
+		{name: "not a map", msg: []byte{0xc0}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := c.decodeEvent(tt.msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeEvent() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			if err != nil || tt.name != "event" {
+				return
+			}
+
+			if event.Name != "maintenance" || !event.Timestamp.Equal(time.Unix(1704110400, 0)) || event.Data["until"] != "2024-01-01T14:00:00Z" {
+				t.Fatalf("decodeEvent() = %+v", event)
+			}
+
+			if _, ok := event.Data["ts"]; ok {
+				t.Fatal("the timestamp is kept among the event data")
+			}
+		})
+	}
+}
+
+func TestEventAction(t *testing.T) {
+	c, err := NewClient(ClientConfig{
+		ServerPublicKey:   "test",
+		RefreshListEvents: []string{"list_rotated"},
+		ReconnectEvents:   []string{"key_rotated"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]EventAction{
+		"list_rotated": EventActionRefreshList,
+		"key_rotated":  EventActionReconnect,
+		"maintenance":  EventActionNone,
+	}
+
+	for name, want := range tests {
+		if got := c.EventAction(Event{Name: name}); got != want {
+			t.Errorf("EventAction(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
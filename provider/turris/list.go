@@ -37,14 +37,25 @@ func (c *Client) decodeList(rawMsg []byte) (List, error) {
 		}
 	}
 
-	version := rawMap["version"].(uint32)
-	serial, ok := rawMap["serial"].(uint32)
+	version, ok := toUint32(rawMap["version"])
 	if !ok {
-		serial = uint32(rawMap["serial"].(uint16))
+		return List{}, errors.New("malformed list message; version is not an unsigned 32-bit integer")
 	}
 
-	rawBlocklist := rawMap["list"].([]interface{})
-	ts := rawMap["ts"].(uint32)
+	serial, ok := toUint32(rawMap["serial"])
+	if !ok {
+		return List{}, errors.New("malformed list message; serial is not an unsigned 32-bit integer")
+	}
+
+	ts, ok := toUint32(rawMap["ts"])
+	if !ok {
+		return List{}, errors.New("malformed list message; timestamp is not an unsigned 32-bit integer")
+	}
+
+	rawBlocklist, ok := rawMap["list"].([]interface{})
+	if !ok {
+		return List{}, errors.New("malformed list message; list is not an array")
+	}
 
 	for _, ip := range rawBlocklist {
 		if ipStr, ok := ip.(string); ok {
@@ -59,3 +70,34 @@ func (c *Client) decodeList(rawMsg []byte) (List, error) {
 		Timestamp: time.Unix(int64(ts), 0),
 	}, nil
 }
+
+// EncodeList encodes list as a dynfw/list message body, the way the Sentinel server does, i.e. for a relay re-publishing the feed
+func EncodeList(list List) ([]byte, error) {
+	var buf bytes.Buffer
+	e := msgpack.NewEncoder(&buf)
+
+	// the numbers are always encoded as uint32, which agents of earlier releases insist on
+	err := errors.Join(
+		e.EncodeMapLen(len(listMapExpectedKeys)),
+		e.EncodeString("ts"),
+		e.EncodeUint32(uint32(list.Timestamp.Unix())),
+		e.EncodeString("version"),
+		e.EncodeUint32(uint32(list.Version.Unix())),
+		e.EncodeString("serial"),
+		e.EncodeUint32(list.Serial),
+		e.EncodeString("list"),
+		e.EncodeArrayLen(len(list.Blacklist)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode list message: %w", err)
+	}
+
+	for _, ip := range list.Blacklist {
+		err = e.EncodeString(ip.String())
+		if err != nil {
+			return nil, fmt.Errorf("unable to encode list message: %w", err)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,107 @@
+package turris
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// encodeMap encodes fields as a msgpack map, in order, so that every value keeps the integer width it was given
+func encodeMap(t *testing.T, fields ...interface{}) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	e := msgpack.NewEncoder(&buf)
+	if err := e.EncodeMapLen(len(fields) / 2); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, field := range fields {
+		if err := e.Encode(field); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func TestDecodeList(t *testing.T) {
+	c := &Client{}
+	list := []interface{}{"192.0.2.1", "2001:db8::1"}
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		serial  uint32
+		wantErr bool
+	}{
+		{name: "uint32", msg: encodeMap(t, "ts", uint32(1700000000), "version", uint32(1690000000), "serial", uint32(70000), "list", list), serial: 70000},
+		{name: "narrow integers", msg: encodeMap(t, "ts", uint32(1700000000), "version", uint32(1690000000), "serial", uint8(7), "list", list), serial: 7},
+		{name: "signed integers", msg: encodeMap(t, "ts", int64(1700000000), "version", int32(1690000000), "serial", int16(7), "list", list), serial: 7},
+		{name: "negative serial", msg: encodeMap(t, "ts", uint32(1700000000), "version", uint32(1690000000), "serial", int8(-1), "list", list), wantErr: true},
+		{name: "timestamp beyond uint32", msg: encodeMap(t, "ts", uint64(1<<32), "version", uint32(1690000000), "serial", uint32(7), "list", list), wantErr: true},
+		{name: "version as string", msg: encodeMap(t, "ts", uint32(1700000000), "version", "1690000000", "serial", uint32(7), "list", list), wantErr: true},
+		{name: "list as string", msg: encodeMap(t, "ts", uint32(1700000000), "version", uint32(1690000000), "serial", uint32(7), "list", "192.0.2.1"), wantErr: true},
+		{name: "missing list", msg: encodeMap(t, "ts", uint32(1700000000), "version", uint32(1690000000), "serial", uint32(7)), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := c.decodeList(tt.msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeList() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if decoded.Serial != tt.serial || decoded.Version.Unix() != 1690000000 || decoded.Timestamp.Unix() != 1700000000 || len(decoded.Blacklist) != 2 {
+				t.Errorf("decodeList() = %+v", decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeDelta(t *testing.T) {
+	c := &Client{}
+
+	delta, err := c.decodeDelta(encodeMap(t, "delta", "positive", "ip", "192.0.2.1", "serial", uint16(300), "ts", int64(1700000000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if delta.Operation != "positive" || delta.Serial != 300 || !delta.IP.Equal([]byte{192, 0, 2, 1}) {
+		t.Errorf("decodeDelta() = %+v", delta)
+	}
+
+	if _, err := c.decodeDelta(encodeMap(t, "delta", 1, "ip", "192.0.2.1", "serial", uint32(7), "ts", uint32(1700000000))); err == nil {
+		t.Error("decodeDelta() accepted an operation that is not a string")
+	}
+}
+
+func TestEncodeListRoundTrip(t *testing.T) {
+	list := List{
+		Version:   time.Unix(1690000000, 0),
+		Serial:    42,
+		Blacklist: []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")},
+		Timestamp: time.Unix(1700000000, 0),
+	}
+
+	body, err := EncodeList(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := (&Client{}).decodeList(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !decoded.Version.Equal(list.Version) || decoded.Serial != 42 || len(decoded.Blacklist) != 2 || !decoded.Blacklist[1].Equal(list.Blacklist[1]) {
+		t.Errorf("decodeList(EncodeList()) = %+v, want %+v", decoded, list)
+	}
+}
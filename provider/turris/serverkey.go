@@ -0,0 +1,221 @@
+package turris
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	zmq "github.com/pebbe/zmq4"
+)
+
+//go:generate curl -fsSL -o dynfw.pub https://repo.turris.cz/sentinel/dynfw.pub
+
+//go:embed dynfw.pub
+var bundledServerKeyCert []byte
+
+const z85Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ.-:+=^!/*?&<>()[]{}@%$#"
+
+// ServerKeyConfig controls where the Sentinel server CURVE public key comes from
+// The pinned key is, in order of precedence, PublicKey, the key in PublicKeyFile or the key bundled with dynafire
+type ServerKeyConfig struct {
+	PublicKey     string
+	PublicKeyFile string
+	// Fingerprint is the hex encoded SHA-256 of the raw server key;
+	// when empty, the fingerprint of the pinned key is used
+	Fingerprint string
+	// Fetch opts into downloading the key from CertUrl, the downloaded key is only used if it matches Fingerprint
+	Fetch   bool
+	CertUrl string
+}
+
+// ResolveServerPubKey returns the Z85 encoded server public key according to conf
+// It only touches the network when conf.Fetch is set or no key is pinned, and never fails just because the download did while a key is pinned
+func ResolveServerPubKey(conf ServerKeyConfig) (string, error) {
+	pinnedKey, pinnedFrom, err := pinnedServerPubKey(conf)
+	if err != nil {
+		return "", err
+	}
+
+	fingerprint := strings.ToLower(strings.TrimSpace(conf.Fingerprint))
+	if fingerprint == "" && pinnedKey != "" {
+		fingerprint = KeyFingerprint(pinnedKey)
+	}
+
+	if pinnedKey != "" && conf.Fingerprint != "" && KeyFingerprint(pinnedKey) != fingerprint {
+		return "", fmt.Errorf("pinned Turris server public key from %s has fingerprint %s, which does not match the configured fingerprint %s", pinnedFrom, KeyFingerprint(pinnedKey), fingerprint)
+	}
+
+	if !conf.Fetch && pinnedKey != "" {
+		slog.Info("using pinned Turris server public key", "key_source", pinnedFrom, "fingerprint", KeyFingerprint(pinnedKey))
+		return pinnedKey, nil
+	}
+
+	certUrl := conf.CertUrl
+	if certUrl == "" {
+		certUrl = CertUrl
+	}
+
+	fetchedKey, err := getServerPubKey(certUrl)
+	if err != nil {
+		if pinnedKey == "" {
+			return "", fmt.Errorf("unable to fetch Turris server public key and no pinned key is available: %w", err)
+		}
+
+		slog.Warn("unable to fetch Turris server public key, falling back to the pinned key", "url", certUrl, "key_source", pinnedFrom, "details", err)
+		return pinnedKey, nil
+	}
+
+	if fingerprint == "" {
+		// nothing to verify against, as in a build without a bundled key; it still starts, the way it did when the key was always downloaded
+		slog.Warn("!!! WARNING !!! using the Turris server public key downloaded from the network without verifying it, no key or fingerprint is pinned. Pin the logged fingerprint in turris.server_key_fingerprint.",
+			"url", certUrl, "fingerprint", KeyFingerprint(fetchedKey))
+
+		return fetchedKey, nil
+	}
+
+	if KeyFingerprint(fetchedKey) != fingerprint {
+		slog.Error("!!! WARNING !!! Turris server public key fetched from the network does NOT match the pinned fingerprint; the key may have been rotated or the download tampered with. Verify the new key out of band before pinning it.",
+			"url", certUrl, "fetched_fingerprint", KeyFingerprint(fetchedKey), "pinned_fingerprint", fingerprint)
+
+		if pinnedKey == "" {
+			return "", errors.New("fetched Turris server public key failed fingerprint verification and no pinned key is available")
+		}
+
+		return pinnedKey, nil
+	}
+
+	if pinnedKey != "" && fetchedKey != pinnedKey {
+		slog.Error("!!! WARNING !!! Turris server public key has been rotated; the fetched key matches the pinned fingerprint but differs from the pinned key. Update the pinned key.",
+			"key_source", pinnedFrom, "pinned_key_fingerprint", KeyFingerprint(pinnedKey), "fetched_fingerprint", fingerprint)
+	}
+
+	slog.Info("using verified Turris server public key", "url", certUrl, "fingerprint", fingerprint)
+
+	return fetchedKey, nil
+}
+
+func pinnedServerPubKey(conf ServerKeyConfig) (string, string, error) {
+	if conf.PublicKey != "" {
+		key := strings.TrimSpace(conf.PublicKey)
+		if err := validateZ85Key(key); err != nil {
+			return "", "", fmt.Errorf("invalid pinned Turris server public key: %w", err)
+		}
+
+		return key, "config", nil
+	}
+
+	if conf.PublicKeyFile != "" {
+		certB, err := os.ReadFile(conf.PublicKeyFile)
+		if err != nil {
+			return "", "", fmt.Errorf("unable to read Turris server public key file: %w", err)
+		}
+
+		key, err := parseServerPubKey(certB)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid Turris server public key file %s: %w", conf.PublicKeyFile, err)
+		}
+
+		return key, conf.PublicKeyFile, nil
+	}
+
+	key, err := parseServerPubKey(bundledServerKeyCert)
+	if err != nil {
+		// an empty bundled cert is a valid build, it just means the key has to come from elsewhere
+		slog.Debug("no usable bundled Turris server public key", "details", err)
+		return "", "", nil
+	}
+
+	return key, "bundled", nil
+}
+
+// KeyFingerprint returns the hex encoded SHA-256 of the raw 32 byte CURVE key behind a Z85 encoded key
+func KeyFingerprint(z85Key string) string {
+	sum := sha256.Sum256([]byte(zmq.Z85decode(z85Key)))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseServerPubKey extracts the public key out of a ZeroMQ CURVE certificate, i.e. the contents of dynfw.pub
+func parseServerPubKey(certB []byte) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(certB))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, found := strings.Cut(line, "=")
+		if !found || strings.TrimSpace(name) != "public-key" {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+			return "", errors.New("public-key value is not quoted")
+		}
+
+		key := value[1 : len(value)-1]
+		err := validateZ85Key(key)
+		if err != nil {
+			return "", err
+		}
+
+		return key, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", errors.New("no public-key entry found")
+}
+
+func validateZ85Key(key string) error {
+	if len(key) != 40 {
+		return fmt.Errorf("expected a 40 character Z85 encoded key, got %d characters", len(key))
+	}
+
+	for _, r := range key {
+		if !strings.ContainsRune(z85Alphabet, r) {
+			return fmt.Errorf("key contains non-Z85 character %q", r)
+		}
+	}
+
+	return nil
+}
+
+func getServerPubKey(certDlUri string) (string, error) {
+	httpClient := &http.Client{Timeout: 15 * time.Second}
+
+	resp, err := httpClient.Get(certDlUri)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			slog.Error("unable to close HTTP response body", "details", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected HTTP status while fetching server public key: %s", resp.Status)
+	}
+
+	certB, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", err
+	}
+
+	return parseServerPubKey(certB)
+}
@@ -0,0 +1,84 @@
+package turris
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testCert(key string) string {
+	return "#   ****  Generated by dynafire tests  ****\n\nmetadata\ncurve\n    public-key = \"" + key + "\"\n"
+}
+
+func TestParseServerPubKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		cert    string
+		wantErr bool
+	}{
+		{name: "certificate", cert: testCert(testServerKey)},
+		{name: "unquoted", cert: "curve\n    public-key = " + testServerKey + "\n", wantErr: true},
+		{name: "short key", cert: testCert("abc"), wantErr: true},
+		{name: "not Z85", cert: testCert(strings.Repeat(",", 40)), wantErr: true},
+		{name: "no key", cert: "curve\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseServerPubKey([]byte(tt.cert))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseServerPubKey() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			if err == nil && key != testServerKey {
+				t.Fatalf("parseServerPubKey() = %q, want %q", key, testServerKey)
+			}
+		})
+	}
+}
+
+func TestResolveServerPubKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "dynfw.pub")
+	if err := os.WriteFile(keyFile, []byte(testCert(testOtherKey)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cert := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testCert(testServerKey)))
+	}))
+	t.Cleanup(cert.Close)
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(missing.Close)
+
+	tests := []struct {
+		name    string
+		conf    ServerKeyConfig
+		want    string
+		wantErr bool
+	}{
+		{name: "pinned in the config", conf: ServerKeyConfig{PublicKey: testServerKey, PublicKeyFile: keyFile}, want: testServerKey},
+		{name: "pinned in a file", conf: ServerKeyConfig{PublicKeyFile: keyFile}, want: testOtherKey},
+		{name: "invalid pinned key", conf: ServerKeyConfig{PublicKey: "abc"}, wantErr: true},
+		{name: "missing key file", conf: ServerKeyConfig{PublicKeyFile: filepath.Join(t.TempDir(), "none.pub")}, wantErr: true},
+		{name: "pinned key against another fingerprint", conf: ServerKeyConfig{PublicKey: testServerKey, Fingerprint: strings.Repeat("0", 64)}, wantErr: true},
+		{name: "download failing falls back to the pinned key", conf: ServerKeyConfig{PublicKey: testOtherKey, Fetch: true, CertUrl: missing.URL}, want: testOtherKey},
+		{name: "downloaded key matching the pinned fingerprint", conf: ServerKeyConfig{PublicKey: testServerKey, Fetch: true, CertUrl: cert.URL}, want: testServerKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ResolveServerPubKey(tt.conf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveServerPubKey() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			if key != tt.want {
+				t.Fatalf("ResolveServerPubKey() = %q, want %q", key, tt.want)
+			}
+		})
+	}
+}
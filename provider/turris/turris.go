@@ -1,13 +1,18 @@
 package turris
 
 import (
-	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
-	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	zmq "github.com/pebbe/zmq4"
 )
@@ -16,8 +21,44 @@ const (
 	Url     = "sentinel.turris.cz"
 	Port    = 7087
 	CertUrl = "https://repo.turris.cz/sentinel/dynfw.pub"
+
+	TopicList  = "dynfw/list"
+	TopicDelta = "dynfw/delta"
+	TopicEvent = "dynfw/event"
+
+	// TopicSnapshot is followed by a token only one client subscribes to, a hub answers that subscription with the list
+	// on the very same topic, so that the other agents are not sent a list they already have;
+	// it is outside dynfw/ for the same reason
+	TopicSnapshot = "dynafire/snapshot/"
 )
 
+// recvTimeout bounds how long receiving waits for a message, so that refresh and reconnect requests, and cancellation,
+// are picked up while the feed is idle
+const recvTimeout = time.Second
+
+var DefaultTopics = []string{"dynfw/"}
+
+var knownTopics = []string{TopicList, TopicDelta, TopicEvent}
+
+// ClientConfig describes the Turris (or compatible relay) server to connect to and how to authenticate
+// Zero values fall back to the public Sentinel server and the full dynfw/ feed
+type ClientConfig struct {
+	ServerUrl       string
+	ServerPort      int
+	ServerPublicKey string
+	// Topics are ZMQ subscription prefixes, i.e. "dynfw/" for everything or "dynfw/list" and "dynfw/delta" only
+	Topics []string
+	// ClientKeyFile stores a stable client CURVE key pair, it is created if it does not exist
+	// When empty, a new key pair is generated on every run
+	ClientKeyFile string
+	// ResolveServerPublicKey resolves the server public key again when reconnecting, as the server may have rotated it;
+	// nil keeps using ServerPublicKey
+	ResolveServerPublicKey func() (string, error)
+	// RefreshListEvents and ReconnectEvents are the names of the dynfw/event messages that call for a list refresh or a reconnect
+	RefreshListEvents []string
+	ReconnectEvents   []string
+}
+
 type Client struct {
 	zmqClient           *zmq.Socket
 	zmqClientPrivateKey string
@@ -25,11 +66,43 @@ type Client struct {
 	zmqServerPublicKey  string
 	zmqServerUrl        string
 	zmqServerPort       int
+	resolveServerKey    func() (string, error)
+	topics              []string
+	eventActions        map[string]EventAction
 	ListChan            chan List
 	DeltaChan           chan Delta
+	EventChan           chan Event
+	refreshRequested    atomic.Bool
+	reconnectRequested  atomic.Bool
+	// pending is the message Connect verified the connection with, RequestMessages processes it first
+	pending [][]byte
+
+	// the version of the list the consumer applied last and the serial of the last delta it applied to it in sequence,
+	// i.e. to skip a relay re-publishing the very same list for another subscriber
+	appliedMu      sync.Mutex
+	applied        bool
+	appliedVersion time.Time
+	appliedSerial  uint32
 }
 
-func NewClient(zmqServerUrl string, zmqServerPort int) (*Client, error) {
+func NewClient(conf ClientConfig) (*Client, error) {
+	if conf.ServerUrl == "" {
+		conf.ServerUrl = Url
+	}
+
+	if conf.ServerPort == 0 {
+		conf.ServerPort = Port
+	}
+
+	if len(conf.Topics) == 0 {
+		conf.Topics = DefaultTopics
+	}
+
+	err := validateTopics(conf.Topics)
+	if err != nil {
+		return nil, err
+	}
+
 	zmqCtx, err := zmq.NewContext()
 	if err != nil {
 		slog.Debug("creating ZMQ context", "details", err)
@@ -42,33 +115,59 @@ func NewClient(zmqServerUrl string, zmqServerPort int) (*Client, error) {
 		return nil, err
 	}
 
-	zmqServerPubKey, err := getServerPubKey(CertUrl)
-	if err != nil {
-		slog.Debug("fetching Turris public key", "details", err)
-		return nil, err
+	var zmqClientPubKey, zmqClientPrivateKey string
+	if conf.ClientKeyFile != "" {
+		zmqClientPubKey, zmqClientPrivateKey, err = loadOrCreateClientKeypair(conf.ClientKeyFile)
+	} else {
+		zmqClientPubKey, zmqClientPrivateKey, err = zmq.NewCurveKeypair()
 	}
 
-	zmqClientPubKey, zmqClientPrivateKey, err := zmq.NewCurveKeypair()
 	if err != nil {
 		slog.Debug("creating Turris client key pair", "details", err)
 		return nil, err
 	}
 
-	err = zmqClient.SetSubscribe("dynfw/")
-	if err != nil {
-		slog.Debug("subscribing to Turris dynfw messages", "details", err)
-		return nil, err
+	topics := conf.Topics
+	if subscribesTo(conf.Topics, TopicList) {
+		token := make([]byte, 16)
+		_, err = rand.Read(token)
+		if err != nil {
+			return nil, err
+		}
+
+		topics = append(slices.Clone(topics), TopicSnapshot+hex.EncodeToString(token))
+	}
+
+	for _, topic := range topics {
+		err = zmqClient.SetSubscribe(topic)
+		if err != nil {
+			slog.Debug("subscribing to Turris dynfw messages", "topic", topic, "details", err)
+			return nil, err
+		}
+	}
+
+	eventActions := make(map[string]EventAction, len(conf.RefreshListEvents)+len(conf.ReconnectEvents))
+	for _, name := range conf.RefreshListEvents {
+		eventActions[name] = EventActionRefreshList
+	}
+
+	for _, name := range conf.ReconnectEvents {
+		eventActions[name] = EventActionReconnect
 	}
 
 	return &Client{
 		zmqClient:           zmqClient,
 		zmqClientPrivateKey: zmqClientPrivateKey,
 		zmqClientPublicKey:  zmqClientPubKey,
-		zmqServerPublicKey:  zmqServerPubKey,
-		zmqServerUrl:        zmqServerUrl,
-		zmqServerPort:       zmqServerPort,
+		zmqServerPublicKey:  conf.ServerPublicKey,
+		zmqServerUrl:        conf.ServerUrl,
+		zmqServerPort:       conf.ServerPort,
+		resolveServerKey:    conf.ResolveServerPublicKey,
+		topics:              topics,
+		eventActions:        eventActions,
 		ListChan:            make(chan List),
 		DeltaChan:           make(chan Delta),
+		EventChan:           make(chan Event),
 	}, nil
 }
 
@@ -79,18 +178,21 @@ func (c *Client) Close() {
 		slog.Warn("ZMQ client not initialised, nothing to close")
 	}
 
-	err := c.zmqClient.SetUnsubscribe("dynfw/")
-	if err != nil {
-		slog.Error("unable to unsubscribe from Turris dynfw/ topic", "details", err)
+	for _, topic := range c.topics {
+		err := c.zmqClient.SetUnsubscribe(topic)
+		if err != nil {
+			slog.Error("unable to unsubscribe from Turris topic", "topic", topic, "details", err)
+		}
 	}
 
-	err = c.zmqClient.Close()
+	err := c.zmqClient.Close()
 	if err != nil {
 		slog.Error("unable to close ZMQ client", "details", err)
 	}
 
 	close(c.DeltaChan)
 	close(c.ListChan)
+	close(c.EventChan)
 }
 
 func (c *Client) Connect() error {
@@ -99,7 +201,7 @@ func (c *Client) Connect() error {
 		return err
 	}
 
-	err = c.zmqClient.Connect(fmt.Sprintf("tcp://%s:%d", c.zmqServerUrl, c.zmqServerPort))
+	err = c.zmqClient.Connect(c.endpoint())
 	if err != nil {
 		return err
 	}
@@ -113,7 +215,87 @@ func (c *Client) Connect() error {
 		return errors.New("failed to verify connection, dynfw/ test message has no data")
 	}
 
-	return nil
+	// a relay answers the subscription with the current list, which must not get lost
+	c.pending = recvTestMsg
+
+	return c.zmqClient.SetRcvtimeo(recvTimeout)
+}
+
+// PublicKey returns the Z85 encoded client CURVE public key, i.e. for allowlisting this client on a relay
+func (c *Client) PublicKey() string {
+	return c.zmqClientPublicKey
+}
+
+func (c *Client) endpoint() string {
+	return fmt.Sprintf("tcp://%s:%d", c.zmqServerUrl, c.zmqServerPort)
+}
+
+// RefreshList makes RequestMessages discard deltas until the next full dynfw/list message arrives
+// It is safe to call from any goroutine, the request is picked up within a second
+func (c *Client) RefreshList() {
+	c.refreshRequested.Store(true)
+}
+
+// Reconnect makes RequestMessages drop and re-establish the connection to the Turris server,
+// followed by a full list refresh
+// It is safe to call from any goroutine, the request is picked up within a second
+func (c *Client) Reconnect() {
+	c.reconnectRequested.Store(true)
+}
+
+func (c *Client) reconnect() error {
+	err := c.zmqClient.Disconnect(c.endpoint())
+	if err != nil {
+		return err
+	}
+
+	// reconnecting with a key the server rotated away from cannot succeed
+	if c.resolveServerKey != nil {
+		key, err := c.resolveServerKey()
+		if err != nil {
+			return fmt.Errorf("unable to re-read the server public key: %w", err)
+		}
+
+		if key != c.zmqServerPublicKey {
+			slog.Info("Turris server public key changed", "fingerprint", KeyFingerprint(key))
+			c.zmqServerPublicKey = key
+
+			err = c.zmqClient.ClientAuthCurve(c.zmqServerPublicKey, c.zmqClientPublicKey, c.zmqClientPrivateKey)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return c.zmqClient.Connect(c.endpoint())
+}
+
+// ListApplied records that the consumer of ListChan applied list, a refresh then skips the very same list
+// Lists that are not reported as applied, i.e. because applying them failed, are never skipped
+func (c *Client) ListApplied(list List) {
+	c.appliedMu.Lock()
+	defer c.appliedMu.Unlock()
+
+	c.applied, c.appliedVersion, c.appliedSerial = true, list.Version, list.Serial
+}
+
+// DeltaApplied records that the consumer of DeltaChan applied delta; once one delta is not reported as applied,
+// the applied list is behind, and no list is skipped until the next one is applied
+func (c *Client) DeltaApplied(delta Delta) {
+	c.appliedMu.Lock()
+	defer c.appliedMu.Unlock()
+
+	if c.appliedSerial+1 == delta.Serial {
+		c.appliedSerial = delta.Serial
+	}
+}
+
+// holds reports whether list is the one the consumer applied last, with the same deltas applied to it
+func (c *Client) holds(list List) bool {
+	c.appliedMu.Lock()
+	defer c.appliedMu.Unlock()
+
+	return c.applied && list.Version.Equal(c.appliedVersion) && list.Serial == c.appliedSerial
 }
 
 func (c *Client) RequestMessages(ctx context.Context) {
@@ -121,20 +303,62 @@ func (c *Client) RequestMessages(ctx context.Context) {
 	refreshList := true // upon launch, initialize the list
 
 	for {
-		payloadB, err := c.zmqClient.RecvMessageBytes(0)
-		if err != nil {
-			slog.Error("unable to receive dynfw message", "details", err)
+		if ctx.Err() != nil {
+			c.Close()
 			return
 		}
 
+		if c.reconnectRequested.Swap(false) {
+			slog.Info("reconnecting to Turris firewall update server")
+			err := c.reconnect()
+			if err != nil {
+				slog.Error("unable to reconnect to Turris firewall update server", "details", err)
+				return
+			}
+
+			c.refreshRequested.Store(true)
+		}
+
+		if c.refreshRequested.Swap(false) {
+			refreshList = true
+			previousDeltaSerial = 0
+		}
+
+		payloadB := c.pending
+		c.pending = nil
+		if payloadB == nil {
+			var err error
+			payloadB, err = c.zmqClient.RecvMessageBytes(0)
+			if zmq.AsErrno(err) == zmq.Errno(syscall.EAGAIN) {
+				continue
+			}
+
+			if err != nil {
+				slog.Error("unable to receive dynfw message", "details", err)
+				return
+			}
+		}
+
 		if len(payloadB) != 2 {
 			slog.Error("malformed dynfw message")
 			return
 		}
 
-		switch string(payloadB[0]) {
+		topic := string(payloadB[0])
+		// a hub's answer to this client's subscription
+		if strings.HasPrefix(topic, TopicSnapshot) {
+			topic = TopicList
+		}
+
+		switch topic {
 		case "dynfw/event":
-			continue
+			eRes, err := c.decodeEvent(payloadB[1])
+			if err != nil {
+				slog.Warn("unable to decode event message", "details", err)
+				continue
+			}
+
+			c.EventChan <- eRes
 		case "dynfw/delta":
 			if refreshList {
 				continue
@@ -163,19 +387,14 @@ func (c *Client) RequestMessages(ctx context.Context) {
 				}
 
 				refreshList = false
+				if c.holds(lRes) {
+					slog.Debug("skipping list already applied", "version", lRes.Version, "serial", lRes.Serial)
+					continue
+				}
+
 				c.ListChan <- lRes
-			} else {
-				continue
 			}
 		}
-
-		select {
-		case <-ctx.Done():
-			c.Close()
-			return
-		default:
-			continue
-		}
 	}
 }
 
@@ -187,31 +406,38 @@ func serialOk(oldSerial, currentSerial uint32) bool {
 	return false
 }
 
-func getServerPubKey(certDlUri string) (string, error) {
-	resp, err := http.Get(certDlUri)
-	defer func(resp *http.Response) {
-		if resp == nil {
-			return
+// subscribesTo reports whether any of topics selects the known topic
+func subscribesTo(topics []string, known string) bool {
+	for _, topic := range topics {
+		if strings.HasPrefix(known, topic) {
+			return true
 		}
+	}
 
-		err := resp.Body.Close()
-		if err != nil {
-			slog.Error("unable to close HTTP response body", "details", err)
-			return
+	return false
+}
+
+// validateTopics ensures every topic selects at least one known dynfw topic
+// and that deltas are never subscribed to without the list they apply to
+func validateTopics(topics []string) error {
+	subscribed := make(map[string]bool)
+	for _, topic := range topics {
+		matched := false
+		for _, known := range knownTopics {
+			if strings.HasPrefix(known, topic) {
+				subscribed[known] = true
+				matched = true
+			}
 		}
-	}(resp)
 
-	if err != nil {
-		return "", err
+		if !matched {
+			return fmt.Errorf("unknown Turris topic %q", topic)
+		}
 	}
 
-	certB, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+	if subscribed[TopicDelta] && !subscribed[TopicList] {
+		return fmt.Errorf("subscribing to %s requires subscribing to %s as well", TopicDelta, TopicList)
 	}
 
-	keyStartIdx := bytes.IndexByte(certB, '"')
-	keyEndIdx := bytes.LastIndexByte(certB, '"')
-
-	return string(certB[keyStartIdx+1 : keyEndIdx]), nil
+	return nil
 }
@@ -0,0 +1,85 @@
+package turris
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientHolds(t *testing.T) {
+	version := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	list := List{Version: version, Serial: 7}
+
+	c := &Client{}
+	if c.holds(list) {
+		t.Fatal("a client that applied nothing holds a list")
+	}
+
+	c.ListApplied(list)
+	c.DeltaApplied(Delta{Serial: 8})
+	c.DeltaApplied(Delta{Serial: 9})
+
+	tests := []struct {
+		name string
+		list List
+		want bool
+	}{
+		{"the applied list with the applied deltas", List{Version: version, Serial: 9}, true},
+		{"the applied list without the deltas", List{Version: version, Serial: 7}, false},
+		{"a newer list", List{Version: version.Add(time.Hour), Serial: 9}, false},
+	}
+
+	for _, test := range tests {
+		if got := c.holds(test.list); got != test.want {
+			t.Errorf("%s: holds() = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// delta 10 failed to apply, the client is behind any list from then on
+	c.DeltaApplied(Delta{Serial: 11})
+	if c.holds(List{Version: version, Serial: 11}) {
+		t.Fatal("a client missing a delta holds the list including it")
+	}
+}
+
+func TestSubscribesTo(t *testing.T) {
+	tests := []struct {
+		topics []string
+		want   bool
+	}{
+		{DefaultTopics, true},
+		{[]string{TopicList, TopicDelta}, true},
+		{[]string{TopicEvent}, false},
+	}
+
+	for _, test := range tests {
+		if got := subscribesTo(test.topics, TopicList); got != test.want {
+			t.Errorf("subscribesTo(%v, %s) = %v, want %v", test.topics, TopicList, got, test.want)
+		}
+	}
+
+	// snapshot topics are not under dynfw/, so agents subscribed to everything do not get the snapshots of others
+	if subscribesTo(DefaultTopics, TopicSnapshot+"0123") {
+		t.Error("the default topics select the snapshots of other agents")
+	}
+}
+
+func TestValidateTopics(t *testing.T) {
+	tests := []struct {
+		topics  []string
+		wantErr bool
+	}{
+		{topics: DefaultTopics},
+		{topics: []string{TopicList, TopicDelta}},
+		{topics: []string{TopicList}},
+		{topics: []string{TopicEvent}},
+		{topics: []string{"dynfw/l"}},
+		{topics: []string{TopicDelta}, wantErr: true},
+		{topics: []string{"sentinel/"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		if err := validateTopics(tt.topics); (err != nil) != tt.wantErr {
+			t.Errorf("validateTopics(%v) error = %v, want error %v", tt.topics, err, tt.wantErr)
+		}
+	}
+}